@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The access policy restricts who may use the server by the client address
+// and the country and network -geoip-db places it in, e.g. the LAN and the
+// ISPs of one country: -access-deny refuses clients, -access-allow refuses
+// everyone else, and -access-limit caps the test sessions of its clients.
+// Addresses on the -blocklist-url list are refused as well. Refused clients
+// get an explanation page instead of the web UI, and 403 from the API. The
+// console at /admin, /metrics and /healthz stay reachable for operators.
+// Clients are identified by the connection's peer, like -max-client-sessions.
+
+// accessRule matches clients by one of: country, network or address range.
+type accessRule struct {
+	country string       // ISO 3166-1 alpha-2
+	asn     uint32       // autonomous system
+	prefix  netip.Prefix // address range
+	lan     bool         // loopback, private and link-local addresses
+}
+
+// accessRules is a comma-separated list of rules, matching clients that match any.
+type accessRules []accessRule
+
+// accessPolicy is the parsed access flags.
+type accessPolicy struct {
+	allow   accessRules // empty to allow everyone not denied
+	deny    accessRules
+	limit   accessRules
+	limiter *rateLimiter // test sessions of clients matching limit
+}
+
+// access is nil without access flags.
+var access *accessPolicy
+
+var accessRefused = newCounterVec("netspeed_access_refused_total",
+	"Requests and test sessions refused by the access policy.", "reason")
+
+// accessExemptPaths stay reachable for refused clients.
+var accessExemptPaths = []string{"/admin/", "/metrics", "/healthz"}
+
+// parseAccessRules parses e.g. "lan,DE,AS3320,192.0.2.0/24".
+func parseAccessRules(name, value string) (accessRules, error) {
+	var rules accessRules
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "":
+			continue
+		case strings.EqualFold(field, "lan"):
+			rules = append(rules, accessRule{lan: true})
+		case len(field) > 2 && strings.EqualFold(field[:2], "AS"):
+			asn, err := strconv.ParseUint(field[2:], 10, 32)
+			if err != nil || asn == 0 {
+				return nil, fmt.Errorf("-%s: invalid ASN %q", name, field)
+			}
+			rules = append(rules, accessRule{asn: uint32(asn)})
+		case strings.Contains(field, "/"):
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, fmt.Errorf("-%s: %w", name, err)
+			}
+			rules = append(rules, accessRule{prefix: prefix.Masked()})
+		case len(field) == 2 && isLetters(field):
+			rules = append(rules, accessRule{country: strings.ToUpper(field)})
+		default:
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("-%s: %q is not lan, a country code, an ASN such as AS3320, an address or a CIDR range", name, field)
+			}
+			rules = append(rules, accessRule{prefix: netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())})
+		}
+	}
+	return rules, nil
+}
+
+func isLetters(s string) bool {
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// needsGeo reports whether any rule matches by country or network.
+func (rules accessRules) needsGeo() bool {
+	for _, rule := range rules {
+		if rule.country != "" || rule.asn != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether a client matches any rule. geo is looked up at most
+// once, and only when an address rule did not match.
+func (rules accessRules) match(addr netip.Addr, geo func() *GeoInfo) bool {
+	for _, rule := range rules {
+		switch {
+		case rule.lan:
+			if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() {
+				return true
+			}
+		case rule.prefix.IsValid():
+			if rule.prefix.Contains(addr) {
+				return true
+			}
+		}
+	}
+	if !rules.needsGeo() {
+		return false
+	}
+	info := geo()
+	if info == nil {
+		return false
+	}
+	for _, rule := range rules {
+		if (rule.country != "" && rule.country == info.CountryCode) || (rule.asn != 0 && rule.asn == info.ASN) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadAccessPolicy parses the access flags.
+func loadAccessPolicy() error {
+	var p accessPolicy
+	var err error
+	if p.allow, err = parseAccessRules("access-allow", *accessAllow); err != nil {
+		return err
+	}
+	if p.deny, err = parseAccessRules("access-deny", *accessDeny); err != nil {
+		return err
+	}
+	if p.limit, err = parseAccessRules("access-limit", *accessLimit); err != nil {
+		return err
+	}
+	if len(p.allow)+len(p.deny)+len(p.limit) == 0 {
+		return nil
+	}
+	if (p.allow.needsGeo() || p.deny.needsGeo() || p.limit.needsGeo()) && len(geoDatabases) == 0 {
+		return fmt.Errorf("country and ASN rules need -geoip-db")
+	}
+	if len(p.limit) > 0 {
+		if *accessLimitRate <= 0 {
+			return fmt.Errorf("-access-limit-rate must be positive")
+		}
+		p.limiter = newRateLimiter(float64(*accessLimitRate)/60, *accessLimitRate)
+	}
+	access = &p
+	log.Printf("Access policy: allow %q, deny %q, limit %q to %d sessions per hour", *accessAllow, *accessDeny, *accessLimit, *accessLimitRate)
+	return nil
+}
+
+// accessClient is the address and GeoIP record of a request's client.
+type accessClient struct {
+	addr netip.Addr
+	geo  *GeoInfo
+	done bool
+}
+
+func newAccessClient(r *http.Request) *accessClient {
+	addr, _ := netip.ParseAddr(requestClientIP(r))
+	return &accessClient{addr: addr.Unmap()}
+}
+
+// Geo looks the client up in -geoip-db on first use.
+func (c *accessClient) Geo() *GeoInfo {
+	if !c.done {
+		c.geo, c.done = lookupGeo(c.addr.String()), true
+	}
+	return c.geo
+}
+
+// refusal returns why the policy refuses a client, or "".
+func (p *accessPolicy) refusal(c *accessClient) string {
+	if !c.addr.IsValid() {
+		return ""
+	}
+	if p.deny.match(c.addr, c.Geo) {
+		return "denied"
+	}
+	if len(p.allow) > 0 && !p.allow.match(c.addr, c.Geo) {
+		return "not-allowed"
+	}
+	return ""
+}
+
+// withAccessPolicy refuses the requests of clients the access policy does not
+// admit, with the explanation page for browsers.
+func withAccessPolicy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (access == nil && *blocklistURL == "") || isAccessExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := newAccessClient(r)
+		var reason string
+		if blocklisted(client.addr) {
+			reason = "blocklist"
+		} else if access != nil {
+			reason = access.refusal(client)
+		}
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		accessRefused.Inc(reason)
+		if *verbose {
+			log.Printf("Access policy refused %s %s (%s)", loggedAddr(r.RemoteAddr), r.URL.Path, reason)
+		}
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			renderAccessPage(w, r, client)
+			return
+		}
+		http.Error(w, tr(r, "Speed tests are not available from your network"), http.StatusForbidden)
+	})
+}
+
+func isAccessExempt(path string) bool {
+	for _, p := range accessExemptPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAccessLimit answers 429 when a client matching -access-limit has used
+// up its test sessions.
+func checkAccessLimit(w http.ResponseWriter, r *http.Request) bool {
+	if access == nil || access.limiter == nil {
+		return true
+	}
+	client := newAccessClient(r)
+	if !client.addr.IsValid() || !access.limit.match(client.addr, client.Geo) || access.limiter.Allow(client.addr.String()) {
+		return true
+	}
+	accessRefused.Inc("limit")
+	w.Header().Set("Retry-After", strconv.Itoa(int((time.Hour / time.Duration(*accessLimitRate)).Seconds())))
+	http.Error(w, tr(r, "Too many tests from your network, try again later"), http.StatusTooManyRequests)
+	return false
+}
+
+// refuseClient returns why the access policy, its -access-limit or the
+// blocklist refuse a test from addr outside HTTP, such as an iperf3 test, or
+// "" to admit it.
+func refuseClient(addr netip.Addr) string {
+	client := &accessClient{addr: addr.Unmap()}
+	var reason string
+	switch {
+	case blocklisted(client.addr):
+		reason = "blocklist"
+	case access == nil || !client.addr.IsValid():
+		return ""
+	default:
+		reason = access.refusal(client)
+		if reason == "" && access.limiter != nil && access.limit.match(client.addr, client.Geo) && !access.limiter.Allow(client.addr.String()) {
+			reason = "limit"
+		}
+	}
+	if reason != "" {
+		accessRefused.Inc(reason)
+	}
+	return reason
+}
+
+// accessTemplate renders the page refused browsers get instead of the web UI.
+var accessTemplate = template.Must(template.New("access").Funcs(template.FuncMap{"tr": localize}).Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<meta name="robots" content="noindex">
+<title>{{tr .Lang "Speed tests are not available from your network"}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 2rem auto; padding: 0 1rem; color: #111827; }
+dt { font-weight: 600; }
+dd { margin: 0 0 .6rem; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{tr .Lang "Speed tests are not available from your network"}}</h1>
+{{- if .Message}}
+<p>{{.Message}}</p>
+{{- else}}
+<p>{{tr .Lang "This server only accepts tests from certain networks. Please use a speed test closer to you, or contact the operator of this server if you think you should have access."}}</p>
+{{- end}}
+<dl>
+<dt>{{tr .Lang "Your address"}}</dt>
+<dd>{{.Addr}}</dd>
+{{- with .Geo}}
+{{- if .CountryCode}}
+<dt>{{tr $.Lang "Your country"}}</dt>
+<dd>{{if .Country}}{{.Country}} ({{.CountryCode}}){{else}}{{.CountryCode}}{{end}}</dd>
+{{- end}}
+{{- if .ASN}}
+<dt>{{tr $.Lang "Your network"}}</dt>
+<dd>AS{{.ASN}}{{with .ISP}} {{.}}{{end}}</dd>
+{{- end}}
+{{- end}}
+</dl>
+</main>
+</body>
+</html>
+`))
+
+// accessPage is the data of accessTemplate.
+type accessPage struct {
+	Lang    string
+	Message string // -access-message
+	Addr    string
+	Geo     *GeoInfo
+}
+
+func renderAccessPage(w http.ResponseWriter, r *http.Request, client *accessClient) {
+	lang := requestLanguage(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Vary", "Accept, Accept-Language")
+	w.WriteHeader(http.StatusForbidden)
+	page := accessPage{Lang: lang, Message: *accessMessage, Addr: client.addr.String(), Geo: client.Geo()}
+	if err := accessTemplate.Execute(w, page); err != nil {
+		log.Printf("Failed to render access page: %v", err)
+	}
+}
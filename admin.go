@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"flag"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// The admin SPA is embedded separately from the public UI so it can never be
+// replaced through the './static/' override directory.
+//
+//go:embed admin/*
+var adminFiles embed.FS
+
+const adminPrefix = "admin"
+
+// isAdminRequest reports whether a request carries the admin token, either as
+// "Authorization: Bearer <token>" or an X-Admin-Token header.
+func isAdminRequest(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.Header.Get("X-Admin-Token")
+	}
+	return *adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) == 1
+}
+
+// requireAdmin wraps a handler so it only runs for requests carrying the admin
+// token.
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdminRequest(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go-netspeed admin"`)
+			http.Error(w, tr(r, "Unauthorized"), http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// registerAdminRoutes mounts the admin SPA and its APIs on mux.
+func registerAdminRoutes(mux *http.ServeMux) {
+	ui, err := fs.Sub(adminFiles, adminPrefix)
+	if err != nil {
+		log.Fatalf("Failed to load embedded admin UI: %v", err)
+	}
+	mux.Handle("/admin/", http.StripPrefix("/admin/", http.FileServer(http.FS(ui))))
+
+	mux.HandleFunc("/admin/api/results", requireAdmin(adminResultsHandler))
+	mux.HandleFunc("/admin/api/stats", requireAdmin(adminStatsHandler))
+	mux.HandleFunc("/admin/api/sessions", requireAdmin(adminSessionsHandler))
+	mux.HandleFunc("/admin/api/config", requireAdmin(adminConfigHandler))
+	mux.HandleFunc("/admin/api/test-errors", requireAdmin(adminTestErrorsHandler))
+	mux.HandleFunc("/admin/api/peer-test", requireAdmin(adminPeerTestHandler))
+	mux.HandleFunc("/admin/api/probes", requireAdmin(adminProbesHandler))
+	mux.HandleFunc("/admin/api/schedules", requireAdmin(adminSchedulesHandler))
+	mux.HandleFunc("/admin/api/alerts", requireAdmin(adminAlertsHandler))
+	mux.HandleFunc("/admin/api/alerts/", requireAdmin(adminAlertHandler))
+	mux.HandleFunc("/admin/api/trends", requireAdmin(adminTrendsHandler))
+	mux.HandleFunc("/admin/api/captures", requireAdmin(adminCapturesHandler))
+	mux.HandleFunc("/admin/api/captures/", requireAdmin(adminCaptureFileHandler))
+	mux.HandleFunc("/admin/store", requireAdmin(adminStoreHandler))
+	mux.HandleFunc("/admin/store/gc", requireAdmin(adminStoreGCHandler))
+	mux.HandleFunc("/admin/store/compact", requireAdmin(adminStoreCompactHandler))
+	mux.HandleFunc("/admin/store/backup", requireAdmin(adminStoreBackupHandler))
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// adminResultsHandler returns a page of stored results matching the filter
+// query parameters (see parseResultFilter), newest first.
+func adminResultsHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := parsePageRequest(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	filter, err := parseResultFilter(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	page, total, err := globalStore.Query(filter, req.Offset, req.Limit)
+	if err != nil {
+		log.Printf("Failed to list results: %v", err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"total":   total,
+		"offset":  req.Offset,
+		"limit":   req.Limit,
+		"results": page,
+	})
+}
+
+// ResultSummary holds simple aggregates over all stored results.
+type ResultSummary struct {
+	Count                int     `json:"count"`
+	AvgDownloadSpeedMbps float64 `json:"avgDownloadSpeedMbps"`
+	AvgUploadSpeedMbps   float64 `json:"avgUploadSpeedMbps"`
+	AvgLatencyMs         float64 `json:"avgLatencyMs"`
+	AvgJitterMs          float64 `json:"avgJitterMs"`
+	AvgPacketLossPercent float64 `json:"avgPacketLossPercent"`
+	MaxDownloadSpeedMbps float64 `json:"maxDownloadSpeedMbps"`
+	MaxUploadSpeedMbps   float64 `json:"maxUploadSpeedMbps"`
+	ActiveSessions       int     `json:"activeSessions"`
+}
+
+// adminStatsHandler summarizes stored results and current activity.
+func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var summary ResultSummary
+	err := globalStore.Iterate(func(result TestResult) error {
+		summary.Count++
+		summary.AvgDownloadSpeedMbps += result.DownloadSpeedMbps
+		summary.AvgUploadSpeedMbps += result.UploadSpeedMbps
+		summary.AvgLatencyMs += result.LatencyMs
+		summary.AvgJitterMs += result.JitterMs
+		summary.AvgPacketLossPercent += result.PacketLossPercent
+		summary.MaxDownloadSpeedMbps = max(summary.MaxDownloadSpeedMbps, result.DownloadSpeedMbps)
+		summary.MaxUploadSpeedMbps = max(summary.MaxUploadSpeedMbps, result.UploadSpeedMbps)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to compute stats: %v", err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	if summary.Count > 0 {
+		n := float64(summary.Count)
+		summary.AvgDownloadSpeedMbps /= n
+		summary.AvgUploadSpeedMbps /= n
+		summary.AvgLatencyMs /= n
+		summary.AvgJitterMs /= n
+		summary.AvgPacketLossPercent /= n
+	}
+	summary.ActiveSessions = len(activeSessions.Active())
+	writeJSON(w, summary)
+}
+
+// adminSessionsHandler lists the tests currently in progress.
+func adminSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, activeSessions.Active())
+}
+
+// adminConfigHandler reports the effective command-line configuration with secrets redacted.
+func adminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	config := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if isSecretFlag(f.Name) && value != "" {
+			value = "[redacted]"
+		}
+		config[f.Name] = value
+	})
+	writeJSON(w, config)
+}
+
+func isSecretFlag(name string) bool {
+	for _, marker := range []string{"token", "secret", "password", "key", "dsn", "redis-url"} {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+	return false
+}
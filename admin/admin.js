@@ -0,0 +1,183 @@
+/*
+ * Admin console for Go Netspeed. Talks to the token-protected /admin/api/ endpoints.
+ */
+
+const TOKEN_KEY = 'netspeedAdminToken';
+const PAGE_SIZE = 50;
+const SESSION_REFRESH_MS = 2000;
+
+const $ = (id) => document.getElementById(id);
+let resultsOffset = 0;
+let sessionTimer = null;
+
+// Escape values before inserting them into markup
+const esc = (value) => String(value ?? '').replace(/[&<>"']/g, (c) => ({
+    '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'
+}[c]));
+
+async function api(path) {
+    const response = await fetch('/admin/api/' + path, {
+        headers: { 'Authorization': 'Bearer ' + sessionStorage.getItem(TOKEN_KEY) },
+        cache: 'no-store'
+    });
+    if (response.status === 401) {
+        signOut('Invalid admin token.');
+        throw new Error('unauthorized');
+    }
+    if (!response.ok) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+    }
+    return response.json();
+}
+
+// --- Tabs ---
+
+// Build the filter query from the search box and the raw filter expression
+function resultsQuery() {
+    const params = new URLSearchParams($('results-filter').value.trim());
+    const search = $('results-search').value.trim();
+    if (search) params.set('q', search);
+    params.set('offset', resultsOffset);
+    params.set('limit', PAGE_SIZE);
+    return params.toString();
+}
+
+async function loadResults() {
+    const data = await api('results?' + resultsQuery());
+    $('results-body').innerHTML = data.results.map(r => `
+        <tr class="border-b last:border-b-0">
+            <td class="py-2">${esc(new Date(r.timestamp).toLocaleString())}</td>
+            <td><a class="text-indigo-600 underline" href="/?resultId=${encodeURIComponent(r.id)}" target="_blank">${esc(r.id.slice(0, 8))}</a></td>
+            <td>${r.downloadSpeedMbps.toFixed(2)}</td>
+            <td>${r.uploadSpeedMbps.toFixed(2)}</td>
+            <td>${r.latencyMs.toFixed(2)}</td>
+            <td>${r.jitterMs.toFixed(2)}</td>
+            <td>${r.packetLossPercent.toFixed(2)}</td>
+            <td>${esc(r.clientIp)}${r.verified ? ' <span class="text-green-600" title="Client tested against this server">&#10003;</span>' : ''}</td>
+            <td class="text-xs">${Object.entries(r.tags || {}).map(([k, v]) => esc(k + ':' + v)).join(', ')}</td>
+        </tr>`).join('') || '<tr><td class="py-2 text-gray-500" colspan="9">No matching results.</td></tr>';
+
+    const last = Math.min(data.offset + data.results.length, data.total);
+    $('page-info').textContent = data.total ? `${data.offset + 1}-${last} of ${data.total}` : '';
+    $('prev-page').disabled = data.offset === 0;
+    $('next-page').disabled = last >= data.total;
+}
+
+async function loadStats() {
+    const stats = await api('stats');
+    $('stats-list').innerHTML = Object.entries(stats).map(([key, value]) => `
+        <dt class="text-gray-600">${esc(key)}</dt>
+        <dd class="font-semibold">${esc(typeof value === 'number' ? +value.toFixed(2) : value)}</dd>`).join('');
+}
+
+async function loadSessions() {
+    const sessions = await api('sessions');
+    $('sessions-body').innerHTML = sessions.map(s => `
+        <tr class="border-b last:border-b-0">
+            <td class="py-2">${esc(s.type)}</td>
+            <td>${esc(s.client)}</td>
+            <td>${esc(new Date(s.startedAt).toLocaleTimeString())}</td>
+            <td>${esc(s.bytes.toLocaleString())}</td>
+            <td>${s.mbps ? esc(s.mbps.toFixed(2)) : ''}</td>
+            <td>${s.chunkSize ? esc(s.chunkSize.toLocaleString()) : ''}</td>
+            <td class="font-mono text-xs">${esc(s.id)}</td>
+        </tr>`).join('') || '<tr><td class="py-2 text-gray-500" colspan="7">No tests running.</td></tr>';
+}
+
+// Render values as a small SVG line chart
+function sparkline(values, color) {
+    if (values.length < 2) return '<span class="text-gray-400 text-xs">not enough data</span>';
+    const width = 300, height = 40, top = Math.max(...values) || 1;
+    const points = values.map((v, i) => `${(i / (values.length - 1) * width).toFixed(1)},${(height - v / top * height).toFixed(1)}`).join(' ');
+    return `<svg width="${width}" height="${height}" class="bg-gray-50 rounded"><polyline fill="none" stroke="${color}" stroke-width="1.5" points="${points}"/></svg>`;
+}
+
+async function loadProbes() {
+    const data = await api('probes');
+    $('probes-list').innerHTML = Object.entries(data.targets).map(([target, probes]) => {
+        const ok = probes.filter(p => !p.error);
+        const last = probes[probes.length - 1];
+        return `
+        <div>
+            <h3 class="font-semibold text-gray-800 break-all">${esc(target)}</h3>
+            <p class="text-xs text-gray-500 mb-2">${last ? `Last probe ${esc(new Date(last.timestamp).toLocaleString())}: ` +
+                (last.error ? `<span class="text-red-600">${esc(last.error)}</span>` : `${last.latencyMs.toFixed(1)} ms, ${last.throughputMbps.toFixed(2)} Mbps`) : 'No probes yet.'}
+                ${probes.length ? ` &middot; ${probes.length - ok.length} of ${probes.length} failed` : ''}</p>
+            <div class="flex flex-wrap gap-6 text-xs text-gray-600">
+                <div>Latency (ms, max ${esc(Math.max(0, ...ok.map(p => p.latencyMs)).toFixed(1))})<br>${sparkline(ok.map(p => p.latencyMs), '#2563eb')}</div>
+                <div>Throughput (Mbps, max ${esc(Math.max(0, ...ok.map(p => p.throughputMbps)).toFixed(2))})<br>${sparkline(ok.map(p => p.throughputMbps), '#16a34a')}</div>
+            </div>
+        </div>`;
+    }).join('') || '<p class="text-gray-500">No probe targets configured.</p>';
+}
+
+async function loadConfig() {
+    const config = await api('config');
+    $('config-list').innerHTML = Object.keys(config).sort().map(key => `
+        <dt class="text-gray-600">${esc(key)}</dt>
+        <dd>${esc(config[key]) || '<span class="text-gray-400">(empty)</span>'}</dd>`).join('');
+}
+
+const loaders = { results: loadResults, stats: loadStats, sessions: loadSessions, probes: loadProbes, config: loadConfig };
+
+function showTab(name) {
+    document.querySelectorAll('.tab').forEach(el => el.classList.toggle('hidden', el.id !== 'tab-' + name));
+    document.querySelectorAll('.tab-btn').forEach(el => el.classList.toggle('ring-2', el.dataset.tab === name));
+
+    clearInterval(sessionTimer);
+    if (name === 'sessions') {
+        sessionTimer = setInterval(() => loadSessions().catch(console.error), SESSION_REFRESH_MS);
+    }
+    loaders[name]().catch(console.error);
+}
+
+// --- Sign in / out ---
+
+function signOut(message = '') {
+    sessionStorage.removeItem(TOKEN_KEY);
+    clearInterval(sessionTimer);
+    $('app').classList.add('hidden');
+    $('logout-btn').classList.add('hidden');
+    $('login').classList.remove('hidden');
+    $('login-error').textContent = message;
+}
+
+async function signIn() {
+    sessionStorage.setItem(TOKEN_KEY, $('token-input').value);
+    try {
+        await api('config');
+    } catch (e) {
+        return;
+    }
+    $('login').classList.add('hidden');
+    $('app').classList.remove('hidden');
+    $('logout-btn').classList.remove('hidden');
+    showTab('results');
+}
+
+window.onload = () => {
+    $('login-btn').addEventListener('click', signIn);
+    $('token-input').addEventListener('keydown', (e) => { if (e.key === 'Enter') signIn(); });
+    $('logout-btn').addEventListener('click', () => signOut());
+    document.querySelectorAll('.tab-btn').forEach(el => el.addEventListener('click', () => showTab(el.dataset.tab)));
+
+    $('prev-page').addEventListener('click', () => {
+        resultsOffset = Math.max(0, resultsOffset - PAGE_SIZE);
+        loadResults().catch(console.error);
+    });
+    const applyFilter = () => {
+        resultsOffset = 0;
+        loadResults().catch(console.error);
+    };
+    $('results-apply').addEventListener('click', applyFilter);
+    ['results-search', 'results-filter'].forEach(id => $(id).addEventListener('keydown', (e) => { if (e.key === 'Enter') applyFilter(); }));
+    $('next-page').addEventListener('click', () => {
+        resultsOffset += PAGE_SIZE;
+        loadResults().catch(console.error);
+    });
+
+    if (sessionStorage.getItem(TOKEN_KEY)) {
+        $('token-input').value = sessionStorage.getItem(TOKEN_KEY);
+        signIn();
+    }
+};
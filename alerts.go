@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Alert states.
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// resolvedAlertRetention is how long resolved alerts stay listed.
+const resolvedAlertRetention = 7 * 24 * time.Hour
+
+// alertRule is one -alert-rules threshold, e.g. "download<50".
+type alertRule struct {
+	text   string
+	metric string
+	below  bool
+	limit  float64
+}
+
+var alertRulePattern = regexp.MustCompile(`^(download|upload|latency|jitter|loss)\s*([<>])\s*([0-9]+(?:\.[0-9]+)?)$`)
+
+// value returns the metric of the rule from a result.
+func (r alertRule) value(result TestResult) float64 {
+	switch r.metric {
+	case "download":
+		return result.DownloadSpeedMbps
+	case "upload":
+		return result.UploadSpeedMbps
+	case "latency":
+		return result.LatencyMs
+	case "jitter":
+		return result.JitterMs
+	default:
+		return result.PacketLossPercent
+	}
+}
+
+func (r alertRule) breached(result TestResult) bool {
+	if r.below {
+		return r.value(result) < r.limit
+	}
+	return r.value(result) > r.limit
+}
+
+// parseAlertRules parses the comma-separated -alert-rules flag.
+func parseAlertRules(list string) ([]alertRule, error) {
+	var rules []alertRule
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		m := alertRulePattern.FindStringSubmatch(field)
+		if m == nil {
+			return nil, fmt.Errorf("invalid alert rule %q, want e.g. download<50 or latency>100 (download, upload, latency, jitter or loss)", field)
+		}
+		limit, _ := strconv.ParseFloat(m[3], 64)
+		rules = append(rules, alertRule{text: m[1] + m[2] + m[3], metric: m[1], below: m[2] == "<", limit: limit})
+	}
+	return rules, nil
+}
+
+// Alert tracks one rule breached by the results of one subject: a peer, a
+// device or a client address. It fires on the first breaching result and
+// resolves on the first result within the threshold again.
+type Alert struct {
+	ID             string     `json:"id"`
+	Rule           string     `json:"rule"`
+	Subject        string     `json:"subject"`
+	State          string     `json:"state"` // firing or resolved
+	FiredAt        time.Time  `json:"firedAt"`
+	ResolvedAt     *time.Time `json:"resolvedAt,omitempty"`
+	LastBreach     time.Time  `json:"lastBreach"`
+	Breaches       int        `json:"breaches"` // breaching results since it fired
+	ResultID       string     `json:"resultId"` // the latest breaching result
+	Value          float64    `json:"value"`    // its metric
+	Acknowledged   bool       `json:"acknowledged"`
+	AcknowledgedAt *time.Time `json:"acknowledgedAt,omitempty"`
+	SilencedUntil  *time.Time `json:"silencedUntil,omitempty"` // no notifications or escalation until then
+	Escalated      bool       `json:"escalated"`
+	Suppressed     int        `json:"suppressed"` // notifications held back by -alert-dedupe or a silence
+
+	lastNotified      time.Time
+	lastNotifiedState string
+}
+
+// AlertStore is implemented by result stores that keep the state of alerts,
+// so firing alerts, acknowledgements and silences survive restarts.
+type AlertStore interface {
+	// SaveAlert stores an alert, replacing the one with its ID.
+	SaveAlert(alert Alert) error
+	// Alerts returns the stored alerts.
+	Alerts() ([]Alert, error)
+	// DeleteAlert removes an alert; removing a missing one is not an error.
+	DeleteAlert(id string) error
+}
+
+// maxAlertSilence bounds how long an alert can be silenced.
+const maxAlertSilence = 30 * 24 * time.Hour
+
+// alerts holds the state of every alert, keyed by rule and subject.
+var alerts = struct {
+	sync.Mutex
+	rules      []alertRule
+	byKey      map[string]*Alert
+	escalation Notifier // nil without -alert-escalation-url
+}{byKey: make(map[string]*Alert)}
+
+// setupAlerts parses the alert flags; it runs after setupNotifiers.
+func setupAlerts() error {
+	rules, err := parseAlertRules(*alertRules)
+	if err != nil {
+		return err
+	}
+	if *alertDedupe < 0 || *alertEscalateAfter < 0 {
+		return fmt.Errorf("-alert-dedupe and -alert-escalate-after must not be negative")
+	}
+	if (*alertEscalateAfter > 0) != (*alertEscalationURL != "") {
+		return fmt.Errorf("-alert-escalate-after and -alert-escalation-url must be set together")
+	}
+	alerts.rules = rules
+	if *alertEscalationURL != "" {
+		w, err := newWebhookNotifier(*alertEscalationURL, *webhookFormat, *webhookTemplate)
+		if err != nil {
+			return err
+		}
+		alerts.escalation = w
+	}
+	if len(rules) > 0 {
+		log.Printf("Alerting on %d rules", len(rules))
+	}
+	return nil
+}
+
+// restoreAlerts loads the alerts kept by the store; it runs once the store is
+// open. Alerts of rules no longer in -alert-rules, or of a changed
+// -cert-expiry-alert, are dropped.
+func restoreAlerts() {
+	store, ok := optionalStore[AlertStore]()
+	if !ok || (len(alerts.rules) == 0 && *certExpiryAlert <= 0) {
+		return
+	}
+	list, err := store.Alerts()
+	if err != nil {
+		log.Printf("Failed to load alerts: %v", err)
+		return
+	}
+	alerts.Lock()
+	defer alerts.Unlock()
+	restored := 0
+	for _, stored := range list {
+		known := slices.ContainsFunc(alerts.rules, func(r alertRule) bool { return r.text == stored.Rule })
+		if !known && !(*certExpiryAlert > 0 && stored.Rule == certExpiryRule()) {
+			deleteAlert(stored.ID)
+			continue
+		}
+		a := &stored
+		// Their state was announced before the restart.
+		a.lastNotifiedState, a.lastNotified = a.State, a.FiredAt
+		if a.State == alertResolved {
+			a.lastNotified = *a.ResolvedAt
+		}
+		alerts.byKey[a.Rule+"\x00"+a.Subject] = a
+		if a.State == alertFiring && !a.Acknowledged && !a.Escalated {
+			armEscalation(a)
+		}
+		restored++
+	}
+	if restored > 0 {
+		log.Printf("Restored %d alerts", restored)
+	}
+}
+
+// saveAlert stores the state of an alert, if the store keeps alerts. The
+// caller holds the alerts lock, so writes of one alert stay in order.
+func saveAlert(a *Alert) {
+	if store, ok := optionalStore[AlertStore](); ok {
+		if err := store.SaveAlert(*a); err != nil {
+			log.Printf("Failed to save alert %s: %v", a.ID, err)
+		}
+	}
+}
+
+// deleteAlert removes an alert from the store, if it keeps alerts.
+func deleteAlert(id string) {
+	if store, ok := optionalStore[AlertStore](); ok {
+		if err := store.DeleteAlert(id); err != nil {
+			log.Printf("Failed to delete alert %s: %v", id, err)
+		}
+	}
+}
+
+// armEscalation escalates a firing alert -alert-escalate-after after it
+// fired, unless it is acknowledged or resolved by then.
+func armEscalation(a *Alert) {
+	if *alertEscalateAfter > 0 {
+		firedAt := a.FiredAt
+		time.AfterFunc(time.Until(firedAt.Add(*alertEscalateAfter)), func() { escalateAlert(a, firedAt) })
+	}
+}
+
+// silenced reports whether the alert is silenced at now.
+func (a *Alert) silenced(now time.Time) bool {
+	return a.SilencedUntil != nil && now.Before(*a.SilencedUntil)
+}
+
+// alertSubject names where a result was measured from, so each path alerts
+// separately.
+func alertSubject(result TestResult) string {
+	switch {
+	case result.Tags["peer"] != "":
+		return "peer " + result.Tags["peer"]
+	case result.ClientID != "":
+		return "client " + result.ClientID
+	default:
+		return "ip " + result.ClientIP
+	}
+}
+
+// checkAlerts updates the alerts of the result's subject and notifies of
+// those that fired or resolved.
+func checkAlerts(result TestResult, resultURL string) {
+	if len(alerts.rules) == 0 {
+		return
+	}
+	now := time.Now()
+	subject := alertSubject(result)
+	var events []NotificationEvent
+
+	alerts.Lock()
+	for _, rule := range alerts.rules {
+		key := rule.text + "\x00" + subject
+		a := alerts.byKey[key]
+		breached := rule.breached(result)
+		switch {
+		case breached && (a == nil || a.State == alertResolved):
+			if a == nil {
+				a = &Alert{ID: uuid.NewString(), Rule: rule.text, Subject: subject}
+				alerts.byKey[key] = a
+			}
+			a.State, a.FiredAt, a.ResolvedAt, a.Breaches = alertFiring, now, nil, 0
+			a.Acknowledged, a.AcknowledgedAt, a.Escalated = false, nil, false
+			armEscalation(a)
+			log.Printf("Alert %s fired for %s: %.2f", rule.text, subject, rule.value(result))
+			fallthrough
+		case breached:
+			a.LastBreach, a.ResultID, a.Value = now, result.ID, rule.value(result)
+			a.Breaches++
+		case a != nil && a.State == alertFiring:
+			a.State, a.ResolvedAt = alertResolved, &now
+			log.Printf("Alert %s resolved for %s", rule.text, subject)
+		default:
+			continue
+		}
+		if event, ok := a.notification(now, result, resultURL); ok {
+			events = append(events, event)
+		}
+		saveAlert(a)
+	}
+	for key, a := range alerts.byKey {
+		if a.State == alertResolved && now.Sub(*a.ResolvedAt) > resolvedAlertRetention && !a.silenced(now) {
+			delete(alerts.byKey, key)
+			deleteAlert(a.ID)
+		}
+	}
+	alerts.Unlock()
+
+	for _, event := range events {
+		dispatchEvent(event)
+	}
+}
+
+// notification returns the event announcing the alert's state, unless it
+// repeats the last one, the alert is silenced or it comes within
+// -alert-dedupe of the last one. A link flapping between firing and resolved
+// is announced once per window. The caller holds the alerts lock.
+func (a *Alert) notification(now time.Time, result TestResult, resultURL string) (NotificationEvent, bool) {
+	if a.lastNotifiedState == a.State || (a.State == alertResolved && a.lastNotifiedState == "") {
+		return NotificationEvent{}, false
+	}
+	if a.silenced(now) {
+		a.Suppressed++
+		return NotificationEvent{}, false
+	}
+	if a.State == alertFiring && !a.lastNotified.IsZero() && now.Sub(a.lastNotified) < *alertDedupe {
+		a.Suppressed++
+		return NotificationEvent{}, false
+	}
+	a.lastNotified, a.lastNotifiedState = now, a.State
+	alert := *a
+	return NotificationEvent{Type: "alert", Server: serverHostname(), Result: result, ResultURL: resultURL, Alert: &alert, Time: now}, true
+}
+
+// escalateAlert sends an alert that fired at firedAt and is still firing
+// unacknowledged to the escalation channel. A silenced alert is escalated
+// when its silence ends.
+func escalateAlert(a *Alert, firedAt time.Time) {
+	alerts.Lock()
+	if a.State != alertFiring || !a.FiredAt.Equal(firedAt) || a.Acknowledged || a.Escalated {
+		alerts.Unlock()
+		return
+	}
+	if a.silenced(time.Now()) {
+		time.AfterFunc(time.Until(*a.SilencedUntil), func() { escalateAlert(a, firedAt) })
+		alerts.Unlock()
+		return
+	}
+	a.Escalated = true
+	saveAlert(a)
+	alert := *a
+	alerts.Unlock()
+
+	log.Printf("Alert %s for %s is unacknowledged after %s, escalating", alert.Rule, alert.Subject, *alertEscalateAfter)
+	result, err := globalStore.Load(alert.ResultID)
+	if err != nil {
+		result = TestResult{ID: alert.ResultID}
+	}
+	event := NotificationEvent{Type: "alert", Server: serverHostname(), Result: result,
+		ResultURL: shareURL(*publicURL, alert.ResultID), Alert: &alert, Time: time.Now()}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := alerts.escalation.Notify(ctx, event); err != nil {
+		log.Printf("Alert escalation failed: %v", err)
+	}
+}
+
+// alertTitle is the notification title of an alert event.
+func alertTitle(event NotificationEvent) string {
+	a := event.Alert
+	switch {
+	case a.State == alertResolved:
+		return fmt.Sprintf(localize(*defaultLocale, "Resolved on %s: %s (%s)"), event.Server, a.Rule, a.Subject)
+	case a.Escalated:
+		return fmt.Sprintf(localize(*defaultLocale, "Unacknowledged alert on %s: %s (%s)"), event.Server, a.Rule, a.Subject)
+	default:
+		return fmt.Sprintf(localize(*defaultLocale, "Alert on %s: %s (%s)"), event.Server, a.Rule, a.Subject)
+	}
+}
+
+// adminAlertsHandler serves GET /admin/api/alerts: firing alerts first, then
+// recently resolved ones, newest first. ?state=firing or ?state=resolved
+// lists only those.
+func adminAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	p := newParamReader(r.URL.Query())
+	state := p.Enum("state", "", alertFiring, alertResolved)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	alerts.Lock()
+	list := make([]Alert, 0, len(alerts.byKey))
+	for _, a := range alerts.byKey {
+		if state == "" || a.State == state {
+			list = append(list, *a)
+		}
+	}
+	alerts.Unlock()
+	slices.SortFunc(list, func(a, b Alert) int {
+		if a.State != b.State {
+			return strings.Compare(a.State, b.State) // firing < resolved
+		}
+		return b.FiredAt.Compare(a.FiredAt)
+	})
+	writeJSON(w, list)
+}
+
+// adminAlertHandler serves the actions on one alert:
+//
+//	POST   /admin/api/alerts/{id}/ack              stop it from being escalated
+//	POST   /admin/api/alerts/{id}/silence?for=2h  hold back its notifications and escalation for a while
+//	DELETE /admin/api/alerts/{id}/silence         end the silence
+func adminAlertHandler(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/api/alerts/"), "/")
+	switch action {
+	case "ack":
+		if r.Method != http.MethodPost {
+			http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+			return
+		}
+	case "silence":
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, tr(r, "Only POST and DELETE methods are supported"), http.StatusMethodNotAllowed)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	var silence time.Duration
+	if action == "silence" && r.Method == http.MethodPost {
+		p := newParamReader(r.URL.Query())
+		silence = p.Duration("for", time.Hour, time.Minute, maxAlertSilence)
+		if err := p.Err(); err != nil {
+			badRequest(w, err)
+			return
+		}
+	}
+
+	alerts.Lock()
+	defer alerts.Unlock()
+	var a *Alert
+	for _, candidate := range alerts.byKey {
+		if candidate.ID == id {
+			a = candidate
+			break
+		}
+	}
+	if a == nil {
+		http.Error(w, tr(r, "Alert not found"), http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	switch {
+	case action == "ack":
+		a.Acknowledged, a.AcknowledgedAt = true, &now
+		log.Printf("Alert %s for %s acknowledged", a.Rule, a.Subject)
+	case r.Method == http.MethodPost:
+		until := now.Add(silence)
+		a.SilencedUntil = &until
+		log.Printf("Alert %s for %s silenced until %s", a.Rule, a.Subject, until.Format(time.RFC3339))
+	default:
+		a.SilencedUntil = nil
+		log.Printf("Alert %s for %s unsilenced", a.Rule, a.Subject)
+	}
+	saveAlert(a)
+	writeJSON(w, *a)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Results are shared by their UUID. An admin can also give a result one or
+// more memorable aliases, served at /r/{alias}, e.g.
+// /r/office-fiber-before-upgrade.
+
+var (
+	errAliasTaken    = errors.New("alias is already taken by another result")
+	errAliasNotFound = errors.New("alias not found")
+)
+
+// aliasPattern is lowercase words joined by hyphens, 3 to 64 characters.
+var aliasPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}[a-z0-9]$`)
+
+// AliasStore is implemented by result stores that can give results aliases.
+// Aliases are deleted along with their result.
+type AliasStore interface {
+	// AddAlias points alias at an existing result. Adding an alias the result
+	// already has is not an error; errAliasTaken means another result has it.
+	AddAlias(alias, resultID string) error
+	// ResolveAlias returns the ID of the result alias points at, or errAliasNotFound.
+	ResolveAlias(alias string) (string, error)
+	// Aliases returns the aliases of a result, sorted.
+	Aliases(resultID string) ([]string, error)
+	// DeleteAlias removes an alias, or returns errAliasNotFound.
+	DeleteAlias(alias string) error
+}
+
+// ResultAlias is the request and response of POST /results/{id}/aliases.
+type ResultAlias struct {
+	Alias    string `json:"alias"`
+	ResultID string `json:"resultId"`
+}
+
+// validateAlias checks an alias submitted by an admin.
+func validateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) || strings.Contains(alias, "--") {
+		return &paramError{"alias", "must be 3 to 64 lowercase letters, digits and single hyphens, not starting or ending with a hyphen"}
+	}
+	return nil
+}
+
+// aliasesHandler serves /results/{id}/aliases: GET lists the aliases of a
+// result, POST adds one and requires the admin token.
+func aliasesHandler(w http.ResponseWriter, r *http.Request, resultID string) {
+	store, ok := optionalStore[AliasStore]()
+	if !ok {
+		http.Error(w, tr(r, "Aliases are not supported by this store"), http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if _, err := globalStore.Load(resultID); err != nil {
+			resultError(w, r, resultID, err)
+			return
+		}
+		aliases, err := store.Aliases(resultID)
+		if err != nil {
+			log.Printf("Error loading aliases of result ID %s: %v", resultID, err)
+			http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+			return
+		}
+		if aliases == nil {
+			aliases = []string{}
+		}
+		writeJSON(w, aliases)
+	case http.MethodPost:
+		requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, 16*1024)
+			var alias ResultAlias
+			if err := json.NewDecoder(r.Body).Decode(&alias); err != nil {
+				http.Error(w, tr(r, "Invalid JSON alias"), http.StatusBadRequest)
+				return
+			}
+			if err := validateAlias(alias.Alias); err != nil {
+				badRequest(w, err)
+				return
+			}
+			alias.ResultID = resultID
+			if err := store.AddAlias(alias.Alias, resultID); errors.Is(err, errAliasTaken) {
+				http.Error(w, tr(r, "Alias is already taken by another result"), http.StatusConflict)
+				return
+			} else if err != nil {
+				resultError(w, r, resultID, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			if err := json.NewEncoder(w).Encode(alias); err != nil {
+				log.Printf("Failed to encode alias: %v", err)
+			}
+		})(w, r)
+	default:
+		http.Error(w, tr(r, "Only GET and POST methods are supported"), http.StatusMethodNotAllowed)
+	}
+}
+
+// aliasRedirectHandler serves /r/{alias}: GET redirects to the share page of
+// the result, DELETE removes the alias and requires the admin token.
+func aliasRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	alias := strings.TrimPrefix(r.URL.Path, "/r/")
+	store, ok := optionalStore[AliasStore]()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		id, err := store.ResolveAlias(alias)
+		if errors.Is(err, errAliasNotFound) {
+			http.Error(w, tr(r, "Alias not found"), http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error resolving alias %s: %v", alias, err)
+			http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/share/"+id, http.StatusFound)
+	case http.MethodDelete:
+		requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+			if err := store.DeleteAlias(alias); errors.Is(err, errAliasNotFound) {
+				http.Error(w, tr(r, "Alias not found"), http.StatusNotFound)
+				return
+			} else if err != nil {
+				log.Printf("Error deleting alias %s: %v", alias, err)
+				http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+				return
+			}
+			log.Printf("Alias %s deleted", alias)
+			w.WriteHeader(http.StatusNoContent)
+		})(w, r)
+	default:
+		http.Error(w, tr(r, "Only GET and DELETE methods are supported"), http.StatusMethodNotAllowed)
+	}
+}
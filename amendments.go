@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Results are never modified once saved, so the original submission stays
+// available if a result is disputed. Later corrections and annotations are
+// stored as amendments linked to the result, in the order they were made.
+
+// Amendment kinds and the meaning of their value.
+const (
+	amendVerified = "verified" // "true" or "false", overriding the result's verified flag
+	amendTicket   = "ticket"   // a support ticket reference
+	amendNote     = "note"     // free text
+)
+
+const (
+	maxAmendmentValueLength  = 2000
+	maxAmendmentAuthorLength = 100
+)
+
+// Amendment is an annotation or correction of a stored result.
+type Amendment struct {
+	ID        string    `json:"id"`
+	ResultID  string    `json:"resultId"`
+	Timestamp time.Time `json:"timestamp"`
+	Kind      string    `json:"kind"`
+	Value     string    `json:"value"`
+	Author    string    `json:"author,omitempty"`
+}
+
+// AmendmentStore is implemented by result stores that can record amendments.
+// Amendments are deleted along with their result.
+type AmendmentStore interface {
+	// SaveAmendment stores an amendment of an existing result, assigning its
+	// ID and timestamp.
+	SaveAmendment(amendment Amendment) (Amendment, error)
+	// Amendments returns the amendments of a result, oldest first.
+	Amendments(resultID string) ([]Amendment, error)
+}
+
+// amendedResult is a stored result as served by GET /results/{id}: the
+// original submission, followed by its amendments.
+type amendedResult struct {
+	TestResult
+	Amendments []Amendment      `json:"amendments,omitempty"`
+	Signature  *ResultSignature `json:"signature,omitempty"` // with ?verify=1; covers the result, not its amendments
+}
+
+// validateAmendment checks an amendment submitted by a client.
+func validateAmendment(a Amendment) error {
+	switch a.Kind {
+	case amendVerified:
+		if _, err := strconv.ParseBool(a.Value); err != nil {
+			return &paramError{"value", "must be true or false for a verified amendment"}
+		}
+	case amendTicket, amendNote:
+		if strings.TrimSpace(a.Value) == "" {
+			return &paramError{"value", "must not be empty"}
+		}
+	default:
+		return &paramError{"kind", fmt.Sprintf("must be one of %s, %s or %s", amendVerified, amendTicket, amendNote)}
+	}
+	if utf8.RuneCountInString(a.Value) > maxAmendmentValueLength {
+		return &paramError{"value", fmt.Sprintf("must be at most %d characters", maxAmendmentValueLength)}
+	}
+	if utf8.RuneCountInString(a.Author) > maxAmendmentAuthorLength {
+		return &paramError{"author", fmt.Sprintf("must be at most %d characters", maxAmendmentAuthorLength)}
+	}
+	return nil
+}
+
+// amendmentsHandler serves /results/{id}/amendments: GET lists the
+// amendments of a result, POST adds one and requires the admin token.
+func amendmentsHandler(w http.ResponseWriter, r *http.Request, resultID string) {
+	store, ok := optionalStore[AmendmentStore]()
+	if !ok {
+		http.Error(w, tr(r, "Amendments are not supported by this store"), http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if _, err := globalStore.Load(resultID); err != nil {
+			resultError(w, r, resultID, err)
+			return
+		}
+		amendments, err := store.Amendments(resultID)
+		if err != nil {
+			log.Printf("Error loading amendments of result ID %s: %v", resultID, err)
+			http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+			return
+		}
+		if amendments == nil {
+			amendments = []Amendment{}
+		}
+		writeJSON(w, amendments)
+	case http.MethodPost:
+		requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, 16*1024)
+			var amendment Amendment
+			if err := json.NewDecoder(r.Body).Decode(&amendment); err != nil {
+				http.Error(w, tr(r, "Invalid JSON amendment"), http.StatusBadRequest)
+				return
+			}
+			if err := validateAmendment(amendment); err != nil {
+				badRequest(w, err)
+				return
+			}
+			amendment.ResultID = resultID
+			saved, err := store.SaveAmendment(amendment)
+			if err != nil {
+				resultError(w, r, resultID, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			if err := json.NewEncoder(w).Encode(saved); err != nil {
+				log.Printf("Failed to encode amendment: %v", err)
+			}
+		})(w, r)
+	default:
+		http.Error(w, tr(r, "Only GET and POST methods are supported"), http.StatusMethodNotAllowed)
+	}
+}
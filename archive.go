@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	archiveBatchSize = 10000 // results per object
+	archiveTimeout   = 2 * time.Minute
+	archiveStateName = "archive-state.json"
+)
+
+var (
+	archivedResults = newCounterVec("netspeed_archived_results_total",
+		"Results exported to object storage by the archiver.")
+	archiveFailures = newCounterVec("netspeed_archive_failures_total",
+		"Archive runs that failed; the results are retried on the next run.")
+)
+
+// ArchiveState is stored next to the archived objects and records how far
+// the archiver got, so restarts and other instances sharing the bucket
+// prefix carry on where it stopped.
+type ArchiveState struct {
+	ArchivedThrough time.Time `json:"archivedThrough"` // timestamp of the newest archived result
+	LastObject      string    `json:"lastObject,omitempty"`
+}
+
+// s3Client talks to an S3-compatible object store with path-style URLs and
+// Signature Version 4, which AWS, MinIO, Ceph and most others accept.
+type s3Client struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// newArchiveClient configures the object store from the -archive-s3-* flags,
+// taking credentials missing from the flags from the standard AWS
+// environment variables.
+func newArchiveClient() (*s3Client, error) {
+	endpoint, err := url.Parse(*archiveEndpoint)
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+		return nil, fmt.Errorf("-archive-s3-endpoint must be an http(s) URL")
+	}
+	c := &s3Client{
+		endpoint:  endpoint,
+		region:    *archiveRegion,
+		bucket:    *archiveBucket,
+		accessKey: *archiveAccessKey,
+		secretKey: *archiveSecretKey,
+		client:    &http.Client{Timeout: archiveTimeout},
+	}
+	if c.accessKey == "" {
+		c.accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if c.secretKey == "" {
+		c.secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, fmt.Errorf("-archive-s3-bucket needs -archive-s3-access-key and -archive-s3-secret-key (or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	}
+	return c, nil
+}
+
+// s3Escape percent-encodes everything but unreserved characters and slashes,
+// as Signature Version 4 expects of the canonical URI.
+func s3Escape(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// do sends a signed request for the object key and fails on a non-2xx status.
+func (c *s3Client) do(ctx context.Context, method, key string, body []byte, contentType string) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket + "/" + key
+	u.RawPath = s3Escape(u.Path)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	canonical := strings.Join([]string{
+		method,
+		u.RawPath,
+		"", // no query
+		"host:" + u.Host,
+		"x-amz-content-sha256:" + hex.EncodeToString(payloadHash[:]),
+		"x-amz-date:" + amzDate,
+		"",
+		"host;x-amz-content-sha256;x-amz-date",
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := now.Format("20060102") + "/" + c.region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	signingKey := hmacSHA256([]byte("AWS4"+c.secretKey), now.Format("20060102"))
+	for _, part := range []string{c.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%s",
+		c.accessKey, scope, hex.EncodeToString(hmacSHA256(signingKey, stringToSign))))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp, fmt.Errorf("%s %s: status %d: %s", method, key, resp.StatusCode, bytes.TrimSpace(detail))
+	}
+	return resp, nil
+}
+
+// put uploads an object.
+func (c *s3Client) put(ctx context.Context, key string, body []byte, contentType string) error {
+	resp, err := c.do(ctx, http.MethodPut, key, body, contentType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// loadState reads the archive state, which is empty before the first run.
+func (c *s3Client) loadState(ctx context.Context) (ArchiveState, error) {
+	var state ArchiveState
+	resp, err := c.do(ctx, http.MethodGet, *archivePrefix+archiveStateName, nil, "")
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return state, fmt.Errorf("invalid %s: %w", archiveStateName, err)
+	}
+	return state, nil
+}
+
+// archiveResults exports the results saved since the last run, oldest
+// first, as gzip-compressed JSON lines of up to archiveBatchSize results per
+// object. The state is advanced after each object, so a failed run resumes
+// with the first batch that was not uploaded.
+func archiveResults(ctx context.Context, c *s3Client) (int, error) {
+	state, err := c.loadState(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var pending []TestResult
+	err = globalStore.IterateMatching(ResultFilter{From: state.ArchivedThrough}, func(result TestResult) error {
+		if result.Timestamp.After(state.ArchivedThrough) {
+			pending = append(pending, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Timestamp.Before(pending[j].Timestamp) })
+
+	archived := 0
+	for len(pending) > 0 {
+		batch := pending[:min(len(pending), archiveBatchSize)]
+		pending = pending[len(batch):]
+
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		enc := json.NewEncoder(zw)
+		for _, result := range batch {
+			if err := enc.Encode(result); err != nil {
+				return archived, err
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return archived, err
+		}
+		first, last := batch[0].Timestamp.UTC(), batch[len(batch)-1].Timestamp.UTC()
+		key := fmt.Sprintf("%sresults/%s/%s-%s.ndjson.gz", *archivePrefix, first.Format("2006/01/02"),
+			first.Format("20060102T150405.000000000Z"), last.Format("20060102T150405.000000000Z"))
+		if err := c.put(ctx, key, buf.Bytes(), "application/x-ndjson"); err != nil {
+			return archived, err
+		}
+
+		state = ArchiveState{ArchivedThrough: last, LastObject: key}
+		data, err := json.Marshal(state)
+		if err != nil {
+			return archived, err
+		}
+		if err := c.put(ctx, *archivePrefix+archiveStateName, data, "application/json"); err != nil {
+			return archived, err
+		}
+		archived += len(batch)
+		archivedResults.Add(float64(len(batch)))
+		log.Printf("Archived %d results to s3://%s/%s", len(batch), c.bucket, key)
+	}
+	return archived, nil
+}
+
+// startArchiver exports new results to -archive-s3-bucket at startup and
+// then every -archive-interval.
+func startArchiver() {
+	if *archiveBucket == "" {
+		return
+	}
+	c, err := newArchiveClient()
+	if err != nil {
+		log.Printf("Warning: result archiving disabled: %v", err)
+		return
+	}
+	log.Printf("Archiving results to s3://%s/%s every %s", *archiveBucket, *archivePrefix, *archiveInterval)
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(serverContext, *archiveInterval)
+			if _, err := archiveResults(ctx, c); err != nil {
+				archiveFailures.Inc()
+				log.Printf("Failed to archive results: %v", err)
+			}
+			cancel()
+			select {
+			case <-time.After(*archiveInterval):
+			case <-serverContext.Done():
+				return
+			}
+		}
+	}()
+}
+
+// validateArchive checks the archive flags at startup.
+func validateArchive() error {
+	if *archiveBucket == "" {
+		return nil
+	}
+	if *archiveInterval < time.Minute {
+		return fmt.Errorf("-archive-interval must be at least 1m")
+	}
+	if *archivePrefix != "" && !strings.HasSuffix(*archivePrefix, "/") {
+		return fmt.Errorf("-archive-s3-prefix must end with a slash")
+	}
+	_, err := newArchiveClient()
+	return err
+}
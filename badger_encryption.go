@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// badgerIndexCacheSize bounds the decrypted table indexes Badger keeps in
+// memory, which it recommends setting when encryption is enabled.
+const badgerIndexCacheSize = 100 << 20
+
+// readEncryptionKey reads an AES key file: 16, 24 or 32 raw bytes, or their
+// hex encoding as "openssl rand -hex 32" writes. An empty path is no key.
+func readEncryptionKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch len(data) {
+	case 16, 24, 32:
+		return data, nil
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err == nil {
+		switch len(key) {
+		case 16, 24, 32:
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%s must hold a 16, 24 or 32 byte key, raw or hex-encoded", path)
+}
+
+// badgerKeyError explains an encryption key the store does not accept.
+func badgerKeyError(err error) error {
+	if errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+		return fmt.Errorf("%w: -badger-encryption-key-file does not match the key the store was encrypted with; "+
+			"change keys with the rotate-badger-key command", err)
+	}
+	return err
+}
+
+// runRotateBadgerKey re-encrypts the Badger store's data keys under a new
+// encryption key while the server is stopped: from -badger-old-encryption-key-file
+// (unencrypted when empty) to -badger-encryption-key-file (unencrypted when empty).
+// The results themselves are encrypted with the data keys and are not rewritten.
+func runRotateBadgerKey() {
+	if *storeType != "badger" || *badgerPath == "" {
+		fmt.Fprintln(os.Stderr, "rotate-badger-key only applies to the badger store on disk (-badger-path)")
+		os.Exit(2)
+	}
+	oldKey, err := readEncryptionKey(*badgerOldKeyFile)
+	if err != nil {
+		log.Fatalf("Invalid old encryption key: %v", err)
+	}
+	newKey, err := readEncryptionKey(*badgerKeyFile)
+	if err != nil {
+		log.Fatalf("Invalid encryption key: %v", err)
+	}
+	if len(oldKey) == 0 && len(newKey) == 0 {
+		fmt.Fprintln(os.Stderr, "rotate-badger-key needs -badger-encryption-key-file, -badger-old-encryption-key-file or both")
+		os.Exit(2)
+	}
+
+	// Opening the store checks the old key and that no server holds the store
+	opts := badger.DefaultOptions(*badgerPath).WithEncryptionKey(oldKey)
+	if len(oldKey) > 0 {
+		opts = opts.WithIndexCacheSize(badgerIndexCacheSize)
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		if errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+			log.Fatalf("-badger-old-encryption-key-file does not match the key the store is encrypted with")
+		}
+		log.Fatalf("Failed to open Badger KV store: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		log.Fatalf("Failed to close Badger KV store: %v", err)
+	}
+
+	registryOpts := badger.KeyRegistryOptions{
+		Dir:                           *badgerPath,
+		ReadOnly:                      true,
+		EncryptionKey:                 oldKey,
+		EncryptionKeyRotationDuration: *badgerKeyRotation,
+	}
+	registry, err := badger.OpenKeyRegistry(registryOpts)
+	if err != nil {
+		log.Fatalf("Failed to read the key registry: %v", err)
+	}
+	registryOpts.EncryptionKey = newKey
+	if err := badger.WriteKeyRegistry(registry, registryOpts); err != nil {
+		log.Fatalf("Failed to write the key registry: %v", err)
+	}
+	switch {
+	case len(oldKey) == 0:
+		log.Printf("Badger store %s is now encrypted; existing results are encrypted as compactions rewrite them", *badgerPath)
+	case len(newKey) == 0:
+		log.Printf("Badger store %s is no longer protected by an encryption key", *badgerPath)
+	default:
+		log.Printf("Badger store %s is now encrypted with the new key", *badgerPath)
+	}
+}
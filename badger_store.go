@@ -0,0 +1,1431 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+)
+
+// BadgerStore implements ResultStore using the Badger Key-Value database.
+type BadgerStore struct {
+	db *badger.DB
+
+	// Optional quota; zero means unlimited. Usage counts result records only
+	// (key plus encoded value), not index entries or Badger overhead.
+	maxResults int64
+	maxBytes   int64
+	count      atomic.Int64
+	bytes      atomic.Int64
+	evictMu    sync.Mutex
+
+	resultTTL time.Duration // results expire this long after they were saved; zero keeps them
+	stopGC    chan struct{}
+
+	maintenanceMu sync.Mutex // held by admin maintenance tasks, one at a time
+}
+
+// valueLogGCInterval is how often the value log is compacted, reclaiming the
+// space of expired and deleted entries.
+const valueLogGCInterval = 10 * time.Minute
+
+// NewBadgerStore initializes and returns a BadgerStore instance. With an
+// encryptionKey, results are encrypted at rest with data keys that Badger
+// replaces every keyRotation and keeps encrypted under encryptionKey.
+func NewBadgerStore(path string, encryptionKey []byte, keyRotation time.Duration) (*BadgerStore, error) {
+	opts := badger.DefaultOptions(path)
+	if len(encryptionKey) > 0 {
+		opts = opts.WithEncryptionKey(encryptionKey).
+			WithEncryptionKeyRotationDuration(keyRotation).
+			WithIndexCacheSize(badgerIndexCacheSize)
+	}
+
+	// If path is empty, set Badger to run entirely in-memory.
+	if path == "" {
+		opts = opts.WithInMemory(true)
+		log.Println("Badger configured for IN-MEMORY storage (data will be lost on exit).")
+	} else {
+		// Ensure the directory exists for file storage
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create badger directory: %w", err)
+		}
+		log.Printf("Badger configured for FILE storage at: %s", path)
+	}
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger db: %w", badgerKeyError(err))
+	}
+
+	store := &BadgerStore{db: db, stopGC: make(chan struct{})}
+	if !opts.InMemory {
+		go store.runValueLogGC()
+	}
+	if version, _ := store.indexVersion(); version != currentIndexVersion {
+		log.Printf("Secondary indexes are missing or outdated (version %d, want %d), rebuilding...", version, currentIndexVersion)
+		if err := store.Reindex(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to rebuild indexes: %w", err)
+		}
+	}
+	return store, nil
+}
+
+// Save generates a unique ID, saves the result, and returns the ID.
+func (s *BadgerStore) Save(result TestResult) (string, error) {
+	result.ID = uuid.New().String()
+	result.Timestamp = time.Now() // Use server time for official record
+	return s.save(result)
+}
+
+// Import saves a result with its original ID and timestamp.
+func (s *BadgerStore) Import(result TestResult) (string, error) {
+	return s.save(result)
+}
+
+// save stores result under its ID and timestamp.
+func (s *BadgerStore) save(result TestResult) (string, error) {
+	id := result.ID
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.Update(func(txn *badger.Txn) error {
+		// Results are append-only; never overwrite one, however unlikely the collision
+		if _, err := txn.Get([]byte(id)); err != badger.ErrKeyNotFound {
+			if err == nil {
+				err = fmt.Errorf("result %s: %w", id, errResultExists)
+			}
+			return err
+		}
+		if err := txn.SetEntry(s.resultEntry([]byte(id), data, result.Timestamp)); err != nil {
+			return err
+		}
+		for _, key := range indexKeys(result) {
+			if err := txn.SetEntry(s.resultEntry(key, nil, result.Timestamp)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return id, err
+	}
+	log.Printf("Result saved with ID: %s", id)
+
+	s.count.Add(1)
+	s.bytes.Add(int64(len(id) + len(data)))
+	if err := s.enforceQuota(); err != nil {
+		log.Printf("Failed to enforce store quota: %v", err)
+	}
+	return id, nil
+}
+
+// Load retrieves a result by its unique ID.
+func (s *BadgerStore) Load(id string) (TestResult, error) {
+	var result TestResult
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		result, err = loadResult(txn, id)
+		return err // badger.ErrKeyNotFound or other errors
+	})
+
+	if err == badger.ErrKeyNotFound {
+		return TestResult{}, fmt.Errorf("result not found for ID: %s", id)
+	}
+	return result, err
+}
+
+// Delete removes a result, its index entries and its amendments.
+func (s *BadgerStore) Delete(id string) error {
+	if !isResultKey([]byte(id)) {
+		return fmt.Errorf("result not found for ID: %s", id)
+	}
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(id))
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return fmt.Errorf("result not found for ID: %s", id)
+	} else if err != nil {
+		return err
+	}
+	if err := s.evict(id); err != nil {
+		return err
+	}
+	log.Printf("Result deleted with ID: %s", id)
+	return nil
+}
+
+// Iterate calls fn for every stored result in key order, stopping at the first error.
+func (s *BadgerStore) Iterate(fn func(result TestResult) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !isResultKey(item.Key()) {
+				continue
+			}
+			var result TestResult
+			if err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &result)
+			}); err != nil {
+				return err
+			}
+			result.ID = string(item.Key())
+			if err := fn(result); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Keyspace layout: results are stored under their bare UUID. Everything else
+// lives under a reserved prefix so it can never collide with a result ID.
+//
+//	idx:ts:<unix nanos>:<id>               every result, in time order
+//	idx:tag:<key>=<value>:<unix nanos>:<id> one per tag
+//	idx:verified:<unix nanos>:<id>         verified results only
+//	idx:client:<client id>:<unix nanos>:<id> results saved by one device
+//	meta:index-version                     layout version of the idx: keys
+//	meta:result-ttl                        retention the stored results expire by
+//	err:<unix nanos>:<uuid>                client test error reports (with TTL)
+//	probe:<unix nanos>:<uuid>              external target probe results (with TTL)
+//	snap:<period>:<unix nanos>             report snapshots, by period start
+//	amend:<id>:<unix nanos>:<uuid>         amendments of a result, expiring with it
+//	alias:<alias>                          the ID of the result an alias points at, expiring with it
+//	ralias:<id>:<alias>                    the aliases of a result, expiring with it
+//	alert:<uuid>                           alert state, see alerts.go
+//	recurring:<uuid>                       recurring test registrations, see recurring.go
+//	owner:<owner>:<unix nanos>:<id>        results saved by one owner, see owner.go, expiring with them
+//	rowner:<id>:<owner>                    the owners of a result, expiring with it
+//	sig:<id>                               the signature of a result, see signing.go, expiring with it
+const (
+	metaKeyPrefix        = "meta:"
+	amendmentKeyPrefix   = "amend:"
+	aliasKeyPrefix       = "alias:"
+	resultAliasKeyPrefix = "ralias:"
+	alertKeyPrefix       = "alert:"
+	recurringKeyPrefix   = "recurring:"
+	ownerKeyPrefix       = "owner:"
+	resultOwnerKeyPrefix = "rowner:"
+	signatureKeyPrefix   = "sig:"
+	testErrorKeyPrefix   = "err:"
+	probeKeyPrefix       = "probe:"
+	snapshotKeyPrefix    = "snap:"
+	indexVersionKey      = metaKeyPrefix + "index-version"
+	resultTTLKey         = metaKeyPrefix + "result-ttl"
+	currentIndexVersion  = 1
+)
+
+func isResultKey(key []byte) bool {
+	k := string(key)
+	return !strings.HasPrefix(k, indexKeyPrefix) && !strings.HasPrefix(k, metaKeyPrefix) &&
+		!strings.HasPrefix(k, testErrorKeyPrefix) && !strings.HasPrefix(k, probeKeyPrefix) &&
+		!strings.HasPrefix(k, snapshotKeyPrefix) && !strings.HasPrefix(k, amendmentKeyPrefix) &&
+		!strings.HasPrefix(k, aliasKeyPrefix) && !strings.HasPrefix(k, resultAliasKeyPrefix) &&
+		!strings.HasPrefix(k, alertKeyPrefix) && !strings.HasPrefix(k, recurringKeyPrefix) &&
+		!strings.HasPrefix(k, ownerKeyPrefix) && !strings.HasPrefix(k, resultOwnerKeyPrefix) &&
+		!strings.HasPrefix(k, signatureKeyPrefix)
+}
+
+// indexTimestamp renders t so that lexical key order matches time order.
+func indexTimestamp(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// timeIndexPrefix is the prefix of the timestamp index.
+func timeIndexPrefix() string {
+	return indexKeyPrefix + "ts:"
+}
+
+// tagIndexPrefix is the prefix of the index entries for one tag value.
+func tagIndexPrefix(key, value string) string {
+	return fmt.Sprintf("%stag:%s=%s:", indexKeyPrefix, key, value)
+}
+
+// verifiedIndexPrefix is the prefix of the verified-results index.
+func verifiedIndexPrefix() string {
+	return indexKeyPrefix + "verified:"
+}
+
+// clientIndexPrefix is the prefix of the index entries of one device's results.
+func clientIndexPrefix(clientID string) string {
+	return indexKeyPrefix + "client:" + clientID + ":"
+}
+
+// indexKeys returns the secondary index entries for a result. Index keys have
+// no value; the result ID is the final segment of the key, preceded by the
+// timestamp so each index can be walked in time order.
+func indexKeys(result TestResult) [][]byte {
+	suffix := indexTimestamp(result.Timestamp) + ":" + result.ID
+	keys := [][]byte{[]byte(timeIndexPrefix() + suffix)}
+	for k, v := range result.Tags {
+		keys = append(keys, []byte(tagIndexPrefix(k, v)+suffix))
+	}
+	if result.Verified {
+		keys = append(keys, []byte(verifiedIndexPrefix()+suffix))
+	}
+	if result.ClientID != "" {
+		keys = append(keys, []byte(clientIndexPrefix(result.ClientID)+suffix))
+	}
+	return keys
+}
+
+// filterIndex picks the most selective index for filter.
+func filterIndex(filter ResultFilter) (prefix string, indexed int) {
+	switch {
+	case filter.ClientID != "":
+		return clientIndexPrefix(filter.ClientID), indexedByClient
+	case filter.TagKey != "":
+		return tagIndexPrefix(filter.TagKey, filter.TagValue), indexedByTag
+	case filter.Verified != nil && *filter.Verified:
+		return verifiedIndexPrefix(), indexedByVerified
+	}
+	return timeIndexPrefix(), indexedByTime
+}
+
+// walkIndex calls fn with the result ID of each entry under prefix within the
+// filter's time range, newest first, stopping at the first error. A non-empty
+// after is an index position ("<unix nanos>:<id>") to resume behind.
+func walkIndex(txn *badger.Txn, prefix string, filter ResultFilter, after string, fn func(id string) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Reverse = true
+	opts.Prefix = []byte(prefix)
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	// Reverse iteration must seek past the last key with the prefix, or to
+	// the upper time bound, which excludes keys at exactly that time.
+	seek := append([]byte(prefix), 0xFF)
+	if !filter.To.IsZero() {
+		seek = []byte(prefix + indexTimestamp(filter.To))
+	}
+	if after != "" && prefix+after < string(seek) {
+		seek = []byte(prefix + after)
+	}
+	var from string
+	if !filter.From.IsZero() {
+		from = indexTimestamp(filter.From)
+	}
+	for it.Seek(seek); it.ValidForPrefix([]byte(prefix)); it.Next() {
+		key := string(it.Item().Key())
+		if after != "" && key == prefix+after {
+			continue // the last entry of the previous page
+		}
+		sep := strings.LastIndex(key, ":")
+		if from != "" && key[sep-len(from):sep] < from {
+			break // every index is time ordered, so the rest is older
+		}
+		if err := fn(key[sep+1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns one page of results matching filter, newest first, along with
+// the total number of matches. It walks the most selective index available;
+// when the index alone answers the filter only the returned page is loaded.
+func (s *BadgerStore) Query(filter ResultFilter, offset, limit int) ([]TestResult, int, error) {
+	prefix, indexed := filterIndex(filter)
+	loadAll := filter.needsValues(indexed)
+
+	page := []TestResult{}
+	total := 0
+	err := s.db.View(func(txn *badger.Txn) error {
+		return walkIndex(txn, prefix, filter, "", func(id string) error {
+			inPage := total >= offset && len(page) < limit
+			if !loadAll && !inPage {
+				total++
+				return nil
+			}
+
+			result, err := loadResult(txn, id)
+			if err == badger.ErrKeyNotFound {
+				return nil // stale index entry for a result that no longer exists
+			} else if err != nil {
+				return err
+			}
+			if loadAll && !filter.Matches(result) {
+				return nil
+			}
+			if inPage {
+				page = append(page, result)
+			}
+			total++
+			return nil
+		})
+	})
+	return page, total, err
+}
+
+// IterateMatching calls fn for every result matching filter, newest first,
+// stopping at the first error.
+func (s *BadgerStore) IterateMatching(filter ResultFilter, fn func(result TestResult) error) error {
+	prefix, indexed := filterIndex(filter)
+	check := filter.needsValues(indexed)
+	return s.db.View(func(txn *badger.Txn) error {
+		return walkIndex(txn, prefix, filter, "", func(id string) error {
+			result, err := loadResult(txn, id)
+			if err == badger.ErrKeyNotFound {
+				return nil // stale index entry for a result that no longer exists
+			} else if err != nil {
+				return err
+			}
+			if check && !filter.Matches(result) {
+				return nil
+			}
+			return fn(result)
+		})
+	})
+}
+
+// List returns up to limit results matching filter, newest first, starting
+// after cursor (empty for the first page). The returned cursor continues the
+// listing and is empty after the last page. Cursors are index positions, which
+// are the same in every index, so pages stay stable while new results are
+// saved.
+func (s *BadgerStore) List(filter ResultFilter, cursor string, limit int) ([]TestResult, string, error) {
+	var after string
+	if cursor != "" {
+		position, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		after = string(position)
+	}
+
+	prefix, indexed := filterIndex(filter)
+	check := filter.needsValues(indexed)
+	page := []TestResult{}
+	next := ""
+	err := s.db.View(func(txn *badger.Txn) error {
+		err := walkIndex(txn, prefix, filter, after, func(id string) error {
+			result, err := loadResult(txn, id)
+			if err == badger.ErrKeyNotFound {
+				return nil // stale index entry for a result that no longer exists
+			} else if err != nil {
+				return err
+			}
+			if check && !filter.Matches(result) {
+				return nil
+			}
+			if len(page) == limit {
+				last := page[len(page)-1]
+				next = base64.RawURLEncoding.EncodeToString([]byte(indexTimestamp(last.Timestamp) + ":" + last.ID))
+				return errPageFull
+			}
+			page = append(page, result)
+			return nil
+		})
+		if err == errPageFull {
+			return nil
+		}
+		return err
+	})
+	return page, next, err
+}
+
+// errPageFull stops an index walk once a page is complete.
+var errPageFull = errors.New("page full")
+
+// loadResult reads and decodes a single result inside a transaction.
+func loadResult(txn *badger.Txn, id string) (TestResult, error) {
+	var result TestResult
+	item, err := txn.Get([]byte(id))
+	if err != nil {
+		return result, err
+	}
+	err = item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &result)
+	})
+	result.ID = id // Results saved before IDs were stored in the record
+	return result, err
+}
+
+func (s *BadgerStore) indexVersion() (int, error) {
+	version := 0
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(indexVersionKey))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			version, err = strconv.Atoi(string(val))
+			return err
+		})
+	})
+	return version, err
+}
+
+// Reindex drops every secondary index entry and rebuilds them from the stored results.
+func (s *BadgerStore) Reindex() error {
+	if err := s.db.DropPrefix([]byte(indexKeyPrefix)); err != nil {
+		return fmt.Errorf("failed to drop old indexes: %w", err)
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	count := 0
+	err := s.Iterate(func(result TestResult) error {
+		for _, key := range indexKeys(result) {
+			if err := wb.SetEntry(s.resultEntry(key, nil, result.Timestamp)); err != nil {
+				return err
+			}
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := wb.Set([]byte(indexVersionKey), []byte(strconv.Itoa(currentIndexVersion))); err != nil {
+		return err
+	}
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+	log.Printf("Reindexed %d results", count)
+	return nil
+}
+
+// SetQuota caps the number of stored results and their total size, evicting
+// the oldest results whenever a cap is exceeded. Zero disables a cap.
+func (s *BadgerStore) SetQuota(maxResults int, maxBytes int64) error {
+	s.maxResults = int64(maxResults)
+	s.maxBytes = maxBytes
+	if maxResults <= 0 && maxBytes <= 0 {
+		return nil
+	}
+	if err := s.measureUsage(); err != nil {
+		return err
+	}
+	log.Printf("Store quota: %d results, %d bytes in use (max results %d, max bytes %d)", s.count.Load(), s.bytes.Load(), maxResults, maxBytes)
+	return s.enforceQuota()
+}
+
+// measureUsage counts the stored results and their size without reading values.
+func (s *BadgerStore) measureUsage() error {
+	var count, size int64
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if isResultKey(item.Key()) {
+				count++
+				size += int64(len(item.Key())) + item.ValueSize()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.count.Store(count)
+	s.bytes.Store(size)
+	return nil
+}
+
+func (s *BadgerStore) overQuota() bool {
+	return (s.maxResults > 0 && s.count.Load() > s.maxResults) ||
+		(s.maxBytes > 0 && s.bytes.Load() > s.maxBytes)
+}
+
+// enforceQuota evicts the oldest results, in batches, until usage is within the quota.
+func (s *BadgerStore) enforceQuota() error {
+	if !s.overQuota() {
+		return nil
+	}
+	s.evictMu.Lock()
+	defer s.evictMu.Unlock()
+
+	const batchSize = 100
+	evicted := 0
+	for s.overQuota() {
+		var ids []string
+		err := s.db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			opts.Prefix = []byte(timeIndexPrefix())
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Rewind(); it.Valid() && len(ids) < batchSize; it.Next() {
+				key := string(it.Item().Key())
+				ids = append(ids, key[strings.LastIndex(key, ":")+1:])
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break // nothing indexed left to evict
+		}
+
+		for _, id := range ids {
+			if !s.overQuota() {
+				break
+			}
+			if err := s.evict(id); err != nil {
+				return fmt.Errorf("failed to evict result %s: %w", id, err)
+			}
+			evicted++
+		}
+	}
+
+	if evicted > 0 {
+		log.Printf("Evicted %d oldest results to stay within the store quota", evicted)
+		// Reclaim value log space in the background; ErrNoRewrite just means nothing to do.
+		go s.db.RunValueLogGC(0.5)
+	}
+	return nil
+}
+
+// evict deletes a result, its index entries, amendments and aliases, updating the usage counters.
+func (s *BadgerStore) evict(id string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(id))
+		if err == badger.ErrKeyNotFound {
+			// Stale timestamp index entry; drop it so eviction makes progress.
+			return s.dropIndexEntries(txn, timeIndexPrefix(), id)
+		} else if err != nil {
+			return err
+		}
+		size := int64(len(id)) + item.ValueSize()
+
+		result, err := loadResult(txn, id)
+		if err != nil {
+			return err
+		}
+		for _, key := range indexKeys(result) {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		var amendments [][]byte
+		if err := scanAmendments(txn, id, func(key, _ []byte) error {
+			amendments = append(amendments, key)
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, key := range amendments {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		aliases, err := scanAliases(txn, id)
+		if err != nil {
+			return err
+		}
+		for _, alias := range aliases {
+			if err := txn.Delete([]byte(aliasKeyPrefix + alias)); err != nil {
+				return err
+			}
+			if err := txn.Delete([]byte(resultAliasKeyPrefix + id + ":" + alias)); err != nil {
+				return err
+			}
+		}
+		owners, err := scanOwners(txn, id)
+		if err != nil {
+			return err
+		}
+		for _, owner := range owners {
+			if err := txn.Delete(ownerKey(owner, result)); err != nil {
+				return err
+			}
+			if err := txn.Delete([]byte(resultOwnerKeyPrefix + id + ":" + owner)); err != nil {
+				return err
+			}
+		}
+		if err := txn.Delete([]byte(signatureKeyPrefix + id)); err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte(id)); err != nil {
+			return err
+		}
+		s.count.Add(-1)
+		s.bytes.Add(-size)
+		return nil
+	})
+}
+
+// dropIndexEntries removes the entries under prefix that point at id.
+func (s *BadgerStore) dropIndexEntries(txn *badger.Txn, prefix, id string) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte(prefix)
+	it := txn.NewIterator(opts)
+	var keys [][]byte
+	for it.Rewind(); it.Valid(); it.Next() {
+		if strings.HasSuffix(string(it.Item().Key()), ":"+id) {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+	}
+	it.Close()
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveTestError stores an anonymized client error report. Reports expire
+// after testErrorRetention and do not count towards the result quota.
+func (s *BadgerStore) SaveTestError(report TestErrorReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal error report: %w", err)
+	}
+	key := testErrorKeyPrefix + indexTimestamp(report.Timestamp) + ":" + uuid.New().String()
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry([]byte(key), data).WithTTL(testErrorRetention))
+	})
+}
+
+// TestErrors returns the stored error reports newer than since, oldest first.
+func (s *BadgerStore) TestErrors(since time.Time) ([]TestErrorReport, error) {
+	var reports []TestErrorReport
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(testErrorKeyPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek([]byte(testErrorKeyPrefix + indexTimestamp(since))); it.Valid(); it.Next() {
+			var report TestErrorReport
+			if err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &report)
+			}); err != nil {
+				return err
+			}
+			reports = append(reports, report)
+		}
+		return nil
+	})
+	return reports, err
+}
+
+// SaveProbe stores an external target probe result. Probes expire after
+// probeRetention and do not count towards the result quota.
+func (s *BadgerStore) SaveProbe(probe ProbeResult) error {
+	data, err := json.Marshal(probe)
+	if err != nil {
+		return fmt.Errorf("failed to marshal probe result: %w", err)
+	}
+	key := probeKeyPrefix + indexTimestamp(probe.Timestamp) + ":" + uuid.New().String()
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry([]byte(key), data).WithTTL(probeRetention))
+	})
+}
+
+// Probes returns the stored probe results newer than since, oldest first.
+func (s *BadgerStore) Probes(since time.Time) ([]ProbeResult, error) {
+	var probes []ProbeResult
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(probeKeyPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek([]byte(probeKeyPrefix + indexTimestamp(since))); it.Valid(); it.Next() {
+			var probe ProbeResult
+			if err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &probe)
+			}); err != nil {
+				return err
+			}
+			probes = append(probes, probe)
+		}
+		return nil
+	})
+	return probes, err
+}
+
+func snapshotKey(period string, start time.Time) []byte {
+	return []byte(snapshotKeyPrefix + period + ":" + indexTimestamp(start))
+}
+
+// SaveSnapshot stores a report snapshot, replacing any for the same period start.
+// Snapshots are not subject to the result quota.
+func (s *BadgerStore) SaveSnapshot(snapshot ReportSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report snapshot: %w", err)
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(snapshotKey(snapshot.Period, snapshot.Start), data)
+	})
+}
+
+// HasSnapshot reports whether a snapshot exists for the period starting at start.
+func (s *BadgerStore) HasSnapshot(period string, start time.Time) (bool, error) {
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(snapshotKey(period, start))
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Snapshots returns the snapshots of a period starting at or after since, oldest first.
+func (s *BadgerStore) Snapshots(period string, since time.Time) ([]ReportSnapshot, error) {
+	var snapshots []ReportSnapshot
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(snapshotKeyPrefix + period + ":")
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(snapshotKey(period, since)); it.Valid(); it.Next() {
+			var snapshot ReportSnapshot
+			if err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &snapshot)
+			}); err != nil {
+				return err
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		return nil
+	})
+	return snapshots, err
+}
+
+// SaveAmendment stores an amendment of an existing result. It expires with
+// the result.
+func (s *BadgerStore) SaveAmendment(amendment Amendment) (Amendment, error) {
+	amendment.ID = uuid.New().String()
+	amendment.Timestamp = time.Now()
+	data, err := json.Marshal(amendment)
+	if err != nil {
+		return amendment, fmt.Errorf("failed to marshal amendment: %w", err)
+	}
+	key := amendmentKeyPrefix + amendment.ResultID + ":" + indexTimestamp(amendment.Timestamp) + ":" + amendment.ID
+	err = s.db.Update(func(txn *badger.Txn) error {
+		if !isResultKey([]byte(amendment.ResultID)) {
+			return badger.ErrKeyNotFound
+		}
+		result, err := loadResult(txn, amendment.ResultID)
+		if err != nil {
+			return err
+		}
+		return txn.SetEntry(s.resultEntry([]byte(key), data, result.Timestamp))
+	})
+	if err == badger.ErrKeyNotFound {
+		return amendment, fmt.Errorf("result not found for ID: %s", amendment.ResultID)
+	} else if err != nil {
+		return amendment, err
+	}
+	log.Printf("Amendment %s (%s) saved for result ID: %s", amendment.ID, amendment.Kind, amendment.ResultID)
+	return amendment, nil
+}
+
+// Amendments returns the amendments of a result, oldest first.
+func (s *BadgerStore) Amendments(resultID string) ([]Amendment, error) {
+	var amendments []Amendment
+	err := s.db.View(func(txn *badger.Txn) error {
+		return scanAmendments(txn, resultID, func(_, data []byte) error {
+			var amendment Amendment
+			if err := json.Unmarshal(data, &amendment); err != nil {
+				return err
+			}
+			amendments = append(amendments, amendment)
+			return nil
+		})
+	})
+	return amendments, err
+}
+
+// AddAlias points alias at an existing result. The alias expires with the result.
+func (s *BadgerStore) AddAlias(alias, resultID string) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		if !isResultKey([]byte(resultID)) {
+			return badger.ErrKeyNotFound
+		}
+		result, err := loadResult(txn, resultID)
+		if err != nil {
+			return err
+		}
+		item, err := txn.Get([]byte(aliasKeyPrefix + alias))
+		if err == nil {
+			var current string
+			if err := item.Value(func(val []byte) error {
+				current = string(val)
+				return nil
+			}); err != nil {
+				return err
+			}
+			if current != resultID {
+				return errAliasTaken
+			}
+			return nil
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err := txn.SetEntry(s.resultEntry([]byte(aliasKeyPrefix+alias), []byte(resultID), result.Timestamp)); err != nil {
+			return err
+		}
+		return txn.SetEntry(s.resultEntry([]byte(resultAliasKeyPrefix+resultID+":"+alias), nil, result.Timestamp))
+	})
+	if err == badger.ErrKeyNotFound {
+		return fmt.Errorf("result not found for ID: %s", resultID)
+	} else if err != nil {
+		return err
+	}
+	log.Printf("Alias %s saved for result ID: %s", alias, resultID)
+	return nil
+}
+
+// ResolveAlias returns the ID of the result alias points at.
+func (s *BadgerStore) ResolveAlias(alias string) (string, error) {
+	var id string
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(aliasKeyPrefix + alias))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			id = string(val)
+			return nil
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return "", errAliasNotFound
+	}
+	return id, err
+}
+
+// Aliases returns the aliases of a result, sorted.
+func (s *BadgerStore) Aliases(resultID string) ([]string, error) {
+	var aliases []string
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		aliases, err = scanAliases(txn, resultID)
+		return err
+	})
+	return aliases, err
+}
+
+// DeleteAlias removes an alias.
+func (s *BadgerStore) DeleteAlias(alias string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(aliasKeyPrefix + alias))
+		if err == badger.ErrKeyNotFound {
+			return errAliasNotFound
+		} else if err != nil {
+			return err
+		}
+		id, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte(aliasKeyPrefix + alias)); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(resultAliasKeyPrefix + string(id) + ":" + alias))
+	})
+}
+
+// SaveAlert stores an alert, replacing the one with its ID. Alerts do not
+// count towards the result quota.
+func (s *BadgerStore) SaveAlert(alert Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(alertKeyPrefix+alert.ID), data)
+	})
+}
+
+// Alerts returns the stored alerts.
+func (s *BadgerStore) Alerts() ([]Alert, error) {
+	var list []Alert
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(alertKeyPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			var alert Alert
+			if err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &alert)
+			}); err != nil {
+				return err
+			}
+			list = append(list, alert)
+		}
+		return nil
+	})
+	return list, err
+}
+
+// DeleteAlert removes an alert.
+func (s *BadgerStore) DeleteAlert(id string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(alertKeyPrefix + id))
+	})
+}
+
+// SaveRecurringTest stores a recurring test registration. Registrations do
+// not count towards the result quota.
+func (s *BadgerStore) SaveRecurringTest(test RecurringTest) error {
+	data, err := json.Marshal(test)
+	if err != nil {
+		return fmt.Errorf("failed to marshal recurring test: %w", err)
+	}
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(recurringKeyPrefix+test.ID), data)
+	})
+}
+
+// LoadRecurringTest returns a recurring test registration.
+func (s *BadgerStore) LoadRecurringTest(id string) (RecurringTest, error) {
+	var test RecurringTest
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(recurringKeyPrefix + id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &test)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return test, errRecurringTestNotFound
+	}
+	return test, err
+}
+
+// DeleteRecurringTest removes a recurring test registration.
+func (s *BadgerStore) DeleteRecurringTest(id string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		key := []byte(recurringKeyPrefix + id)
+		if _, err := txn.Get(key); err == badger.ErrKeyNotFound {
+			return errRecurringTestNotFound
+		} else if err != nil {
+			return err
+		}
+		return txn.Delete(key)
+	})
+}
+
+// ClaimResult records that owner saved an existing result. The claim
+// expires with the result.
+func (s *BadgerStore) ClaimResult(owner, resultID string) error {
+	err := s.db.Update(func(txn *badger.Txn) error {
+		if !isResultKey([]byte(resultID)) {
+			return badger.ErrKeyNotFound
+		}
+		result, err := loadResult(txn, resultID)
+		if err != nil {
+			return err
+		}
+		if err := txn.SetEntry(s.resultEntry(ownerKey(owner, result), nil, result.Timestamp)); err != nil {
+			return err
+		}
+		return txn.SetEntry(s.resultEntry([]byte(resultOwnerKeyPrefix+resultID+":"+owner), nil, result.Timestamp))
+	})
+	if err == badger.ErrKeyNotFound {
+		return fmt.Errorf("result not found for ID: %s", resultID)
+	}
+	return err
+}
+
+// OwnedResults returns the IDs of up to limit of owner's results, newest first.
+func (s *BadgerStore) OwnedResults(owner string, limit int) ([]string, error) {
+	var ids []string
+	err := s.db.View(func(txn *badger.Txn) error {
+		return walkIndex(txn, ownerKeyPrefix+owner+":", ResultFilter{}, "", func(id string) error {
+			if len(ids) >= limit {
+				return errPageFull
+			}
+			ids = append(ids, id)
+			return nil
+		})
+	})
+	if err == errPageFull {
+		err = nil
+	}
+	return ids, err
+}
+
+// OwnsResult reports whether owner saved a result.
+func (s *BadgerStore) OwnsResult(owner, resultID string) (bool, error) {
+	err := s.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(resultOwnerKeyPrefix + resultID + ":" + owner))
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SaveSignature stores the signature of an existing result. It expires with
+// the result.
+func (s *BadgerStore) SaveSignature(resultID string, signature ResultSignature) error {
+	data, err := json.Marshal(signature)
+	if err != nil {
+		return fmt.Errorf("failed to marshal signature: %w", err)
+	}
+	err = s.db.Update(func(txn *badger.Txn) error {
+		if !isResultKey([]byte(resultID)) {
+			return badger.ErrKeyNotFound
+		}
+		result, err := loadResult(txn, resultID)
+		if err != nil {
+			return err
+		}
+		return txn.SetEntry(s.resultEntry([]byte(signatureKeyPrefix+resultID), data, result.Timestamp))
+	})
+	if err == badger.ErrKeyNotFound {
+		return fmt.Errorf("result not found for ID: %s", resultID)
+	}
+	return err
+}
+
+// Signature returns the signature of a result, or errSignatureNotFound.
+func (s *BadgerStore) Signature(resultID string) (ResultSignature, error) {
+	var signature ResultSignature
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(signatureKeyPrefix + resultID))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &signature)
+		})
+	})
+	if err == badger.ErrKeyNotFound {
+		return signature, errSignatureNotFound
+	}
+	return signature, err
+}
+
+// ownerKey is the key filing result under owner, in time order.
+func ownerKey(owner string, result TestResult) []byte {
+	return []byte(ownerKeyPrefix + owner + ":" + indexTimestamp(result.Timestamp) + ":" + result.ID)
+}
+
+// scanOwners returns the owners of a result.
+func scanOwners(txn *badger.Txn, resultID string) ([]string, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte(resultOwnerKeyPrefix + resultID + ":")
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	var owners []string
+	for it.Rewind(); it.Valid(); it.Next() {
+		owners = append(owners, strings.TrimPrefix(string(it.Item().Key()), string(opts.Prefix)))
+	}
+	return owners, nil
+}
+
+// scanAliases returns the aliases of a result in key order, which is sorted.
+func scanAliases(txn *badger.Txn, resultID string) ([]string, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = []byte(resultAliasKeyPrefix + resultID + ":")
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	var aliases []string
+	for it.Rewind(); it.Valid(); it.Next() {
+		aliases = append(aliases, strings.TrimPrefix(string(it.Item().Key()), string(opts.Prefix)))
+	}
+	return aliases, nil
+}
+
+// scanAmendments calls fn with copies of the key and value of each amendment
+// of a result, oldest first.
+func scanAmendments(txn *badger.Txn, resultID string, fn func(key, data []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(amendmentKeyPrefix + resultID + ":")
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		data, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(it.Item().KeyCopy(nil), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resultEntry builds the entry for a result record or one of its index keys,
+// expiring resultTTL after the result's timestamp.
+func (s *BadgerStore) resultEntry(key, value []byte, timestamp time.Time) *badger.Entry {
+	e := badger.NewEntry(key, value)
+	if s.resultTTL > 0 {
+		e = e.WithTTL(time.Until(timestamp.Add(s.resultTTL)))
+	}
+	return e
+}
+
+// SetRetention makes results expire ttl after they were saved; zero keeps
+// them forever. When ttl differs from the retention the store was last opened
+// with, existing results are rewritten with the new expiry, and those already
+// older than ttl are deleted.
+func (s *BadgerStore) SetRetention(ttl time.Duration) error {
+	s.resultTTL = ttl
+
+	applied := time.Duration(0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(resultTTLKey))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			applied, err = time.ParseDuration(string(val))
+			return err
+		})
+	})
+	if err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	if applied == ttl {
+		return nil
+	}
+
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	cutoff := time.Now().Add(-ttl)
+	var expired []string
+	rewritten := 0
+	err = s.Iterate(func(result TestResult) error {
+		if ttl > 0 && result.Timestamp.Before(cutoff) {
+			expired = append(expired, result.ID)
+			return nil
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if err := wb.SetEntry(s.resultEntry([]byte(result.ID), data, result.Timestamp)); err != nil {
+			return err
+		}
+		for _, key := range indexKeys(result) {
+			if err := wb.SetEntry(s.resultEntry(key, nil, result.Timestamp)); err != nil {
+				return err
+			}
+		}
+		rewritten++
+		return s.db.View(func(txn *badger.Txn) error {
+			if err := scanAmendments(txn, result.ID, func(key, data []byte) error {
+				return wb.SetEntry(s.resultEntry(key, data, result.Timestamp))
+			}); err != nil {
+				return err
+			}
+			aliases, err := scanAliases(txn, result.ID)
+			if err != nil {
+				return err
+			}
+			for _, alias := range aliases {
+				if err := wb.SetEntry(s.resultEntry([]byte(aliasKeyPrefix+alias), []byte(result.ID), result.Timestamp)); err != nil {
+					return err
+				}
+				if err := wb.SetEntry(s.resultEntry([]byte(resultAliasKeyPrefix+result.ID+":"+alias), nil, result.Timestamp)); err != nil {
+					return err
+				}
+			}
+			owners, err := scanOwners(txn, result.ID)
+			if err != nil {
+				return err
+			}
+			for _, owner := range owners {
+				if err := wb.SetEntry(s.resultEntry(ownerKey(owner, result), nil, result.Timestamp)); err != nil {
+					return err
+				}
+				if err := wb.SetEntry(s.resultEntry([]byte(resultOwnerKeyPrefix+result.ID+":"+owner), nil, result.Timestamp)); err != nil {
+					return err
+				}
+			}
+			item, err := txn.Get([]byte(signatureKeyPrefix + result.ID))
+			if err == badger.ErrKeyNotFound {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			signature, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			return wb.SetEntry(s.resultEntry([]byte(signatureKeyPrefix+result.ID), signature, result.Timestamp))
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if err := wb.Set([]byte(resultTTLKey), []byte(ttl.String())); err != nil {
+		return err
+	}
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+	for _, id := range expired {
+		if err := s.evict(id); err != nil {
+			return fmt.Errorf("failed to delete expired result %s: %w", id, err)
+		}
+	}
+	log.Printf("Result retention changed from %s to %s: %d results updated, %d expired", applied, ttl, rewritten, len(expired))
+	return nil
+}
+
+// runValueLogGC periodically compacts the value log until Close. Expired
+// results disappear from reads immediately, but their space is only
+// reclaimed here; the quota counters are refreshed at the same time.
+func (s *BadgerStore) runValueLogGC() {
+	ticker := time.NewTicker(valueLogGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopGC:
+			return
+		case <-ticker.C:
+		}
+		// Each successful run rewrites one file; repeat until there is nothing left to do
+		for s.db.RunValueLogGC(0.5) == nil {
+		}
+		if s.resultTTL > 0 && (s.maxResults > 0 || s.maxBytes > 0) {
+			if err := s.measureUsage(); err != nil {
+				log.Printf("Failed to measure store usage: %v", err)
+			}
+		}
+	}
+}
+
+// StoreInfo reports the size of the LSM tree and value log and counts the keys.
+func (s *BadgerStore) StoreInfo() (StoreInfo, error) {
+	opts := s.db.Opts()
+	info := StoreInfo{Store: "badger", Path: opts.Dir, Encrypted: len(opts.EncryptionKey) > 0}
+	info.LSMBytes, info.VlogBytes = s.db.Size()
+	err := s.db.View(func(txn *badger.Txn) error {
+		iterOpts := badger.DefaultIteratorOptions
+		iterOpts.PrefetchValues = false
+		it := txn.NewIterator(iterOpts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			info.Keys++
+			if isResultKey(it.Item().Key()) {
+				info.Results++
+			}
+		}
+		return nil
+	})
+	return info, err
+}
+
+// StoreSize returns the size of the LSM tree and value log, as last measured
+// by Badger.
+func (s *BadgerStore) StoreSize() (int64, error) {
+	lsm, vlog := s.db.Size()
+	return lsm + vlog, nil
+}
+
+// CollectGarbage runs value log GC until no file is worth rewriting.
+func (s *BadgerStore) CollectGarbage() (int, error) {
+	if !s.maintenanceMu.TryLock() {
+		return 0, errStoreBusy
+	}
+	defer s.maintenanceMu.Unlock()
+	if s.db.Opts().InMemory {
+		return 0, nil // there is no value log
+	}
+	files := 0
+	for {
+		err := s.db.RunValueLogGC(0.5)
+		switch {
+		case err == nil:
+			files++
+			continue
+		case errors.Is(err, badger.ErrNoRewrite):
+		case errors.Is(err, badger.ErrRejected):
+			return files, errStoreBusy // the periodic GC is running
+		default:
+			return files, err
+		}
+		break
+	}
+	if s.maxResults > 0 || s.maxBytes > 0 {
+		if err := s.measureUsage(); err != nil {
+			log.Printf("Failed to measure store usage: %v", err)
+		}
+	}
+	return files, nil
+}
+
+// Compact flattens the LSM tree into one level.
+func (s *BadgerStore) Compact() error {
+	if !s.maintenanceMu.TryLock() {
+		return errStoreBusy
+	}
+	defer s.maintenanceMu.Unlock()
+	return s.db.Flatten(runtime.GOMAXPROCS(0))
+}
+
+// Backup writes a backup in the format of "badger backup" to path, which
+// "badger restore" loads into a new store. The file only appears once complete.
+func (s *BadgerStore) Backup(path string, since uint64) (uint64, error) {
+	if !s.maintenanceMu.TryLock() {
+		return 0, errStoreBusy
+	}
+	defer s.maintenanceMu.Unlock()
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	version, err := s.db.Backup(f, since)
+	if err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return 0, err
+	}
+	// Entries at since itself are not dumped, so the next backup starts at
+	// the newest version in this one
+	return max(version, since), nil
+}
+
+// Close stops the value log GC and closes the database.
+func (s *BadgerStore) Close() error {
+	close(s.stopGC)
+	return s.db.Close()
+}
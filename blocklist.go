@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// -blocklist-url subscribes the access policy to a list of addresses and
+// CIDR ranges to refuse, e.g. known abuse ranges, refreshed every
+// -blocklist-refresh. A failed refresh keeps the previous list. The list is
+// one entry per line; text after # or ; and after the first field is
+// ignored, so lists such as Spamhaus DROP can be used as published.
+
+const (
+	blocklistTimeout = 30 * time.Second
+	maxBlocklistSize = 64 << 20
+)
+
+// blocklist is a set of ranges, indexed by prefix length for lookups that
+// do not depend on the number of entries.
+type blocklist struct {
+	byBits  map[int]map[netip.Prefix]struct{}
+	entries int
+	etag    string
+	updated time.Time
+}
+
+// currentBlocklist is nil until a list was loaded.
+var currentBlocklist atomic.Pointer[blocklist]
+
+var blocklistRefreshes = newCounterVec("netspeed_blocklist_refreshes_total",
+	"Refreshes of -blocklist-url by result: updated, unchanged or failed.", "result")
+
+var _ = newGaugeFunc("netspeed_blocklist_entries",
+	"Addresses and ranges in the current -blocklist-url list.", nil,
+	func() map[string]float64 {
+		list := currentBlocklist.Load()
+		if list == nil {
+			return nil
+		}
+		return map[string]float64{"": float64(list.entries)}
+	})
+
+var _ = newGaugeFunc("netspeed_blocklist_updated_timestamp_seconds",
+	"When the current -blocklist-url list was loaded, in Unix seconds.", nil,
+	func() map[string]float64 {
+		list := currentBlocklist.Load()
+		if list == nil {
+			return nil
+		}
+		return map[string]float64{"": float64(list.updated.Unix())}
+	})
+
+// contains reports whether addr is in a listed range.
+func (l *blocklist) contains(addr netip.Addr) bool {
+	for bits, prefixes := range l.byBits {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue // a length of the other address family
+		}
+		if _, ok := prefixes[prefix]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// blocklisted reports whether the current list refuses addr.
+func blocklisted(addr netip.Addr) bool {
+	list := currentBlocklist.Load()
+	return list != nil && addr.IsValid() && list.contains(addr)
+}
+
+// parseBlocklist reads a list, skipping lines that are not an address or range.
+func parseBlocklist(r io.Reader) (*blocklist, int, error) {
+	list := &blocklist{byBits: make(map[int]map[netip.Prefix]struct{})}
+	skipped := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line, _, _ = strings.Cut(line, ";")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			addr, err := netip.ParseAddr(fields[0])
+			if err != nil {
+				skipped++
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		// Clients are matched by their unmapped address
+		if addr := prefix.Addr(); addr.Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+		}
+		prefix = prefix.Masked()
+		if list.byBits[prefix.Bits()] == nil {
+			list.byBits[prefix.Bits()] = make(map[netip.Prefix]struct{})
+		}
+		list.byBits[prefix.Bits()][prefix] = struct{}{}
+		list.entries++
+	}
+	return list, skipped, scanner.Err()
+}
+
+// refreshBlocklist fetches -blocklist-url, or reads it when it is a file,
+// and swaps the list in.
+func refreshBlocklist() error {
+	source := *blocklistURL
+	previous := currentBlocklist.Load()
+	var body io.Reader
+	var etag string
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		req, err := http.NewRequest(http.MethodGet, source, nil)
+		if err != nil {
+			return err
+		}
+		if previous != nil && previous.etag != "" {
+			req.Header.Set("If-None-Match", previous.etag)
+		}
+		resp, err := (&http.Client{Timeout: blocklistTimeout}).Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotModified && previous != nil {
+			blocklistRefreshes.Inc("unchanged")
+			return nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: HTTP %d", source, resp.StatusCode)
+		}
+		body, etag = resp.Body, resp.Header.Get("ETag")
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		body = f
+	}
+
+	list, skipped, err := parseBlocklist(io.LimitReader(body, maxBlocklistSize))
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	list.etag, list.updated = etag, time.Now()
+	currentBlocklist.Store(list)
+	blocklistRefreshes.Inc("updated")
+	log.Printf("Loaded blocklist %s: %d entries, %d lines skipped", source, list.entries, skipped)
+	return nil
+}
+
+// startBlocklist loads -blocklist-url and refreshes it in the background.
+// A list that cannot be loaded at startup is retried at the next refresh.
+func startBlocklist() error {
+	if *blocklistURL == "" {
+		return nil
+	}
+	if *blocklistRefresh < time.Minute {
+		return fmt.Errorf("-blocklist-refresh must be at least 1m")
+	}
+	if err := refreshBlocklist(); err != nil {
+		blocklistRefreshes.Inc("failed")
+		log.Printf("Warning: failed to load blocklist: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(*blocklistRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := refreshBlocklist(); err != nil {
+					blocklistRefreshes.Inc("failed")
+					log.Printf("Failed to refresh blocklist, keeping the previous list: %v", err)
+				}
+			case <-serverContext.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"golang.org/x/net/bpf"
+)
+
+// captureSnapLen keeps the Ethernet, IP and TCP/UDP headers of each packet.
+// That is all throughput analysis needs; test payloads are filler anyway.
+const captureSnapLen = 128
+
+const (
+	captureDefaultSeconds = 30
+	captureMaxSeconds     = 600
+)
+
+// errCaptureTimeout is returned by packetSocket.Read when no packet arrived in time.
+var errCaptureTimeout = errors.New("capture read timed out")
+
+// CaptureRequest is the body of POST /admin/api/captures. The traffic of
+// either a running session's client or a client IP is captured, e.g. to arm a
+// capture before a user reproduces a problem.
+type CaptureRequest struct {
+	SessionID string `json:"sessionId,omitempty"`
+	ClientIP  string `json:"clientIp,omitempty"`
+	Seconds   int    `json:"seconds,omitempty"` // default 30, capped at 10 minutes and -capture-max-duration
+	MaxMB     int64  `json:"maxMB,omitempty"`   // capped at -capture-max-bytes
+}
+
+// CaptureFile describes a packet capture in -capture-dir.
+type CaptureFile struct {
+	Name     string    `json:"name"`
+	Bytes    int64     `json:"bytes"`
+	Modified time.Time `json:"modified"`
+	Running  bool      `json:"running,omitempty"`
+}
+
+// runningCapture is the name of the capture being written; one runs at a time.
+var runningCapture struct {
+	sync.Mutex
+	name string
+}
+
+// hostFilter compiles a BPF program accepting Ethernet frames of IPv4 or IPv6
+// packets to or from ip, truncated to captureSnapLen.
+func hostFilter(ip net.IP) ([]bpf.RawInstruction, error) {
+	etherType, src, dst, addr := uint32(0x86DD), uint32(22), uint32(38), ip.To16()
+	if v4 := ip.To4(); v4 != nil {
+		etherType, src, dst, addr = 0x0800, 26, 30, v4
+	}
+	words := len(addr) / 4
+	// Layout: type check, source block, destination block, accept, reject
+	accept := 2 + 4*words
+	reject := accept + 1
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 12, Size: 2},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: etherType, SkipFalse: uint8(reject - 2)},
+	}
+	for block, off := range []uint32{src, dst} {
+		mismatch := reject
+		if block == 0 {
+			mismatch = 2 + 2*words // try the destination
+		}
+		for i := 0; i < words; i++ {
+			pc := len(prog) + 1
+			jump := bpf.JumpIf{
+				Cond:      bpf.JumpEqual,
+				Val:       uint32(addr[4*i])<<24 | uint32(addr[4*i+1])<<16 | uint32(addr[4*i+2])<<8 | uint32(addr[4*i+3]),
+				SkipFalse: uint8(mismatch - pc - 1),
+			}
+			if i == words-1 {
+				jump.SkipTrue = uint8(accept - pc - 1)
+			}
+			prog = append(prog, bpf.LoadAbsolute{Off: off + uint32(4*i), Size: 4}, jump)
+		}
+	}
+	prog = append(prog, bpf.RetConstant{Val: captureSnapLen}, bpf.RetConstant{Val: 0})
+	return bpf.Assemble(prog)
+}
+
+// capturePackets writes the packets to and from ip seen on iface to path,
+// until the duration passes or the file reaches maxBytes.
+func capturePackets(iface string, ip net.IP, path string, duration time.Duration, maxBytes int64) error {
+	filter, err := hostFilter(ip)
+	if err != nil {
+		return err
+	}
+	sock, err := openPacketSocket(iface, filter)
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(captureSnapLen, layers.LinkTypeEthernet); err != nil {
+		return err
+	}
+
+	written, packets := int64(24), 0 // file header
+	deadline := time.Now().Add(duration)
+	for time.Now().Before(deadline) {
+		data, length, err := sock.Read()
+		if err == errCaptureTimeout {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if written+16+int64(len(data)) > maxBytes {
+			break
+		}
+		ci := gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: length}
+		if err := w.WritePacket(ci, data); err != nil {
+			return err
+		}
+		written += 16 + int64(len(data))
+		packets++
+	}
+	log.Printf("Packet capture %s finished: %d packets, %d bytes", filepath.Base(path), packets, written)
+	return nil
+}
+
+// adminCapturesHandler lists the captures (GET) or starts one (POST).
+func adminCapturesHandler(w http.ResponseWriter, r *http.Request) {
+	if *captureDir == "" {
+		http.Error(w, tr(r, "Packet capture is disabled; set -capture-dir and -capture-interface"), http.StatusNotImplemented)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		files, err := listCaptures()
+		if err != nil {
+			log.Printf("Failed to list captures: %v", err)
+			http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, files)
+	case http.MethodPost:
+		startCaptureHandler(w, r)
+	default:
+		http.Error(w, tr(r, "Only GET and POST methods are supported"), http.StatusMethodNotAllowed)
+	}
+}
+
+func startCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	var req CaptureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, tr(r, "Invalid JSON capture request"), http.StatusBadRequest)
+		return
+	}
+
+	client := req.ClientIP
+	if req.SessionID != "" {
+		session, ok := activeSessions.Lookup(req.SessionID)
+		if !ok {
+			http.Error(w, tr(r, "session not found"), http.StatusNotFound)
+			return
+		}
+		client = session.Client
+	}
+	ip := net.ParseIP(client)
+	if ip == nil {
+		badRequest(w, &paramError{Param: "clientIp", Reason: "required as an IP address unless sessionId is given"})
+		return
+	}
+	seconds := req.Seconds
+	if seconds == 0 {
+		seconds = captureDefaultSeconds
+	}
+	if seconds < 0 || seconds > captureMaxSeconds {
+		badRequest(w, &paramError{Param: "seconds", Reason: fmt.Sprintf("must be between 1 and %d", captureMaxSeconds)})
+		return
+	}
+	duration := min(time.Duration(seconds)*time.Second, *captureMaxDuration)
+	maxBytes := *captureMaxBytes
+	if req.MaxMB > 0 {
+		maxBytes = min(maxBytes, req.MaxMB*1024*1024)
+	}
+
+	name := fmt.Sprintf("%s-%s.pcap", time.Now().UTC().Format("20060102T150405Z"), strings.ReplaceAll(ip.String(), ":", "_"))
+	runningCapture.Lock()
+	if runningCapture.name != "" {
+		runningCapture.Unlock()
+		http.Error(w, tr(r, "a capture is already running"), http.StatusConflict)
+		return
+	}
+	runningCapture.name = name
+	runningCapture.Unlock()
+
+	go func() {
+		defer func() {
+			runningCapture.Lock()
+			runningCapture.name = ""
+			runningCapture.Unlock()
+		}()
+		log.Printf("Capturing traffic of %s on %s for up to %s into %s", ip, *captureInterface, duration, name)
+		if err := capturePackets(*captureInterface, ip, filepath.Join(*captureDir, name), duration, maxBytes); err != nil {
+			log.Printf("Packet capture %s failed: %v", name, err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(CaptureFile{Name: name, Modified: time.Now(), Running: true})
+}
+
+// listCaptures returns the captures in -capture-dir, newest first.
+func listCaptures() ([]CaptureFile, error) {
+	entries, err := os.ReadDir(*captureDir)
+	if err != nil {
+		return nil, err
+	}
+	runningCapture.Lock()
+	running := runningCapture.name
+	runningCapture.Unlock()
+
+	files := []CaptureFile{}
+	for i := len(entries) - 1; i >= 0; i-- { // names start with the time
+		entry := entries[i]
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".pcap") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, CaptureFile{
+			Name:     entry.Name(),
+			Bytes:    info.Size(),
+			Modified: info.ModTime(),
+			Running:  entry.Name() == running,
+		})
+	}
+	return files, nil
+}
+
+// adminCaptureFileHandler serves GET /admin/api/captures/{name} as a download.
+func adminCaptureFileHandler(w http.ResponseWriter, r *http.Request) {
+	if *captureDir == "" {
+		http.Error(w, tr(r, "Packet capture is disabled; set -capture-dir and -capture-interface"), http.StatusNotImplemented)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/admin/api/captures/")
+	if name != filepath.Base(name) || !strings.HasSuffix(name, ".pcap") {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeFile(w, r, filepath.Join(*captureDir, name))
+}
+
+// validateCapture checks the capture flags at startup.
+func validateCapture() error {
+	if *captureDir == "" {
+		return nil
+	}
+	if *captureInterface == "" {
+		return fmt.Errorf("-capture-dir needs -capture-interface")
+	}
+	if _, err := net.InterfaceByName(*captureInterface); err != nil {
+		return fmt.Errorf("-capture-interface: %w", err)
+	}
+	if !packetCaptureSupported {
+		return fmt.Errorf("packet capture is only supported on Linux")
+	}
+	if *captureMaxBytes <= 24 || *captureMaxDuration <= 0 {
+		return fmt.Errorf("-capture-max-bytes and -capture-max-duration must be positive")
+	}
+	return os.MkdirAll(*captureDir, 0o700)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+const packetCaptureSupported = true
+
+// packetSocket is an AF_PACKET socket receiving the frames of one interface
+// that pass a BPF filter. Opening it needs CAP_NET_RAW.
+type packetSocket struct {
+	fd  int
+	buf []byte
+}
+
+func htons(v uint16) uint16 { return v<<8 | v>>8 }
+
+func openPacketSocket(ifname string, filter []bpf.RawInstruction) (*packetSocket, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packet socket: %w", err)
+	}
+	s := &packetSocket{fd: fd, buf: make([]byte, captureSnapLen)}
+
+	// Attach the filter before binding, so no other traffic is queued
+	prog := make([]unix.SockFilter, len(filter))
+	for i, ins := range filter {
+		prog[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	if err := unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER,
+		&unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to attach capture filter: %w", err)
+	}
+	// Reads time out so a quiet capture still ends on time
+	timeout := unix.NsecToTimeval(int64(200 * time.Millisecond))
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: iface.Index}); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to bind to %s: %w", ifname, err)
+	}
+	return s, nil
+}
+
+// Read returns the next frame, truncated to captureSnapLen, and its original length.
+func (s *packetSocket) Read() ([]byte, int, error) {
+	n, _, err := unix.Recvfrom(s.fd, s.buf, unix.MSG_TRUNC)
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return nil, 0, errCaptureTimeout
+	} else if err != nil {
+		return nil, 0, err
+	}
+	return s.buf[:min(n, len(s.buf))], n, nil
+}
+
+func (s *packetSocket) Close() error {
+	return unix.Close(s.fd)
+}
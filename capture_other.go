@@ -0,0 +1,26 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/net/bpf"
+)
+
+// Packet capture uses AF_PACKET sockets, which only Linux has.
+const packetCaptureSupported = false
+
+type packetSocket struct{}
+
+func openPacketSocket(ifname string, filter []bpf.RawInstruction) (*packetSocket, error) {
+	return nil, fmt.Errorf("packet capture is only supported on Linux")
+}
+
+func (s *packetSocket) Read() ([]byte, int, error) {
+	return nil, 0, errCaptureTimeout
+}
+
+func (s *packetSocket) Close() error {
+	return nil
+}
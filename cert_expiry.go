@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// With TLS, /metrics exposes when the served certificate expires and
+// /healthz reports it, and -cert-expiry-alert raises an alert through the
+// notifiers while the certificate expires within that long. The alert is
+// listed, acknowledged and silenced like those of -alert-rules, and resolves
+// when a renewed certificate is picked up. The certificate file is checked
+// every certExpiryCheckInterval, so a renewal is noticed without waiting for
+// a handshake.
+
+const certExpiryCheckInterval = time.Hour
+
+// serverCert is set by setupTLS.
+var serverCert *certReloader
+
+var _ = newGaugeFunc("netspeed_tls_certificate_expiry_timestamp_seconds",
+	"When the served TLS certificate expires, in Unix seconds.", nil,
+	func() map[string]float64 {
+		leaf := serverCert.Leaf()
+		if leaf == nil {
+			return nil
+		}
+		return map[string]float64{"": float64(leaf.NotAfter.Unix())}
+	})
+
+// Leaf returns the parsed certificate being served, or nil without TLS.
+func (c *certReloader) Leaf() *x509.Certificate {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	cert := c.cert
+	c.mu.Unlock()
+	if cert == nil {
+		return nil
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
+
+// reloadNow picks up a renewed certificate file.
+func (c *certReloader) reloadNow() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checkedAt = time.Now()
+	if err := c.load(); err != nil {
+		log.Printf("Failed to reload TLS certificate, keeping the current one: %v", err)
+	}
+}
+
+// certExpiryRule is the rule of certificate alerts, e.g. certificate<14d.
+func certExpiryRule() string {
+	return "certificate<" + strconv.FormatFloat(certExpiryAlert.Hours()/24, 'f', -1, 64) + "d"
+}
+
+func isCertExpiryRule(rule string) bool {
+	return strings.HasPrefix(rule, "certificate<")
+}
+
+// checkCertExpiry fires the certificate alert while the certificate expires
+// within -cert-expiry-alert, and resolves it once it does not. The alert's
+// value is the days left.
+func checkCertExpiry() {
+	leaf := serverCert.Leaf()
+	if leaf == nil || *certExpiryAlert <= 0 {
+		return
+	}
+	now := time.Now()
+	left := leaf.NotAfter.Sub(now)
+	expiring := left < *certExpiryAlert
+	rule, subject := certExpiryRule(), "tls "+serverCert.certPath
+	key := rule + "\x00" + subject
+
+	alerts.Lock()
+	a := alerts.byKey[key]
+	switch {
+	case expiring && (a == nil || a.State == alertResolved):
+		if a == nil {
+			a = &Alert{ID: uuid.NewString(), Rule: rule, Subject: subject}
+			alerts.byKey[key] = a
+		}
+		a.State, a.FiredAt, a.ResolvedAt, a.Breaches = alertFiring, now, nil, 0
+		a.Acknowledged, a.AcknowledgedAt, a.Escalated = false, nil, false
+		log.Printf("Alert %s fired for %s: expires %s", rule, subject, leaf.NotAfter.Format(time.RFC3339))
+		fallthrough
+	case expiring:
+		a.LastBreach, a.Value = now, math.Round(left.Hours()/24*10)/10
+		a.Breaches++
+	case a != nil && a.State == alertFiring:
+		a.State, a.ResolvedAt = alertResolved, &now
+		log.Printf("Alert %s resolved for %s: expires %s", rule, subject, leaf.NotAfter.Format(time.RFC3339))
+	default:
+		alerts.Unlock()
+		return
+	}
+	event, notify := a.notification(now, TestResult{}, "")
+	saveAlert(a)
+	alerts.Unlock()
+	if notify {
+		dispatchEvent(event)
+	}
+}
+
+// startCertExpiryCheck checks the certificate for -cert-expiry-alert now and
+// every certExpiryCheckInterval.
+func startCertExpiryCheck() {
+	if serverCert == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(certExpiryCheckInterval)
+		defer ticker.Stop()
+		for {
+			checkCertExpiry()
+			select {
+			case <-ticker.C:
+				serverCert.reloadNow()
+			case <-serverContext.Done():
+				return
+			}
+		}
+	}()
+}
+
+// HealthStatus is the response of /healthz.
+type HealthStatus struct {
+	Status      string             `json:"status"` // ok, warning or unhealthy
+	Store       string             `json:"store"`  // ok or the error
+	Certificate *CertificateStatus `json:"certificate,omitempty"`
+}
+
+// CertificateStatus describes the served TLS certificate.
+type CertificateStatus struct {
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"notAfter"`
+	DaysLeft float64   `json:"daysLeft"`
+	Expiring bool      `json:"expiring"` // within -cert-expiry-alert
+	Expired  bool      `json:"expired"`
+}
+
+// healthHandler serves GET /healthz for load balancers and monitoring: 200
+// while the result store answers and the certificate is valid, 503 otherwise.
+// A certificate expiring within -cert-expiry-alert makes the status warning.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	status := HealthStatus{Status: "ok", Store: "ok"}
+	if err := serverHealthy(); err != nil {
+		status.Status, status.Store = "unhealthy", err.Error()
+	}
+	if leaf := serverCert.Leaf(); leaf != nil {
+		left := time.Until(leaf.NotAfter)
+		cert := &CertificateStatus{
+			Subject:  leaf.Subject.CommonName,
+			NotAfter: leaf.NotAfter,
+			DaysLeft: math.Round(left.Hours()/24*10) / 10,
+			Expiring: *certExpiryAlert > 0 && left < *certExpiryAlert,
+			Expired:  left <= 0,
+		}
+		if cert.Subject == "" && len(leaf.DNSNames) > 0 {
+			cert.Subject = leaf.DNSNames[0]
+		}
+		switch {
+		case cert.Expired:
+			status.Status = "unhealthy"
+		case cert.Expiring && status.Status == "ok":
+			status.Status = "warning"
+		}
+		status.Certificate = cert
+	}
+	code := http.StatusOK
+	if status.Status == "unhealthy" {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,356 @@
+// Package client runs speed tests against a netspeed server and saves and
+// reads its results, the way the web client does, for Go programs such as
+// monitoring agents.
+//
+//	c, err := client.New("https://speedtest.example.com")
+//	if err != nil {
+//		return err
+//	}
+//	result, err := c.Run(ctx, client.RunOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	id, err := c.Save(ctx, result)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// ErrNotFound is returned when the server has no result with the given ID.
+var ErrNotFound = errors.New("result not found")
+
+// Client talks to one netspeed server. Its fields may be changed before the
+// first request; a Client is safe for concurrent use after that.
+type Client struct {
+	// BaseURL is the server's root, e.g. https://speedtest.example.com
+	BaseURL *url.URL
+	// HTTPClient sends the requests; http.DefaultClient when nil. Test
+	// requests are bounded by their context rather than a client timeout.
+	HTTPClient *http.Client
+	// WebRTC creates the peer connection of the jitter test; a default API
+	// when nil. Set it to bind the test to an interface or address.
+	WebRTC *webrtc.API
+	// ICEServers are used by the jitter test; Google's public STUN server
+	// when nil, like the web client.
+	ICEServers []webrtc.ICEServer
+	// AdminToken authorizes List and, on servers run with
+	// -delete-requires-admin, Delete.
+	AdminToken string
+	// ConsentVersion acknowledges the terms of servers run with
+	// -consent-file, as found in Config().Consent. Such servers refuse tests
+	// and saves without it.
+	ConsentVersion string
+}
+
+// New returns a client for the netspeed server at baseURL.
+func New(baseURL string) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid netspeed URL %q", baseURL)
+	}
+	return &Client{BaseURL: base}, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// send sends a request with the client's consent.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	if c.ConsentVersion != "" {
+		req.Header.Set("X-Consent-Version", c.ConsentVersion)
+	}
+	return c.send(req)
+}
+
+func (c *Client) url(path string) string {
+	return c.BaseURL.String() + path
+}
+
+// StatusError is returned for an unexpected HTTP status.
+type StatusError struct {
+	Method, Path string
+	StatusCode   int
+	Message      string // start of the response body
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: status %d", e.Method, e.Path, e.StatusCode)
+	}
+	return fmt.Sprintf("%s %s: status %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// do sends a request with a JSON body, unless body is nil, and decodes a
+// JSON response into out, unless out is nil.
+func (c *Client) do(ctx context.Context, method, path string, body, out any, admin bool) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if admin && c.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AdminToken)
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{Method: method, Path: req.URL.Path, StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(detail))}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: invalid response: %w", method, req.URL.Path, err)
+	}
+	return nil
+}
+
+// Config is the server's public configuration (/api/config).
+type Config struct {
+	DataPorts     []int `json:"dataPorts"`
+	MaxSizeMB     int64 `json:"maxSizeMB"`
+	DefaultSizeMB int64 `json:"defaultSizeMB"`
+	MinSizeMB     int64 `json:"minSizeMB"`
+	Relay         bool  `json:"relay"`
+	Demo          bool  `json:"demo"` // test endpoints synthesize results
+	DeleteResults bool  `json:"deleteResults"`
+
+	Latency LatencyPolicy `json:"latency"`
+
+	Consent *ConsentTerms `json:"consent,omitempty"` // terms to accept with Client.ConsentVersion before testing
+
+	CongestionControls []string `json:"congestionControls,omitempty"` // algorithms DownloadOptions.Congestion accepts
+}
+
+// ConsentTerms are the terms a server requires clients to accept.
+type ConsentTerms struct {
+	Version string `json:"version"`
+	Text    string `json:"text"`
+}
+
+// LatencyPolicy is how the server asks clients to sample latency and
+// jitter, and the limits its echo paths enforce per connection.
+type LatencyPolicy struct {
+	IntervalMs  float64 `json:"intervalMs"`
+	Count       int     `json:"count"`
+	MaxRate     int     `json:"maxRate"`     // probes per second; 0 for unlimited
+	MaxMessages int     `json:"maxMessages"` // probes per connection; 0 for unlimited
+}
+
+// Config fetches the server's configuration.
+func (c *Client) Config(ctx context.Context) (Config, error) {
+	var config Config
+	err := c.do(ctx, http.MethodGet, "/api/config", nil, &config, false)
+	return config, err
+}
+
+// Result is a test result as the server saves and returns it. The fields
+// after the metrics are set by the server, apart from ClientID, Tags,
+// Methodology, WebRTCSessionID and TCPSessionIDs, which Save sends along.
+type Result struct {
+	ID                string    `json:"id,omitempty"`
+	Timestamp         time.Time `json:"timestamp"`
+	DownloadSpeedMbps float64   `json:"downloadSpeedMbps"`
+	UploadSpeedMbps   float64   `json:"uploadSpeedMbps"`
+	LatencyMs         float64   `json:"latencyMs"`
+	JitterMs          float64   `json:"jitterMs"`
+	PacketLossPercent float64   `json:"packetLossPercent"`
+
+	ClientIP string            `json:"clientIp,omitempty"`
+	ClientID string            `json:"clientId,omitempty"` // persistent device identifier, for History
+	Client   *ClientMetadata   `json:"client,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Verified bool              `json:"verified,omitempty"`
+
+	WebRTCSessionID string         `json:"webrtcSessionId,omitempty"`
+	WebRTCLog       []SessionEvent `json:"webrtcLog,omitempty"`
+
+	Server      *ServerIdentity   `json:"server,omitempty"`
+	Methodology map[string]string `json:"methodology,omitempty"` // metric -> method identifier
+	QoS         *QoSMarking       `json:"qos,omitempty"`
+
+	TCPSessionIDs []string    `json:"tcpSessionIds,omitempty"`
+	TCPInfo       []TCPInfo   `json:"tcpInfo,omitempty"`
+	Load          *ServerLoad `json:"load,omitempty"`
+
+	Samples *Samples `json:"samples,omitempty"` // raw measurements, saved with the result
+}
+
+// Samples are the raw measurements behind a result's summary numbers.
+type Samples struct {
+	Download    []ThroughputSample `json:"download,omitempty"`    // one per second
+	Upload      []ThroughputSample `json:"upload,omitempty"`      // one per second
+	LatencyRTTs []float64          `json:"latencyRtts,omitempty"` // ms, one per latency ping
+	JitterRTTs  []float64          `json:"jitterRtts,omitempty"`  // ms, data-channel echoes in arrival order
+}
+
+// ThroughputSample is the rate of a transfer over the interval ending
+// ElapsedMs after it started.
+type ThroughputSample struct {
+	ElapsedMs float64 `json:"elapsedMs"`
+	Mbps      float64 `json:"mbps"`
+}
+
+// ClientMetadata records where a result was submitted from.
+type ClientMetadata struct {
+	RemoteIP  string `json:"remoteIp"`
+	UserAgent string `json:"userAgent,omitempty"`
+	Protocol  string `json:"protocol"`
+	Hostname  string `json:"hostname"`
+}
+
+// SessionEvent is one entry of the server's WebRTC session timeline.
+type SessionEvent struct {
+	ElapsedMs float64 `json:"elapsedMs"`
+	Event     string  `json:"event"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// ServerIdentity identifies the server that saved a result.
+type ServerIdentity struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	Label   string `json:"label,omitempty"`
+}
+
+// QoSMarking is the DSCP the server marked its test traffic with.
+type QoSMarking struct {
+	TCP    *int `json:"tcp,omitempty"`
+	WebRTC *int `json:"webrtc,omitempty"`
+}
+
+// TCPInfo is the server kernel's view of a download or upload connection
+// when its session ended; Linux servers only.
+type TCPInfo struct {
+	SessionID        string  `json:"sessionId"`
+	Type             string  `json:"type"`
+	Congestion       string  `json:"congestion,omitempty"`
+	RTTMs            float64 `json:"rttMs"`
+	RTTVarMs         float64 `json:"rttVarMs"`
+	MinRTTMs         float64 `json:"minRttMs"`
+	Retransmits      uint32  `json:"retransmits"`
+	Lost             uint32  `json:"lost"`
+	Cwnd             uint32  `json:"cwnd"`
+	MSS              uint32  `json:"mss"`
+	DeliveryRateMbps float64 `json:"deliveryRateMbps"`
+	PacingRateMbps   float64 `json:"pacingRateMbps"`
+	BytesSent        uint64  `json:"bytesSent"`
+	BytesRetrans     uint64  `json:"bytesRetrans"`
+	BusyMs           float64 `json:"busyMs"`
+	RwndLimitedMs    float64 `json:"rwndLimitedMs"`
+	SndbufLimitedMs  float64 `json:"sndbufLimitedMs"`
+}
+
+// ServerLoad is how busy the server was while a result's sessions ran.
+type ServerLoad struct {
+	ConcurrentSessions int     `json:"concurrentSessions"`
+	ServerMbps         float64 `json:"serverMbps"`
+}
+
+// Save stores result on the server and returns its ID. The server sets the
+// timestamp, client address and its own annotations.
+func (c *Client) Save(ctx context.Context, result Result) (string, error) {
+	var saved struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/save-result", result, &saved, false); err != nil {
+		return "", err
+	}
+	return saved.ID, nil
+}
+
+// Result fetches a saved result. It returns ErrNotFound for an unknown ID.
+func (c *Client) Result(ctx context.Context, id string) (Result, error) {
+	var result Result
+	err := c.do(ctx, http.MethodGet, "/results/"+url.PathEscape(id), nil, &result, false)
+	if statusCode(err) == http.StatusNotFound {
+		return result, ErrNotFound
+	}
+	return result, err
+}
+
+// Delete removes a saved result with AdminToken. It returns ErrNotFound for
+// an unknown ID.
+func (c *Client) Delete(ctx context.Context, id string) error {
+	err := c.do(ctx, http.MethodDelete, "/results/"+url.PathEscape(id), nil, nil, true)
+	if statusCode(err) == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// History fetches the results saved with clientID, newest first.
+func (c *Client) History(ctx context.Context, clientID string) ([]Result, error) {
+	var history struct {
+		Results []Result `json:"results"`
+	}
+	err := c.do(ctx, http.MethodGet, "/history/"+url.PathEscape(clientID), nil, &history, false)
+	return history.Results, err
+}
+
+// List fetches a page of at most limit saved results, newest first, matching
+// filter, which takes the query parameters of GET /results such as from,
+// tag or client. An empty cursor starts at the newest result; pass the
+// returned cursor to fetch the next page, until it is empty. List needs the
+// admin token.
+func (c *Client) List(ctx context.Context, filter url.Values, cursor string, limit int) ([]Result, string, error) {
+	query := url.Values{}
+	for key, values := range filter {
+		query[key] = values
+	}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	path := "/results"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var page struct {
+		Results    []Result `json:"results"`
+		NextCursor string   `json:"nextCursor"`
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, &page, true)
+	return page.Results, page.NextCursor, err
+}
+
+func statusCode(err error) int {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,399 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-netspeed/measure"
+)
+
+// Methodology identifiers Run records per metric, matching the server's.
+const (
+	MethodHTTPStream = "http-stream/1"
+	MethodHTTPPost   = "http-post/1"
+	MethodHTTPPing   = "http-ping/1"
+	MethodStreamPing = "stream-ping/1"
+	MethodWebRTCEcho = "webrtc-echo/1"
+)
+
+const (
+	defaultPings        = 10
+	defaultPingInterval = 100 * time.Millisecond
+	sampleInterval      = time.Second // of Transfer.Samples
+)
+
+// Transfer is the outcome of a download or upload test.
+type Transfer struct {
+	Bytes     int64
+	Duration  time.Duration
+	Mbps      float64     // in the web client's units, (Bytes * 8) / 1024^2 per second
+	SessionID string      // the server's session, which it reports TCP info and load for
+	Echo      *UploadEcho // acks of an upload; nil for downloads
+	Samples   []ThroughputSample
+}
+
+// UploadEcho summarizes the acks the server streams back during an upload.
+type UploadEcho struct {
+	Acks         int   // 0 when the server does not support echo mode
+	Received     int64 // bytes the server acknowledged in its final ack
+	MaxLeadBytes int64 // most bytes written but not yet acknowledged
+}
+
+// Buffered reports whether the upload of size bytes looks buffered by a proxy:
+// more than half of it was written before the server acknowledged it.
+func (e UploadEcho) Buffered(size int64) bool {
+	return e.Acks > 0 && e.MaxLeadBytes > size/2
+}
+
+// sampledReader records the throughput of the bytes read through it once
+// per sampleInterval.
+type sampledReader struct {
+	r io.Reader
+
+	mu      sync.Mutex // an upload body is read by the HTTP transport
+	start   time.Time
+	last    time.Time
+	bytes   int64 // since last
+	samples []ThroughputSample
+}
+
+func newSampledReader(r io.Reader) *sampledReader {
+	now := time.Now()
+	return &sampledReader{r: r, start: now, last: now}
+}
+
+func (s *sampledReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes += int64(n)
+	if now := time.Now(); now.Sub(s.last) >= sampleInterval {
+		s.record(now)
+	}
+	return n, err
+}
+
+// record closes the current interval. s.mu must be held.
+func (s *sampledReader) record(now time.Time) {
+	s.samples = append(s.samples, ThroughputSample{
+		ElapsedMs: float64(now.Sub(s.start).Microseconds()) / 1000,
+		Mbps:      measure.Mbps(s.bytes, now.Sub(s.last)),
+	})
+	s.last, s.bytes = now, 0
+}
+
+// Samples returns the samples so far, including the partial last interval.
+func (s *sampledReader) Samples() []ThroughputSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bytes > 0 {
+		s.record(time.Now())
+	}
+	return append([]ThroughputSample(nil), s.samples...)
+}
+
+// latencyProbe is one line of a /latency/stream request and its echo.
+type latencyProbe struct {
+	Seq        int     `json:"seq"`
+	ClientTime float64 `json:"clientTime"`
+	ServerTime int64   `json:"serverTime,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Latency measures count round trips, interval apart, over one long-lived
+// /latency/stream request and returns them in milliseconds. On error, the
+// round trips measured so far are returned with it.
+func (c *Client) Latency(ctx context.Context, count int, interval time.Duration) ([]float64, error) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/latency/stream"), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, fmt.Errorf("latency stream failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("latency stream failed: status %d", resp.StatusCode)
+	}
+
+	enc := json.NewEncoder(pw)
+	dec := json.NewDecoder(resp.Body)
+	rtts := make([]float64, 0, count)
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return rtts, ctx.Err()
+			}
+		}
+		start := time.Now()
+		if err := enc.Encode(latencyProbe{Seq: seq, ClientTime: float64(start.UnixMicro()) / 1000}); err != nil {
+			return rtts, fmt.Errorf("latency stream send failed: %w", err)
+		}
+		var echo latencyProbe
+		if err := dec.Decode(&echo); err != nil {
+			return rtts, fmt.Errorf("latency stream receive failed: %w", err)
+		}
+		if echo.Error != "" {
+			return rtts, fmt.Errorf("latency stream: %s", echo.Error)
+		}
+		if echo.Seq != seq {
+			return rtts, fmt.Errorf("latency stream echoed probe %d, want %d", echo.Seq, seq)
+		}
+		rtts = append(rtts, float64(time.Since(start).Microseconds())/1000)
+	}
+	return rtts, nil
+}
+
+// Pings measures count round trips, interval apart, with separate GET
+// /latency requests, which servers without /latency/stream answer too.
+// Failed pings are left out.
+func (c *Client) Pings(ctx context.Context, count int, interval time.Duration) ([]float64, error) {
+	var rtts []float64
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return rtts, ctx.Err()
+			}
+		}
+		start := time.Now()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?%d", c.url("/latency"), start.UnixNano()), nil)
+		if err != nil {
+			return rtts, err
+		}
+		resp, err := c.send(req)
+		if err != nil {
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			rtts = append(rtts, float64(time.Since(start).Microseconds())/1000)
+		}
+	}
+	return rtts, nil
+}
+
+// DownloadOptions configures a download test.
+type DownloadOptions struct {
+	SizeMB     int64
+	Congestion string // congestion control the server sends with, one of Config.CongestionControls
+}
+
+// Download streams opts.SizeMB from the server over one request.
+func (c *Client) Download(ctx context.Context, opts DownloadOptions) (Transfer, error) {
+	query := url.Values{"size": {strconv.FormatInt(opts.SizeMB, 10)}}
+	if opts.Congestion != "" {
+		query.Set("cc", opts.Congestion)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/download?"+query.Encode()), nil)
+	if err != nil {
+		return Transfer{}, err
+	}
+	start := time.Now()
+	resp, err := c.send(req)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Transfer{}, fmt.Errorf("download failed: status %d", resp.StatusCode)
+	}
+	body := newSampledReader(resp.Body)
+	received, err := io.Copy(io.Discard, body)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("download failed: %w", err)
+	}
+	d := time.Since(start)
+	return Transfer{Bytes: received, Duration: d, Mbps: measure.Mbps(received, d), SessionID: resp.Header.Get("X-Session-ID"),
+		Samples: body.Samples()}, nil
+}
+
+// zeroReader is an endless source of zero bytes for uploads.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// sentCounter counts the upload bytes written, for comparison with acks.
+type sentCounter struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (sc *sentCounter) Read(p []byte) (int, error) {
+	n, err := sc.r.Read(p)
+	sc.n.Add(int64(n))
+	return n, err
+}
+
+// uploadAck is one line of the response of /upload?echo=1.
+type uploadAck struct {
+	Bytes int64  `json:"bytes"`
+	Done  bool   `json:"done,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Upload streams sizeMB to the server over one request, with echo acks that
+// show a proxy buffering the upload.
+func (c *Client) Upload(ctx context.Context, sizeMB int64) (Transfer, error) {
+	size := sizeMB * 1024 * 1024
+	sent := &sentCounter{r: io.LimitReader(zeroReader{}, size)}
+	body := newSampledReader(sent)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/upload?echo=1"), body)
+	if err != nil {
+		return Transfer{}, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	start := time.Now()
+	resp, err := c.send(req)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Transfer{}, fmt.Errorf("upload failed: status %d", resp.StatusCode)
+	}
+	echo, err := readUploadAcks(resp.Body, sent)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("upload failed: %w", err)
+	}
+	d := time.Since(start)
+	return Transfer{Bytes: size, Duration: d, Mbps: measure.Mbps(size, d), SessionID: resp.Header.Get("X-Session-ID"), Echo: &echo,
+		Samples: body.Samples()}, nil
+}
+
+// readUploadAcks consumes the response of /upload?echo=1 while the request
+// body is still being written through sent.
+func readUploadAcks(body io.Reader, sent *sentCounter) (UploadEcho, error) {
+	var echo UploadEcho
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		var ack uploadAck
+		if err := json.Unmarshal(scanner.Bytes(), &ack); err != nil {
+			return echo, fmt.Errorf("invalid upload ack: %w", err)
+		}
+		echo.Acks++
+		echo.MaxLeadBytes = max(echo.MaxLeadBytes, sent.n.Load()-ack.Bytes)
+		if ack.Done {
+			if ack.Error != "" {
+				return echo, fmt.Errorf("server: %s", ack.Error)
+			}
+			echo.Received = ack.Bytes
+		}
+	}
+	return echo, scanner.Err()
+}
+
+// RunOptions configures Run.
+type RunOptions struct {
+	SizeMB int64 // download and upload size; the server's default when 0
+	Pings  int   // latency round trips; 10 when 0
+	// Logf reports fallbacks and warnings, such as an upload buffered by a
+	// proxy; they are not reported when nil.
+	Logf func(format string, args ...any)
+}
+
+// Run runs the web client's tests: latency from HTTP pings, download,
+// upload, and jitter and loss over a WebRTC data channel. Pings use
+// /latency/stream, or separate requests on servers without it. When the data
+// channel cannot be established, jitter and loss fall back to the pings. The
+// result records the sessions of the transfers, so saving it attaches the
+// server's TCP info and load, and keeps the raw samples in Samples; set it
+// to nil to save only the summary.
+func (c *Client) Run(ctx context.Context, opts RunOptions) (Result, error) {
+	logf := opts.Logf
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	pings := opts.Pings
+	if pings <= 0 {
+		pings = defaultPings
+	}
+	sizeMB := opts.SizeMB
+	if sizeMB <= 0 {
+		config, err := c.Config(ctx)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to read the default size: %w", err)
+		}
+		sizeMB = config.DefaultSizeMB
+	}
+	result := Result{Methodology: map[string]string{
+		"download": MethodHTTPStream,
+		"upload":   MethodHTTPPost,
+		"jitter":   MethodWebRTCEcho,
+		"loss":     MethodWebRTCEcho,
+	}}
+
+	// 1. Latency from sequential pings, over one stream when the server supports it
+	rtts, err := c.Latency(ctx, pings, defaultPingInterval)
+	latencyMethod := MethodStreamPing
+	if err != nil {
+		logf("Latency stream to %s failed, using separate pings instead: %v", c.BaseURL, err)
+		rtts, _ = c.Pings(ctx, pings, defaultPingInterval)
+		latencyMethod = MethodHTTPPing
+	}
+	if len(rtts) == 0 {
+		return Result{}, fmt.Errorf("%s did not answer latency pings", c.BaseURL)
+	}
+	result.LatencyMs = measure.MeanLatency(rtts)
+	result.Methodology["latency"] = latencyMethod
+	result.Samples = &Samples{LatencyRTTs: rtts}
+
+	// 2. Download
+	down, err := c.Download(ctx, DownloadOptions{SizeMB: sizeMB})
+	if err != nil {
+		return Result{}, err
+	}
+	result.DownloadSpeedMbps = down.Mbps
+	result.Samples.Download = down.Samples
+
+	// 3. Upload
+	up, err := c.Upload(ctx, sizeMB)
+	if err != nil {
+		return Result{}, err
+	}
+	result.UploadSpeedMbps = up.Mbps
+	result.Samples.Upload = up.Samples
+	if up.Echo.Buffered(up.Bytes) {
+		logf("Upload to %s looks buffered by a proxy: up to %d of %d bytes were sent before the server received them",
+			c.BaseURL, up.Echo.MaxLeadBytes, up.Bytes)
+	}
+	for _, id := range []string{down.SessionID, up.SessionID} {
+		if id != "" {
+			result.TCPSessionIDs = append(result.TCPSessionIDs, id)
+		}
+	}
+
+	// 4. Jitter and loss over UDP, like the browser
+	jitter, err := c.Jitter(ctx)
+	if err != nil {
+		logf("WebRTC jitter test to %s failed, using HTTP pings instead: %v", c.BaseURL, err)
+		result.JitterMs, result.PacketLossPercent = measure.JitterLoss(rtts, pings)
+		result.Methodology["jitter"], result.Methodology["loss"] = latencyMethod, latencyMethod
+	} else {
+		result.JitterMs, result.PacketLossPercent = jitter.JitterMs, jitter.LossPercent
+		result.WebRTCSessionID = jitter.SessionID
+		result.Samples.JitterRTTs = jitter.RTTs
+	}
+	return result, nil
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"go-netspeed/measure"
+)
+
+// The data-channel test sends the same packets at the same pace as the web
+// client (NUM_PACKETS, PACKET_INTERVAL and MAX_WAIT_BUFFER in speedtest.js),
+// unless the server's /api/config asks for a different cadence.
+const (
+	jitterTestPackets  = 250
+	jitterTestInterval = 40 * time.Millisecond
+	jitterTestWait     = time.Second
+	jitterTestConnect  = 15 * time.Second // signaling, ICE and data channel open
+)
+
+// defaultICEServers are the web client's.
+var defaultICEServers = []webrtc.ICEServer{{URLs: []string{"stun:stun.l.google.com:19302"}}}
+
+// JitterResult is the outcome of a data-channel jitter and loss test.
+type JitterResult struct {
+	SessionID   string    `json:"sessionId,omitempty"` // server session, for its WebRTC timeline
+	Sent        int       `json:"sent"`
+	RTTs        []float64 `json:"rtts"` // in arrival order, lost packets left out
+	JitterMs    float64   `json:"jitterMs"`
+	LossPercent float64   `json:"lossPercent"`
+}
+
+// jitterPacket is the payload the server echoes back.
+type jitterPacket struct {
+	ID       int   `json:"id"`
+	SendTime int64 `json:"sendTime"` // unix milliseconds
+}
+
+// offerAnswer is the answer of /webrtc/offer: an SDP answer, or the
+// synthesized round-trip times of a -demo server.
+type offerAnswer struct {
+	SDP       string    `json:"sdp"`
+	SessionID string    `json:"sessionId,omitempty"`
+	Demo      bool      `json:"demo"`
+	Sent      int       `json:"sent"`
+	RTTs      []float64 `json:"rtts"`
+}
+
+// latencyPolicy fetches the probe cadence the server asks for, falling back
+// to the defaults for servers that do not publish one.
+func (c *Client) latencyPolicy(ctx context.Context) LatencyPolicy {
+	policy := LatencyPolicy{IntervalMs: float64(jitterTestInterval.Milliseconds()), Count: jitterTestPackets}
+	config, err := c.Config(ctx)
+	if err == nil && config.Latency.Count > 0 && config.Latency.IntervalMs > 0 {
+		policy = config.Latency
+	}
+	return policy
+}
+
+// Jitter measures UDP jitter and loss the way the browser does: an
+// unordered, unreliable data channel whose packets the server echoes back,
+// at the cadence the server's configuration asks for.
+func (c *Client) Jitter(ctx context.Context) (JitterResult, error) {
+	policy := c.latencyPolicy(ctx)
+	packets, interval := policy.Count, time.Duration(policy.IntervalMs*float64(time.Millisecond))
+
+	api := c.WebRTC
+	if api == nil {
+		api = webrtc.NewAPI()
+	}
+	iceServers := c.ICEServers
+	if iceServers == nil {
+		iceServers = defaultICEServers
+	}
+	pc, err := api.NewPeerConnection(webrtc.Configuration{ICEServers: iceServers})
+	if err != nil {
+		return JitterResult{}, fmt.Errorf("failed to create peer connection: %w", err)
+	}
+	defer pc.Close()
+
+	ordered, maxRetransmits := false, uint16(0)
+	dc, err := pc.CreateDataChannel("jitter-test", &webrtc.DataChannelInit{Ordered: &ordered, MaxRetransmits: &maxRetransmits})
+	if err != nil {
+		return JitterResult{}, fmt.Errorf("failed to create data channel: %w", err)
+	}
+
+	var (
+		mu       sync.Mutex
+		sentAt   = make([]time.Time, packets)
+		rtts     []float64
+		allEchos = make(chan struct{})
+	)
+	opened := make(chan struct{})
+	dc.OnOpen(func() { close(opened) })
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		var p jitterPacket
+		if json.Unmarshal(msg.Data, &p) != nil || p.ID < 0 || p.ID >= packets {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if sentAt[p.ID].IsZero() {
+			return
+		}
+		rtts = append(rtts, float64(time.Since(sentAt[p.ID]).Microseconds())/1000)
+		sentAt[p.ID] = time.Time{} // count duplicates once
+		if len(rtts) == packets {
+			close(allEchos)
+		}
+	})
+
+	// Signaling: send the complete offer, as the browser does after gathering
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return JitterResult{}, fmt.Errorf("failed to create offer: %w", err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return JitterResult{}, fmt.Errorf("failed to set local description: %w", err)
+	}
+	connectCtx, cancel := context.WithTimeout(ctx, jitterTestConnect)
+	defer cancel()
+	select {
+	case <-gatherComplete:
+	case <-connectCtx.Done():
+		return JitterResult{}, fmt.Errorf("ICE gathering: %w", connectCtx.Err())
+	}
+
+	var answer offerAnswer
+	offerBody := map[string]string{"sdp": pc.LocalDescription().SDP}
+	if err := c.do(connectCtx, http.MethodPost, "/webrtc/offer", offerBody, &answer, false); err != nil {
+		return JitterResult{}, fmt.Errorf("offer failed: %w", err)
+	}
+	if answer.Demo {
+		result := JitterResult{Sent: answer.Sent, RTTs: answer.RTTs}
+		result.JitterMs, result.LossPercent = measure.JitterLoss(result.RTTs, result.Sent)
+		return result, nil
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer.SDP}); err != nil {
+		return JitterResult{}, fmt.Errorf("invalid answer SDP: %w", err)
+	}
+	select {
+	case <-opened:
+	case <-connectCtx.Done():
+		return JitterResult{}, fmt.Errorf("data channel did not open: %w", connectCtx.Err())
+	}
+
+	// Send at a fixed pace, then wait briefly for stragglers
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	sent := 0
+	for ; sent < packets; sent++ {
+		payload, _ := json.Marshal(jitterPacket{ID: sent, SendTime: time.Now().UnixMilli()})
+		mu.Lock()
+		sentAt[sent] = time.Now()
+		mu.Unlock()
+		if err := dc.Send(payload); err != nil {
+			return JitterResult{}, fmt.Errorf("data channel send failed after %d packets: %w", sent, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return JitterResult{}, ctx.Err()
+		}
+	}
+	select {
+	case <-allEchos:
+	case <-time.After(jitterTestWait):
+	case <-ctx.Done():
+		return JitterResult{}, ctx.Err()
+	}
+	dc.Close()
+
+	mu.Lock()
+	result := JitterResult{SessionID: answer.SessionID, Sent: sent, RTTs: append([]float64{}, rtts...)}
+	mu.Unlock()
+	result.JitterMs, result.LossPercent = measure.JitterLoss(result.RTTs, result.Sent)
+	return result, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// One-way delays, the server times in latency and WebSocket echoes and
+// result timestamps are only as good as the system clock. With -ntp-servers
+// the clock is compared against NTP (SNTP, RFC 4330) at startup and every
+// -ntp-interval; a skew beyond -ntp-max-skew is logged and results saved
+// meanwhile are tagged clock-skew={skew}. The server only checks the clock,
+// setting it is left to the system's time daemon.
+
+const (
+	ntpTimeout = 5 * time.Second
+	// ntpEpochOffset is the seconds from the NTP epoch (1900) to the Unix epoch.
+	ntpEpochOffset = 2208988800
+)
+
+// clockCheck is the outcome of the last successful check.
+type clockCheck struct {
+	skew    time.Duration // system time minus NTP time, the median of the servers
+	servers int           // servers that answered
+}
+
+// lastClockCheck is nil until a check succeeded.
+var lastClockCheck atomic.Pointer[clockCheck]
+
+var clockChecks = newCounterVec("netspeed_clock_checks_total",
+	"Checks of the system clock against -ntp-servers by result: ok, skewed or failed.", "result")
+
+var _ = newGaugeFunc("netspeed_clock_skew_seconds",
+	"How far the system clock was ahead of -ntp-servers at the last check, negative when behind.", nil,
+	func() map[string]float64 {
+		check := lastClockCheck.Load()
+		if check == nil {
+			return nil
+		}
+		return map[string]float64{"": check.skew.Seconds()}
+	})
+
+// toNTPTime encodes t as a 64-bit NTP timestamp.
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// fromNTPTime decodes a 64-bit NTP timestamp of the current era.
+func fromNTPTime(ts uint64) time.Time {
+	seconds := int64(ts>>32) - ntpEpochOffset
+	nanos := int64((ts & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanos)
+}
+
+// queryNTP asks one server for the time and returns its offset from the
+// system clock, positive when the system clock is behind.
+func queryNTP(server string) (time.Duration, error) {
+	addr := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		addr = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", addr, ntpTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ntpTimeout))
+
+	request := make([]byte, 48)
+	request[0] = 0x23 // no leap warning, version 4, client mode
+	sent := time.Now()
+	binary.BigEndian.PutUint64(request[40:], toNTPTime(sent))
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	response := make([]byte, 48)
+	for {
+		n, err := conn.Read(response)
+		if err != nil {
+			return 0, err
+		}
+		received := time.Now()
+		// The server echoes the transmit time as the origin time; anything
+		// else is a late answer to another request
+		if n < 48 || !bytes.Equal(response[24:32], request[40:48]) {
+			continue
+		}
+		if mode := response[0] & 0x07; mode != 4 {
+			return 0, fmt.Errorf("%s: unexpected NTP mode %d", server, mode)
+		}
+		if stratum := response[1]; stratum == 0 || stratum > 15 {
+			return 0, fmt.Errorf("%s: server is unsynchronized (stratum %d)", server, stratum)
+		}
+		serverReceived := fromNTPTime(binary.BigEndian.Uint64(response[32:40]))
+		serverSent := fromNTPTime(binary.BigEndian.Uint64(response[40:48]))
+		return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+	}
+}
+
+// checkClock queries every -ntp-servers entry and records the median skew.
+func checkClock() error {
+	var skews []time.Duration
+	var errs []string
+	for _, server := range strings.Split(*ntpServers, ",") {
+		if server = strings.TrimSpace(server); server == "" {
+			continue
+		}
+		offset, err := queryNTP(server)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		skews = append(skews, -offset)
+	}
+	if len(skews) == 0 {
+		return fmt.Errorf("no NTP server answered: %s", strings.Join(errs, "; "))
+	}
+	slices.Sort(skews)
+	check := &clockCheck{skew: skews[len(skews)/2], servers: len(skews)}
+	previous := lastClockCheck.Swap(check)
+	wasSkewed := previous != nil && skewed(previous.skew)
+	switch {
+	case skewed(check.skew):
+		clockChecks.Inc("skewed")
+		log.Printf("Warning: the system clock is off by %s according to NTP (%d servers answered); one-way delays and timestamps are unreliable until it is corrected", check.skew.Round(time.Millisecond), check.servers)
+	case wasSkewed:
+		clockChecks.Inc("ok")
+		log.Printf("The system clock is back within %s of NTP (off by %s)", *ntpMaxSkew, check.skew.Round(time.Millisecond))
+	default:
+		clockChecks.Inc("ok")
+		if *verbose {
+			log.Printf("The system clock is off by %s according to NTP (%d servers answered)", check.skew.Round(time.Millisecond), check.servers)
+		}
+	}
+	return nil
+}
+
+func skewed(skew time.Duration) bool {
+	return skew > *ntpMaxSkew || skew < -*ntpMaxSkew
+}
+
+// clockSkew returns how far the system clock was off at the last check, when
+// that is beyond -ntp-max-skew.
+func clockSkew() (time.Duration, bool) {
+	check := lastClockCheck.Load()
+	if check == nil || !skewed(check.skew) {
+		return 0, false
+	}
+	return check.skew, true
+}
+
+// attachClockSkew tags a result with clock-skew, e.g. clock-skew=-1.2s for a
+// system clock 1.2 seconds behind, while the clock is skewed, and removes
+// the tag otherwise.
+func attachClockSkew(result *TestResult) {
+	delete(result.Tags, "clock-skew")
+	skew, ok := clockSkew()
+	if !ok {
+		return
+	}
+	if result.Tags == nil {
+		result.Tags = map[string]string{}
+	}
+	result.Tags["clock-skew"] = skew.Round(time.Millisecond).String()
+}
+
+// startClockCheck checks the clock against -ntp-servers and again every
+// -ntp-interval in the background.
+func startClockCheck() error {
+	if *ntpServers == "" {
+		return nil
+	}
+	if *ntpInterval < time.Minute {
+		return fmt.Errorf("-ntp-interval must be at least 1m")
+	}
+	if *ntpMaxSkew <= 0 {
+		return fmt.Errorf("-ntp-max-skew must be positive")
+	}
+	go func() {
+		ticker := time.NewTicker(*ntpInterval)
+		defer ticker.Stop()
+		for {
+			if err := checkClock(); err != nil {
+				clockChecks.Inc("failed")
+				log.Printf("Failed to check the system clock: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-serverContext.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
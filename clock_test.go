@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttachClockSkew(t *testing.T) {
+	defer lastClockCheck.Store(lastClockCheck.Load())
+	tests := []struct {
+		check *clockCheck
+		want  string // empty for no tag
+	}{
+		{nil, ""},
+		{&clockCheck{skew: *ntpMaxSkew / 2, servers: 3}, ""},
+		{&clockCheck{skew: -1250 * time.Millisecond, servers: 3}, "-1.25s"},
+	}
+	for _, tt := range tests {
+		lastClockCheck.Store(tt.check)
+		result := TestResult{Tags: map[string]string{"clock-skew": "5s"}}
+		attachClockSkew(&result)
+		if got, ok := result.Tags["clock-skew"]; got != tt.want || ok != (tt.want != "") {
+			t.Errorf("attachClockSkew with %+v tagged clock-skew=%q, want %q", tt.check, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go-netspeed/client"
+)
+
+// runCommand executes a maintenance subcommand instead of starting the server.
+// Subcommands accept the same flags as the server, e.g.
+//
+//	go-netspeed reindex -badger-path /var/lib/netspeed
+//	go-netspeed rotate-badger-key -badger-old-encryption-key-file old.key -badger-encryption-key-file new.key
+//	go-netspeed peer-test -target https://other-instance:8080
+//	go-netspeed test -target https://speedtest.example.com
+//	go-netspeed replay -target http://localhost:8080 recordings/*.json
+//	go-netspeed congestion-test -target https://speedtest.example.com -congestion-controls cubic,bbr
+func runCommand(name string, args []string) {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	if err := validateOutbound(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	switch name {
+	case "reindex":
+		runReindex()
+	case "rotate-badger-key":
+		runRotateBadgerKey()
+	case "peer-test":
+		runPeerTestCommand()
+	case "replay":
+		runReplayCommand(flag.Args())
+	case "test":
+		runTestCommand()
+	case "congestion-test":
+		runCongestionTestCommand()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q. Available commands: reindex, rotate-badger-key, peer-test, replay, test, congestion-test\n", name)
+		os.Exit(2)
+	}
+}
+
+// runReindex rebuilds the Badger secondary indexes from the stored results.
+func runReindex() {
+	if *storeType != "badger" {
+		fmt.Fprintln(os.Stderr, "reindex only applies to the badger store; SQL databases maintain their indexes themselves")
+		os.Exit(2)
+	}
+	key, err := readEncryptionKey(*badgerKeyFile)
+	if err != nil {
+		log.Fatalf("Invalid encryption key: %v", err)
+	}
+	store, err := NewBadgerStore(*badgerPath, key, *badgerKeyRotation)
+	if err != nil {
+		log.Fatalf("Failed to open Badger KV store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Reindex(); err != nil {
+		log.Fatalf("Reindex failed: %v", err)
+	}
+}
+
+// runPeerTestCommand measures the path to -target and stores the result in the
+// local store, printing it as JSON.
+func runPeerTestCommand() {
+	if *peerTarget == "" {
+		fmt.Fprintln(os.Stderr, "peer-test requires -target, e.g. -target https://other-instance:8080")
+		os.Exit(2)
+	}
+	store, err := openStore()
+	if err != nil {
+		log.Fatalf("Failed to open result store: %v", err)
+	}
+	defer store.Close()
+	globalStore = store
+
+	result, err := runPeerTest(*peerTarget, *defaultSize, nil)
+	if err != nil {
+		log.Fatalf("Peer test failed: %v", err)
+	}
+	out, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(out))
+}
+
+// runTestCommand runs the web client's tests, including the WebRTC jitter and
+// loss test, against -target and saves the result there like the browser
+// does, printing it as JSON.
+func runTestCommand() {
+	if *peerTarget == "" {
+		fmt.Fprintln(os.Stderr, "test requires -target, e.g. -target https://speedtest.example.com")
+		os.Exit(2)
+	}
+	base, err := parseTargetURL(*peerTarget)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	c, err := newTargetClient(base)
+	if err != nil {
+		log.Fatalf("Test failed: %v", err)
+	}
+	result, err := c.Run(serverContext, client.RunOptions{SizeMB: *defaultSize, Logf: log.Printf})
+	if err != nil {
+		log.Fatalf("Test failed: %v", err)
+	}
+	result.Timestamp = time.Now()
+	result.Tags = map[string]string{"source": "cli"}
+	if uplink := outboundLabel(); uplink != "" {
+		result.Tags["uplink"] = uplink
+	}
+	if id, err := c.Save(serverContext, result); err != nil {
+		log.Printf("Failed to save result on %s: %v", base, err)
+	} else {
+		result.ID = id
+		log.Printf("Result saved: %s", shareURL(base.String(), id))
+	}
+	out, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(out))
+}
+
+// runCongestionTestCommand compares the download throughput of congestion
+// control algorithms on the path from -target, printing the comparison as JSON.
+func runCongestionTestCommand() {
+	if *peerTarget == "" {
+		fmt.Fprintln(os.Stderr, "congestion-test requires -target, e.g. -target https://speedtest.example.com")
+		os.Exit(2)
+	}
+	base, err := parseTargetURL(*peerTarget)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *congestionRounds < 1 {
+		fmt.Fprintln(os.Stderr, "-congestion-rounds must be at least 1")
+		os.Exit(2)
+	}
+
+	algorithms := strings.FieldsFunc(*congestionCompare, func(r rune) bool { return r == ',' || r == ' ' })
+	comparisons, err := runCongestionTest(base, algorithms, *congestionRounds, *defaultSize)
+	if err != nil {
+		log.Fatalf("Congestion test failed: %v", err)
+	}
+	out, _ := json.MarshalIndent(comparisons, "", "  ")
+	fmt.Println(string(out))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"go-netspeed/client"
+)
+
+// congestionControls are the TCP congestion control algorithms downloads may
+// select with ?cc=, resolved by configureCongestion.
+var congestionControls []string
+
+// configureCongestion checks -congestion-control against the algorithms this
+// process may use.
+func configureCongestion() error {
+	if congestionControlSupported {
+		congestionControls = permittedCongestionControls()
+	}
+	if *congestionControl == "" {
+		return nil
+	}
+	if !congestionControlSupported {
+		return fmt.Errorf("-congestion-control is only supported on Linux")
+	}
+	if !slices.Contains(congestionControls, *congestionControl) {
+		return fmt.Errorf("congestion control %q is not permitted (available: %s)", *congestionControl, strings.Join(congestionControls, ", "))
+	}
+	log.Printf("Serving tests with TCP congestion control %s", *congestionControl)
+	return nil
+}
+
+// listenControl sets -congestion-control on listening sockets; accepted
+// connections inherit it.
+func listenControl(network, address string, c syscall.RawConn) error {
+	if *congestionControl == "" {
+		return nil
+	}
+	var err error
+	if cerr := c.Control(func(fd uintptr) { err = setCongestion(fd, *congestionControl) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// setConnCongestion switches c to the named algorithm and returns the one
+// it used before.
+func setConnCongestion(c *net.TCPConn, name string) (string, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	var previous string
+	if cerr := raw.Control(func(fd uintptr) {
+		if previous, err = getCongestion(fd); err == nil {
+			err = setCongestion(fd, name)
+		}
+	}); cerr != nil {
+		return "", cerr
+	}
+	return previous, err
+}
+
+// CongestionComparison summarizes the downloads one algorithm served in a
+// congestion-test run.
+type CongestionComparison struct {
+	Congestion string    `json:"congestion"`
+	Mbps       []float64 `json:"mbps"` // one per round
+	MeanMbps   float64   `json:"meanMbps"`
+	ResultIDs  []string  `json:"resultIds,omitempty"`
+}
+
+// runCongestionTest downloads sizeMB from the netspeed server at base once
+// per algorithm and round, each on a fresh connection, and saves every
+// download there as a result tagged source=congestion-test. The order of the
+// algorithms rotates each round, so a trend on the path does not favor one.
+// Without algorithms, all the server permits are compared.
+func runCongestionTest(base *url.URL, algorithms []string, rounds int, sizeMB int64) ([]CongestionComparison, error) {
+	c, err := newTargetClient(base)
+	if err != nil {
+		return nil, err
+	}
+	transport := outboundTransport()
+	transport.DisableKeepAlives = true
+	c.HTTPClient = &http.Client{Timeout: peerTestTimeout, Transport: transport}
+	if len(algorithms) == 0 {
+		if algorithms, err = serverCongestionControls(c); err != nil {
+			return nil, err
+		}
+	}
+
+	comparisons := make([]CongestionComparison, len(algorithms))
+	for i, cc := range algorithms {
+		comparisons[i].Congestion = cc
+	}
+	for round := 0; round < rounds; round++ {
+		for k := range algorithms {
+			comparison := &comparisons[(round+k)%len(algorithms)]
+			down, err := c.Download(serverContext, client.DownloadOptions{SizeMB: sizeMB, Congestion: comparison.Congestion})
+			if err != nil {
+				return nil, fmt.Errorf("download with %s failed: %w", comparison.Congestion, err)
+			}
+			comparison.Mbps = append(comparison.Mbps, down.Mbps)
+			log.Printf("Round %d: %.2f Mbps with %s", round+1, down.Mbps, comparison.Congestion)
+
+			result := client.Result{
+				DownloadSpeedMbps: down.Mbps,
+				Tags:              map[string]string{"source": "congestion-test", "round": strconv.Itoa(round + 1)},
+			}
+			if down.SessionID != "" {
+				result.TCPSessionIDs = []string{down.SessionID}
+			}
+			if id, err := c.Save(serverContext, result); err != nil {
+				log.Printf("Failed to save result on %s: %v", base, err)
+			} else {
+				comparison.ResultIDs = append(comparison.ResultIDs, id)
+			}
+		}
+	}
+	for i := range comparisons {
+		var total float64
+		for _, speed := range comparisons[i].Mbps {
+			total += speed
+		}
+		comparisons[i].MeanMbps = total / float64(len(comparisons[i].Mbps))
+	}
+	return comparisons, nil
+}
+
+// serverCongestionControls reads the algorithms the server c talks to
+// permits from its /api/config.
+func serverCongestionControls(c *client.Client) ([]string, error) {
+	config, err := c.Config(serverContext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the configuration of %s: %w", c.BaseURL, err)
+	}
+	if len(config.CongestionControls) == 0 {
+		return nil, fmt.Errorf("%s does not support selecting the congestion control", c.BaseURL)
+	}
+	return config.CongestionControls, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const congestionControlSupported = true
+
+// permittedCongestionControls lists the algorithms this process may select:
+// any loaded one with CAP_NET_ADMIN (approximated by root), otherwise those
+// in net.ipv4.tcp_allowed_congestion_control.
+func permittedCongestionControls() []string {
+	path := "/proc/sys/net/ipv4/tcp_allowed_congestion_control"
+	if os.Geteuid() == 0 {
+		path = "/proc/sys/net/ipv4/tcp_available_congestion_control"
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+func setCongestion(fd uintptr, name string) error {
+	return unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION, name)
+}
+
+func getCongestion(fd uintptr) (string, error) {
+	return unix.GetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION)
+}
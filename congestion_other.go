@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// Selecting the congestion control per socket is Linux-specific.
+const congestionControlSupported = false
+
+func permittedCongestionControls() []string {
+	return nil
+}
+
+func setCongestion(fd uintptr, name string) error {
+	return fmt.Errorf("congestion control selection is only supported on Linux")
+}
+
+func getCongestion(fd uintptr) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Public instances may have to show their terms or privacy notice before
+// anyone tests. With -consent-file, /api/config serves the text with its
+// version, and test sessions and saves are refused until the client sends
+// that version back, as the X-Consent-Version header or ?consent=. Saved
+// results are tagged consent={version}.
+
+// maxConsentTextSize bounds the -consent-file text.
+const maxConsentTextSize = 64 * 1024
+
+// ConsentTerms is the text clients must acknowledge, served in /api/config.
+type ConsentTerms struct {
+	Version string `json:"version"`
+	Text    string `json:"text"`
+}
+
+// consentTerms is nil when no consent is required.
+var consentTerms *ConsentTerms
+
+// loadConsent reads -consent-file. Without -consent-version, the version is
+// derived from the text, so editing the terms asks everyone again.
+func loadConsent() error {
+	if *consentFile == "" {
+		if *consentVersion != "" {
+			return fmt.Errorf("-consent-version needs -consent-file")
+		}
+		return nil
+	}
+	data, err := os.ReadFile(*consentFile)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxConsentTextSize || !utf8.Valid(data) {
+		return fmt.Errorf("%s must be UTF-8 text of at most %d bytes", *consentFile, maxConsentTextSize)
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return fmt.Errorf("%s is empty", *consentFile)
+	}
+	version := *consentVersion
+	if version == "" {
+		hash := sha256.Sum256([]byte(text))
+		version = hex.EncodeToString(hash[:6])
+	}
+	if len(version) > maxTagLength {
+		return fmt.Errorf("-consent-version must be at most %d characters", maxTagLength)
+	}
+	consentTerms = &ConsentTerms{Version: version, Text: text}
+	return nil
+}
+
+// requestConsent returns the consent version a request acknowledges.
+func requestConsent(r *http.Request) string {
+	if v := r.Header.Get("X-Consent-Version"); v != "" {
+		return v
+	}
+	return r.URL.Query().Get("consent")
+}
+
+// checkConsent answers 428 when the server requires consent and the request
+// does not acknowledge the current terms. The response names the version
+// expected in X-Consent-Version.
+func checkConsent(w http.ResponseWriter, r *http.Request) bool {
+	if consentTerms == nil || requestConsent(r) == consentTerms.Version {
+		return true
+	}
+	w.Header().Set("X-Consent-Version", consentTerms.Version)
+	http.Error(w, tr(r, "Accept the terms of this server at /api/config before testing"), http.StatusPreconditionRequired)
+	return false
+}
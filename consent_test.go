@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestSaveResultConsentTag(t *testing.T) {
+	defer func(terms *ConsentTerms) { consentTerms = terms }(consentTerms)
+	const body = `{"downloadSpeedMbps": 10, "tags": {"consent": "v9"}}`
+
+	consentTerms = nil
+	if v, ok := saveTestResult(t, newSaveRequest(body)).Tags["consent"]; ok {
+		t.Errorf("without consent terms, the client's consent=%s was stored", v)
+	}
+
+	consentTerms = &ConsentTerms{Version: "v2", Text: "Terms"}
+	r := newSaveRequest(body)
+	r.Header.Set("X-Consent-Version", "v2")
+	if v := saveTestResult(t, r).Tags["consent"]; v != "v2" {
+		t.Errorf("consent tag = %q, want the server's v2", v)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// dataPorts are the extra listener ports actually bound for the data plane.
+var dataPorts []int
+
+// shutdowner is a server that stops gracefully, over TCP or QUIC.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// parsePortList parses a comma-separated list of ports, skipping invalid entries.
+func parsePortList(list string) []int {
+	var ports []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		p, err := strconv.Atoi(field)
+		if err != nil || p <= 0 || p > 65535 {
+			log.Printf("Warning: ignoring invalid data port %q", field)
+			continue
+		}
+		ports = append(ports, p)
+	}
+	return ports
+}
+
+// withCORS allows the page served from the main port to call the data plane,
+// which is a different origin because the port differs.
+func withCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Session-ID, X-Chunk-Size, X-Demo-Size, Content-Range, X-Consent-Version")
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Demo-Size, Range, X-Consent-Version")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// startDataPlane listens on each configured data port and serves only the
+// throughput and latency endpoints there. Spreading streams across ports
+// sidesteps per-connection and per-port throttling in some CGNAT middleboxes.
+// It returns the servers to shut down on exit.
+func startDataPlane(ports []int) []shutdowner {
+	var servers []shutdowner
+	mux := http.NewServeMux()
+	latency, download, upload := testHandlers()
+	mux.HandleFunc("/latency", withCORS(latency))
+	mux.HandleFunc("/download", withCORS(download))
+	if !*demoMode {
+		mux.HandleFunc("/download/multi", withCORS(multiDownloadHandler))
+		mux.HandleFunc("/download/multi/", withCORS(multiDownloadHandler))
+		mux.HandleFunc("/ws/download", websocketDownloadHandler)
+		mux.HandleFunc("/ws/upload", websocketUploadHandler)
+		mux.HandleFunc("/ws/ping", websocketPingHandler)
+	}
+	mux.HandleFunc("/upload", withCORS(upload))
+
+	for _, p := range ports {
+		if p == *port {
+			continue
+		}
+		ln, err := listenTCP(p)
+		if err != nil {
+			log.Printf("Warning: data plane port %d unavailable: %v", p, err)
+			continue
+		}
+		dataPorts = append(dataPorts, p)
+		handler := withBodyDrain(withAccessPolicy(withRecorder(mux)))
+		if quicServer, err := startHTTP3(p, handler); err != nil {
+			log.Printf("Warning: data plane port %d serves no HTTP/3: %v", p, err)
+		} else {
+			handler = withAltSvc(p, handler)
+			if quicServer != nil {
+				servers = append(servers, quicServer)
+			}
+		}
+		server := &http.Server{Handler: handler, ConnState: trackConnState, ConnContext: connContext, BaseContext: serverBaseContext}
+		configureHTTP2(server)
+		servers = append(servers, server)
+		go func() {
+			if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Printf("Data plane listener on port %d stopped: %v", p, err)
+			}
+		}()
+	}
+	if len(dataPorts) > 0 {
+		log.Printf("Data plane listening on additional ports %v", dataPorts)
+	}
+	return servers
+}
+
+// ClientConfig is the public configuration the web client reads at startup.
+type ClientConfig struct {
+	DataPorts     []int `json:"dataPorts"`
+	MaxSizeMB     int64 `json:"maxSizeMB"`
+	DefaultSizeMB int64 `json:"defaultSizeMB"`
+	MinSizeMB     int64 `json:"minSizeMB"`
+	Relay         bool  `json:"relay"`         // /relay streams a payload fetched from an upstream origin
+	NetPing       bool  `json:"netPing"`       // /api/netping pings the client at the network layer
+	Demo          bool  `json:"demo"`          // test endpoints synthesize results
+	DeleteResults bool  `json:"deleteResults"` // browsers can delete the results they saved
+
+	Latency LatencyPolicy `json:"latency"`
+
+	MaxDownloadSeconds int64 `json:"maxDownloadSeconds"` // longest /download?duration=; 0 when disabled
+	MaxUploadSizeMB    int64 `json:"maxUploadSizeMB"`    // largest /upload body; 0 when unlimited
+	MaxUploadSeconds   int64 `json:"maxUploadSeconds"`   // longest /upload; 0 when unlimited
+
+	Consent *ConsentTerms `json:"consent,omitempty"` // terms to accept before testing
+
+	CongestionControls []string `json:"congestionControls,omitempty"` // algorithms /download?cc= accepts
+}
+
+// clientConfigHandler serves /api/config.
+func clientConfigHandler(w http.ResponseWriter, r *http.Request) {
+	ports := dataPorts
+	if ports == nil {
+		ports = []int{}
+	}
+	writeJSON(w, ClientConfig{
+		DataPorts:     ports,
+		MaxSizeMB:     *maxDownloadSize,
+		DefaultSizeMB: *defaultSize,
+		MinSizeMB:     *minSize,
+		Relay:         relayEnabled() && !*demoMode,
+		NetPing:       *netPing && !*demoMode,
+		DeleteResults: ownerDeletes(),
+		Demo:          *demoMode,
+		Latency:       latencyPolicy(),
+
+		MaxDownloadSeconds: int64(maxDownloadTime.Seconds()),
+		MaxUploadSizeMB:    *maxUploadSize,
+		MaxUploadSeconds:   int64(maxUploadTime.Seconds()),
+
+		Consent: consentTerms,
+
+		CongestionControls: congestionControls,
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	demoTick      = 100 * time.Millisecond
+	demoTickBytes = 256 // actually written per tick, regardless of the simulated size
+)
+
+// demoProfile is the connection the demo mode pretends to measure.
+var demoProfile = struct {
+	DownloadMbps, UploadMbps float64
+	LatencyMs, JitterMs      float64
+	LossPercent              float64
+}{DownloadMbps: 480, UploadMbps: 42, LatencyMs: 14, JitterMs: 2.5, LossPercent: 0.4}
+
+// vary returns v randomly scaled by up to ±spread, so repeated runs differ.
+func vary(v, spread float64) float64 {
+	return v * (1 + spread*(2*rand.Float64()-1))
+}
+
+// testHandlers returns the latency, download and upload handlers, which are
+// synthesized in -demo mode.
+func testHandlers() (latency, download, upload http.HandlerFunc) {
+	if *demoMode {
+		return demoLatencyHandler, demoDownloadHandler, demoUploadHandler
+	}
+	return latencyHandler, downloadHandler, uploadHandler
+}
+
+// demoLatencyHandler answers like latencyHandler after a simulated round trip.
+func demoLatencyHandler(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(time.Duration(vary(demoProfile.LatencyMs, 0.2) * float64(time.Millisecond)))
+	latencyHandler(w, r)
+}
+
+// simulateTransfer paces a transfer of size bytes at mbps, reporting the
+// simulated bytes to the session so samples and metrics look real. tick is
+// called once per interval and returns false to stop early; the transfer also
+// stops when ctx is canceled.
+func simulateTransfer(ctx context.Context, session *TestSession, size int64, mbps float64, tick func() bool) {
+	duration := time.Duration(float64(size*8) / (mbps * 1024 * 1024) * float64(time.Second))
+	ticks := max(int64(duration/demoTick), 1)
+	for i := int64(1); i <= ticks; i++ {
+		select {
+		case <-time.After(duration / time.Duration(ticks)):
+		case <-ctx.Done():
+			return
+		}
+		bytes := size / ticks
+		if i == ticks {
+			bytes += size % ticks
+		}
+		session.AddBytes(bytes)
+		if !tick() {
+			return
+		}
+	}
+}
+
+// demoDownloadHandler streams a few bytes per tick over the time the
+// requested size would take, and reports the simulated size in X-Demo-Size
+// for the client to use instead of the bytes it received.
+func demoDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := parseDownloadRequest(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	size, mbps := req.SizeBytes, vary(demoProfile.DownloadMbps, 0.1)
+	if req.Duration > 0 {
+		size = int64(mbps * 1024 * 1024 / 8 * req.Duration.Seconds())
+	}
+	session, ok := startSession(w, "download", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+	w.Header().Set("X-Session-ID", session.ID)
+	w.Header().Set("X-Demo-Size", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	filler := make([]byte, demoTickBytes)
+	simulateTransfer(r.Context(), session, size, mbps, func() bool {
+		if _, err := w.Write(filler); err != nil {
+			return false
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return true
+	})
+}
+
+// demoUploadHandler discards the (small) body and holds the response for as
+// long as uploading the size in the client's X-Demo-Size header, up to
+// -maxsize, would take.
+func demoUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	p := newParamReader(url.Values{"X-Demo-Size": r.Header.Values("X-Demo-Size")})
+	size := p.Int64("X-Demo-Size", *defaultSize*1024*1024, 1, *maxDownloadSize*1024*1024)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	session, ok := startSession(w, "upload", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+	w.Header().Set("X-Session-ID", session.ID)
+	io.Copy(io.Discard, io.LimitReader(r.Body, maxRequestSize))
+	simulateTransfer(r.Context(), session, size, vary(demoProfile.UploadMbps, 0.1), func() bool { return true })
+	writeUploadReport(w, session, session.StartedAt, time.Now(), nil)
+}
+
+// DemoWebRTCResult replaces the SDP answer in -demo mode: the round-trip
+// times the jitter test would have measured, with lost packets left out.
+type DemoWebRTCResult struct {
+	Demo bool      `json:"demo"`
+	Sent int       `json:"sent"`
+	RTTs []float64 `json:"rtts"`
+}
+
+// demoWebRTCHandler synthesizes the jitter test without a peer connection.
+func demoWebRTCHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	const sent = 250
+	result := DemoWebRTCResult{Demo: true, Sent: sent, RTTs: []float64{}}
+	for i := 0; i < sent; i++ {
+		if rand.Float64()*100 < demoProfile.LossPercent {
+			continue
+		}
+		rtt := demoProfile.LatencyMs + rand.NormFloat64()*demoProfile.JitterMs
+		result.RTTs = append(result.RTTs, max(1, float64(int(rtt*10))/10))
+	}
+	time.Sleep(time.Second) // the real test takes about ten seconds; do not answer instantly
+	writeJSON(w, result)
+}
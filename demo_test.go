@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestSaveResultDemoSource(t *testing.T) {
+	defer func(enabled bool) { *demoMode = enabled }(*demoMode)
+	const body = `{"downloadSpeedMbps": 10, "tags": {"source": "demo"}}`
+
+	*demoMode = false
+	if v, ok := saveTestResult(t, newSaveRequest(body)).Tags["source"]; ok {
+		t.Errorf("outside demo mode, the client's source=%s was stored", v)
+	}
+
+	*demoMode = true
+	if v := saveTestResult(t, newSaveRequest(body)).Tags["source"]; v != "demo" {
+		t.Errorf("source tag in demo mode = %q, want demo", v)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Clients size downloads up front, in megabytes or seconds, and may stop
+// reading before the end, e.g. when a test phase times out or the page is
+// closed. Each download session records how much of what it requested was
+// sent before it ended: /sessions/{id}/samples and /admin/api/sessions report
+// it as completion, and /metrics counts downloads by outcome with a histogram
+// of their completion ratios. Clients that often abort at a low ratio request
+// more than they use; sized downloads that complete within a second or two
+// are too small for a stable rate. Bytes still in the server's send buffer
+// when a client goes away count as sent.
+
+// Outcomes of a download.
+const (
+	downloadComplete = "complete"
+	downloadAborted  = "aborted"  // the client went away
+	downloadShutdown = "shutdown" // cut off by the server shutting down
+)
+
+var (
+	downloadsEnded = newCounterVec("netspeed_downloads_total",
+		"Downloads by outcome: complete, aborted by the client, or cut off by shutdown.", "outcome")
+	downloadCompletion = newHistogramVec("netspeed_download_completion_ratio",
+		"Fraction of the requested bytes, or of the requested duration of timed downloads, sent before downloads ended, by kind: sized or timed.",
+		[]float64{0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 1}, "kind")
+)
+
+// downloadGoal is what a download session was asked to send.
+type downloadGoal struct {
+	bytes    int64         // of a sized download
+	duration time.Duration // of a timed download
+}
+
+// DownloadCompletion is how much of its request a download sent.
+type DownloadCompletion struct {
+	RequestedBytes int64   `json:"requestedBytes,omitempty"` // of a sized download
+	RequestedMs    float64 `json:"requestedMs,omitempty"`    // of a timed download
+	SentBytes      int64   `json:"sentBytes"`
+	ElapsedMs      float64 `json:"elapsedMs"`
+	Ratio          float64 `json:"ratio"`             // of the bytes or the duration, from 0 to 1
+	Outcome        string  `json:"outcome,omitempty"` // once ended: complete, aborted or shutdown
+}
+
+// SetDownloadGoal records the size or, for a timed download, the duration a
+// download was asked for.
+func (s *TestSession) SetDownloadGoal(bytes int64, duration time.Duration) {
+	if duration > 0 {
+		bytes = 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.goal = &downloadGoal{bytes: bytes, duration: duration}
+}
+
+// EndDownload records how a download ended, complete when it sent all it
+// was asked for, and counts it in the metrics. Later calls are ignored.
+func (s *TestSession) EndDownload(complete bool) {
+	s.mu.Lock()
+	if s.goal == nil || s.outcome != "" {
+		s.mu.Unlock()
+		return
+	}
+	switch {
+	case complete:
+		s.outcome = downloadComplete
+	case serverContext.Err() != nil:
+		s.outcome = downloadShutdown
+	default:
+		s.outcome = downloadAborted
+	}
+	s.endedAt = time.Now()
+	c, kind := s.completionLocked(), "sized"
+	s.mu.Unlock()
+
+	if c.RequestedMs > 0 {
+		kind = "timed"
+	}
+	downloadsEnded.Inc(c.Outcome)
+	downloadCompletion.Observe(c.Ratio, kind)
+}
+
+// Completion reports the progress of a download against its request, or
+// nil for other sessions.
+func (s *TestSession) Completion() *DownloadCompletion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.goal == nil {
+		return nil
+	}
+	return s.completionLocked()
+}
+
+// completionLocked is Completion with s.mu held.
+func (s *TestSession) completionLocked() *DownloadCompletion {
+	end := s.endedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	elapsed := end.Sub(s.StartedAt)
+	c := &DownloadCompletion{
+		RequestedBytes: s.goal.bytes,
+		SentBytes:      s.Bytes(),
+		ElapsedMs:      float64(elapsed.Microseconds()) / 1000,
+		Outcome:        s.outcome,
+	}
+	switch {
+	case s.outcome == downloadComplete:
+		c.Ratio = 1
+	case s.goal.duration > 0:
+		c.Ratio = elapsed.Seconds() / s.goal.duration.Seconds()
+	case s.goal.bytes > 0:
+		c.Ratio = float64(c.SentBytes) / float64(s.goal.bytes)
+	}
+	if s.goal.duration > 0 {
+		c.RequestedMs = float64(s.goal.duration.Microseconds()) / 1000
+	}
+	c.Ratio = math.Round(min(c.Ratio, 1)*1000) / 1000
+	return c
+}
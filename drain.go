@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// An HTTP/1.1 connection carries the next request only once the body of the
+// current one has been read. A handler that answers early, e.g. 405 for a
+// POST to /download or 428 before an upload, leaves the rest of the body on
+// the wire; net/http discards up to 256KB of it and otherwise closes the
+// connection after the response. A browser still sending the body then sees
+// a reset instead of the error, or reuses a connection the server is about
+// to close, and the next test on it stalls. withBodyDrain reads what is left
+// of the body before an error response is written, up to maxDrainBytes
+// within drainTimeout, so the client gets the response and the connection
+// stays usable; a larger or slower body is answered with Connection: close,
+// so the client does not reuse the connection. Handlers that close the
+// connection themselves, such as refused uploads, are left alone.
+
+const (
+	maxDrainBytes = 8 * 1024 * 1024
+	drainTimeout  = 2 * time.Second
+)
+
+var unreadBodies = newCounterVec("netspeed_request_bodies_unread_total",
+	"Request bodies left unread by an error response, by action: drained, or closed with the connection.", "action")
+
+// drainBody tracks how much of a request body has been read.
+type drainBody struct {
+	io.ReadCloser
+	read int64
+	eof  bool
+}
+
+func (b *drainBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// drainWriter drains the request body when an error status is written.
+type drainWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	body        *drainBody
+	wroteHeader bool
+}
+
+func (w *drainWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= http.StatusOK {
+		w.wroteHeader = true
+		if status >= http.StatusBadRequest {
+			w.drain()
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *drainWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *drainWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *drainWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// drain reads the rest of the request body, or marks the connection to be
+// closed after the response when that is too much or too slow.
+func (w *drainWriter) drain() {
+	if w.body.eof || w.Header().Get("Connection") == "close" {
+		return
+	}
+	// Reading now would ask a client waiting for 100 Continue to send the
+	// body; net/http closes those connections itself
+	if w.r.Header.Get("Expect") != "" {
+		return
+	}
+	if w.r.ContentLength < 0 || w.r.ContentLength-w.body.read <= maxDrainBytes {
+		rc := http.NewResponseController(w.ResponseWriter)
+		rc.SetReadDeadline(time.Now().Add(drainTimeout))
+		_, err := io.CopyN(io.Discard, w.body, maxDrainBytes)
+		rc.SetReadDeadline(time.Time{})
+		if w.body.eof || errors.Is(err, io.EOF) {
+			unreadBodies.Inc("drained")
+			return
+		}
+	}
+	unreadBodies.Inc("closed")
+	w.Header().Set("Connection", "close")
+}
+
+// withBodyDrain drains the unread request bodies of HTTP/1.x error responses
+// from next. Requests without a body, including WebSocket upgrades, are
+// passed through unwrapped.
+func withBodyDrain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 1 || r.ContentLength == 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &drainBody{ReadCloser: r.Body}
+		r.Body = body
+		next.ServeHTTP(&drainWriter{ResponseWriter: w, r: r, body: body}, r)
+	})
+}
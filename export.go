@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// exportFlushEvery is how many rows are written between flushes, so large
+// exports reach the client as they are produced.
+const exportFlushEvery = 100
+
+// exportColumns are the CSV header; rows follow the same order.
+var exportColumns = []string{
+	"id", "timestamp", "downloadSpeedMbps", "uploadSpeedMbps", "latencyMs", "jitterMs", "packetLossPercent",
+	"clientIp", "verified", "serverId", "serverVersion", "serverLabel", "tags",
+	"countryCode", "city", "asn", "isp",
+}
+
+func exportRow(r TestResult) []string {
+	var server ServerIdentity
+	if r.Server != nil {
+		server = *r.Server
+	}
+	var geo GeoInfo
+	if r.Geo != nil {
+		geo = *r.Geo
+	}
+	asn := ""
+	if geo.ASN != 0 {
+		asn = strconv.FormatUint(uint64(geo.ASN), 10)
+	}
+	tags := ""
+	if len(r.Tags) > 0 {
+		data, _ := json.Marshal(r.Tags) // a JSON object, so spreadsheets never read it as a formula
+		tags = string(data)
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	return []string{
+		r.ID, r.Timestamp.UTC().Format(time.RFC3339Nano),
+		f(r.DownloadSpeedMbps), f(r.UploadSpeedMbps), f(r.LatencyMs), f(r.JitterMs), f(r.PacketLossPercent),
+		r.ClientIP, strconv.FormatBool(r.Verified), server.ID, server.Version, server.Label, tags,
+		geo.CountryCode, geo.City, asn, geo.ISP,
+	}
+}
+
+// exportResultsHandler serves GET /results/export: every stored result
+// matching the same filters as the admin results API, newest first, streamed
+// as CSV (?format=csv, the default) or JSON lines (?format=ndjson).
+func exportResultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	p := newParamReader(q)
+	format := p.Enum("format", "csv", "csv", "ndjson")
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	filter, err := parseResultFilter(q)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Cache-Control", "no-store")
+	rows := 0
+	var write func(TestResult) error
+	var flush func() error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="netspeed-results.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write(exportColumns)
+		write = func(result TestResult) error { return cw.Write(exportRow(result)) }
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="netspeed-results.ndjson"`)
+		enc := json.NewEncoder(w)
+		write = func(result TestResult) error { return enc.Encode(result) }
+		flush = func() error { return nil }
+	}
+
+	err = globalStore.IterateMatching(filter, func(result TestResult) error {
+		if err := write(result); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushEvery == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			rc.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		log.Printf("Result export failed after %d rows: %v", rows, err)
+		if rows == 0 {
+			// Nothing has been sent yet; later failures truncate the export
+			http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		}
+		return
+	}
+	if *verbose {
+		log.Printf("Exported %d results as %s to %s", rows, format, loggedAddr(r.RemoteAddr))
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// metricRange bounds one numeric field of a TestResult. Nil bounds are open.
+type metricRange struct {
+	Lt *float64
+	Gt *float64
+}
+
+func (m metricRange) matches(v float64) bool {
+	if m.Lt != nil && !(v < *m.Lt) {
+		return false
+	}
+	if m.Gt != nil && !(v > *m.Gt) {
+		return false
+	}
+	return true
+}
+
+// ResultFilter selects results when browsing the store.
+type ResultFilter struct {
+	Download metricRange
+	Upload   metricRange
+	Latency  metricRange
+	Jitter   metricRange
+	Loss     metricRange
+
+	TagKey    string // tag filter, e.g. location=office
+	TagValue  string
+	IPPrefix  string
+	ClientID  string // results saved by one device (see validClientID)
+	Verified  *bool
+	Contended *bool  // whether other clients tested at the same time (see ServerLoad)
+	Text      string // case-insensitive free-text match over ID, client IP and tags
+
+	From time.Time // inclusive lower bound on Timestamp; zero is open
+	To   time.Time // exclusive upper bound on Timestamp; zero is open
+}
+
+// metricByName maps query parameter prefixes to the range they populate.
+func (f *ResultFilter) metricByName(name string) *metricRange {
+	switch name {
+	case "download":
+		return &f.Download
+	case "upload":
+		return &f.Upload
+	case "latency":
+		return &f.Latency
+	case "jitter":
+		return &f.Jitter
+	case "loss":
+		return &f.Loss
+	}
+	return nil
+}
+
+// metricNames are the metrics parseResultFilter reads <name>_lt and <name>_gt
+// bounds for.
+var metricNames = []string{"download", "upload", "latency", "jitter", "loss"}
+
+// parseResultFilter builds a filter from query parameters such as
+// download_lt=50, latency_gt=20, tag=location:office, ip=192.168., client=<clientId>,
+// verified=true, contended=false, q=text and from=/to= RFC 3339 timestamps.
+func parseResultFilter(q url.Values) (ResultFilter, error) {
+	var f ResultFilter
+	p := newParamReader(q)
+	for _, key := range slices.Sorted(maps.Keys(q)) {
+		if name, bound, ok := strings.Cut(key, "_"); ok && (bound == "lt" || bound == "gt") && !slices.Contains(metricNames, name) {
+			p.fail(key, "%q is not one of %s", name, strings.Join(metricNames, ", "))
+		}
+	}
+	for _, name := range metricNames {
+		m := f.metricByName(name)
+		m.Lt = p.OptionalFloat(name + "_lt")
+		m.Gt = p.OptionalFloat(name + "_gt")
+	}
+	if tag, ok := p.raw("tag"); ok {
+		k, v, ok := strings.Cut(tag, ":")
+		if !ok {
+			k, v, ok = strings.Cut(tag, "=")
+		}
+		if !ok || k == "" {
+			p.fail("tag", "%q is not key:value", tag)
+		}
+		f.TagKey, f.TagValue = k, v
+	}
+	f.IPPrefix, _ = p.raw("ip")
+	f.ClientID, _ = p.raw("client")
+	f.Verified = p.OptionalBool("verified")
+	f.Contended = p.OptionalBool("contended")
+	text, _ := p.raw("q")
+	f.Text = strings.ToLower(text)
+	f.From, f.To = p.Time("from"), p.Time("to")
+	if err := p.Err(); err != nil {
+		return f, err
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.To.After(f.From) {
+		return f, &paramError{Param: "to", Reason: "must be after from"}
+	}
+	return f, nil
+}
+
+// Which index a store walked to answer a query.
+const (
+	indexedByTime = iota
+	indexedByTag
+	indexedByVerified
+	indexedByClient
+)
+
+// needsValues reports whether results must be loaded to evaluate the filter,
+// given the index being walked already guarantees its own condition. The time
+// range never needs values since every index key carries the timestamp.
+func (f ResultFilter) needsValues(indexed int) bool {
+	for _, m := range []metricRange{f.Download, f.Upload, f.Latency, f.Jitter, f.Loss} {
+		if m.Lt != nil || m.Gt != nil {
+			return true
+		}
+	}
+	if f.IPPrefix != "" || f.Text != "" || f.Contended != nil {
+		return true
+	}
+	if f.TagKey != "" && indexed != indexedByTag {
+		return true
+	}
+	if f.ClientID != "" && indexed != indexedByClient {
+		return true
+	}
+	if f.Verified != nil && !(*f.Verified && indexed == indexedByVerified) {
+		return true
+	}
+	return false
+}
+
+// Matches reports whether result satisfies every condition of the filter.
+func (f ResultFilter) Matches(result TestResult) bool {
+	if !f.Download.matches(result.DownloadSpeedMbps) ||
+		!f.Upload.matches(result.UploadSpeedMbps) ||
+		!f.Latency.matches(result.LatencyMs) ||
+		!f.Jitter.matches(result.JitterMs) ||
+		!f.Loss.matches(result.PacketLossPercent) {
+		return false
+	}
+	if f.TagKey != "" {
+		if v, ok := result.Tags[f.TagKey]; !ok || v != f.TagValue {
+			return false
+		}
+	}
+	if f.IPPrefix != "" && !strings.HasPrefix(result.ClientIP, f.IPPrefix) {
+		return false
+	}
+	if f.ClientID != "" && result.ClientID != f.ClientID {
+		return false
+	}
+	if f.Verified != nil && result.Verified != *f.Verified {
+		return false
+	}
+	if f.Contended != nil && result.Load.Contended() != *f.Contended {
+		return false
+	}
+	if !f.inTimeRange(result.Timestamp) {
+		return false
+	}
+	if f.Text != "" && !resultContainsText(result, f.Text) {
+		return false
+	}
+	return true
+}
+
+func (f ResultFilter) inTimeRange(t time.Time) bool {
+	return (f.From.IsZero() || !t.Before(f.From)) && (f.To.IsZero() || t.Before(f.To))
+}
+
+func resultContainsText(result TestResult, text string) bool {
+	if strings.Contains(strings.ToLower(result.ID), text) || strings.Contains(strings.ToLower(result.ClientIP), text) {
+		return true
+	}
+	for k, v := range result.Tags {
+		if strings.Contains(strings.ToLower(k), text) || strings.Contains(strings.ToLower(v), text) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	maxTags        = 20
+	maxTagLength   = 64
+	indexKeyPrefix = "idx:"
+)
+
+// serverTags are the tag keys only the server sets, from its own
+// measurements and settings. Clients cannot set them.
+var serverTags = []string{"congestion", "protocol", "clock-skew", "consent", "recurring", "source", "peer"}
+
+// clientSources are the source tags clients may claim, those the netspeed
+// CLI commands save their results with; the server's own sources, such as
+// demo and peer-test, are never taken from clients.
+var clientSources = []string{"cli", "congestion-test"}
+
+// sanitizeTags drops empty, oversized or excess tags supplied by clients,
+// and the server's own tags.
+func sanitizeTags(tags map[string]string) map[string]string {
+	clean := sanitizeImportedTags(tags)
+	for _, k := range serverTags {
+		delete(clean, k)
+	}
+	if source := strings.TrimSpace(tags["source"]); slices.Contains(clientSources, source) {
+		clean["source"] = source
+	}
+	if len(clean) == 0 {
+		return nil
+	}
+	return clean
+}
+
+// sanitizeImportedTags is sanitizeTags for results another instance
+// exported, keeping the tags that instance set.
+// Separators used in index keys are not allowed in tag keys.
+func sanitizeImportedTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	clean := make(map[string]string, len(tags))
+	for k, v := range tags {
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if k == "" || len(k) > maxTagLength || len(v) > maxTagLength || strings.ContainsAny(k, ":=") {
+			continue
+		}
+		if len(clean) >= maxTags {
+			break
+		}
+		clean[k] = v
+	}
+	return clean
+}
+
+const (
+	minClientIDLength = 8
+	maxClientIDLength = 64
+)
+
+// validClientID reports whether id can identify a device: 8 to 64 letters,
+// digits, dashes or underscores, such as the UUID the web client keeps.
+func validClientID(id string) bool {
+	if len(id) < minClientIDLength || len(id) > maxClientIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newSaveRequest returns a POST /save-result of body.
+func newSaveRequest(body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/save-result", bytes.NewBufferString(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// saveTestResult serves r on a fresh memory store and returns the stored
+// result.
+func saveTestResult(t *testing.T, r *http.Request) TestResult {
+	t.Helper()
+	globalStore = NewMemoryStore(100, 0)
+	w := httptest.NewRecorder()
+	saveResultHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /save-result = %d %s", w.Code, w.Body)
+	}
+	var response struct{ ID string }
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	result, err := globalStore.Load(response.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestSaveResultDropsServerTags(t *testing.T) {
+	result := saveTestResult(t, newSaveRequest(`{"downloadSpeedMbps": 10, "tags": {
+		"congestion": "bbr", "protocol": "h3", "clock-skew": "5s", "consent": "v9",
+		"recurring": "5f0c4b7e-1111-4222-8333-944445555666", "source": "demo", "peer": "branch-office",
+		"location": "office"}}`))
+	for _, key := range serverTags {
+		if v, ok := result.Tags[key]; ok {
+			t.Errorf("client tag %s=%s was stored", key, v)
+		}
+	}
+	if result.Tags["location"] != "office" {
+		t.Errorf("client tag location was not stored: %v", result.Tags)
+	}
+}
+
+func TestSanitizeTags(t *testing.T) {
+	tests := []struct {
+		tags map[string]string
+		want map[string]string
+	}{
+		{nil, nil},
+		{map[string]string{"source": "cli"}, map[string]string{"source": "cli"}},
+		{map[string]string{"source": " congestion-test "}, map[string]string{"source": "congestion-test"}},
+		{map[string]string{"source": "peer-test", "isp": "acme"}, map[string]string{"isp": "acme"}},
+		{map[string]string{"recurring": "x", "consent": "v1"}, nil},
+		{map[string]string{"a:b": "c", "d": "e"}, map[string]string{"d": "e"}},
+	}
+	for _, tt := range tests {
+		got := sanitizeTags(tt.tags)
+		if len(got) != len(tt.want) {
+			t.Errorf("sanitizeTags(%v) = %v, want %v", tt.tags, got, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Errorf("sanitizeTags(%v) = %v, want %v", tt.tags, got, tt.want)
+			}
+		}
+	}
+	imported := sanitizeImportedTags(map[string]string{"source": "demo", "protocol": "h2"})
+	if imported["source"] != "demo" || imported["protocol"] != "h2" {
+		t.Errorf("sanitizeImportedTags dropped the exporting server's tags: %v", imported)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// customFrontend reports whether -frontend-dir or -frontend-url replaces the
+// embedded UI.
+func customFrontend() bool {
+	return *frontendDir != "" || *frontendURL != ""
+}
+
+// validateFrontend checks the frontend flags at startup.
+func validateFrontend() error {
+	if *frontendDir != "" && *frontendURL != "" {
+		return fmt.Errorf("-frontend-dir and -frontend-url are mutually exclusive")
+	}
+	if customFrontend() && *sriEnabled {
+		return fmt.Errorf("-sri only applies to the embedded UI")
+	}
+	if *frontendDir != "" {
+		info, err := os.Stat(*frontendDir)
+		if err != nil {
+			return fmt.Errorf("-frontend-dir: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("-frontend-dir %s is not a directory", *frontendDir)
+		}
+	}
+	if *frontendURL != "" {
+		u, err := url.Parse(*frontendURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("-frontend-url must be an http(s) URL")
+		}
+	}
+	return nil
+}
+
+// frontendHandler serves the UI at /: the embedded UI with per-file
+// overrides from ./static, or the operator's own UI from -frontend-dir or
+// -frontend-url, in which case no embedded asset is served.
+func frontendHandler() http.Handler {
+	switch {
+	case *frontendDir != "":
+		return frontendDirHandler(*frontendDir)
+	case *frontendURL != "":
+		target, _ := url.Parse(*frontendURL) // checked by validateFrontend
+		return &httputil.ReverseProxy{Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			// The frontend host gets no credentials meant for this server
+			for _, header := range []string{"Cookie", "Authorization", "X-Admin-Token"} {
+				r.Out.Header.Del(header)
+			}
+		}}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 1. Normalize root path to index.html
+		path := r.URL.Path
+		if path == "/" {
+			path = "/index.html"
+		}
+
+		// 2. Pass the normalized path to the server function
+		// We no longer prepend the 'static/' path here, fixing the path doubling bug.
+		serveHybridFile(w, r, *port, path)
+	})
+}
+
+// frontendDirHandler serves the files in dir. Paths without a file extension
+// that match no file get index.html, so single-page apps can route on the
+// client; missing assets are still 404.
+func frontendDirHandler(dir string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err == nil || path.Ext(name) != "" ||
+			(r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			files.ServeHTTP(w, r)
+			return
+		}
+		f, err := os.Open(filepath.Join(dir, "index.html"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "index.html", info.ModTime(), f)
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFrontendProxyDropsCredentials(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	defer func(url string) { *frontendURL = url }(*frontendURL)
+	*frontendURL = upstream.URL
+
+	r := httptest.NewRequest(http.MethodGet, "/index.html", nil)
+	r.Header.Set("Cookie", ownerCookieName+"=secret")
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Admin-Token", "secret")
+	r.Header.Set("Accept", "text/html")
+	frontendHandler().ServeHTTP(httptest.NewRecorder(), r)
+
+	for _, header := range []string{"Cookie", "Authorization", "X-Admin-Token"} {
+		if v := got.Get(header); v != "" {
+			t.Errorf("the frontend host got %s: %s", header, v)
+		}
+	}
+	if got.Get("Accept") != "text/html" {
+		t.Errorf("the frontend host got Accept %q, want text/html", got.Get("Accept"))
+	}
+}
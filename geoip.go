@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// GeoInfo is where a result's client address is registered, looked up in the
+// -geoip-db databases when the result is saved.
+type GeoInfo struct {
+	CountryCode string `json:"countryCode,omitempty"` // ISO 3166-1 alpha-2
+	Country     string `json:"country,omitempty"`
+	City        string `json:"city,omitempty"`
+	ASN         uint32 `json:"asn,omitempty"`
+	ISP         string `json:"isp,omitempty"` // the ISP, or the AS organization without an ISP database
+}
+
+// geoDatabases are the -geoip-db databases, consulted in order.
+var geoDatabases []*mmdbReader
+
+// loadGeoIP opens the comma-separated -geoip-db files, e.g. a GeoLite2 City
+// and a GeoLite2 ASN database.
+func loadGeoIP() error {
+	for _, path := range strings.Split(*geoIPDB, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		db, err := openMMDB(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		log.Printf("Annotating results with %s (%s)", db.databaseType, path)
+		geoDatabases = append(geoDatabases, db)
+	}
+	return nil
+}
+
+// lookupGeo returns the location and network of ip, or nil when it is not an
+// address or no database knows it.
+func lookupGeo(ip string) *GeoInfo {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || len(geoDatabases) == 0 {
+		return nil
+	}
+	var info GeoInfo
+	for _, db := range geoDatabases {
+		record, err := db.lookup(addr)
+		if err != nil {
+			log.Printf("GeoIP lookup of %s in %s failed: %v", loggedAddr(ip), db.databaseType, err)
+			continue
+		}
+		info.merge(record)
+	}
+	if info == (GeoInfo{}) {
+		return nil
+	}
+	return &info
+}
+
+// merge fills the empty fields of info from a City, Country, ASN or ISP
+// database record.
+func (info *GeoInfo) merge(record map[string]any) {
+	country, _ := record["country"].(map[string]any)
+	if info.CountryCode == "" {
+		info.CountryCode, _ = country["iso_code"].(string)
+	}
+	if info.Country == "" {
+		info.Country = geoName(country)
+	}
+	if info.City == "" {
+		city, _ := record["city"].(map[string]any)
+		info.City = geoName(city)
+	}
+	if asn, ok := record["autonomous_system_number"].(uint64); ok && info.ASN == 0 && asn <= math.MaxUint32 {
+		info.ASN = uint32(asn)
+	}
+	if isp, _ := record["isp"].(string); isp != "" && info.ISP == "" {
+		info.ISP = isp
+	}
+	if org, _ := record["autonomous_system_organization"].(string); org != "" && info.ISP == "" {
+		info.ISP = org
+	}
+}
+
+// geoName picks the -locale name of a place, or its English one.
+func geoName(place map[string]any) string {
+	names, _ := place["names"].(map[string]any)
+	if name, ok := names[*defaultLocale].(string); ok {
+		return name
+	}
+	name, _ := names["en"].(string)
+	return name
+}
+
+// --- MaxMind DB reader ---
+
+// mmdbMetadataMarker precedes the metadata at the end of a MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbMaxDepth bounds the nesting of decoded values, so a corrupt file of
+// pointers to themselves cannot recurse forever.
+const mmdbMaxDepth = 32
+
+// mmdbReader looks up addresses in a MaxMind DB file held in memory; see
+// https://maxmind.github.io/MaxMind-DB/ for the format.
+type mmdbReader struct {
+	tree         []byte
+	data         []byte
+	nodeCount    uint64
+	recordSize   uint64 // bits: 24, 28 or 32
+	ipVersion    uint64
+	ipv4Start    uint64 // node of ::/96 in an IPv6 tree
+	databaseType string
+}
+
+var errMMDBCorrupt = errors.New("corrupt MaxMind database")
+
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, errors.New("not a MaxMind database")
+	}
+	value, _, err := mmdbDecoder(buf[i+len(mmdbMetadataMarker):]).decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _ := value.(map[string]any)
+	r := &mmdbReader{}
+	r.nodeCount, _ = metadata["node_count"].(uint64)
+	r.recordSize, _ = metadata["record_size"].(uint64)
+	r.ipVersion, _ = metadata["ip_version"].(uint64)
+	r.databaseType, _ = metadata["database_type"].(string)
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", r.ipVersion)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint64(i) {
+		return nil, errMMDBCorrupt
+	}
+	r.tree, r.data = buf[:treeSize], buf[treeSize+16:i]
+
+	if r.ipVersion == 6 {
+		for bit := 0; bit < 96 && r.ipv4Start < r.nodeCount; bit++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node.
+func (r *mmdbReader) record(node uint64, bit byte) uint64 {
+	b := r.tree[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+	case 28:
+		if bit == 0 {
+			return uint64(b[3]&0xf0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+		}
+		return uint64(b[3]&0x0f)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6])
+	default:
+		return uint64(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the record of the network containing addr, or nil.
+func (r *mmdbReader) lookup(addr netip.Addr) (map[string]any, error) {
+	addr = addr.Unmap()
+	var node uint64
+	var ip []byte
+	if addr.Is4() {
+		node = r.ipv4Start
+		b := addr.As4()
+		ip = b[:]
+	} else {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		b := addr.As16()
+		ip = b[:]
+	}
+	for i := 0; i < len(ip)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, ip[i/8]>>(7-i%8)&1)
+	}
+	if node <= r.nodeCount {
+		return nil, nil // no data for this network
+	}
+	offset := node - r.nodeCount - 16
+	value, _, err := mmdbDecoder(r.data).decode(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]any)
+	return record, nil
+}
+
+// mmdbDecoder decodes values of a data section: maps, arrays, strings,
+// bytes, booleans, floats as float64, signed integers as int64 and unsigned
+// ones as uint64 (uint128 is truncated to its low 64 bits).
+type mmdbDecoder []byte
+
+func (d mmdbDecoder) decode(offset uint64, depth int) (any, uint64, error) {
+	if depth > mmdbMaxDepth || offset >= uint64(len(d)) {
+		return nil, 0, errMMDBCorrupt
+	}
+	ctrl := d[offset]
+	offset++
+	kind := ctrl >> 5
+	if kind == 1 { // pointer
+		n := uint64(ctrl>>3&0x3) + 1
+		if offset+n > uint64(len(d)) {
+			return nil, 0, errMMDBCorrupt
+		}
+		var ptr uint64
+		if n < 4 {
+			ptr = uint64(ctrl & 0x7)
+		}
+		for _, b := range d[offset : offset+n] {
+			ptr = ptr<<8 | uint64(b)
+		}
+		ptr += []uint64{0, 2048, 526336, 0}[n-1]
+		value, _, err := d.decode(ptr, depth+1)
+		return value, offset + n, err
+	}
+	if kind == 0 { // extended
+		if offset >= uint64(len(d)) {
+			return nil, 0, errMMDBCorrupt
+		}
+		kind = 7 + d[offset]
+		offset++
+	}
+	size := uint64(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint64(len(d)) {
+			return nil, 0, errMMDBCorrupt
+		}
+		var extra uint64
+		for _, b := range d[offset : offset+n] {
+			extra = extra<<8 | uint64(b)
+		}
+		size = []uint64{29, 285, 65821}[n-1] + extra
+		offset += n
+	}
+
+	switch kind {
+	case 7: // map
+		m := make(map[string]any, size)
+		for range size {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errMMDBCorrupt
+			}
+			if m[k], offset, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case 11: // array
+		a := make([]any, 0, min(size, 1024))
+		for range size {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case 14: // boolean, held in the size
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint64(len(d)) {
+		return nil, 0, errMMDBCorrupt
+	}
+	b, next := d[offset:offset+size], offset+size
+	switch kind {
+	case 2: // UTF-8 string
+		return string(b), next, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errMMDBCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errMMDBCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case 4: // bytes
+		return bytes.Clone(b), next, nil
+	case 5, 6, 9, 10: // uint16, uint32, uint64, uint128
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, next, nil
+	case 8: // int32
+		var v uint32
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), next, nil
+	default:
+		return nil, 0, fmt.Errorf("%w: unknown data type %d", errMMDBCorrupt, kind)
+	}
+}
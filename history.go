@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxHistoryResults bounds a /history response.
+const maxHistoryResults = 10000
+
+// historyLimiter bounds how often each client can list a device's history.
+var historyLimiter = newRateLimiter(30, 10)
+
+// historyHandler serves GET /history/{clientId}: the results saved with that
+// client ID, newest first, for charting a device's trend. The client ID is
+// never served with a result (see publicResult), so only the device that
+// generated it, and the admin, know it.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	clientID := strings.TrimPrefix(r.URL.Path, "/history/")
+	if !validClientID(clientID) {
+		badRequest(w, &paramError{Param: "clientId", Reason: "must be 8 to 64 letters, digits, dashes or underscores"})
+		return
+	}
+	if !historyLimiter.Allow(requestClientIP(r)) {
+		http.Error(w, tr(r, "Too many requests"), http.StatusTooManyRequests)
+		return
+	}
+
+	results := []TestResult{}
+	err := globalStore.IterateMatching(ResultFilter{ClientID: clientID}, func(result TestResult) error {
+		if len(results) >= maxHistoryResults {
+			return errPageFull
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil && err != errPageFull {
+		log.Printf("Failed to load history of client %s: %v", clientID, err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"clientId": clientID, "results": results})
+}
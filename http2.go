@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	h2 "golang.org/x/net/http2"
+)
+
+// Over HTTP/2, a client may only send as much as the server's flow-control
+// windows allow before the server reads it, 1MB per stream and per
+// connection by default. That caps a single-stream upload at about 1MB per
+// round trip, e.g. 80 Mbps over a 100ms path. The -http2-* flags set the
+// server's HTTP/2 settings: the windows of upload streams and connections,
+// the concurrent streams a client may open and the largest frame the server
+// accepts. How much the server may send ahead on downloads is up to the
+// windows the client advertises.
+
+// http2Flags are the settings that only apply with -http2.
+var http2Flags = []string{"http2-max-streams", "http2-stream-window", "http2-conn-window", "http2-max-frame-size"}
+
+// HTTP/2 limits from RFC 9113.
+const (
+	http2MinWindow    = 65535
+	http2MinFrameSize = 16384
+	http2MaxFrameLen  = 1<<24 - 1
+)
+
+// validateHTTP2 checks the -http2-* flags.
+func validateHTTP2() error {
+	if !*http2 {
+		var err error
+		flag.Visit(func(f *flag.Flag) {
+			for _, name := range http2Flags {
+				if f.Name == name && err == nil {
+					err = fmt.Errorf("-%s needs -http2", name)
+				}
+			}
+		})
+		return err
+	}
+	switch {
+	case *http2MaxStreams < 1 || *http2MaxStreams > math.MaxUint32:
+		return fmt.Errorf("-http2-max-streams must be at least 1")
+	case *http2StreamWindow < http2MinWindow || *http2StreamWindow > math.MaxInt32:
+		return fmt.Errorf("-http2-stream-window must be between %d and %d bytes", http2MinWindow, math.MaxInt32)
+	case *http2ConnWindow < http2MinWindow || *http2ConnWindow > math.MaxInt32:
+		return fmt.Errorf("-http2-conn-window must be between %d and %d bytes", http2MinWindow, math.MaxInt32)
+	case *http2MaxFrameSize < http2MinFrameSize || *http2MaxFrameSize > http2MaxFrameLen:
+		return fmt.Errorf("-http2-max-frame-size must be between %d and %d bytes", http2MinFrameSize, http2MaxFrameLen)
+	case *http2ConnWindow < *http2StreamWindow:
+		log.Printf("Warning: -http2-conn-window is smaller than -http2-stream-window, so one upload stream cannot use its whole window")
+	}
+	return nil
+}
+
+// configureHTTP2 serves HTTP/2 with the -http2-* settings on a TLS server.
+func configureHTTP2(server *http.Server) {
+	if serverTLSConfig == nil || !*http2 {
+		return
+	}
+	// The listener does the TLS handshake; ConfigureServer only needs a
+	// config of its own to add h2 to, which the server does not use
+	err := h2.ConfigureServer(server, &h2.Server{
+		MaxConcurrentStreams:         uint32(*http2MaxStreams),
+		MaxUploadBufferPerStream:     int32(*http2StreamWindow),
+		MaxUploadBufferPerConnection: int32(*http2ConnWindow),
+		MaxReadFrameSize:             uint32(*http2MaxFrameSize),
+	})
+	if err != nil {
+		log.Printf("Warning: HTTP/2 settings not applied: %v", err)
+	}
+}
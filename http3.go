@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// With -http3, every TLS port is also served over QUIC on the same UDP port,
+// and HTTP/1.1 and HTTP/2 responses advertise it with Alt-Svc, so browsers
+// switch to HTTP/3 for later requests. Tests then run over QUIC instead of
+// TCP, and results are tagged protocol=h3 to compare the two. QUIC has no
+// TCP_INFO, so such results carry no tcpInfo.
+
+// altSvcMaxAge is how long clients may remember the HTTP/3 endpoint, in
+// seconds.
+const altSvcMaxAge = 24 * 60 * 60
+
+// validateHTTP3 checks -http3 against the TLS settings.
+func validateHTTP3() error {
+	if *http3Enabled && serverTLSConfig == nil {
+		return fmt.Errorf("-http3 needs -tls-cert and -tls-key")
+	}
+	return nil
+}
+
+// startHTTP3 serves handler over HTTP/3 on the UDP port of the same number as
+// a TLS listener, and returns the server, or nil without -http3.
+func startHTTP3(port int, handler http.Handler) (*http3.Server, error) {
+	if !*http3Enabled || serverTLSConfig == nil {
+		return nil, nil
+	}
+	network := "udp"
+	if listenNetwork == "tcp6" {
+		network = "udp6"
+	}
+	conn, err := net.ListenPacket(network, net.JoinHostPort(listenHost, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for HTTP/3 on UDP port %d: %w", port, err)
+	}
+	server := &http3.Server{
+		Handler:     handler,
+		TLSConfig:   http3.ConfigureTLSConfig(serverTLSConfig.Clone()),
+		ConnContext: quicConnContext,
+	}
+	go func() {
+		if err := server.Serve(conn); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP/3 listener on UDP port %d stopped: %v", port, err)
+		}
+	}()
+	return server, nil
+}
+
+// quicConnContext also cancels the contexts of a QUIC connection's requests
+// on shutdown, like serverBaseContext does for TCP.
+func quicConnContext(ctx context.Context, _ *quic.Conn) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(serverContext, cancel)
+	context.AfterFunc(ctx, func() { stop() })
+	return ctx
+}
+
+// withAltSvc advertises HTTP/3 on port to clients of the TCP listeners.
+func withAltSvc(port int, next http.Handler) http.Handler {
+	if !*http3Enabled || serverTLSConfig == nil {
+		return next
+	}
+	altSvc := fmt.Sprintf(`h3=":%d"; ma=%d`, port, altSvcMaxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			w.Header().Set("Alt-Svc", altSvc)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The server's own strings (error responses and notifications) are written
+// in English and translated through catalogs keyed by that English text, so a
+// missing translation falls back to English.
+//
+//go:embed locales/*.json
+var embeddedLocales embed.FS
+
+// catalogs maps a lowercase language tag, such as "de" or "pt-br", to its
+// translations.
+var catalogs = map[string]map[string]string{}
+
+// loadLocales reads the embedded catalogs, then the <tag>.json files of
+// -locale-dir over them: entries there replace or add translations, and new
+// files add languages.
+func loadLocales() error {
+	entries, err := embeddedLocales.ReadDir("locales")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		data, err := embeddedLocales.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			return err
+		}
+		if err := mergeCatalog(entry.Name(), data); err != nil {
+			return err
+		}
+	}
+	if *localeDir != "" {
+		files, err := filepath.Glob(filepath.Join(*localeDir, "*.json"))
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no <language>.json files in %s", *localeDir)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if err := mergeCatalog(filepath.Base(file), data); err != nil {
+				return err
+			}
+		}
+	}
+	*defaultLocale = strings.ToLower(*defaultLocale)
+	if _, ok := catalogs[*defaultLocale]; !ok && *defaultLocale != "en" {
+		return fmt.Errorf("no catalog for -locale %q", *defaultLocale)
+	}
+	return nil
+}
+
+func mergeCatalog(name string, data []byte) error {
+	var messages map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return fmt.Errorf("invalid catalog %s: %w", name, err)
+	}
+	tag := strings.ToLower(strings.TrimSuffix(name, ".json"))
+	if catalogs[tag] == nil {
+		catalogs[tag] = map[string]string{}
+	}
+	for text, translation := range messages {
+		if translation != "" {
+			catalogs[tag][text] = translation
+		}
+	}
+	return nil
+}
+
+// localize translates text into the language tag, falling back from a
+// regional tag to its base language and then to English.
+func localize(lang, text string) string {
+	for lang != "" {
+		if translation, ok := catalogs[lang][text]; ok {
+			return translation
+		}
+		i := strings.LastIndexByte(lang, '-')
+		if i < 0 {
+			break
+		}
+		lang = lang[:i]
+	}
+	return text
+}
+
+// tr translates text for the client of r.
+func tr(r *http.Request, text string) string {
+	return localize(requestLanguage(r), text)
+}
+
+// requestLanguage picks the most preferred language of the Accept-Language
+// header that has a catalog, or that is English. Without one it is -locale.
+func requestLanguage(r *http.Request) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if tag != "" && tag != "*" && q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, c := range choices {
+		for tag := c.tag; tag != ""; {
+			if _, ok := catalogs[tag]; ok || tag == "en" {
+				return tag
+			}
+			i := strings.LastIndexByte(tag, '-')
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return *defaultLocale
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// ServerIdentity records which server instance stored a result, so data
+// aggregated from several instances can be told apart later.
+type ServerIdentity struct {
+	ID      string `json:"id"`              // -server-id, or the hostname
+	Version string `json:"version"`         // build version and VCS revision
+	Label   string `json:"label,omitempty"` // -server-label, e.g. a region
+}
+
+// ClientMetadata records where a result was submitted from, as seen by the
+// server that saved it.
+type ClientMetadata struct {
+	RemoteIP  string `json:"remoteIp"` // origin client, from X-Forwarded-For behind a proxy
+	UserAgent string `json:"userAgent,omitempty"`
+	Protocol  string `json:"protocol"` // HTTP version of the save request, e.g. HTTP/2.0
+	Hostname  string `json:"hostname"` // host name of the server that handled it
+}
+
+const maxUserAgentLength = 512
+
+// Methodology identifiers, recorded per metric. The suffix is bumped whenever
+// a method changes in a way that makes results incomparable.
+const (
+	methodHTTPStream = "http-stream/1" // download: streamed GETs of /download, spread over the data ports
+	methodHTTPPost   = "http-post/1"   // upload: POST bodies to /upload
+	methodHTTPPing   = "http-ping/1"   // latency, and jitter and loss from the same round trips
+	methodStreamPing = "stream-ping/1" // like http-ping/1, over one long-lived /latency/stream request
+	methodWebRTCEcho = "webrtc-echo/1" // jitter and loss: 250 unordered data-channel packets echoed by the server
+	methodSimulated  = "simulated/1"   // synthesized by a -demo server
+	methodICMPEcho   = "icmp-echo/1"   // network latency: ICMP echoes the server sends to the client with -net-ping
+)
+
+// Metric names the methodology is recorded under.
+var measuredMetrics = []string{"download", "upload", "latency", "jitter", "loss"}
+
+// webClientMethodology is how the web client measures each metric.
+var webClientMethodology = map[string]string{
+	"download": methodHTTPStream,
+	"upload":   methodHTTPPost,
+	"latency":  methodHTTPPing,
+	"jitter":   methodWebRTCEcho,
+	"loss":     methodWebRTCEcho,
+}
+
+func isKnownMethod(method string) bool {
+	switch method {
+	case methodHTTPStream, methodHTTPPost, methodHTTPPing, methodStreamPing, methodWebRTCEcho, methodSimulated:
+		return true
+	}
+	return false
+}
+
+// serverVersion returns the module version the binary was built from. Builds
+// without a module version use the VCS revision.
+var serverVersion = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v // includes the revision and +dirty for builds from a checkout
+	}
+	var revision, dirty string
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision":
+			revision = "+" + s.Value[:min(len(s.Value), 12)]
+		case s.Key == "vcs.modified" && s.Value == "true":
+			dirty = "-dirty"
+		}
+	}
+	return "devel" + revision + dirty
+})
+
+// serverIdentity returns the identity stamped on results stored by this server.
+func serverIdentity() *ServerIdentity {
+	id := *serverID
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+	return &ServerIdentity{ID: id, Version: serverVersion(), Label: *serverLabel}
+}
+
+// forwardedClientIP returns the address a request originated from: the first
+// X-Forwarded-For entry when a proxy added one, otherwise the connection's
+// peer. Unlike requestClientIP it can be set by the client, so it is only
+// recorded, never trusted.
+func forwardedClientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		if ip := strings.TrimSpace(strings.Split(fwd, ",")[0]); net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	return requestClientIP(r)
+}
+
+// clientMetadata describes the client and connection that submitted r.
+func clientMetadata(r *http.Request) *ClientMetadata {
+	hostname, _ := os.Hostname()
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLength {
+		ua = strings.ToValidUTF8(ua[:maxUserAgentLength], "")
+	}
+	return &ClientMetadata{RemoteIP: forwardedClientIP(r), UserAgent: ua, Protocol: r.Proto, Hostname: hostname}
+}
+
+// simulatedMethodology marks every metric as synthesized.
+func simulatedMethodology() map[string]string {
+	methodology := make(map[string]string, len(measuredMetrics))
+	for _, metric := range measuredMetrics {
+		methodology[metric] = methodSimulated
+	}
+	return methodology
+}
+
+// stampMeasurement records the server identity and QoS marking on a result
+// about to be saved, and the methodology of each metric: clients may describe
+// theirs with known identifiers, otherwise the web client's methods are assumed.
+func stampMeasurement(result *TestResult) {
+	result.Server = serverIdentity()
+	result.QoS = qosMarking()
+	methodology := make(map[string]string, len(measuredMetrics))
+	for _, metric := range measuredMetrics {
+		if method := result.Methodology[metric]; isKnownMethod(method) {
+			methodology[metric] = method
+		} else {
+			methodology[metric] = webClientMethodology[metric]
+		}
+	}
+	result.Methodology = methodology
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxImportErrors bounds the per-line errors reported by /results/import.
+const maxImportErrors = 20
+
+// errResultExists is returned by ResultStore.Import for an ID that is already stored.
+var errResultExists = errors.New("result already exists")
+
+// ImportSummary is the response of POST /results/import.
+type ImportSummary struct {
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"` // already stored, or older than -result-ttl
+	Failed   int      `json:"failed"`
+	Errors   []string `json:"errors,omitempty"` // the first failures, by line number
+}
+
+func (s *ImportSummary) fail(line int, reason string) {
+	s.Failed++
+	if len(s.Errors) < maxImportErrors {
+		s.Errors = append(s.Errors, fmt.Sprintf("line %d: %s", line, reason))
+	}
+}
+
+// importResultsHandler serves POST /results/import: results as JSON lines,
+// such as GET /results/export?format=ndjson of another instance writes, saved
+// with their original IDs and timestamps. Results already stored are skipped,
+// so an interrupted import can simply be repeated.
+func importResultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var summary ImportSummary
+	cutoff := time.Time{}
+	if *resultTTL > 0 {
+		cutoff = time.Now().Add(-*resultTTL)
+	}
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxRequestSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var result TestResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			summary.fail(line, "invalid JSON result")
+			continue
+		}
+		if result.Timestamp.IsZero() {
+			summary.fail(line, "missing timestamp")
+			continue
+		}
+		if result.Timestamp.After(time.Now()) {
+			summary.fail(line, "timestamp is in the future")
+			continue
+		}
+		if result.Timestamp.Before(cutoff) {
+			summary.Skipped++ // would expire right away
+			continue
+		}
+		if err := validateResultSamples(result.Samples); err != nil {
+			summary.fail(line, err.Error())
+			continue
+		}
+		if _, err := uuid.Parse(result.ID); err != nil {
+			result.ID = uuid.New().String()
+		}
+		result.Tags = sanitizeImportedTags(result.Tags)
+		if !validClientID(result.ClientID) {
+			result.ClientID = ""
+		}
+		result.TCPSessionIDs = nil
+
+		if _, err := globalStore.Import(result); errors.Is(err, errResultExists) {
+			summary.Skipped++
+		} else if err != nil {
+			log.Printf("Failed to import result %s: %v", result.ID, err)
+			summary.fail(line, "failed to save result")
+		} else {
+			summary.Imported++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Result import stopped after %d results: %v", summary.Imported, err)
+		summary.fail(line+1, "failed to read: "+err.Error())
+	}
+	log.Printf("Imported %d results (%d skipped, %d failed)", summary.Imported, summary.Skipped, summary.Failed)
+	writeJSON(w, summary)
+}
@@ -0,0 +1,803 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With -iperf-port, the server also speaks enough of the iperf3 protocol
+// (https://github.com/esnet/iperf) on that TCP and UDP port for standard
+// iperf3 clients to test against it without a browser: `iperf3 -c host -p
+// port` with -u for UDP, -R for the server to send, -P for parallel streams,
+// -t, -n, -k, -b, -l, -w, -O and -C. Like iperf3's own server, it runs one
+// test at a time and tells other clients it is busy. A test is a download
+// (-R) or upload session within -max-sessions, -max-client-sessions, the
+// access policy and the blocklist, and its bytes count in /metrics. The
+// server reports no CPU use, and bidirectional tests (--bidir), SCTP and
+// authentication are refused as not implemented.
+
+// Control states of the iperf3 protocol, sent as single bytes.
+const (
+	iperfTestStart       int8 = 1
+	iperfTestRunning     int8 = 2
+	iperfTestEnd         int8 = 4
+	iperfParamExchange   int8 = 9
+	iperfCreateStreams   int8 = 10
+	iperfServerTerminate int8 = 11
+	iperfClientTerminate int8 = 12
+	iperfExchangeResults int8 = 13
+	iperfDisplayResults  int8 = 14
+	iperfDone            int8 = 16
+	iperfAccessDenied    int8 = -1
+	iperfServerError     int8 = -2
+)
+
+// iperf3 error numbers sent with iperfServerError; the client explains them.
+const (
+	iperfErrDuration   = 5
+	iperfErrNumStreams = 6
+	iperfErrBlockSize  = 7
+	iperfErrOmit       = 12
+	iperfErrUnimpl     = 13
+)
+
+const (
+	iperfCookieSize       = 37 // including the terminating NUL
+	iperfMaxJSON          = 64 * 1024
+	iperfMaxStreams       = 128
+	iperfMaxTCPBlock      = 1024 * 1024
+	iperfDefaultTCPBlock  = 128 * 1024
+	iperfDefaultUDPBlock  = 1460
+	iperfMaxUDPBlock      = 65507
+	iperfHandshakeTimeout = 10 * time.Second
+	// iperfEndGrace is how long after its duration a test may run before
+	// the client's TEST_END
+	iperfEndGrace = 5 * time.Second
+)
+
+// UDP streams are set up with a 4-byte datagram the server answers, in the
+// byte order of the client's host; clients before iperf 3.1 use the legacy
+// values.
+const (
+	iperfUDPConnect       = 0x36373839
+	iperfUDPReply         = 0x39383736
+	iperfUDPConnectLegacy = 123456789
+	iperfUDPReplyLegacy   = 987654321
+)
+
+var iperfTests = newCounterVec("netspeed_iperf_tests_total",
+	"iperf3 tests by protocol, tcp or udp, and result: completed, aborted, or refused.", "protocol", "result")
+
+// iperfParams are the test parameters an iperf3 client sends. Numbers that
+// may be large are floats, since iperf3 may print them with exponents.
+type iperfParams struct {
+	TCP           bool    `json:"tcp"`
+	UDP           bool    `json:"udp"`
+	SCTP          bool    `json:"sctp"`
+	Omit          int     `json:"omit"`       // seconds
+	Time          int     `json:"time"`       // seconds, or 0 with Num or BlockCount
+	Num           float64 `json:"num"`        // bytes to send
+	BlockCount    float64 `json:"blockcount"` // blocks to send
+	Parallel      int     `json:"parallel"`
+	Reverse       bool    `json:"reverse"`
+	Bidirectional bool    `json:"bidirectional"`
+	Window        int     `json:"window"` // socket buffer size
+	Len           int     `json:"len"`    // block size
+	Bandwidth     float64 `json:"bandwidth"`
+	Congestion    string  `json:"congestion"`
+	UDPCounters64 bool    `json:"udp_counters_64bit"`
+	ClientVersion string  `json:"client_version"`
+}
+
+// check returns the iperf3 error number of parameters the server does not
+// accept, or 0, and fills in the defaults.
+func (p *iperfParams) check() int32 {
+	if p.Parallel == 0 {
+		p.Parallel = 1
+	}
+	if p.Len == 0 {
+		p.Len = iperfDefaultTCPBlock
+		if p.UDP {
+			p.Len = iperfDefaultUDPBlock
+		}
+	}
+	switch {
+	case p.Bidirectional || p.SCTP:
+		return iperfErrUnimpl
+	case p.Omit < 0:
+		return iperfErrOmit
+	case p.Time < 0 || time.Duration(p.Time+p.Omit)*time.Second > *iperfMaxDuration:
+		return iperfErrDuration
+	case p.Parallel < 0 || p.Parallel > iperfMaxStreams:
+		return iperfErrNumStreams
+	case p.Len < 0 || (!p.UDP && p.Len > iperfMaxTCPBlock) || (p.UDP && (p.Len < 16 || p.Len > iperfMaxUDPBlock)):
+		return iperfErrBlockSize
+	}
+	return 0
+}
+
+func (p *iperfParams) protocol() string {
+	if p.UDP {
+		return "udp"
+	}
+	return "tcp"
+}
+
+// iperfStreamResult is one stream in the results server and client exchange.
+type iperfStreamResult struct {
+	ID             int     `json:"id"`
+	Bytes          int64   `json:"bytes"`
+	Retransmits    int64   `json:"retransmits"`
+	Jitter         float64 `json:"jitter"` // seconds
+	Errors         int64   `json:"errors"`
+	OmittedErrors  int64   `json:"omitted_errors"`
+	Packets        int64   `json:"packets"`
+	OmittedPackets int64   `json:"omitted_packets"`
+	StartTime      float64 `json:"start_time"`
+	EndTime        float64 `json:"end_time"`
+}
+
+// iperfResults are the results of one side of a test.
+type iperfResults struct {
+	CPUUtilTotal         float64             `json:"cpu_util_total"`
+	CPUUtilUser          float64             `json:"cpu_util_user"`
+	CPUUtilSystem        float64             `json:"cpu_util_system"`
+	SenderHasRetransmits int                 `json:"sender_has_retransmits"` // -1 when receiving
+	CongestionUsed       string              `json:"congestion_used,omitempty"`
+	Streams              []iperfStreamResult `json:"streams"`
+}
+
+// iperfServer accepts iperf3 clients on -iperf-port.
+type iperfServer struct {
+	ln  net.Listener
+	udp *net.UDPConn
+	seq atomic.Uint64 // orders the streams as their client opened them
+
+	mu   sync.Mutex
+	test *iperfTest // running, or nil
+}
+
+// startIperf serves iperf3 clients on port until the server shuts down.
+func startIperf(port int) error {
+	if consentTerms != nil {
+		return errors.New("iperf3 clients cannot accept the -consent-file terms")
+	}
+	ln, err := listenPlainTCP(port)
+	if err != nil {
+		return err
+	}
+	network := "udp"
+	if listenNetwork == "tcp6" {
+		network = "udp6"
+	}
+	pc, err := net.ListenPacket(network, net.JoinHostPort(listenHost, strconv.Itoa(port)))
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	srv := &iperfServer{ln: ln, udp: pc.(*net.UDPConn)}
+	go srv.serveTCP()
+	go srv.serveUDP()
+	go func() {
+		<-serverContext.Done()
+		ln.Close()
+		pc.Close()
+	}()
+	log.Printf("iperf3 server listening on port %d (TCP and UDP)", port)
+	return nil
+}
+
+// serveTCP accepts control connections and the data connections of TCP
+// streams, which both start with the test's cookie.
+func (srv *iperfServer) serveTCP() {
+	for {
+		c, err := srv.ln.Accept()
+		if err != nil {
+			if serverContext.Err() == nil {
+				log.Printf("iperf3 server stopped accepting connections: %v", err)
+			}
+			return
+		}
+		go srv.handleConn(c, srv.seq.Add(1))
+	}
+}
+
+func (srv *iperfServer) handleConn(c net.Conn, seq uint64) {
+	c.SetDeadline(time.Now().Add(iperfHandshakeTimeout))
+	var cookie [iperfCookieSize]byte
+	if _, err := io.ReadFull(c, cookie[:]); err != nil {
+		c.Close()
+		return
+	}
+	srv.mu.Lock()
+	t := srv.test
+	if t == nil {
+		t = newIperfTest(srv, c, string(cookie[:]))
+		srv.test = t
+	}
+	srv.mu.Unlock()
+
+	switch {
+	case t.control == c:
+		defer func() {
+			srv.mu.Lock()
+			srv.test = nil
+			srv.mu.Unlock()
+		}()
+		t.run()
+	case t.addTCPStream(c, string(cookie[:]), seq):
+	default:
+		writeIperfState(c, iperfAccessDenied)
+		c.Close()
+	}
+}
+
+// serveUDP sets up UDP streams and receives their datagrams.
+func (srv *iperfServer) serveUDP() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, peer, err := srv.udp.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if serverContext.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		srv.mu.Lock()
+		t := srv.test
+		srv.mu.Unlock()
+		if t != nil {
+			t.handleDatagram(buf[:n], peer, time.Now())
+		}
+	}
+}
+
+// Phases of a test, in order.
+const (
+	iperfExchanging = iota // parameters
+	iperfConnecting        // streams
+	iperfRunning
+	iperfEnded
+)
+
+// iperfTest is the test of one control connection.
+type iperfTest struct {
+	srv     *iperfServer
+	control net.Conn
+	cookie  string
+	client  netip.Addr
+	params  iperfParams // set before streams are accepted
+	session *TestSession
+	sent    atomic.Int64 // bytes or blocks sent, of -n and -k
+	started time.Time    // of the measurement, after --omit
+
+	mu        sync.Mutex
+	phase     int
+	streams   []*iperfStream
+	connected chan struct{} // closed when all streams are connected
+	ended     time.Time
+}
+
+func newIperfTest(srv *iperfServer, c net.Conn, cookie string) *iperfTest {
+	var client netip.Addr
+	if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		client = addr.AddrPort().Addr().Unmap()
+	}
+	return &iperfTest{srv: srv, control: c, cookie: cookie, client: client, connected: make(chan struct{})}
+}
+
+// iperfStream is one TCP connection or UDP flow of a test.
+type iperfStream struct {
+	id    int
+	seq   uint64
+	conn  net.Conn       // of a TCP stream
+	peer  netip.AddrPort // of a UDP stream
+	bytes atomic.Int64
+
+	mu          sync.Mutex
+	packets     int64 // sent, or the highest packet number received
+	errors      int64 // packets lost
+	outOfOrder  int64
+	jitter      float64 // seconds
+	prevTransit float64
+	omitted     iperfStreamResult // counts at the end of --omit
+}
+
+// run runs the test of the control connection.
+func (t *iperfTest) run() {
+	c := t.control
+	defer c.Close()
+	defer t.closeStreams()
+	refuse := func(reason string) {
+		iperfTests.Inc(t.params.protocol(), "refused")
+		if *verbose {
+			log.Printf("Refused iperf3 test from %s: %s", loggedAddr(t.client.String()), reason)
+		}
+	}
+
+	if err := writeIperfState(c, iperfParamExchange); err != nil {
+		return
+	}
+	if err := readIperfJSON(c, &t.params); err != nil {
+		if *verbose {
+			log.Printf("iperf3 client %s sent no valid parameters: %v", loggedAddr(t.client.String()), err)
+		}
+		return
+	}
+	if reason := refuseClient(t.client); reason != "" {
+		writeIperfState(c, iperfAccessDenied)
+		refuse(reason)
+		return
+	}
+	if code := t.params.check(); code != 0 {
+		writeIperfError(c, code)
+		refuse(fmt.Sprintf("unsupported parameters (iperf3 error %d)", code))
+		return
+	}
+	kind := "upload"
+	if t.params.Reverse {
+		kind = "download"
+	}
+	session, err := activeSessions.StartConn(kind, t.client.String(), "iperf3", nil)
+	if err != nil {
+		writeIperfState(c, iperfAccessDenied)
+		refuse(err.Error())
+		return
+	}
+	defer activeSessions.Finish(session)
+	t.session = session
+	if t.params.Reverse {
+		defer session.EndDownload(false)
+		blocks := int64(t.params.BlockCount) * int64(t.params.Len)
+		session.SetDownloadGoal(max(int64(t.params.Num), blocks), time.Duration(t.params.Time)*time.Second)
+	}
+
+	t.setPhase(iperfConnecting)
+	if err := writeIperfState(c, iperfCreateStreams); err != nil {
+		return
+	}
+	select {
+	case <-t.connected:
+	case <-time.After(iperfHandshakeTimeout):
+		iperfTests.Inc(t.params.protocol(), "aborted")
+		return
+	}
+	if tc, ok := t.streams[0].conn.(*net.TCPConn); ok {
+		session.mu.Lock()
+		session.conn = tc
+		session.mu.Unlock()
+	}
+
+	c.SetDeadline(time.Time{})
+	if writeIperfState(c, iperfTestStart) != nil || writeIperfState(c, iperfTestRunning) != nil {
+		return
+	}
+	completed := t.transfer()
+	if !completed {
+		iperfTests.Inc(t.params.protocol(), "aborted")
+		return
+	}
+	if t.params.Reverse {
+		session.EndDownload(true)
+	}
+
+	c.SetDeadline(time.Now().Add(iperfHandshakeTimeout))
+	var clientResults iperfResults
+	if writeIperfState(c, iperfExchangeResults) != nil ||
+		readIperfJSON(c, &clientResults) != nil ||
+		writeIperfJSON(c, t.results()) != nil ||
+		writeIperfState(c, iperfDisplayResults) != nil {
+		iperfTests.Inc(t.params.protocol(), "aborted")
+		return
+	}
+	// The client sends IPERF_DONE once it printed the results
+	var done [1]byte
+	io.ReadFull(c, done[:])
+	iperfTests.Inc(t.params.protocol(), "completed")
+	if *verbose {
+		log.Printf("iperf3 %s test from %s: %d streams, %d bytes", t.params.protocol(), loggedAddr(t.client.String()), len(t.streams), session.Bytes())
+	}
+}
+
+func (t *iperfTest) setPhase(phase int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.phase = phase
+	if phase == iperfEnded {
+		t.ended = time.Now()
+	}
+}
+
+// addTCPStream adds c, the data connection of a TCP stream, to the test if
+// it is one of the streams the test is waiting for.
+func (t *iperfTest) addTCPStream(c net.Conn, cookie string, seq uint64) bool {
+	addr, ok := c.RemoteAddr().(*net.TCPAddr)
+	if !ok || cookie != t.cookie || addr.AddrPort().Addr().Unmap() != t.client {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.phase != iperfConnecting || t.params.UDP || len(t.streams) >= t.params.Parallel {
+		return false
+	}
+	c.SetDeadline(time.Time{})
+	if tc, ok := c.(*net.TCPConn); ok {
+		if t.params.Window > 0 {
+			tc.SetReadBuffer(t.params.Window)
+			tc.SetWriteBuffer(t.params.Window)
+		}
+		if t.params.Congestion != "" {
+			if _, err := setConnCongestion(tc, t.params.Congestion); err != nil && *verbose {
+				log.Printf("iperf3 client %s asked for congestion control %q: %v", loggedAddr(t.client.String()), t.params.Congestion, err)
+			}
+		}
+	}
+	t.addStreamLocked(&iperfStream{conn: c, seq: seq})
+	return true
+}
+
+// handleDatagram sets up a UDP stream or counts one of its datagrams.
+func (t *iperfTest) handleDatagram(b []byte, peer netip.AddrPort, arrival time.Time) {
+	t.mu.Lock()
+	phase := t.phase
+	if phase == iperfExchanging || !t.params.UDP {
+		t.mu.Unlock()
+		return
+	}
+	var stream *iperfStream
+	for _, s := range t.streams {
+		if s.peer == peer {
+			stream = s
+		}
+	}
+	if len(b) == 4 && phase == iperfConnecting && peer.Addr().Unmap() == t.client {
+		if stream == nil && len(t.streams) < t.params.Parallel {
+			stream = &iperfStream{peer: peer, seq: t.srv.seq.Add(1)}
+			t.addStreamLocked(stream)
+		}
+		t.mu.Unlock()
+		if reply, ok := iperfUDPConnectReply(b); ok && stream != nil {
+			t.srv.udp.WriteToUDPAddrPort(reply, peer)
+		}
+		return
+	}
+	t.mu.Unlock()
+	if stream != nil && phase == iperfRunning && !t.params.Reverse {
+		stream.receive(b, arrival, t.params.UDPCounters64)
+		t.session.AddBytes(int64(len(b)))
+	}
+}
+
+// iperfUDPConnectReply answers a UDP stream's connect datagram in the byte
+// order it was sent in.
+func iperfUDPConnectReply(b []byte) ([]byte, bool) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		reply := make([]byte, 4)
+		switch order.Uint32(b) {
+		case iperfUDPConnect:
+			order.PutUint32(reply, iperfUDPReply)
+		case iperfUDPConnectLegacy:
+			order.PutUint32(reply, iperfUDPReplyLegacy)
+		default:
+			continue
+		}
+		return reply, true
+	}
+	return nil, false
+}
+
+// addStreamLocked adds a stream with t.mu held; once all are there, they
+// are numbered like iperf3 does, 1, 3, 4, ..., in the order their client
+// opened them.
+func (t *iperfTest) addStreamLocked(s *iperfStream) {
+	t.streams = append(t.streams, s)
+	if len(t.streams) < t.params.Parallel {
+		return
+	}
+	slices.SortFunc(t.streams, func(a, b *iperfStream) int { return int(a.seq) - int(b.seq) })
+	for i, s := range t.streams {
+		s.id = i + 1
+		if i > 0 {
+			s.id = i + 2
+		}
+	}
+	close(t.connected)
+}
+
+func (t *iperfTest) closeStreams() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.streams {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}
+}
+
+// transfer runs the streams until the client ends the test, and reports
+// whether it did. The test is cut short with SERVER_TERMINATE when it runs
+// past its duration or -iperf-max-duration, or the server shuts down.
+func (t *iperfTest) transfer() bool {
+	ctx, cancel := context.WithCancel(serverContext)
+	defer cancel()
+	t.started = time.Now()
+	t.setPhase(iperfRunning)
+	var wg sync.WaitGroup
+	for _, s := range t.streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch {
+			case t.params.Reverse && t.params.UDP:
+				t.sendUDP(ctx, s)
+			case t.params.Reverse:
+				t.sendTCP(ctx, s)
+			case !t.params.UDP:
+				t.receiveTCP(s)
+			}
+		}()
+	}
+	if t.params.Omit > 0 {
+		omit := time.AfterFunc(time.Duration(t.params.Omit)*time.Second, t.endOmit)
+		defer omit.Stop()
+	}
+
+	states := make(chan int8, 1)
+	go func() {
+		var b [1]byte
+		for {
+			if _, err := io.ReadFull(t.control, b[:]); err != nil {
+				states <- iperfClientTerminate
+				return
+			}
+			if state := int8(b[0]); state == iperfTestEnd || state == iperfClientTerminate {
+				states <- state
+				return
+			}
+		}
+	}()
+	limit := *iperfMaxDuration
+	if t.params.Time > 0 {
+		limit = time.Duration(t.params.Time+t.params.Omit)*time.Second + iperfEndGrace
+	}
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+
+	ended := false
+	select {
+	case state := <-states:
+		ended = state == iperfTestEnd
+	case <-timer.C:
+		writeIperfState(t.control, iperfServerTerminate)
+	case <-serverContext.Done():
+		writeIperfState(t.control, iperfServerTerminate)
+	}
+	t.setPhase(iperfEnded)
+	cancel()
+	for _, s := range t.streams {
+		if s.conn != nil {
+			s.conn.SetDeadline(time.Now())
+		}
+	}
+	wg.Wait()
+	return ended
+}
+
+// endOmit sets aside what the streams counted during --omit.
+func (t *iperfTest) endOmit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, s := range t.streams {
+		s.mu.Lock()
+		s.omitted = iperfStreamResult{Bytes: s.bytes.Load(), Packets: s.packets, Errors: s.errors}
+		s.mu.Unlock()
+	}
+	t.started = time.Now()
+}
+
+// takeBlock reports whether another block of size may be sent within -n
+// or -k.
+func (t *iperfTest) takeBlock(size int) bool {
+	switch {
+	case t.params.Num > 0:
+		return t.sent.Add(int64(size))-int64(size) < int64(t.params.Num)
+	case t.params.BlockCount > 0:
+		return t.sent.Add(1) <= int64(t.params.BlockCount)
+	}
+	return true
+}
+
+func (t *iperfTest) sendTCP(ctx context.Context, s *iperfStream) {
+	size := int64(t.params.Len)
+	payload := newDownloadPayload(newPayloadGenerator(), size)
+	start := time.Now()
+	for ctx.Err() == nil && t.takeBlock(int(size)) {
+		n, err := s.conn.Write(payload.At(s.bytes.Load(), size))
+		s.bytes.Add(int64(n))
+		t.session.AddBytes(int64(n))
+		if err != nil {
+			return
+		}
+		iperfPace(ctx, start, s.bytes.Load(), t.params.Bandwidth)
+	}
+}
+
+func (t *iperfTest) sendUDP(ctx context.Context, s *iperfStream) {
+	buf := make([]byte, t.params.Len)
+	copy(buf, newDownloadPayload(newPayloadGenerator(), int64(len(buf))).At(0, int64(len(buf))))
+	start := time.Now()
+	for ctx.Err() == nil && t.takeBlock(len(buf)) {
+		s.mu.Lock()
+		s.packets++
+		count := s.packets
+		s.mu.Unlock()
+		now := time.Now()
+		binary.BigEndian.PutUint32(buf[0:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(buf[4:], uint32(now.Nanosecond()/1000))
+		if t.params.UDPCounters64 {
+			binary.BigEndian.PutUint64(buf[8:], uint64(count))
+		} else {
+			binary.BigEndian.PutUint32(buf[8:], uint32(count))
+		}
+		n, err := t.srv.udp.WriteToUDPAddrPort(buf, s.peer)
+		if err != nil {
+			return
+		}
+		s.bytes.Add(int64(n))
+		t.session.AddBytes(int64(n))
+		iperfPace(ctx, start, s.bytes.Load(), t.params.Bandwidth)
+	}
+}
+
+// iperfPace waits until sent bytes are due at rate bits per second since
+// start; a rate of 0 is unlimited.
+func iperfPace(ctx context.Context, start time.Time, sent int64, rate float64) {
+	if rate <= 0 {
+		return
+	}
+	due := start.Add(time.Duration(float64(sent) * 8 / rate * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (t *iperfTest) receiveTCP(s *iperfStream) {
+	buf := make([]byte, max(t.params.Len, iperfDefaultTCPBlock))
+	for {
+		n, err := s.conn.Read(buf)
+		s.bytes.Add(int64(n))
+		t.session.AddBytes(int64(n))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// receive counts a UDP datagram like iperf3 does: packets missing from the
+// sequence are lost until they arrive out of order, and the jitter is the
+// smoothed variation of the transit time (RFC 1889).
+func (s *iperfStream) receive(b []byte, arrival time.Time, counters64 bool) {
+	if len(b) < 12 || (counters64 && len(b) < 16) {
+		return
+	}
+	sent := time.Unix(int64(binary.BigEndian.Uint32(b[0:])), int64(binary.BigEndian.Uint32(b[4:]))*1000)
+	count := int64(binary.BigEndian.Uint32(b[8:]))
+	if counters64 {
+		count = int64(binary.BigEndian.Uint64(b[8:]))
+	}
+	s.bytes.Add(int64(len(b)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if count > s.packets {
+		s.errors += count - s.packets - 1
+		s.packets = count
+	} else {
+		s.outOfOrder++
+		if s.errors > 0 {
+			s.errors--
+		}
+	}
+	transit := arrival.Sub(sent).Seconds()
+	if s.prevTransit != 0 {
+		d := transit - s.prevTransit
+		s.jitter += (max(d, -d) - s.jitter) / 16
+	}
+	s.prevTransit = transit
+}
+
+// results are the server's side of the test, without what --omit set aside.
+func (t *iperfTest) results() iperfResults {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := iperfResults{SenderHasRetransmits: -1, Streams: []iperfStreamResult{}}
+	if t.params.Reverse {
+		r.SenderHasRetransmits = 0
+	}
+	elapsed := t.ended.Sub(t.started).Seconds()
+	for _, s := range t.streams {
+		s.mu.Lock()
+		result := iperfStreamResult{
+			ID:             s.id,
+			Bytes:          s.bytes.Load() - s.omitted.Bytes,
+			Jitter:         s.jitter,
+			Errors:         s.errors,
+			OmittedErrors:  s.omitted.Errors,
+			Packets:        s.packets,
+			OmittedPackets: s.omitted.Packets,
+			EndTime:        elapsed,
+		}
+		s.mu.Unlock()
+		if tc, ok := s.conn.(*net.TCPConn); ok {
+			if info, err := readTCPInfo(tc); err == nil && info != nil {
+				r.CongestionUsed = info.Congestion
+				if t.params.Reverse {
+					r.SenderHasRetransmits = 1
+					result.Retransmits = int64(info.Retransmits)
+				}
+			}
+		}
+		r.Streams = append(r.Streams, result)
+	}
+	return r
+}
+
+func writeIperfState(c net.Conn, state int8) error {
+	_, err := c.Write([]byte{byte(state)})
+	return err
+}
+
+// writeIperfError refuses a test with an iperf3 error number.
+func writeIperfError(c net.Conn, code int32) error {
+	if err := writeIperfState(c, iperfServerError); err != nil {
+		return err
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(code))
+	b = binary.BigEndian.AppendUint32(b, 0) // errno
+	_, err := c.Write(b)
+	return err
+}
+
+// readIperfJSON reads a JSON message, which iperf3 prefixes with its length.
+func readIperfJSON(c net.Conn, v any) error {
+	var size [4]byte
+	if _, err := io.ReadFull(c, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > iperfMaxJSON {
+		return fmt.Errorf("iperf3 message of %d bytes is too large", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+func writeIperfJSON(c net.Conn, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	_, err = c.Write(append(b, data...))
+	return err
+}
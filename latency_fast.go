@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clients ping /latency, /latency/stream and /ws/ping many times a second,
+// and under load the server's own work per probe shows up in the round
+// trips. The echo paths therefore neither allocate nor format with fmt or
+// reflection per probe: timestamps are appended to reused buffers, and
+// stream probes of the usual {"seq": 1, "clientTime": 1792155315634.2} shape
+// are echoed without encoding/json, which still handles anything else.
+//
+// Probes are stamped from the monotonic clock, anchored to the wall clock
+// once a minute, which is cheaper than reading the wall clock and keeps a
+// wall clock stepped during a session from making round trips jump.
+
+// clockAnchorInterval is how often serverUnixNano reads the wall clock.
+const clockAnchorInterval = time.Minute
+
+// clockAnchor pairs a wall clock reading with the time it was taken.
+type clockAnchor struct {
+	wall int64     // Unix nanoseconds
+	at   time.Time // with its monotonic reading
+}
+
+var serverClock atomic.Pointer[clockAnchor]
+
+// serverUnixNano returns the current time in Unix nanoseconds.
+func serverUnixNano() int64 {
+	a := serverClock.Load()
+	if a != nil {
+		if elapsed := time.Since(a.at); elapsed < clockAnchorInterval {
+			return a.wall + int64(elapsed)
+		}
+	}
+	now := time.Now()
+	serverClock.Store(&clockAnchor{wall: now.UnixNano(), at: now})
+	return now.UnixNano()
+}
+
+// serverUnixMilli returns the current time in Unix milliseconds.
+func serverUnixMilli() int64 {
+	return serverUnixNano() / int64(time.Millisecond)
+}
+
+// latencyContentType is shared by all /latency responses; net/http does not
+// modify header values.
+var latencyContentType = []string{"text/plain; charset=utf-8"}
+
+// latencyBuffers holds the buffers /latency formats its timestamp into.
+var latencyBuffers = sync.Pool{New: func() any { return new([20]byte) }}
+
+// appendLatencyEcho appends the echo of a /latency/stream probe line in the
+// usual shape, with the server's time, and reports whether it could. Lines
+// of any other shape, including malformed ones, are left to encoding/json.
+func appendLatencyEcho(dst, line []byte, serverTime int64) ([]byte, bool) {
+	var seq, clientTime []byte
+	rest := trimJSONSpace(line)
+	if len(rest) < 2 || rest[0] != '{' || rest[len(rest)-1] != '}' {
+		return dst, false
+	}
+	rest = trimJSONSpace(rest[1 : len(rest)-1])
+	for len(rest) > 0 {
+		key, value, ok := cutJSONKey(rest)
+		if !ok {
+			return dst, false
+		}
+		var more bool
+		value, rest, more = bytes.Cut(value, []byte{','})
+		value = trimJSONSpace(value)
+		if rest = trimJSONSpace(rest); more && len(rest) == 0 {
+			return dst, false
+		}
+		switch {
+		case string(key) == "seq" && seq == nil && isJSONInt(value):
+			seq = value
+		case string(key) == "clientTime" && clientTime == nil && isJSONNumber(value):
+			clientTime = value
+		default:
+			return dst, false
+		}
+	}
+
+	dst = append(dst, `{"seq":`...)
+	if seq == nil {
+		dst = append(dst, '0')
+	} else {
+		n, err := strconv.ParseInt(string(seq), 10, 0)
+		if err != nil {
+			return dst, false
+		}
+		dst = strconv.AppendInt(dst, n, 10)
+	}
+	if clientTime != nil {
+		t, err := strconv.ParseFloat(string(clientTime), 64)
+		// Formatted like encoding/json within this range
+		if abs := max(t, -t); err != nil || (abs != 0 && (abs < 1e-6 || abs >= 1e21)) {
+			return dst, false
+		}
+		if t != 0 {
+			dst = append(dst, `,"clientTime":`...)
+			dst = strconv.AppendFloat(dst, t, 'f', -1, 64)
+		}
+	}
+	if serverTime != 0 {
+		dst = append(dst, `,"serverTime":`...)
+		dst = strconv.AppendInt(dst, serverTime, 10)
+	}
+	return append(dst, '}', '\n'), true
+}
+
+// cutJSONKey cuts a plain quoted key and its colon off the front of b.
+func cutJSONKey(b []byte) (key, rest []byte, ok bool) {
+	if len(b) == 0 || b[0] != '"' {
+		return nil, b, false
+	}
+	end := bytes.IndexByte(b[1:], '"')
+	if end < 0 || bytes.IndexByte(b[1:end+1], '\\') >= 0 {
+		return nil, b, false
+	}
+	key, rest = b[1:end+1], trimJSONSpace(b[end+2:])
+	if len(rest) == 0 || rest[0] != ':' {
+		return nil, b, false
+	}
+	return key, trimJSONSpace(rest[1:]), true
+}
+
+// trimJSONSpace trims the whitespace JSON allows around tokens, which unlike
+// bytes.TrimSpace does not include Unicode spaces.
+func trimJSONSpace(b []byte) []byte {
+	return bytes.Trim(b, " \t\r\n")
+}
+
+// isJSONInt reports whether b is a JSON number without fraction or exponent.
+func isJSONInt(b []byte) bool {
+	if len(b) > 0 && b[0] == '-' {
+		b = b[1:]
+	}
+	if len(b) == 0 || (b[0] == '0' && len(b) > 1) {
+		return false
+	}
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isJSONNumber reports whether b is a JSON number.
+func isJSONNumber(b []byte) bool {
+	mantissa, exponent := b, []byte(nil)
+	if i := bytes.IndexAny(b, "eE"); i >= 0 {
+		mantissa, exponent = b[:i], b[i+1:]
+		if len(exponent) > 0 && (exponent[0] == '+' || exponent[0] == '-') {
+			exponent = exponent[1:]
+		}
+		if !isDigits(exponent) {
+			return false
+		}
+	}
+	integer, fraction, dotted := bytes.Cut(mantissa, []byte{'.'})
+	return isJSONInt(integer) && (!dotted || isDigits(fraction))
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
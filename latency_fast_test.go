@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAppendLatencyEcho(t *testing.T) {
+	tests := []struct {
+		line       string
+		serverTime int64
+		want       string // empty when the line is left to encoding/json
+	}{
+		{`{"seq": 1, "clientTime": 1792155315634.2}`, 1792155315640, `{"seq":1,"clientTime":1792155315634.2,"serverTime":1792155315640}` + "\n"},
+		{`{"seq":7}`, 5, `{"seq":7,"serverTime":5}` + "\n"},
+		{` { "clientTime" : 12 , "seq" : -3 } `, 5, `{"seq":-3,"clientTime":12,"serverTime":5}` + "\n"},
+		{`{}`, 5, `{"seq":0,"serverTime":5}` + "\n"},
+		{`{"seq":1}`, 0, `{"seq":1}` + "\n"},
+		{`{"seq":1,"clientTime":0}`, 5, `{"seq":1,"serverTime":5}` + "\n"},
+		{`{"seq":1,"clientTime":1.5e3}`, 5, `{"seq":1,"clientTime":1500,"serverTime":5}` + "\n"},
+		{`{"seq":1,"clientTime":-0.25}`, 5, `{"seq":1,"clientTime":-0.25,"serverTime":5}` + "\n"},
+
+		// Left to encoding/json
+		{``, 5, ``},
+		{`[1]`, 5, ``},
+		{"\u00a0{}", 5, ``},
+		{`{"seq":1,}`, 5, ``},
+		{`{"seq":1,"seq":2}`, 5, ``},
+		{`{"seq":1.5}`, 5, ``},
+		{`{"seq":01}`, 5, ``},
+		{`{"seq":"1"}`, 5, ``},
+		{`{"Seq":1}`, 5, ``},
+		{`{"s\u0065q":1}`, 5, ``},
+		{`{"seq":1,"serverTime":2}`, 5, ``},
+		{`{"seq":1,"extra":true}`, 5, ``},
+		{`{"seq":99999999999999999999}`, 5, ``},
+		{`{"seq":1,"clientTime":1e-7}`, 5, ``},
+		{`{"seq":1,"clientTime":1e21}`, 5, ``},
+		{`{"seq":1,"clientTime":1e400}`, 5, ``},
+		{`{"seq":1,"clientTime":.5}`, 5, ``},
+		{`{"seq":1 "clientTime":2}`, 5, ``},
+	}
+	for _, tt := range tests {
+		got, ok := appendLatencyEcho(nil, []byte(tt.line), tt.serverTime)
+		if want := tt.want != ""; ok != want {
+			t.Errorf("appendLatencyEcho(%q) ok = %v, want %v", tt.line, ok, want)
+			continue
+		}
+		if ok && string(got) != tt.want {
+			t.Errorf("appendLatencyEcho(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+// FuzzAppendLatencyEcho checks that every echo of the fast path is the one
+// encoding/json would have written.
+func FuzzAppendLatencyEcho(f *testing.F) {
+	for _, line := range []string{
+		`{"seq": 1, "clientTime": 1792155315634.2}`, `{"seq":7}`, `{}`, `{"clientTime":-0}`,
+		`{"seq":1,"clientTime":1e-6}`, `{"seq":1,"clientTime":123456789012345678901}`, `{"seq":-9223372036854775808}`,
+		`{"seq":1,"seq":2}`, `{"seq":1,"error":"x"}`, `not json`,
+	} {
+		f.Add(line, int64(1792155315640))
+		f.Add(line, int64(0))
+	}
+	f.Fuzz(func(t *testing.T, line string, serverTime int64) {
+		got, ok := appendLatencyEcho(nil, []byte(line), serverTime)
+		if !ok {
+			return
+		}
+		var probe LatencyProbe
+		if err := json.Unmarshal([]byte(line), &probe); err != nil {
+			t.Fatalf("appendLatencyEcho(%q) echoed a probe encoding/json rejects: %v", line, err)
+		}
+		probe.ServerTime = serverTime
+		want, err := json.Marshal(probe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want = append(want, '\n'); string(got) != string(want) {
+			t.Fatalf("appendLatencyEcho(%q) = %q, encoding/json wrote %q", line, got, want)
+		}
+	})
+}
+
+func TestLatencyEchoAllocs(t *testing.T) {
+	line := []byte(`{"seq": 123, "clientTime": 1792155315634.2}`)
+	dst := make([]byte, 0, 128)
+	serverUnixMilli() // anchor the clock
+	allocs := testing.AllocsPerRun(1000, func() {
+		var ok bool
+		dst, ok = appendLatencyEcho(dst[:0], line, serverUnixMilli())
+		if !ok {
+			t.Fatal("probe left to encoding/json")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("echoing a probe allocates %v times, want 0", allocs)
+	}
+}
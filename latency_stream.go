@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	latencyStreamMaxDuration = 2 * time.Minute // after which the server ends the stream
+	latencyStreamMaxLine     = 256
+)
+
+// LatencyPolicy tells clients how to sample latency and jitter, and the
+// limits the echo paths enforce per connection.
+type LatencyPolicy struct {
+	IntervalMs  float64 `json:"intervalMs"`  // recommended interval between probes
+	Count       int     `json:"count"`       // recommended number of jitter probes
+	MaxRate     int     `json:"maxRate"`     // probes per second echoed per connection; 0 for unlimited
+	MaxMessages int     `json:"maxMessages"` // probes echoed per connection; 0 for unlimited
+}
+
+var echoDropped = newCounterVec("netspeed_echo_dropped_total",
+	"Probes not echoed because a connection exceeded -echo-max-rate or -echo-max-messages.", "path", "reason")
+
+func latencyPolicy() LatencyPolicy {
+	return LatencyPolicy{
+		IntervalMs:  float64(pingInterval.Microseconds()) / 1000,
+		Count:       *pingCount,
+		MaxRate:     max(*echoMaxRate, 0),
+		MaxMessages: max(*echoMaxMessages, 0),
+	}
+}
+
+// validatePingPolicy checks that clients following the recommended cadence
+// stay within the echo limits.
+func validatePingPolicy() error {
+	if *pingInterval <= 0 || *pingCount <= 0 {
+		return fmt.Errorf("-ping-interval and -ping-count must be positive")
+	}
+	if *echoMaxRate > 0 && *pingInterval < time.Second/time.Duration(*echoMaxRate) {
+		return fmt.Errorf("-ping-interval %s is faster than -echo-max-rate %d/s allows", *pingInterval, *echoMaxRate)
+	}
+	if *echoMaxMessages > 0 && *pingCount > *echoMaxMessages {
+		return fmt.Errorf("-ping-count %d exceeds -echo-max-messages %d", *pingCount, *echoMaxMessages)
+	}
+	return nil
+}
+
+// echoRejectReason is the metric label for an echoLimiter error.
+func echoRejectReason(err error) string {
+	if err == errEchoTotal {
+		return "total"
+	}
+	return "rate"
+}
+
+// LatencyProbe is one line of /latency/stream in either direction: the client
+// sends seq and its clock, the server echoes both with its own.
+type LatencyProbe struct {
+	Seq        int     `json:"seq"`
+	ClientTime float64 `json:"clientTime,omitempty"` // client clock, echoed unchanged
+	ServerTime int64   `json:"serverTime,omitempty"` // unix milliseconds, set on the echo
+	Error      string  `json:"error,omitempty"`      // set instead of echoing a malformed probe
+}
+
+// latencyStreamHandler serves POST /latency/stream: a long-lived full-duplex
+// exchange of newline-delimited JSON probes, each echoed as soon as it
+// arrives. Round trips share one connection, so they cost no request setup and
+// can be sampled at a high rate, also while a transfer loads the link. A
+// client exceeding the echo limits gets an error and the stream ends.
+func latencyStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := startSession(w, "latency", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+
+	rc := http.NewResponseController(w)
+	rc.EnableFullDuplex() // ErrNotSupported on HTTP/2, which is always full duplex
+	rc.SetReadDeadline(time.Now().Add(latencyStreamMaxDuration))
+	w.Header().Set("X-Session-ID", session.ID)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush() // let the client start sending
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, latencyStreamMaxLine), latencyStreamMaxLine)
+	enc := json.NewEncoder(w)
+	limiter := newEchoLimiter()
+	echo := make([]byte, 0, latencyStreamMaxLine+32)
+	probes := 0
+	for scanner.Scan() {
+		// Dropping a probe would leave the client waiting for it; end the stream instead
+		limitErr := limiter.Allow()
+		var ok bool
+		if limitErr == nil {
+			echo, ok = appendLatencyEcho(echo[:0], scanner.Bytes(), serverUnixMilli())
+		}
+		if !ok { // a probe of another shape, a malformed one or one over the limits
+			var probe LatencyProbe
+			if err := json.Unmarshal(scanner.Bytes(), &probe); err != nil {
+				enc.Encode(LatencyProbe{Error: "invalid probe"})
+				return
+			}
+			if limitErr != nil {
+				echoDropped.Inc("/latency/stream", echoRejectReason(limitErr))
+				enc.Encode(LatencyProbe{Seq: probe.Seq, Error: limitErr.Error()})
+				return
+			}
+			probe.ServerTime = serverUnixMilli()
+			echo, _ = json.Marshal(probe)
+			echo = append(echo, '\n')
+		}
+		if _, err := w.Write(echo); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		probes++
+	}
+	if *verbose {
+		log.Printf("Latency stream from %s ended after %d probes: %v", loggedAddr(r.RemoteAddr), probes, scanner.Err())
+	}
+}
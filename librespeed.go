@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// With -librespeed-compat, the server answers like a LibreSpeed backend, so
+// LibreSpeed frontends and librespeed-cli can test against it unchanged:
+// garbage.php?ckSize={MB} downloads, POSTs to empty.php upload and GETs ping,
+// and getIP.php names the client's address and, with ?isp=true, its network.
+// The endpoints are served at the root and under /backend/, the two layouts
+// LibreSpeed deployments use. Transfers are test sessions like those of the
+// native endpoints, within the same size, duration and session limits.
+
+// librespeedChunk is the unit of garbage.php?ckSize=.
+const librespeedChunk = 1024 * 1024
+
+// librespeedMaxChunks is the ckSize LibreSpeed caps requests at.
+const librespeedMaxChunks = 1024
+
+// registerLibreSpeed adds the LibreSpeed endpoints to mux.
+func registerLibreSpeed(mux *http.ServeMux) {
+	for _, prefix := range []string{"/", "/backend/"} {
+		mux.HandleFunc(prefix+"garbage.php", withLibreSpeedCORS(librespeedGarbageHandler))
+		mux.HandleFunc(prefix+"empty.php", withLibreSpeedCORS(librespeedEmptyHandler))
+		mux.HandleFunc(prefix+"getIP.php", withLibreSpeedCORS(librespeedIPHandler))
+	}
+	log.Printf("Serving LibreSpeed-compatible endpoints garbage.php, empty.php and getIP.php")
+}
+
+// withLibreSpeedCORS allows other origins like the LibreSpeed backend does
+// when a frontend adds ?cors to its requests.
+func withLibreSpeedCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["cors"]; ok || r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Encoding, Content-Type")
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// setLibreSpeedNoCache sets the cache headers of LibreSpeed's responses, so
+// proxies and browsers do not cache test data.
+func setLibreSpeedNoCache(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0, s-maxage=0")
+	w.Header().Set("Pragma", "no-cache")
+}
+
+// librespeedGarbageHandler serves GET /garbage.php?ckSize={MB}: ckSize
+// megabytes of incompressible data, 4 by default. Like LibreSpeed, a missing
+// or malformed ckSize falls back to the default rather than failing; the
+// size is then clamped to -min-size and -maxsize.
+func librespeedGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	// Not read with a paramReader on purpose: LibreSpeed frontends send
+	// ckSize unchecked, and refusing it would fail tests LibreSpeed runs.
+	chunks, err := strconv.ParseInt(r.URL.Query().Get("ckSize"), 10, 64)
+	if err != nil || chunks < 1 {
+		chunks = 4
+	}
+	chunks = max(*minSize, min(chunks, librespeedMaxChunks, *maxDownloadSize))
+	// The server's default chunk size, without the native parameters
+	req, err := parseDownloadRequest(url.Values{})
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	req.SizeBytes = chunks * librespeedChunk
+
+	session, ok := startSession(w, "download", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+	session.SetChunkSize(req.ChunkSize)
+	w.Header().Set("X-Session-ID", session.ID)
+	setLibreSpeedNoCache(w)
+	w.Header().Set("Content-Description", "File Transfer")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename=random.dat")
+	w.Header().Set("Content-Transfer-Encoding", "binary")
+	w.Header().Set("Content-Length", strconv.FormatInt(req.SizeBytes, 10))
+	streamDownload(w, r, session, req)
+}
+
+// librespeedEmptyHandler serves /empty.php: GETs are pings, answered with
+// an empty response, and POSTs are uploads, whose bodies are read and
+// discarded before the empty response.
+func librespeedEmptyHandler(w http.ResponseWriter, r *http.Request) {
+	setLibreSpeedNoCache(w)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, tr(r, "Only GET and POST methods are supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	if r.ContentLength == 0 {
+		return
+	}
+	if limit := *maxUploadSize * 1024 * 1024; limit > 0 && r.ContentLength > limit {
+		refuseUpload(w, r, errUploadTooLarge)
+		return
+	}
+	session, ok := startSession(w, "upload", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+	w.Header().Set("X-Session-ID", session.ID)
+	uploadedBytes, err := io.Copy(io.Discard, newUploadReader(w, r, session))
+	switch {
+	case errors.Is(err, errUploadTooLarge) || errors.Is(err, errUploadTooLong):
+		refuseUpload(w, r, err)
+	case r.Context().Err() != nil:
+		if *verbose {
+			log.Printf("LibreSpeed upload canceled after %d bytes: %v", uploadedBytes, r.Context().Err())
+		}
+	case err != nil:
+		log.Printf("LibreSpeed upload failed to read body: %v", err)
+		http.Error(w, tr(r, "Upload failed to read body"), http.StatusInternalServerError)
+	}
+}
+
+// LibreSpeedIP is the response of getIP.php.
+type LibreSpeedIP struct {
+	ProcessedString string `json:"processedString"` // e.g. "192.0.2.1 - Example ISP, DE"
+	RawISPInfo      any    `json:"rawIspInfo"`      // *LibreSpeedISPInfo with ?isp=true, otherwise ""
+}
+
+// LibreSpeedISPInfo is the client's network in the format of ipinfo.io,
+// which LibreSpeed backends look clients up with.
+type LibreSpeedISPInfo struct {
+	IP      string `json:"ip"`
+	City    string `json:"city,omitempty"`
+	Country string `json:"country,omitempty"`
+	Org     string `json:"org,omitempty"` // e.g. "AS3320 Deutsche Telekom AG"
+}
+
+// librespeedIPHandler serves GET /getIP.php[?isp=true]: the client's address
+// and, with ?isp=true, its ISP and country from -geoip-db. Addresses -geoip-db
+// cannot know are described like LibreSpeed does, e.g. "private IPv4 access".
+func librespeedIPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	ip := requestClientIP(r)
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		ip = addr.Unmap().WithZone("").String()
+	}
+	response := LibreSpeedIP{ProcessedString: ip, RawISPInfo: ""}
+	if r.URL.Query().Get("isp") == "true" {
+		info := &LibreSpeedISPInfo{IP: ip}
+		network := librespeedSpecialAddress(ip)
+		if geo := lookupGeo(ip); network == "" && geo != nil {
+			info.City, info.Country = geo.City, geo.CountryCode
+			network = geo.ISP
+			if geo.ASN != 0 {
+				info.Org = strings.TrimSpace(fmt.Sprintf("AS%d %s", geo.ASN, geo.ISP))
+			}
+			if network == "" {
+				network = "Unknown ISP"
+			}
+			if geo.CountryCode != "" {
+				network += ", " + geo.CountryCode
+			}
+		}
+		if network != "" {
+			response.ProcessedString += " - " + network
+		}
+		response.RawISPInfo = info
+	}
+	writeJSON(w, response)
+}
+
+// librespeedSpecialAddress describes loopback, private and link-local
+// addresses like LibreSpeed does, or returns "" for public ones.
+func librespeedSpecialAddress(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	family := "IPv4"
+	if addr.Is6() {
+		family = "IPv6"
+	}
+	switch {
+	case addr.IsLoopback():
+		return "localhost " + family + " access"
+	case addr.IsLinkLocalUnicast():
+		return "link-local " + family + " access"
+	case addr.Is6() && addr.IsPrivate():
+		return "ULA IPv6 access"
+	case addr.IsPrivate():
+		return "private IPv4 access"
+	case addr.Is4() && netip.MustParsePrefix("100.64.0.0/10").Contains(addr):
+		return "CGNAT IPv4 access"
+	}
+	return ""
+}
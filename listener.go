@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// listenHost and listenNetwork are resolved from -listen and -ipv6-only by
+// configureListener. An empty host binds all interfaces.
+var (
+	listenHost    string
+	listenNetwork = "tcp"
+)
+
+// parseListenAddr splits a -listen value into host and optional port. It
+// accepts bare IPv4 or IPv6 addresses, bracketed IPv6 addresses and
+// host:port forms, e.g. "0.0.0.0", "::1", "[::1]", "[::]:8080".
+func parseListenAddr(addr string) (string, int, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return "", 0, nil
+	}
+	if net.ParseIP(addr) != nil {
+		return addr, 0, nil
+	}
+	if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		host := addr[1 : len(addr)-1]
+		if net.ParseIP(host) == nil {
+			return "", 0, fmt.Errorf("invalid IPv6 address %q", host)
+		}
+		return host, 0, nil
+	}
+	if !strings.Contains(addr, ":") {
+		return addr, 0, nil // hostname
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.Atoi(portStr)
+	if err != nil || p <= 0 || p > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", portStr)
+	}
+	return host, p, nil
+}
+
+// configureListener applies -listen and -ipv6-only. A port given in -listen
+// replaces -port.
+func configureListener(addr string, v6Only bool) error {
+	host, p, err := parseListenAddr(addr)
+	if err != nil {
+		return err
+	}
+	if p != 0 {
+		*port = p
+	}
+	if v6Only {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+			return fmt.Errorf("-ipv6-only cannot bind the IPv4 address %s", host)
+		}
+		if host == "" {
+			host = "::"
+		}
+		// With tcp6, wildcard listeners set IPV6_V6ONLY instead of accepting
+		// IPv4-mapped connections.
+		listenNetwork = "tcp6"
+	}
+	listenHost = host
+	return nil
+}
+
+// serverBaseContext makes request contexts derive from serverContext, so
+// handlers see a cancellation on shutdown.
+func serverBaseContext(net.Listener) context.Context {
+	return serverContext
+}
+
+// listenTCP binds port on the configured listen address, with TLS, DSCP
+// marking and the congestion control when enabled.
+func listenTCP(port int) (net.Listener, error) {
+	ln, err := listenPlainTCP(port)
+	if err != nil || serverTLSConfig == nil {
+		return ln, err
+	}
+	return tls.NewListener(ln, serverTLSConfig), nil
+}
+
+// listenPlainTCP is listenTCP without TLS, for protocols other than HTTP.
+func listenPlainTCP(port int) (net.Listener, error) {
+	lc := net.ListenConfig{Control: listenControl}
+	ln, err := lc.Listen(context.Background(), listenNetwork, net.JoinHostPort(listenHost, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	if tcpDSCP >= 0 {
+		ln = &dscpListener{Listener: ln, dscp: tcpDSCP}
+	}
+	return ln, nil
+}
+
+// configureWebRTCFamily restricts ICE candidates to one address family (and
+// to the listen address when it is a specific IP). family is "ipv4", "ipv6"
+// or empty to follow the listener: -ipv6-only or an IPv6 listen address
+// means IPv6, an IPv4 listen address means IPv4, otherwise both are used.
+func configureWebRTCFamily(s *webrtc.SettingEngine, family string) error {
+	ip := net.ParseIP(listenHost)
+	if family == "" {
+		switch {
+		case listenNetwork == "tcp6":
+			family = "ipv6"
+		case ip != nil && ip.To4() != nil:
+			family = "ipv4"
+		case ip != nil && !ip.IsUnspecified():
+			family = "ipv6"
+		}
+	}
+	switch family {
+	case "":
+		return nil
+	case "ipv4":
+		s.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeTCP4})
+	case "ipv6":
+		s.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP6, webrtc.NetworkTypeTCP6})
+	default:
+		return fmt.Errorf("unknown WebRTC address family %q (want ipv4 or ipv6)", family)
+	}
+	if ip != nil && !ip.IsUnspecified() && (ip.To4() != nil) == (family == "ipv4") {
+		s.SetIPFilter(func(candidate net.IP) bool { return candidate.Equal(ip) })
+		s.SetIncludeLoopbackCandidate(ip.IsLoopback())
+	}
+	log.Printf("WebRTC candidates restricted to %s", family)
+	return nil
+}
+
+// DiscoveryInfo is written to the discovery file so other tools (or a second
+// launch of the binary) can find the address the server actually bound to.
+type DiscoveryInfo struct {
+	Port      int       `json:"port"`
+	URLs      []string  `json:"urls"`
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// candidatePorts returns the ordered list of ports to try: the configured port,
+// followed by the explicit fallback list, followed by the next N sequential ports.
+func candidatePorts(base int, fallbackCount int, fallbackList string) []int {
+	ports := []int{base}
+	seen := map[int]bool{base: true}
+
+	add := func(p int) {
+		if p <= 0 || p > 65535 || seen[p] {
+			return
+		}
+		seen[p] = true
+		ports = append(ports, p)
+	}
+
+	for _, field := range strings.Split(fallbackList, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		p, err := strconv.Atoi(field)
+		if err != nil {
+			log.Printf("Warning: ignoring invalid fallback port %q", field)
+			continue
+		}
+		add(p)
+	}
+
+	for i := 1; i <= fallbackCount; i++ {
+		add(base + i)
+	}
+	return ports
+}
+
+// listenWithFallback binds the first available port from the candidate list.
+// If no fallback is configured it behaves like a plain net.Listen on the base port.
+func listenWithFallback(base int, fallbackCount int, fallbackList string) (net.Listener, int, error) {
+	var lastErr error
+	for _, p := range candidatePorts(base, fallbackCount, fallbackList) {
+		ln, err := listenTCP(p)
+		if err == nil {
+			if p != base {
+				log.Printf("Port %d unavailable, fell back to port %d", base, p)
+			}
+			return ln, p, nil
+		}
+		if *verbose {
+			log.Printf("Unable to listen on port %d: %v", p, err)
+		}
+		lastErr = err
+	}
+	return nil, 0, lastErr
+}
+
+// localURLs lists the URLs clients can use to reach the server on this host.
+func localURLs(port int) []string {
+	scheme := "http"
+	if serverTLSConfig != nil {
+		scheme = "https"
+	}
+	if ip := net.ParseIP(listenHost); listenHost != "" && (ip == nil || !ip.IsUnspecified()) {
+		return []string{scheme + "://" + net.JoinHostPort(listenHost, strconv.Itoa(port))}
+	}
+	urls := []string{fmt.Sprintf("%s://localhost:%d", scheme, port)}
+	if listenNetwork == "tcp6" {
+		urls[0] = fmt.Sprintf("%s://[::1]:%d", scheme, port)
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return urls
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if listenNetwork == "tcp6" && ipNet.IP.To4() != nil {
+			continue
+		}
+		urls = append(urls, scheme+"://"+net.JoinHostPort(ipNet.IP.String(), strconv.Itoa(port)))
+	}
+	return urls
+}
+
+// announceAddress logs the reachable URLs and writes the discovery file when configured.
+func announceAddress(port int, discoveryPath string) {
+	urls := localURLs(port)
+	log.Println("Speed test available at:")
+	for _, u := range urls {
+		log.Printf("  - %s", u)
+	}
+
+	if discoveryPath == "" {
+		return
+	}
+
+	info := DiscoveryInfo{
+		Port:      port,
+		URLs:      urls,
+		PID:       os.Getpid(),
+		StartedAt: time.Now(),
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode discovery file: %v", err)
+		return
+	}
+	if err := os.WriteFile(discoveryPath, data, 0644); err != nil {
+		log.Printf("Failed to write discovery file %s: %v", discoveryPath, err)
+		return
+	}
+	log.Printf("Wrote discovery file: %s", discoveryPath)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go-netspeed/measure"
+)
+
+// serverBytes counts the bytes all sessions transferred, for the server-wide
+// throughput while a session ran.
+var serverBytes atomic.Int64
+
+// ServerLoad describes what else the server was doing while a result's
+// transfers ran, so results skewed by a busy server can be told apart from
+// problems on the path.
+type ServerLoad struct {
+	ConcurrentSessions int     `json:"concurrentSessions"` // other clients' sessions that overlapped the transfers
+	ServerMbps         float64 `json:"serverMbps"`         // throughput of all sessions during a transfer, at the busiest
+}
+
+// Contended reports whether other clients tested at the same time.
+func (l *ServerLoad) Contended() bool {
+	return l != nil && l.ConcurrentSessions > 0
+}
+
+// overlapLocked records that s started while the running sessions of other
+// clients were active. reg.mu must be held.
+func (reg *sessionRegistry) overlapLocked(s *TestSession) {
+	s.overlapping = make(map[string]bool)
+	s.startBytes = serverBytes.Load()
+	for _, other := range reg.sessions {
+		if other.Client != s.Client {
+			other.overlapping[s.ID] = true
+			s.overlapping[other.ID] = true
+		}
+	}
+}
+
+// Load summarizes the server load during sessions: the distinct sessions of
+// other clients that overlapped any of them, and the highest server-wide
+// throughput while one ran. Nil without sessions.
+func (reg *sessionRegistry) Load(sessions []*TestSession) *ServerLoad {
+	if len(sessions) == 0 {
+		return nil
+	}
+	now := time.Now()
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	load := &ServerLoad{}
+	others := make(map[string]bool)
+	for _, s := range sessions {
+		for id := range s.overlapping {
+			others[id] = true
+		}
+		end, bytes := s.finishedAt, s.endBytes
+		if end.IsZero() {
+			end, bytes = now, serverBytes.Load()
+		}
+		load.ServerMbps = max(load.ServerMbps, measure.Mbps(bytes-s.startBytes, end.Sub(s.StartedAt)))
+	}
+	load.ConcurrentSessions = len(others)
+	return load
+}
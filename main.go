@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,13 +12,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/dgraph-io/badger/v4"
-	"github.com/google/uuid"
 	"github.com/pion/webrtc/v4"
 )
 
@@ -26,22 +29,218 @@ import (
 //go:embed static/*
 var embeddedFiles embed.FS
 
+// static/measure.wasm is the measure package compiled for the web client;
+// wasm_exec.js must come from the Go version that built it, which keeps it in
+// misc/wasm before Go 1.24. Both are build output and not tracked: without
+// them, the web client falls back to JavaScript math.
+//
+//go:generate sh -c "GOOS=js GOARCH=wasm go build -trimpath -ldflags=-s -o static/measure.wasm ./wasm && { cp \"$(go env GOROOT)/lib/wasm/wasm_exec.js\" static/ 2>/dev/null || cp \"$(go env GOROOT)/misc/wasm/wasm_exec.js\" static/; }"
+
 // Define configurable settings using command-line flags
 var (
 	port              = flag.Int("port", 8080, "The port to run the server on.")
-	maxDownloadSize   = flag.Int64("maxsize", 100, "Maximum download size in MB (capped at 100MB).")
+	maxDownloadSize   = flag.Int64("maxsize", 100, "Maximum download size in MB (capped at 1TB).")
+	defaultSize       = flag.Int64("default-size", 10, "Download size in MB when the client does not request one.")
+	minSize           = flag.Int64("min-size", 1, "Minimum download size in MB.")
+	maxDownloadTime   = flag.Duration("max-download-duration", 30*time.Second, "Longest download accepted with /download?duration=, which streams for a time rather than a size (0 to disable).")
+	maxUploadSize     = flag.Int64("max-upload-size", 0, "Maximum /upload body in MB; larger uploads are refused with 413 (0 for unlimited).")
+	maxUploadTime     = flag.Duration("max-upload-duration", 0, "Longest /upload; uploads still sending after it are cut off with 408 (0 for unlimited).")
+	maxSessions       = flag.Int("max-sessions", 0, "Maximum concurrent test sessions on the server; more are refused with 503 (0 for unlimited).")
+	maxClientSessions = flag.Int("max-client-sessions", 0, "Maximum concurrent test sessions per client IP; more are refused with 429 (0 for unlimited).")
+	echoMaxRate       = flag.Int("echo-max-rate", 100, "Maximum probes per second one connection may have echoed on /latency/stream, /ws/ping and the WebRTC data channel; faster probes are dropped (0 for unlimited).")
+	echoMaxMessages   = flag.Int("echo-max-messages", 10000, "Maximum probes echoed per connection on /latency/stream, /ws/ping and the WebRTC data channel (0 for unlimited).")
+	pingInterval      = flag.Duration("ping-interval", 40*time.Millisecond, "Interval between latency and jitter probes that clients are told to use via /api/config.")
+	pingCount         = flag.Int("ping-count", 250, "Number of jitter probes that clients are told to send via /api/config.")
 	downloadChunkSize = flag.Int("chunksize", 1024*1024, "Download chunk size in bytes (default 1MB).")
+	randomData        = flag.String("random-data", "off", "Download payload: off for a repeating pattern, fast for splitmix64 or crypto for ChaCha8 pseudorandom data, new in every chunk so compressing proxies cannot inflate results.")
 	webrtcMinPort     = flag.Int("webrtc-min-port", 0, "Minimum UDP port for WebRTC (0 to disable specific range).")
 	webrtcMaxPort     = flag.Int("webrtc-max-port", 0, "Maximum UDP port for WebRTC (0 to disable specific range).")
 
-	// Badger Storage Flags
-	badgerPath = flag.String("badger-path", "badger_data", "Path for Badger KV store (empty string for in-memory mode).")
+	// Listener Flags
+	portFallback      = flag.Int("port-fallback", 0, "Number of subsequent ports to try if the configured port is busy (0 to disable).")
+	portFallbackList  = flag.String("port-fallback-list", "", "Comma-separated list of alternate ports to try if the configured port is busy.")
+	discoveryFile     = flag.String("discovery-file", "", "Write the chosen listen address as JSON to this file (empty to disable).")
+	relayOrigin       = flag.String("relay-origin", "", "URL of a payload the /relay test fetches and streams to clients, reporting both hop speeds (empty to disable).")
+	dataPortList      = flag.String("data-ports", "", "Comma-separated extra ports serving only the test endpoints; clients spread streams across them.")
+	sriEnabled        = flag.Bool("sri", false, "Add subresource-integrity attributes to index.html pinned to the embedded asset hashes.")
+	frontendDir       = flag.String("frontend-dir", "", "Serve the web UI from this directory instead of the embedded one; no embedded asset is served (empty for the embedded UI).")
+	frontendURL       = flag.String("frontend-url", "", "Proxy the web UI from this URL instead of serving the embedded one, e.g. http://localhost:5173 (empty for the embedded UI).")
+	defaultLocale     = flag.String("locale", "en", "Language of notifications, and of error responses to clients whose Accept-Language has no catalog.")
+	localeDir         = flag.String("locale-dir", "", "Directory of <language>.json translation catalogs merged over the embedded ones (empty for the embedded catalogs only).")
+	geoIPDB           = flag.String("geoip-db", "", "Comma-separated MaxMind DB (.mmdb) files, e.g. GeoLite2 City and ASN, to annotate saved results with the client's country, city, ASN and ISP (empty to disable).")
+	listenAddr        = flag.String("listen", "", "Address to bind, e.g. 192.168.1.10, [::1] or [::]:8080; a port here overrides -port (all interfaces when empty).")
+	ipv6Only          = flag.Bool("ipv6-only", false, "Listen and gather WebRTC candidates on IPv6 only.")
+	webrtcFamily      = flag.String("webrtc-family", "", "Restrict WebRTC candidates to ipv4 or ipv6 (follows -listen and -ipv6-only when empty).")
+	dscpFlag          = flag.String("dscp", "", "DSCP to mark test traffic with, 0-63 or a name such as EF, AF41 or CS1; recorded in each result (empty to disable).")
+	webrtcDSCPFlag    = flag.String("webrtc-dscp", "", "DSCP for WebRTC data-channel packets (follows -dscp when empty).")
+	congestionControl = flag.String("congestion-control", "", "TCP congestion control algorithm for test connections, e.g. bbr or cubic (empty for the system default). Linux only.")
+	serverID          = flag.String("server-id", "", "Identifier of this instance, stamped on every stored result (defaults to the hostname).")
+	serverLabel       = flag.String("server-label", "", "Label stamped on every stored result, e.g. a region such as fra1, to tell instances apart in aggregated data.")
+	publicURL         = flag.String("public-url", "", "Public URL clients use to reach the server, e.g. behind a reverse proxy (derived from requests when empty).")
+	signingKey        = flag.String("signing-key", "", "Sign saved results with this Ed25519 private key (PKCS #8 PEM) or HMAC secret file, for GET /results/{id}?verify=1 (empty to disable).")
+
+	// TLS Flags
+	tlsCert          = flag.String("tls-cert", "", "TLS certificate file; serves HTTPS when set with -tls-key (reloaded when the file changes).")
+	tlsKey           = flag.String("tls-key", "", "TLS private key file.")
+	httpRedirectPort = flag.Int("http-redirect-port", 0, "With TLS, also listen for plain HTTP on this port (e.g. 80) and redirect to HTTPS (0 to disable).")
+	http2            = flag.Bool("http2", false, "With TLS, also offer HTTP/2 for comparing it with HTTP/1.1; parallel test streams then share one connection.")
+	http3Enabled     = flag.Bool("http3", false, "With TLS, also serve HTTP/3 over QUIC on the same UDP ports and advertise it with Alt-Svc, for comparing QUIC with TCP.")
+	certExpiryAlert  = flag.Duration("cert-expiry-alert", 0, "Raise an alert through the notifiers while the TLS certificate expires within this long, e.g. 336h (0 to disable).")
+	acmeWebroot      = flag.String("acme-webroot", "", "Directory to serve /.well-known/acme-challenge/ files from on the HTTP redirect port, for certbot or lego webroot mode.")
+
+	http2MaxStreams   = flag.Uint("http2-max-streams", 250, "With -http2, the concurrent streams a client may open on one connection.")
+	http2StreamWindow = flag.Int("http2-stream-window", 1<<20, "With -http2, the flow-control window of each upload stream in bytes; caps a single-stream upload at one window per round trip.")
+	http2ConnWindow   = flag.Int("http2-conn-window", 1<<20, "With -http2, the flow-control window of each connection in bytes, shared by its upload streams.")
+	http2MaxFrameSize = flag.Int("http2-max-frame-size", 1<<20, "With -http2, the largest frame the server accepts in bytes, from 16384 to 16777215.")
+
+	// LAN Discovery Flags
+	mdnsEnabled     = flag.Bool("mdns", false, "Advertise the server on the local network via mDNS/Bonjour.")
+	mdnsName        = flag.String("mdns-name", "", "mDNS service instance name (defaults to 'Go Netspeed on <hostname>').")
+	portMappingMode = flag.String("port-mapping", "", "Request router port mappings for remote testers: auto, natpmp or upnp (empty to disable).")
+	natGateway      = flag.String("nat-gateway", "", "Gateway address for NAT-PMP (auto-detected on Linux when empty).")
+
+	// Probe Flags
+	probeTargets   = flag.String("probe-targets", "", "Comma-separated URLs the server probes for latency and throughput on a schedule (empty to disable).")
+	probeInterval  = flag.Duration("probe-interval", 15*time.Minute, "How often to probe the -probe-targets.")
+	scheduleFile   = flag.String("schedule-file", "", "JSON file of test schedules and blackout windows, editable through /admin/api/schedules (replaces -probe-targets).")
+	recurringTests = flag.Bool("recurring-tests", false, "Let browsers register recurring tests of their own connection at /api/recurring, run with the returned token, e.g. from a service worker.")
+	myResults      = flag.Bool("my-results", false, "Give browsers that save results an anonymous owner cookie and list their own results at /api/my/results.")
+	probeInterface = flag.String("probe-interface", "", "Network interface that probes and peer tests connect through, e.g. a second uplink (Linux only; empty for the default route).")
+	probeSource    = flag.String("probe-source", "", "Source address for probes and peer tests (empty to let the system choose).")
+	probeFwmark    = flag.Int("probe-fwmark", 0, "Firewall mark (SO_MARK) set on probe and peer test sockets, for policy routing (Linux only; 0 to disable).")
+
+	// Report Flags
+	reportTimeZone = flag.String("report-timezone", "UTC", "IANA time zone that daily and weekly reports are bucketed in, e.g. Europe/Berlin.")
+
+	// Notification Flags
+	webhookURL      = flag.String("webhook-url", "", "Comma-separated URLs to POST each saved result to (empty to disable).")
+	webhookFormat   = flag.String("webhook-format", "json", "Webhook payload preset: json, slack or ntfy.")
+	webhookTemplate = flag.String("webhook-template", "", "Path to a Go text/template file for the webhook payload, overriding the preset body.")
+	webhookSecret   = flag.String("webhook-secret", "", "Secret to sign webhook payloads with HMAC-SHA256 in the X-Netspeed-Signature header (empty to send them unsigned).")
+	webhookRetries  = flag.Int("webhook-retries", 3, "How often a failed webhook delivery is retried, with exponential backoff.")
+	ntfyServer      = flag.String("ntfy-url", "https://ntfy.sh", "ntfy server to publish results to when -ntfy-topic is set.")
+	ntfyTopic       = flag.String("ntfy-topic", "", "ntfy topic to publish each saved result to (empty to disable).")
+	ntfyToken       = flag.String("ntfy-token", "", "Access token for protected ntfy topics.")
+	gotifyServer    = flag.String("gotify-url", "", "Gotify server URL to send each saved result to (empty to disable).")
+	gotifyToken     = flag.String("gotify-token", "", "Gotify application token.")
+	syslogAddr      = flag.String("syslog-addr", "", "Syslog collector to send an RFC 5424 message per saved result to, e.g. udp://host:514 or tls://host:6514 (empty to disable).")
+	syslogFacility  = flag.String("syslog-facility", "local0", "Syslog facility: user, daemon or local0 to local7.")
+
+	notifyProxy  = flag.String("notify-proxy", "", "Proxy for webhooks, ntfy, Gotify, syslog and MQTT, e.g. http://proxy:3128 or socks5://proxy:1080, or direct to ignore HTTP_PROXY and HTTPS_PROXY (empty to use them).")
+	webhookProxy = flag.String("webhook-proxy", "", "Proxy for -webhook-url and -alert-escalation-url, overriding -notify-proxy.")
+	ntfyProxy    = flag.String("ntfy-proxy", "", "Proxy for ntfy, overriding -notify-proxy.")
+	gotifyProxy  = flag.String("gotify-proxy", "", "Proxy for Gotify, overriding -notify-proxy.")
+	syslogProxy  = flag.String("syslog-proxy", "", "Proxy for syslog over tcp:// or tls://, overriding -notify-proxy.")
+
+	// Alert Flags
+	alertRules         = flag.String("alert-rules", "", "Comma-separated thresholds that raise alerts through the notifiers, e.g. download<50,latency>100,loss>1 (empty to disable).")
+	alertDedupe        = flag.Duration("alert-dedupe", time.Hour, "An alert that fires again this soon after its last notification is not announced again.")
+	alertEscalateAfter = flag.Duration("alert-escalate-after", 0, "Escalate alerts still firing and unacknowledged this long after they fired to -alert-escalation-url (0 to disable).")
+	alertEscalationURL = flag.String("alert-escalation-url", "", "Webhook URL that receives escalated alerts, in -webhook-format.")
+
+	// MQTT Flags
+	mqttBroker          = flag.String("mqtt-broker", "", "MQTT broker to publish results to, e.g. tcp://broker:1883 or mqtts://broker (empty to disable).")
+	mqttUsername        = flag.String("mqtt-username", "", "MQTT username.")
+	mqttPassword        = flag.String("mqtt-password", "", "MQTT password.")
+	mqttTopic           = flag.String("mqtt-topic", "netspeed", "Topic prefix; results go to <prefix>/<host>/result.")
+	mqttDiscovery       = flag.Bool("mqtt-discovery", true, "Publish Home Assistant MQTT discovery messages for the result sensors.")
+	mqttDiscoveryPrefix = flag.String("mqtt-discovery-prefix", "homeassistant", "Home Assistant discovery topic prefix.")
+	mqttProxy           = flag.String("mqtt-proxy", "", "Proxy for the MQTT broker, overriding -notify-proxy.")
+
+	// Admin Flags
+	adminToken          = flag.String("admin-token", "", "Bearer token for the /admin console and APIs (empty to disable).")
+	deleteRequiresAdmin = flag.Bool("delete-requires-admin", false, "Only allow DELETE /results/{id} with the admin token; otherwise, with -my-results, the browser that saved a result may delete it too.")
+
+	// Privacy Flags
+	privacyLevel        = flag.String("privacy", "off", "Privacy preset for -anonymize-ip, -client-metadata, -log-client-ips and -result-ttl: strict, balanced or off; flags given explicitly override it.")
+	anonymizeIP         = flag.String("anonymize-ip", "none", "How client addresses are stored with results and recordings: none, truncate to the /24 (IPv4) or /48 (IPv6) network, or drop.")
+	storeClientMetadata = flag.Bool("client-metadata", true, "Store the client's user agent, and its GeoIP city and network, with each result; otherwise only the GeoIP country.")
+	logClientIPs        = flag.Bool("log-client-ips", true, "Log full client addresses; otherwise they are logged truncated to their network.")
+	consentFile         = flag.String("consent-file", "", "Text file of terms or a privacy notice clients must accept before testing; served in /api/config (empty to disable).")
+	consentVersion      = flag.String("consent-version", "", "Version of the -consent-file terms recorded with results (derived from the text when empty).")
+
+	// Access Flags
+	accessAllow     = flag.String("access-allow", "", "Comma-separated clients allowed to use the server: lan, country codes such as DE, ASNs such as AS3320, addresses or CIDR ranges; others get an explanation page (empty to allow all).")
+	accessDeny      = flag.String("access-deny", "", "Comma-separated clients refused, in the syntax of -access-allow; takes precedence over -access-allow.")
+	accessLimit     = flag.String("access-limit", "", "Comma-separated clients whose test sessions are limited to -access-limit-rate, in the syntax of -access-allow.")
+	accessLimitRate = flag.Int("access-limit-rate", 30, "Test sessions per hour each client matching -access-limit may start.")
+	accessMessage   = flag.String("access-message", "", "Explanation shown to refused clients, e.g. who the server is for (a generic text when empty).")
+
+	blocklistURL     = flag.String("blocklist-url", "", "URL or file of addresses and CIDR ranges to refuse, one per line, e.g. a list of known abuse ranges (empty to disable).")
+	blocklistRefresh = flag.Duration("blocklist-refresh", time.Hour, "How often -blocklist-url is fetched again.")
+
+	// Clock Flags
+	ntpServers  = flag.String("ntp-servers", "", "Comma-separated NTP servers to check the system clock against at startup and every -ntp-interval, e.g. pool.ntp.org,time.cloudflare.com (empty to disable).")
+	ntpInterval = flag.Duration("ntp-interval", time.Hour, "How often the system clock is checked against -ntp-servers.")
+	ntpMaxSkew  = flag.Duration("ntp-max-skew", 100*time.Millisecond, "Clock skew beyond which the server warns and tags results with clock-skew.")
+
+	// Network Ping Flags
+	netPing = flag.Bool("net-ping", false, "Let clients opt in to being pinged with ICMP echoes at /api/netping, measuring latency without HTTP overhead; needs CAP_NET_RAW or net.ipv4.ping_group_range.")
+
+	// Compatibility Flags
+	librespeedCompat = flag.Bool("librespeed-compat", false, "Serve the LibreSpeed backend endpoints garbage.php, empty.php and getIP.php, also under /backend/, for LibreSpeed frontends and librespeed-cli.")
+	iperfPort        = flag.Int("iperf-port", 0, "Also serve iperf3 clients on this TCP and UDP port, e.g. 5201, for TCP and UDP tests with iperf3 -c (0 to disable).")
+	iperfMaxDuration = flag.Duration("iperf-max-duration", time.Minute, "Longest iperf3 test accepted, including --omit; tests sized with -n or -k are stopped after this long.")
+
+	// Capture Flags
+	captureDir         = flag.String("capture-dir", "", "Directory admin-triggered packet captures are written to (empty to disable).")
+	captureInterface   = flag.String("capture-interface", "", "Network interface packet captures listen on, e.g. eth0 (Linux only).")
+	captureMaxBytes    = flag.Int64("capture-max-bytes", 50*1024*1024, "Maximum size of one packet capture file in bytes.")
+	captureMaxDuration = flag.Duration("capture-max-duration", 2*time.Minute, "Maximum duration of one packet capture.")
+
+	// Storage Flags
+	storeType         = flag.String("store", "badger", "Result store: badger, sqlite for a database that standard SQL tools can query, postgres to share results between instances, redis, or memory for a ring buffer of recent results.")
+	badgerPath        = flag.String("badger-path", "badger_data", "Path for Badger KV store (empty string for in-memory mode).")
+	badgerKeyFile     = flag.String("badger-encryption-key-file", "", "File holding a 16, 24 or 32 byte AES key, raw or hex-encoded, to encrypt the Badger store with (empty to store unencrypted).")
+	badgerKeyRotation = flag.Duration("badger-key-rotation", 10*24*time.Hour, "How often Badger replaces the data key it encrypts new data with.")
+	badgerOldKeyFile  = flag.String("badger-old-encryption-key-file", "", "Encryption key file the rotate-badger-key command replaces with -badger-encryption-key-file (empty when the store is unencrypted).")
+	storeBackupDir    = flag.String("store-backup-dir", "", "Directory POST /admin/store/backup writes Badger backups to (empty to disable).")
+	maxResults        = flag.Int("max-results", 0, "Maximum number of stored results; the oldest are evicted first (0 for unlimited).")
+	maxStoreBytes     = flag.Int64("max-store-bytes", 0, "Maximum total size of stored results in bytes; the oldest are evicted first (0 for unlimited).")
+	resultTTL         = flag.Duration("result-ttl", 0, "Delete results this long after they were saved, e.g. 2160h for 90 days (0 to keep them forever).")
+
+	// SQLite Storage Flags
+	sqlitePath = flag.String("sqlite-path", "netspeed.db", "Path of the SQLite database file used with -store sqlite.")
+
+	// PostgreSQL Storage Flags
+	postgresDSN      = flag.String("dsn", "", "PostgreSQL connection string used with -store postgres, e.g. postgres://netspeed:secret@db/netspeed?sslmode=require.")
+	postgresMaxConns = flag.Int("db-max-conns", 10, "Maximum number of open PostgreSQL connections per instance.")
+
+	// Redis Storage Flags
+	redisURL    = flag.String("redis-url", "redis://localhost:6379/0", "Redis server used with -store redis, e.g. redis://:secret@cache:6379/2.")
+	redisPrefix = flag.String("redis-prefix", "netspeed:", "Prefix of every key the redis store writes, to share a Redis database with other applications.")
+
+	// Memory Storage Flags
+	memoryCapacity = flag.Int("memory-capacity", 1000, "Number of results kept with -store memory; each save beyond it overwrites the oldest.")
+
+	// Archive Flags
+	archiveBucket    = flag.String("archive-s3-bucket", "", "Periodically export new results as gzip-compressed JSON lines to this S3-compatible bucket (empty to disable).")
+	archiveEndpoint  = flag.String("archive-s3-endpoint", "https://s3.amazonaws.com", "Object storage endpoint; requests use path-style URLs, e.g. https://minio.example.com:9000.")
+	archiveRegion    = flag.String("archive-s3-region", "us-east-1", "Region the archive requests are signed for.")
+	archivePrefix    = flag.String("archive-s3-prefix", "netspeed/", "Key prefix of the archived objects and the archive state; must end with a slash.")
+	archiveAccessKey = flag.String("archive-s3-access-key", "", "Access key ID for the archive bucket (defaults to AWS_ACCESS_KEY_ID).")
+	archiveSecretKey = flag.String("archive-s3-secret-key", "", "Secret access key for the archive bucket (defaults to AWS_SECRET_ACCESS_KEY).")
+	archiveInterval  = flag.Duration("archive-interval", time.Hour, "How often new results are archived.")
+
+	// Demo Flags
+	demoMode = flag.Bool("demo", false, "Synthesize plausible test results and timings without moving real data, for UI development and offline demos.")
+
+	// Recording Flags
+	recordDir = flag.String("record-dir", "", "Record each client's test API interactions (timings and sizes, no payloads) as JSON files in this directory, for the replay command (empty to disable).")
+
+	// Command Flags
+	peerTarget        = flag.String("target", "", "Base URL of another netspeed instance for the peer-test, replay, test and congestion-test commands.")
+	congestionCompare = flag.String("congestion-controls", "", "Comma-separated congestion control algorithms the congestion-test command compares (empty for all the target permits).")
+	congestionRounds  = flag.Int("congestion-rounds", 3, "Downloads per algorithm in the congestion-test command.")
 
 	verbose = flag.Bool("verbose", false, "Enable verbose logs for files being served and connections")
 )
 
 const (
-	globalMaxDownloadSizeMB = 1024
+	webrtcSessionTimeout    = 2 * time.Minute  // a jitter test takes about ten seconds
+	shutdownTimeout         = 10 * time.Second // grace period for in-flight requests
+	globalMaxDownloadSizeMB = 1024 * 1024
+	minDownloadChunkSize    = 4096     // Smallest chunk a client may request via ?chunk=
 	localOverrideDir        = "static" // Directory to check for local overrides
 	embeddedPrefix          = "static" // Prefix under which files are embedded
 )
@@ -57,266 +256,498 @@ var (
 	}
 	webrtcAPI   *webrtc.API
 	globalStore ResultStore
+
+	// serverContext is canceled on SIGINT/SIGTERM. Requests and WebRTC
+	// sessions derive from it, so in-flight tests stop on shutdown.
+	serverContext = context.Background()
 )
 
 // TestResult mirrors the data structure sent by the client after a full test run.
 type TestResult struct {
+	ID                string    `json:"id,omitempty"`
 	Timestamp         time.Time `json:"timestamp"`
 	DownloadSpeedMbps float64   `json:"downloadSpeedMbps"`
 	UploadSpeedMbps   float64   `json:"uploadSpeedMbps"`
 	LatencyMs         float64   `json:"latencyMs"`
 	JitterMs          float64   `json:"jitterMs"`
 	PacketLossPercent float64   `json:"packetLossPercent"`
+
+	// Server-derived and client-supplied annotations
+	ClientIP string            `json:"clientIp,omitempty"`
+	ClientID string            `json:"clientId,omitempty"` // persistent device identifier sent by the client, for /history; see publicResult
+	Client   *ClientMetadata   `json:"client,omitempty"`
+	Geo      *GeoInfo          `json:"geo,omitempty"` // from -geoip-db
+	Tags     map[string]string `json:"tags,omitempty"`
+	Verified bool              `json:"verified,omitempty"` // the client ran a test against this server before saving
+
+	// WebRTC session timeline, attached when the client reports its session ID
+	WebRTCSessionID string         `json:"webrtcSessionId,omitempty"`
+	WebRTCLog       []SessionEvent `json:"webrtcLog,omitempty"`
+
+	// Measurement metadata, stamped when the result is saved
+	Server      *ServerIdentity   `json:"server,omitempty"`
+	Methodology map[string]string `json:"methodology,omitempty"` // metric -> method identifier
+	QoS         *QoSMarking       `json:"qos,omitempty"`         // DSCP the server marked its test traffic with
+
+	// Kernel connection state and server load of the download and upload
+	// sessions the client reports; the IDs are replaced by them when the
+	// result is saved
+	TCPSessionIDs []string    `json:"tcpSessionIds,omitempty"`
+	TCPInfo       []TCPInfo   `json:"tcpInfo,omitempty"`
+	Load          *ServerLoad `json:"load,omitempty"`
+
+	// Raw per-second throughput and per-ping round trips, when the client sends them
+	Samples *ResultSamples `json:"samples,omitempty"`
+
+	// Network-layer round trips of the client's -net-ping ping; the ID is
+	// replaced by it when the result is saved
+	NetPingID string         `json:"netPingId,omitempty"`
+	NetPing   *NetPingResult `json:"netPing,omitempty"`
 }
 
 // ResultStore defines the interface for saving and loading test results.
+// Saved results are immutable; see AmendmentStore for changes made later.
 type ResultStore interface {
 	Save(result TestResult) (string, error)
+	Import(result TestResult) (string, error) // keeps the result's ID and timestamp; errResultExists if the ID is taken
 	Load(id string) (TestResult, error)
+	Iterate(fn func(result TestResult) error) error
+	IterateMatching(filter ResultFilter, fn func(result TestResult) error) error // newest first
+	Query(filter ResultFilter, offset, limit int) ([]TestResult, int, error)
+	List(filter ResultFilter, cursor string, limit int) ([]TestResult, string, error) // newest first; returns the next page's cursor
+	Delete(id string) error
 	Close() error
 }
 
-// BadgerStore implements ResultStore using the Badger Key-Value database.
-type BadgerStore struct {
-	db *badger.DB
-}
+// --- API Handlers ---
+const maxRequestSize = 1024 * 1024
 
-// NewBadgerStore initializes and returns a BadgerStore instance.
-func NewBadgerStore(path string) (*BadgerStore, error) {
-	opts := badger.DefaultOptions(path)
+// saveResultHandler receives JSON results from the client, saves them, and returns the unique ID.
+func saveResultHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
 
-	// If path is empty, set Badger to run entirely in-memory.
-	if path == "" {
-		opts = opts.WithInMemory(true)
-		log.Println("Badger configured for IN-MEMORY storage (data will be lost on exit).")
-	} else {
-		// Ensure the directory exists for file storage
-		if err := os.MkdirAll(path, 0755); err != nil {
-			return nil, fmt.Errorf("failed to create badger directory: %w", err)
-		}
-		log.Printf("Badger configured for FILE storage at: %s", path)
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
 	}
 
-	db, err := badger.Open(opts)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open badger db: %w", err)
+	var result TestResult
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		log.Printf("Failed to decode test result: %v", err)
+		http.Error(w, tr(r, "Invalid JSON result format"), http.StatusBadRequest)
+		return
 	}
 
-	return &BadgerStore{db: db}, nil
-}
+	if err := validateResultSamples(result.Samples); err != nil {
+		badRequest(w, err)
+		return
+	}
 
-// Save generates a unique ID, saves the result, and returns the ID.
-func (s *BadgerStore) Save(result TestResult) (string, error) {
-	id := uuid.New().String()
+	// Fill in server-derived fields; clients cannot set them.
+	result.ClientIP = requestClientIP(r)
+	result.Client = clientMetadata(r)
+	result.Geo = lookupGeo(result.ClientIP)
+	result.Tags = sanitizeTags(result.Tags)
+	if !validClientID(result.ClientID) {
+		result.ClientID = ""
+	}
+	if !checkConsent(w, r) {
+		return
+	}
+	// Only the terms this server checked, never a version the client claims
+	delete(result.Tags, "consent")
+	if consentTerms != nil {
+		if result.Tags == nil {
+			result.Tags = map[string]string{}
+		}
+		result.Tags["consent"] = consentTerms.Version
+	}
+	recurring, ok := recurringRun(w, r, &result)
+	if !ok {
+		return
+	}
+	if *demoMode {
+		if result.Tags == nil {
+			result.Tags = map[string]string{}
+		}
+		result.Tags["source"] = "demo"
+		result.Methodology = simulatedMethodology()
+	}
+	result.Verified = activeSessions.RecentlyTested(result.ClientIP)
+	stampMeasurement(&result)
+	result.WebRTCLog = nil
+	if l, ok := webrtcLogs.Get(result.WebRTCSessionID); ok && l.Client == result.ClientIP {
+		result.WebRTCLog = l.Snapshot()
+	} else {
+		result.WebRTCSessionID = ""
+	}
+	sessions := activeSessions.Reported(result.ClientIP, result.TCPSessionIDs)
+	result.TCPSessionIDs = nil
+	attachTCPInfo(&result, sessions)
+	attachProtocol(&result, sessions)
+	attachClockSkew(&result)
+	attachNetPing(&result)
+	result.Load = activeSessions.Load(sessions)
+	anonymizeResult(&result)
 
-	result.Timestamp = time.Now() // Use server time for official record
-	data, err := json.Marshal(result)
+	id, err := globalStore.Save(result)
 	if err != nil {
-		return "", err
+		log.Printf("Failed to save result: %v", err)
+		if recurring != nil {
+			cancelRecurringRun(recurring)
+		}
+		http.Error(w, tr(r, "Failed to save result"), http.StatusInternalServerError)
+		return
 	}
 
-	err = s.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(id), data)
-	})
+	response := map[string]string{"status": "success", "id": id}
+	if signer != nil {
+		// The signature covers the stored copy, so a result that cannot be
+		// signed is removed again rather than kept unsigned.
+		signature, err := signStoredResult(id)
+		if err != nil {
+			log.Printf("Failed to sign result %s: %v", id, err)
+			if err := globalStore.Delete(id); err != nil {
+				log.Printf("Failed to delete unsigned result %s: %v", id, err)
+			}
+			if recurring != nil {
+				cancelRecurringRun(recurring)
+			}
+			http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+			return
+		}
+		response["signature"] = signature.Value
+	}
 
-	if err == nil {
-		log.Printf("Result saved with ID: %s", id)
+	result.ID = id
+	result.Timestamp = time.Now() // the store stamps its copy with server time
+	if recurring != nil {
+		recordRecurringRun(recurring.ID, id, result.Timestamp)
 	}
-	return id, err
-}
+	claimResult(w, r, id)
+	// Only the configured URL, since a client could point a link taken from
+	// its Host or X-Forwarded-Host header at any site
+	notifyResult(result, shareURL(*publicURL, id))
 
-// Load retrieves a result by its unique ID.
-func (s *BadgerStore) Load(id string) (TestResult, error) {
-	var result TestResult
-	err := s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(id))
-		if err != nil {
-			return err // badger.ErrKeyNotFound or other errors
-		}
+	writeJSON(w, response)
+}
 
-		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &result)
-		})
-	})
+// resultHandler serves GET and DELETE on /results/{id}, and the result's
+// amendments on /results/{id}/amendments. Deleting requires the admin token,
+// or unless -delete-requires-admin the owner cookie the result was saved
+// with; knowing the ID is not enough, since share links contain it.
+func resultHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the ID from the path (e.g., /results/123-abc)
+	id := strings.TrimPrefix(r.URL.Path, "/results/")
+	if resultID, ok := strings.CutSuffix(id, "/amendments"); ok && resultID != "" {
+		amendmentsHandler(w, r, resultID)
+		return
+	}
+	if resultID, ok := strings.CutSuffix(id, "/aliases"); ok && resultID != "" {
+		aliasesHandler(w, r, resultID)
+		return
+	}
+	if id == "" {
+		http.Error(w, tr(r, "Missing result ID"), http.StatusBadRequest)
+		return
+	}
 
-	if err == badger.ErrKeyNotFound {
-		return TestResult{}, fmt.Errorf("result not found for ID: %s", id)
+	switch r.Method {
+	case http.MethodGet:
+		loadResultHandler(w, r, id)
+	case http.MethodDelete:
+		if !*deleteRequiresAdmin && ownsResult(r, id) {
+			deleteResultHandler(w, r, id)
+		} else {
+			requireAdmin(func(w http.ResponseWriter, r *http.Request) { deleteResultHandler(w, r, id) })(w, r)
+		}
+	default:
+		http.Error(w, tr(r, "Only GET and DELETE methods are supported"), http.StatusMethodNotAllowed)
 	}
-	return result, err
 }
 
-// Close ensures the database connection is closed.
-func (s *BadgerStore) Close() error {
-	return s.db.Close()
+// resultError answers a failed lookup of a result by ID.
+func resultError(w http.ResponseWriter, r *http.Request, id string, err error) {
+	if strings.Contains(err.Error(), "result not found") {
+		http.Error(w, tr(r, "Result not found"), http.StatusNotFound)
+	} else {
+		log.Printf("Error accessing result ID %s: %v", id, err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+	}
 }
 
-// --- API Handlers ---
-const maxRequestSize = 1024 * 1024
-
-// saveResultHandler receives JSON results from the client, saves them, and returns the unique ID.
-func saveResultHandler(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
+// loadResultHandler retrieves a result by ID, along with its amendments, and
+// with ?verify=1 its signature.
+func loadResultHandler(w http.ResponseWriter, r *http.Request, id string) {
+	p := newParamReader(r.URL.Query())
+	verify := p.Enum("verify", "0", "0", "1") == "1"
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
 		return
 	}
-
-	var result TestResult
-	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
-		log.Printf("Failed to decode test result: %v", err)
-		http.Error(w, "Invalid JSON result format", http.StatusBadRequest)
+	if verify && signer == nil {
+		http.Error(w, tr(r, "Result signing is not enabled on this server"), http.StatusNotFound)
 		return
 	}
 
-	id, err := globalStore.Save(result)
+	result, err := globalStore.Load(id)
 	if err != nil {
-		log.Printf("Failed to save result: %v", err)
-		http.Error(w, "Failed to save result", http.StatusInternalServerError)
+		resultError(w, r, id, err)
 		return
 	}
+	response := amendedResult{TestResult: publicResult(result)}
+	if verify {
+		store, _ := optionalStore[SignatureStore]()
+		signature, err := store.Signature(id)
+		if errors.Is(err, errSignatureNotFound) {
+			http.Error(w, tr(r, "This result was not signed by this server"), http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Failed to load the signature of result %s: %v", id, err)
+			http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+			return
+		}
+		response.Signature = &signature
+	}
+	if store, ok := optionalStore[AmendmentStore](); ok {
+		if response.Amendments, err = store.Amendments(id); err != nil {
+			log.Printf("Error loading amendments of result ID %s: %v", id, err)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status": "success", "id": "%s"}`, id)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to encode result: %v", err)
+	}
 }
 
-// loadResultHandler retrieves a result by ID from the URL path (/results/{id}).
-func loadResultHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Only GET method is supported", http.StatusMethodNotAllowed)
+// deleteResultHandler removes a result and its amendments by ID.
+func deleteResultHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if err := globalStore.Delete(id); err != nil {
+		resultError(w, r, id, err)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	// Extract the ID from the path (e.g., /results/123-abc)
-	id := strings.TrimPrefix(r.URL.Path, "/results/")
-	if id == "" {
-		http.Error(w, "Missing result ID", http.StatusBadRequest)
+// listResultsHandler serves GET /results: stored results newest first, paged
+// with ?limit= and the opaque ?cursor= returned as nextCursor. It lists every
+// client's results, so it is only available with the admin token.
+func listResultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
 		return
 	}
-
-	result, err := globalStore.Load(id)
+	req, err := parseListRequest(r.URL.Query())
 	if err != nil {
-		if strings.Contains(err.Error(), "result not found") {
-			http.Error(w, "Result not found", http.StatusNotFound)
-		} else {
-			log.Printf("Error loading result ID %s: %v", id, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+		badRequest(w, err)
+		return
+	}
+	filter, err := parseResultFilter(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(result); err != nil {
-		log.Printf("Failed to encode result: %v", err)
+	page, next, err := globalStore.List(filter, req.Cursor, req.Limit)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid cursor") {
+			badRequest(w, &paramError{Param: "cursor", Reason: "not a cursor returned by this server"})
+			return
+		}
+		log.Printf("Failed to list results: %v", err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
 	}
+	writeJSON(w, map[string]any{"results": page, "nextCursor": next})
 }
 
 // --- Handlers for Network Tests ---
 
 // latencyHandler returns the current time in milliseconds for RTT calculation.
+// It does not allocate; see latency_fast.go.
 func latencyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header()["Content-Type"] = latencyContentType
 	w.WriteHeader(http.StatusOK)
 	// We return the server's time for the client to calculate RTT
-	fmt.Fprintf(w, "%d", time.Now().UnixMilli())
+	buf := latencyBuffers.Get().(*[20]byte)
+	w.Write(strconv.AppendInt(buf[:0], serverUnixMilli(), 10))
+	latencyBuffers.Put(buf)
 }
 
 // downloadHandler streams a large amount of random data for speed testing.
 func downloadHandler(w http.ResponseWriter, r *http.Request) {
-	// 1. Get requested size (in MB)
-	sizeParam := r.URL.Query().Get("size")
-	requestedSizeMB, err := strconv.ParseInt(sizeParam, 10, 64)
-	if err != nil || requestedSizeMB <= 0 {
-		requestedSizeMB = 10 // Default to 10MB if not specified or invalid
+	// 1. Parse the requested size (in MB) and chunk size; clients may request a smaller chunk with ?chunk=
+	req, err := parseDownloadRequest(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
 	}
+	totalSize, chunkSize := req.SizeBytes, req.ChunkSize
 
-	// 2. Enforce maximum size cap from flags
-	if requestedSizeMB > globalMaxDownloadSizeMB {
-		requestedSizeMB = globalMaxDownloadSizeMB
-	}
-	// Use the configurable flag value
-	if requestedSizeMB > *maxDownloadSize {
-		requestedSizeMB = *maxDownloadSize
+	// Switched before the session starts and restored after it finishes, so
+	// the session's connection state records the algorithm
+	if conn := requestTCPConn(r); req.Congestion != "" && conn != nil {
+		previous, err := setConnCongestion(conn, req.Congestion)
+		if err != nil {
+			log.Printf("Failed to set congestion control %s: %v", req.Congestion, err)
+			http.Error(w, tr(r, "Failed to set congestion control"), http.StatusInternalServerError)
+			return
+		}
+		defer setConnCongestion(conn, previous)
 	}
 
-	totalSize := requestedSizeMB * 1024 * 1024
-
-	// Ensure a minimum size for meaningful test
-	if totalSize < 1024*1024 {
-		totalSize = 1024 * 1024
+	session, ok := startSession(w, "download", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+	session.SetChunkSize(chunkSize)
+	w.Header().Set("X-Session-ID", session.ID) // progress is streamed at /sessions/{id}/samples
+	if prewarmed.Used("download", r) && *verbose {
+		log.Printf("Download reused prewarmed connection %s", loggedAddr(r.RemoteAddr))
 	}
 
-	// 3. Set response headers
+	// 4. Set response headers; a timed download has no length up front
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", strconv.FormatInt(totalSize, 10))
-
-	// 4. Stream data in defined chunks
-	chunkSize := int64(*downloadChunkSize)
-	if chunkSize <= 0 {
-		chunkSize = 1024 * 1024 // Fallback 1MB
+	if req.Duration == 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(totalSize, 10))
 	}
+	w.Header().Set("X-Chunk-Size", strconv.FormatInt(chunkSize, 10))
 
-	// Create a chunk of repeated data to reuse
-	chunk := make([]byte, chunkSize)
-	for i := range chunk {
-		chunk[i] = byte(i % 256)
-	}
+	// 5. Stream data in defined chunks
+	streamDownload(w, r, session, req)
+}
+
+// streamDownload writes the download req asks for to w in chunks, counting
+// the bytes against session.
+func streamDownload(w http.ResponseWriter, r *http.Request, session *TestSession, req DownloadRequest) {
+	totalSize, chunkSize := req.SizeBytes, req.ChunkSize
+	session.SetDownloadGoal(req.SizeBytes, req.Duration)
+	// Recorded as aborted or cut off unless it completes
+	defer session.EndDownload(false)
+	payload := newDownloadPayload(newPayloadGenerator(), chunkSize)
 
 	var sentBytes int64
-	for sentBytes < totalSize {
+	deadline := time.Now().Add(req.Duration)
+	for {
+		if req.Duration > 0 {
+			if !time.Now().Before(deadline) {
+				break
+			}
+		} else if sentBytes >= totalSize {
+			break
+		}
+		// Stop as soon as the client disconnects or the server shuts down,
+		// rather than waiting for the next write to fail
+		if err := r.Context().Err(); err != nil {
+			if *verbose {
+				log.Printf("Download canceled after %d bytes: %v", sentBytes, err)
+			}
+			return
+		}
 		bytesToWrite := chunkSize
-		if totalSize-sentBytes < chunkSize {
+		if req.Duration == 0 && totalSize-sentBytes < chunkSize {
 			bytesToWrite = totalSize - sentBytes
 		}
 
-		if _, err := w.Write(chunk[:bytesToWrite]); err != nil {
+		if _, err := w.Write(payload.At(sentBytes, bytesToWrite)); err != nil {
 			log.Printf("Download write error: %v", err)
 			return
 		}
 		sentBytes += bytesToWrite
+		session.AddBytes(bytesToWrite)
 
 		// Flush the buffer to ensure immediate transmission
 		if f, ok := w.(http.Flusher); ok {
 			f.Flush()
 		}
 	}
+	session.EndDownload(true)
 	if *verbose {
 		log.Printf("Download stream finished. Total bytes sent: %d", sentBytes)
 	}
-
 }
 
 // uploadHandler reads all incoming data and discards it, used for measuring upload speed.
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is supported", http.StatusMethodNotAllowed)
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := parseUploadRequest(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if limit := *maxUploadSize * 1024 * 1024; limit > 0 && r.ContentLength > limit {
+		refuseUpload(w, r, errUploadTooLarge)
 		return
 	}
 
-	uploadedBytes, err := io.Copy(io.Discard, r.Body)
+	session, ok := startSession(w, "upload", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+	w.Header().Set("X-Session-ID", session.ID)
+	if prewarmed.Used("upload", r) && *verbose {
+		log.Printf("Upload reused prewarmed connection %s", loggedAddr(r.RemoteAddr))
+	}
+
+	if req.Echo {
+		uploadedBytes, err := echoUpload(w, r, session)
+		if err != nil && r.Context().Err() == nil {
+			log.Printf("Upload failed to read body: %v", err)
+		} else if *verbose {
+			log.Printf("Echoing upload finished after %d bytes: %v", uploadedBytes, err)
+		}
+		return
+	}
+
+	// The session reader counts bytes as they arrive and publishes progress
+	// samples to /sessions/{id}/samples while the upload is running.
+	body := newUploadReader(w, r, session)
+	uploadedBytes, err := io.Copy(io.Discard, body)
+	// A read deadline cancels the request context too, so the limits are
+	// checked first.
+	if errors.Is(err, errUploadTooLarge) || errors.Is(err, errUploadTooLong) {
+		if *verbose {
+			log.Printf("Upload stopped after %d bytes: %v", uploadedBytes, err)
+		}
+		refuseUpload(w, r, err)
+		return
+	}
+	if r.Context().Err() != nil {
+		if *verbose {
+			log.Printf("Upload canceled after %d bytes: %v", uploadedBytes, r.Context().Err())
+		}
+		return
+	}
 	if err != nil {
 		log.Printf("Upload failed to read body: %v", err)
-		http.Error(w, "Upload failed to read body", http.StatusInternalServerError)
+		http.Error(w, tr(r, "Upload failed to read body"), http.StatusInternalServerError)
 		return
 	}
 	if *verbose {
 		log.Printf("Upload finished. Total bytes received: %d", uploadedBytes)
 	}
 
-	w.WriteHeader(http.StatusOK)
+	writeUploadReport(w, session, body.first, body.last, body.perSecond)
 }
 
 // ========= WebRTC Handler (Jitter and Packet Loss) =========
 
 type sdp struct {
-	SDP string `json:"sdp"`
+	SDP       string `json:"sdp"`
+	SessionID string `json:"sessionId,omitempty"` // set on the answer; identifies the session timeline
 }
 
 // webrtcOfferHandler handles the SDP Offer/Answer exchange for WebRTC peer connection.
 func webrtcOfferHandler(w http.ResponseWriter, r *http.Request) {
 	var offer sdp
 	if err := json.NewDecoder(r.Body).Decode(&offer); err != nil {
-		http.Error(w, "Invalid SDP offer format", http.StatusBadRequest)
+		http.Error(w, tr(r, "Invalid SDP offer format"), http.StatusBadRequest)
 		return
 	}
 
@@ -324,7 +755,7 @@ func webrtcOfferHandler(w http.ResponseWriter, r *http.Request) {
 	peerConnection, err := webrtcAPI.NewPeerConnection(peerConnectionConfig)
 	if err != nil {
 		log.Printf("Failed to create PeerConnection: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
 		return
 	}
 
@@ -336,29 +767,73 @@ func webrtcOfferHandler(w http.ResponseWriter, r *http.Request) {
 
 	if err = peerConnection.SetRemoteDescription(sdpOffer); err != nil {
 		log.Printf("Failed to SetRemoteDescription: %v", err)
-		http.Error(w, "Invalid SDP", http.StatusBadRequest)
+		peerConnection.Close()
+		http.Error(w, tr(r, "Invalid SDP"), http.StatusBadRequest)
+		return
+	}
+
+	// Track the peer connection as an active session until it closes or fails
+	session, ok := startSession(w, "webrtc", r)
+	if !ok {
+		peerConnection.Close()
 		return
 	}
+	timeline := webrtcLogs.Start(session)
+	timeline.Add("offer-received", fmt.Sprintf("%d bytes of SDP", len(offer.SDP)))
+	instrumentPeerConnection(peerConnection, timeline)
+
+	// The session outlives this request, so bound it by server shutdown and a
+	// maximum lifetime instead; an abandoned peer connection is closed then.
+	lifetime, endLifetime := context.WithTimeout(serverContext, webrtcSessionTimeout)
+	go func() {
+		<-lifetime.Done()
+		if errors.Is(lifetime.Err(), context.DeadlineExceeded) {
+			timeline.Add("session-timeout", webrtcSessionTimeout.String())
+		}
+		peerConnection.Close()
+	}()
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		timeline.Add("peer-connection", state.String())
+		if state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateFailed {
+			activeSessions.Finish(session)
+			endLifetime()
+		}
+	})
 
 	// 2. Set up the Data Channel Listener
 	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
 		if *verbose {
 			log.Printf("New DataChannel established: %s - %d", dc.Label(), dc.ID())
 		}
+		timeline.Add("datachannel", dc.Label())
 		dc.OnOpen(func() {
+			timeline.Add("datachannel-open", dc.Label())
 			if *verbose {
 				log.Printf("DataChannel '%s' is open. Ready for Jitter/Packet Loss Test.", dc.Label())
 			}
 		})
 
+		limiter := newEchoLimiter()
 		dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			// The channel is unreliable anyway: probes over the rate are dropped,
+			// and the channel is closed once the connection used up its total.
+			if err := limiter.Allow(); err != nil {
+				echoDropped.Inc("webrtc", echoRejectReason(err))
+				if err == errEchoTotal {
+					dc.Close()
+				}
+				return
+			}
 			// Core logic: echo back the received raw data immediately for RTT/Jitter/Loss calculation.
 			if err := dc.Send(msg.Data); err != nil {
 				log.Printf("Error echoing data: %v", err)
 			}
+			session.AddBytes(int64(len(msg.Data)))
+			timeline.recordMessage(len(msg.Data))
 		})
 
 		dc.OnClose(func() {
+			timeline.recordClose(dc.Label())
 			if *verbose {
 				log.Printf("DataChannel '%s' closed.", dc.Label())
 			}
@@ -373,23 +848,33 @@ func webrtcOfferHandler(w http.ResponseWriter, r *http.Request) {
 	answer, err := peerConnection.CreateAnswer(nil)
 	if err != nil {
 		log.Printf("Failed to create answer: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		peerConnection.Close()
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
 		return
 	}
 
 	// Set the local Session Description (the Answer)
 	if err = peerConnection.SetLocalDescription(answer); err != nil {
 		log.Printf("Failed to set local description: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		peerConnection.Close()
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
 		return
 	}
 
 	// Wait for ICE gathering to complete before sending the Answer
 	// This is important for ensuring the remote peer gets all candidates
-	<-gatherComplete
+	select {
+	case <-gatherComplete:
+	case <-r.Context().Done():
+		timeline.Add("offer-abandoned", r.Context().Err().Error())
+		endLifetime()
+		return
+	}
+	timeline.Add("answer-sent", "")
 
 	// 4. Send the SDP Answer back to the client
-	response := sdp{SDP: peerConnection.LocalDescription().SDP}
+	response := sdp{SDP: peerConnection.LocalDescription().SDP, SessionID: session.ID}
+	w.Header().Set("X-Session-ID", session.ID)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("Failed to encode response: %v", err)
@@ -416,6 +901,18 @@ func serveHybridFile(w http.ResponseWriter, r *http.Request, port int, path stri
 
 	// Check if the local file exists
 	_, err := os.Stat(localPath)
+	if err == nil && *sriEnabled && fileName == "index.html" {
+		// index.html is templated with integrity attributes, so read it instead of using ServeFile
+		content, err := os.ReadFile(localPath)
+		if err != nil {
+			log.Printf("Error reading local file %s: %v", localPath, err)
+			http.Error(w, tr(r, "Internal Server Error"), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(addIntegrityAttributes(content))
+		return
+	}
 	if err == nil {
 		if *verbose {
 			log.Printf("Serving local override: %s", localPath)
@@ -433,7 +930,7 @@ func serveHybridFile(w http.ResponseWriter, r *http.Request, port int, path stri
 			http.NotFound(w, r)
 		} else {
 			log.Printf("Error reading embedded file %s: %v", embedPath, err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			http.Error(w, tr(r, "Internal Server Error"), http.StatusInternalServerError)
 		}
 		return
 	}
@@ -447,6 +944,12 @@ func serveHybridFile(w http.ResponseWriter, r *http.Request, port int, path stri
 		contentType = "text/css; charset=utf-8"
 	case ".js":
 		contentType = "application/javascript"
+	case ".wasm":
+		contentType = "application/wasm" // required by WebAssembly.instantiateStreaming
+	}
+
+	if *sriEnabled && fileName == "index.html" {
+		content = addIntegrityAttributes(content)
 	}
 
 	w.Header().Set("Content-Type", contentType)
@@ -477,17 +980,163 @@ func logEmbeddedFiles() {
 	}
 }
 
+// openStore opens the result store selected by -store and applies the
+// retention and quota flags to it.
+func openStore() (ResultStore, error) {
+	switch *storeType {
+	case "badger":
+		key, err := readEncryptionKey(*badgerKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		store, err := NewBadgerStore(*badgerPath, key, *badgerKeyRotation)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.SetRetention(*resultTTL); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to apply result retention: %w", err)
+		}
+		if err := store.SetQuota(*maxResults, *maxStoreBytes); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to apply store quota: %w", err)
+		}
+		return store, nil
+	case "sqlite":
+		if *maxStoreBytes > 0 {
+			return nil, fmt.Errorf("-max-store-bytes is not supported by the sqlite store, use -max-results")
+		}
+		store, err := NewSQLiteStore(*sqlitePath)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.SetLimits(*resultTTL, *maxResults); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to apply result limits: %w", err)
+		}
+		return store, nil
+	case "postgres":
+		if *maxStoreBytes > 0 {
+			return nil, fmt.Errorf("-max-store-bytes is not supported by the postgres store, use -max-results")
+		}
+		if *postgresDSN == "" {
+			return nil, fmt.Errorf("-store postgres needs -dsn")
+		}
+		store, err := NewPostgresStore(*postgresDSN, max(*postgresMaxConns, 1))
+		if err != nil {
+			return nil, err
+		}
+		if err := store.SetLimits(*resultTTL, *maxResults); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to apply result limits: %w", err)
+		}
+		return store, nil
+	case "redis":
+		if *maxStoreBytes > 0 {
+			return nil, fmt.Errorf("-max-store-bytes is not supported by the redis store, use -max-results")
+		}
+		store, err := NewRedisStore(*redisURL, *redisPrefix)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.SetLimits(*resultTTL, *maxResults); err != nil {
+			store.Close()
+			return nil, fmt.Errorf("failed to apply result limits: %w", err)
+		}
+		return store, nil
+	case "memory":
+		if *maxResults > 0 || *maxStoreBytes > 0 {
+			return nil, fmt.Errorf("-max-results and -max-store-bytes are not supported by the memory store, use -memory-capacity")
+		}
+		if *memoryCapacity < 1 {
+			return nil, fmt.Errorf("-memory-capacity must be at least 1")
+		}
+		return NewMemoryStore(*memoryCapacity, *resultTTL), nil
+	default:
+		return nil, fmt.Errorf("unknown -store %q, want badger, sqlite, postgres, redis or memory", *storeType)
+	}
+}
+
 func main() {
+	// Maintenance subcommands (e.g. "reindex") run instead of the server
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	// Parse command-line flags
 	flag.Parse()
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	if err := applyPrivacyPreset(); err != nil {
+		log.Fatalf("Invalid privacy settings: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serverContext = ctx
 
 	// Validation
 	if *maxDownloadSize > globalMaxDownloadSizeMB {
 		*maxDownloadSize = globalMaxDownloadSizeMB
 		log.Printf("Max download size capped at global maximum: %dMB", globalMaxDownloadSizeMB)
 	}
+	*maxDownloadSize = max(*maxDownloadSize, 1)
+	*minSize = max(*minSize, 1)
+	if *minSize > *maxDownloadSize {
+		log.Printf("Min download size %dMB exceeds the maximum, using %dMB", *minSize, *maxDownloadSize)
+		*minSize = *maxDownloadSize
+	}
+	if *defaultSize < *minSize || *defaultSize > *maxDownloadSize {
+		clamped := max(*minSize, min(*defaultSize, *maxDownloadSize))
+		log.Printf("Default download size %dMB is outside %d-%dMB, using %dMB", *defaultSize, *minSize, *maxDownloadSize, clamped)
+		*defaultSize = clamped
+	}
+
+	if err := configureListener(*listenAddr, *ipv6Only); err != nil {
+		log.Fatalf("Invalid -listen: %v", err)
+	}
+	if err := setupTLS(*tlsCert, *tlsKey); err != nil {
+		log.Fatalf("Invalid TLS settings: %v", err)
+	}
+	if err := loadReportLocation(*reportTimeZone); err != nil {
+		log.Fatalf("Invalid -report-timezone: %v", err)
+	}
+	if err := loadLocales(); err != nil {
+		log.Fatalf("Invalid locale settings: %v", err)
+	}
+	if err := loadGeoIP(); err != nil {
+		log.Fatalf("Invalid GeoIP database: %v", err)
+	}
+	if err := loadAccessPolicy(); err != nil {
+		log.Fatalf("Invalid access policy: %v", err)
+	}
+	if err := startBlocklist(); err != nil {
+		log.Fatalf("Invalid blocklist: %v", err)
+	}
+	if err := startClockCheck(); err != nil {
+		log.Fatalf("Invalid clock check settings: %v", err)
+	}
+	if err := checkNetPing(); err != nil {
+		log.Fatalf("Cannot ping clients: %v", err)
+	}
+	if err := loadConsent(); err != nil {
+		log.Fatalf("Invalid consent settings: %v", err)
+	}
+	if err := loadSigningKey(); err != nil {
+		log.Fatalf("Invalid signing key: %v", err)
+	}
+	if err := setupNotifiers(); err != nil {
+		log.Fatalf("Invalid notification settings: %v", err)
+	}
+	if err := setupAlerts(); err != nil {
+		log.Fatalf("Invalid alert settings: %v", err)
+	}
+	if *recordDir != "" {
+		if err := startRecorder(*recordDir); err != nil {
+			log.Fatalf("Failed to create -record-dir: %v", err)
+		}
+	}
 
 	// 2. Configure WebRTC Ephemeral Port Range
 	s := webrtc.SettingEngine{}
@@ -499,16 +1148,58 @@ func main() {
 	} else if *webrtcMinPort != 0 || *webrtcMaxPort != 0 {
 		log.Printf("Warning: WebRTC port range flags provided but ignored (min=%d, max=%d). Must provide a valid min < max range.", *webrtcMinPort, *webrtcMaxPort)
 	}
+	if err := configureWebRTCFamily(&s, *webrtcFamily); err != nil {
+		log.Fatalf("Invalid -webrtc-family: %v", err)
+	}
+	if err := configureQoS(&s); err != nil {
+		log.Fatalf("Invalid QoS marking: %v", err)
+	}
+	if err := configureCongestion(); err != nil {
+		log.Fatalf("Invalid congestion control: %v", err)
+	}
+	if err := validateRandomData(); err != nil {
+		log.Fatalf("Invalid download payload: %v", err)
+	}
+	if err := validatePingPolicy(); err != nil {
+		log.Fatalf("Invalid ping settings: %v", err)
+	}
+	if err := validateOutbound(); err != nil {
+		log.Fatalf("Invalid probe binding: %v", err)
+	}
+	if err := validateCapture(); err != nil {
+		log.Fatalf("Invalid packet capture settings: %v", err)
+	}
+	if err := validateArchive(); err != nil {
+		log.Fatalf("Invalid archive settings: %v", err)
+	}
+	if err := validateFrontend(); err != nil {
+		log.Fatalf("Invalid frontend settings: %v", err)
+	}
+	if err := loadSchedules(); err != nil {
+		log.Fatalf("Invalid schedules: %v", err)
+	}
 
-	// 3. Configure Global Result Store (Badger)
-	var err error
-	globalStore, err = NewBadgerStore(*badgerPath)
+	// 3. Configure Global Result Store (Badger or SQLite)
+	store, err := openStore()
 	if err != nil {
-		log.Fatalf("Failed to initialize Badger KV store: %v", err)
+		log.Fatalf("Failed to initialize result store: %v", err)
+	}
+	globalStore = instrumentedStore{store}
+	if err := checkSigningStore(); err != nil {
+		log.Fatalf("Invalid signing settings: %v", err)
 	}
 	// IMPORTANT: Ensure the database is closed when the main function exits
 	defer globalStore.Close()
 
+	// Hash the embedded assets so overrides can be checked against them
+	if customFrontend() {
+		assetManifest = map[string]AssetIntegrity{}
+		log.Printf("Serving the web UI from %s%s instead of the embedded assets", *frontendDir, *frontendURL)
+	} else {
+		assetManifest = buildAssetManifest()
+		warnModifiedOverrides()
+	}
+
 	// Initialize the global API instance with the configured settings
 	webrtcAPI = webrtc.NewAPI(webrtc.WithSettingEngine(s))
 
@@ -516,37 +1207,159 @@ func main() {
 	mux := http.NewServeMux()
 
 	// API routes
-	mux.HandleFunc("/latency", latencyHandler)
-	mux.HandleFunc("/download", downloadHandler)
-	mux.HandleFunc("/upload", uploadHandler)
-	mux.HandleFunc("/webrtc/offer", webrtcOfferHandler) // The real WebRTC handler
+	latency, download, upload := testHandlers()
+	mux.HandleFunc("/latency", latency)
+	mux.HandleFunc("/latency/stream", latencyStreamHandler)
+	mux.HandleFunc("/download", download)
+	if !*demoMode {
+		mux.HandleFunc("/download/multi", multiDownloadHandler)
+		mux.HandleFunc("/download/multi/", multiDownloadHandler) // Handles /download/multi/{id} and /download/multi/{id}/stats
+		mux.HandleFunc("/ws/download", websocketDownloadHandler)
+		mux.HandleFunc("/ws/upload", websocketUploadHandler)
+		mux.HandleFunc("/ws/ping", websocketPingHandler)
+	}
+	mux.HandleFunc("/upload", upload)
+	mux.HandleFunc("/relay", relayHandler)
+	if *netPing && !*demoMode {
+		mux.HandleFunc("/api/netping", netPingHandler)
+	}
+	if *librespeedCompat && !*demoMode {
+		registerLibreSpeed(mux)
+	}
+	if *demoMode {
+		mux.HandleFunc("/webrtc/offer", demoWebRTCHandler)
+		log.Printf("Demo mode: test endpoints synthesize results without moving real data")
+	} else {
+		mux.HandleFunc("/webrtc/offer", webrtcOfferHandler) // The real WebRTC handler
+	}
+	mux.HandleFunc("/sessions/", sessionHandler) // Handles /sessions/{id}/webrtc and /sessions/{id}/samples
 
 	// New Storage Routes
 	mux.HandleFunc("/save-result", saveResultHandler)
-	mux.HandleFunc("/results/", loadResultHandler) // Handles /results/{id}
-	// Static file serving (Hybrid: Local/Embedded)
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// 1. Normalize root path to index.html
-		path := r.URL.Path
-		if path == "/" {
-			path = "/index.html"
-		}
+	mux.HandleFunc("/share/", shareHandler)
+	mux.HandleFunc("/r/", aliasRedirectHandler)
+	mux.HandleFunc("/results/", resultHandler) // Handles GET and DELETE /results/{id} and /results/{id}/amendments
+	mux.HandleFunc("/results", requireAdmin(listResultsHandler))
+	mux.HandleFunc("/results/export", requireAdmin(exportResultsHandler))
+	mux.HandleFunc("/results/import", requireAdmin(importResultsHandler))
+
+	// Admin console (token protected)
+	if *adminToken != "" {
+		registerAdminRoutes(mux)
+		log.Println("Admin console enabled at /admin/")
+	}
 
-		// 2. Pass the normalized path to the server function
-		// We no longer prepend the 'static/' path here, fixing the path doubling bug.
-		serveHybridFile(w, r, *port, path)
-	})
+	// Deployment diagnostics
+	mux.HandleFunc("/api/manifest", manifestHandler)
+	mux.HandleFunc("/api/selfcheck", requireAdmin(selfCheckHandler))
+	mux.HandleFunc("/api/test-error", testErrorHandler)
+	mux.HandleFunc("/api/prewarm", prewarmHandler)
+	mux.HandleFunc("/api/config", clientConfigHandler)
+	mux.HandleFunc("/api/signing-key", signingKeyHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/stats", statsHandler)
+	mux.HandleFunc("/api/matrix/latest", matrixLatestHandler)
+	mux.HandleFunc("/history/", historyHandler)
+	mux.HandleFunc("/api/recurring", recurringHandler)
+	mux.HandleFunc("/api/recurring/results", recurringResultsHandler)
+	mux.HandleFunc("/api/my/results", myResultsHandler)
+	mux.HandleFunc("/api/my/results/", myResultHandler) // Handles DELETE /api/my/results/{id}
+	mux.HandleFunc(selfCheckProbePath, selfCheckProbeHandler)
+	// Static file serving (Hybrid: Local/Embedded)
+	mux.Handle("/", frontendHandler())
 
-	// Start the server
-	addr := fmt.Sprintf(":%d", *port)
+	// Start the server, falling back to alternate ports if configured
+	ln, boundPort, err := listenWithFallback(*port, *portFallback, *portFallbackList)
+	if err != nil {
+		log.Fatalf("Server failed to start: %v", err)
+	}
+	*port = boundPort
+	addr := ln.Addr().String()
 	log.Printf("Server starting on %s. Max Download: %dMB, Chunk Size: %d bytes", addr, *maxDownloadSize, *downloadChunkSize)
-	log.Printf("Static files are embedded, but can be overridden by placing files in the './static/' directory.")
+	if !customFrontend() {
+		log.Printf("Static files are embedded, but can be overridden by placing files in the './static/' directory.")
 
-	// Log the list of embedded files
-	if *verbose {
-		logEmbeddedFiles()
+		// Log the list of embedded files
+		if *verbose {
+			logEmbeddedFiles()
+		}
+	}
+	announceAddress(*port, *discoveryFile)
+	if *httpRedirectPort != 0 {
+		if serverTLSConfig == nil {
+			log.Printf("Warning: -http-redirect-port ignored because TLS is not enabled")
+		} else {
+			startHTTPRedirect(*httpRedirectPort, *acmeWebroot)
+		}
+	}
+	var dataPlane []shutdowner
+	if *dataPortList != "" {
+		dataPlane = startDataPlane(parsePortList(*dataPortList))
 	}
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	if *iperfPort != 0 && !*demoMode {
+		if err := startIperf(*iperfPort); err != nil {
+			log.Fatalf("Cannot serve iperf3 clients: %v", err)
+		}
+	}
+	startReportScheduler()
+	startArchiver()
+	startMQTT()
+	restoreAlerts()
+	startCertExpiryCheck()
+	startScheduler()
+	if *mdnsEnabled {
+		advertiser, err := startMDNS(*mdnsName, *port)
+		if err != nil {
+			log.Printf("Warning: mDNS advertisement disabled: %v", err)
+		} else {
+			defer advertiser.Close()
+		}
+	}
+	if *portMappingMode != "" {
+		mapper, err := startPortMapping(*portMappingMode, *natGateway, *port, *webrtcMinPort, *webrtcMaxPort)
+		if err != nil {
+			log.Printf("Warning: port mapping disabled: %v", err)
+		} else {
+			defer mapper.Close()
+		}
+	}
+	handler := withBodyDrain(withAccessPolicy(withRecorder(mux)))
+	quicServer, err := startHTTP3(*port, handler)
+	if err != nil {
+		log.Fatalf("Server failed to start: %v", err)
+	}
+	server := &http.Server{Handler: withAltSvc(*port, handler), ConnState: trackConnState, ConnContext: connContext, BaseContext: serverBaseContext}
+	configureHTTP2(server)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-serverContext.Done()
+		log.Printf("Shutting down, waiting up to %s for %d test sessions and other requests to finish", shutdownTimeout, activeSessions.Count())
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		servers := append([]shutdowner{server}, dataPlane...)
+		if quicServer != nil {
+			servers = append(servers, quicServer)
+		}
+		var wg sync.WaitGroup
+		for _, s := range servers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.Shutdown(ctx); err != nil {
+					log.Printf("Shutdown incomplete: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		// WebRTC sessions are not requests; wait for their peer connections to close
+		if n := activeSessions.Drain(ctx); n > 0 {
+			log.Printf("Exiting with %d test sessions still running", n)
+		}
+	}()
+	if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed to start: %v", err)
 	}
+	<-shutdownDone
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// AssetIntegrity describes one static asset in the integrity manifest.
+type AssetIntegrity struct {
+	Path       string `json:"path"`
+	Size       int    `json:"size"`
+	Integrity  string `json:"integrity"`                // SRI value of the embedded copy
+	Overridden bool   `json:"overridden"`               // a local file replaces the embedded copy
+	Local      string `json:"localIntegrity,omitempty"` // SRI value of the local override
+	Modified   bool   `json:"modified"`                 // the local override differs from the embedded copy
+}
+
+// assetManifest maps asset paths (e.g. "speedtest.js") to their embedded SRI hash.
+// It is computed once at startup since embedded files never change.
+var assetManifest map[string]AssetIntegrity
+
+// sriHash returns the subresource-integrity value for content.
+func sriHash(content []byte) string {
+	sum := sha512.Sum384(content)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// buildAssetManifest hashes every embedded static file.
+func buildAssetManifest() map[string]AssetIntegrity {
+	manifest := make(map[string]AssetIntegrity)
+	err := fs.WalkDir(embeddedFiles, embeddedPrefix, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(embeddedFiles, path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(path, embeddedPrefix+"/")
+		manifest[name] = AssetIntegrity{Path: name, Size: len(content), Integrity: sriHash(content)}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error building asset manifest: %v", err)
+	}
+	return manifest
+}
+
+// currentAssetManifest returns the manifest annotated with the state of any
+// local overrides, so tampered or stale overrides are visible to operators.
+func currentAssetManifest() []AssetIntegrity {
+	assets := make([]AssetIntegrity, 0, len(assetManifest))
+	for name, asset := range assetManifest {
+		if content, err := os.ReadFile(filepath.Join(localOverrideDir, name)); err == nil {
+			asset.Overridden = true
+			asset.Local = sriHash(content)
+			asset.Modified = asset.Local != asset.Integrity
+		}
+		assets = append(assets, asset)
+	}
+	sort.Slice(assets, func(i, j int) bool { return assets[i].Path < assets[j].Path })
+	return assets
+}
+
+// warnModifiedOverrides logs local overrides that differ from the embedded assets.
+func warnModifiedOverrides() {
+	for _, asset := range currentAssetManifest() {
+		if asset.Modified {
+			log.Printf("Warning: local override %s differs from the embedded asset (%s)", asset.Path, asset.Local)
+		}
+	}
+}
+
+// manifestHandler serves the asset integrity manifest as JSON.
+func manifestHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(currentAssetManifest()); err != nil {
+		log.Printf("Failed to encode asset manifest: %v", err)
+	}
+}
+
+// assetRefPattern matches local script and stylesheet references in index.html.
+var assetRefPattern = regexp.MustCompile(`<(script|link)\b([^>]*?)\b(src|href)="([^":]+)"([^>]*)>`)
+
+// addIntegrityAttributes templates index.html, pinning every referenced local
+// asset to the hash of its embedded copy. A browser will refuse to run a local
+// override that does not match.
+func addIntegrityAttributes(html []byte) []byte {
+	return assetRefPattern.ReplaceAllFunc(html, func(tag []byte) []byte {
+		m := assetRefPattern.FindSubmatch(tag)
+		if strings.Contains(string(tag), "integrity=") {
+			return tag
+		}
+		asset, ok := assetManifest[strings.TrimPrefix(string(m[4]), "/")]
+		if !ok {
+			return tag
+		}
+		var b strings.Builder
+		b.WriteString("<")
+		b.Write(m[1])
+		b.Write(m[2])
+		b.Write(m[3])
+		b.WriteString(`="`)
+		b.Write(m[4])
+		b.WriteString(`" integrity="`)
+		b.WriteString(asset.Integrity)
+		b.WriteString(`"`)
+		b.Write(m[5])
+		b.WriteString(">")
+		return []byte(b.String())
+	})
+}
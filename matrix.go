@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MatrixRun holds the results of one run of a schedule's peer tests, a cell
+// per peer, so dashboards can compare the paths to several sites side by side.
+// The results are stored tagged with schedule={name} and run={runId}.
+type MatrixRun struct {
+	Schedule string       `json:"schedule"`
+	RunID    string       `json:"runId"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Cells    []MatrixCell `json:"cells"` // in the order of the schedule's peers
+}
+
+// MatrixCell is the result of the peer test to one peer in a MatrixRun.
+type MatrixCell struct {
+	Peer              string  `json:"peer"`
+	ResultID          string  `json:"resultId,omitempty"`
+	DownloadSpeedMbps float64 `json:"downloadSpeedMbps"`
+	UploadSpeedMbps   float64 `json:"uploadSpeedMbps"`
+	LatencyMs         float64 `json:"latencyMs"`
+	JitterMs          float64 `json:"jitterMs"`
+	PacketLossPercent float64 `json:"packetLossPercent"`
+	Error             string  `json:"error,omitempty"`
+}
+
+// latestMatrices holds the last finished run of each schedule with peers.
+var latestMatrices = struct {
+	sync.Mutex
+	bySchedule map[string]MatrixRun
+}{bySchedule: make(map[string]MatrixRun)}
+
+func newMatrixCell(peer string, result TestResult, err error) MatrixCell {
+	if err != nil {
+		return MatrixCell{Peer: peer, Error: err.Error()}
+	}
+	return MatrixCell{
+		Peer:              peer,
+		ResultID:          result.ID,
+		DownloadSpeedMbps: result.DownloadSpeedMbps,
+		UploadSpeedMbps:   result.UploadSpeedMbps,
+		LatencyMs:         result.LatencyMs,
+		JitterMs:          result.JitterMs,
+		PacketLossPercent: result.PacketLossPercent,
+	}
+}
+
+func recordMatrix(run MatrixRun) {
+	latestMatrices.Lock()
+	latestMatrices.bySchedule[run.Schedule] = run
+	latestMatrices.Unlock()
+}
+
+// pruneMatrices forgets the runs of schedules no longer configured.
+func pruneMatrices(schedules []Schedule) {
+	latestMatrices.Lock()
+	defer latestMatrices.Unlock()
+	for name := range latestMatrices.bySchedule {
+		if !slices.ContainsFunc(schedules, func(s Schedule) bool { return s.Name == name }) {
+			delete(latestMatrices.bySchedule, name)
+		}
+	}
+}
+
+// matrixLatestHandler serves GET /api/matrix/latest: the last run of every
+// schedule with peers, sorted by schedule, or of the configured one named by
+// ?schedule=.
+func matrixLatestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	scheduler.Lock()
+	names := make([]string, 0, len(scheduler.config.Schedules))
+	for _, s := range scheduler.config.Schedules {
+		names = append(names, s.Name)
+	}
+	scheduler.Unlock()
+	p := newParamReader(r.URL.Query())
+	name := p.Enum("schedule", "", names...)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	latestMatrices.Lock()
+	defer latestMatrices.Unlock()
+	if name != "" {
+		run, ok := latestMatrices.bySchedule[name]
+		if !ok {
+			http.Error(w, tr(r, "No finished run of this schedule"), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, run)
+		return
+	}
+	runs := make([]MatrixRun, 0, len(latestMatrices.bySchedule))
+	for _, run := range latestMatrices.bySchedule {
+		runs = append(runs, run)
+	}
+	slices.SortFunc(runs, func(a, b MatrixRun) int { return strings.Compare(a.Schedule, b.Schedule) })
+	writeJSON(w, runs)
+}
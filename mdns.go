@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	mdnsServiceType = "_http._tcp.local."
+	mdnsServiceEnum = "_services._dns-sd._udp.local."
+	mdnsTTL         = 120
+)
+
+var mdnsGroupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsAdvertiser answers multicast DNS queries for the netspeed HTTP service so
+// devices on the LAN can discover the server without knowing its IP.
+type mdnsAdvertiser struct {
+	conn     *net.UDPConn
+	instance dnsmessage.Name // e.g. "Go Netspeed on host._http._tcp.local."
+	service  dnsmessage.Name
+	enum     dnsmessage.Name
+	host     dnsmessage.Name // e.g. "host.local."
+	port     uint16
+	txt      []string
+}
+
+// startMDNS begins advertising the service on port using the given instance name.
+// An empty name defaults to the machine hostname.
+func startMDNS(name string, port int) (*mdnsAdvertiser, error) {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "go-netspeed"
+	}
+	hostname = strings.TrimSuffix(strings.Split(hostname, ".")[0], ".")
+	if name == "" {
+		name = "Go Netspeed on " + hostname
+	}
+
+	a := &mdnsAdvertiser{
+		port: uint16(port),
+		txt:  []string{"netspeed=1", "path=/"},
+	}
+	if a.instance, err = dnsmessage.NewName(name + "." + mdnsServiceType); err != nil {
+		return nil, fmt.Errorf("invalid mdns instance name: %w", err)
+	}
+	if a.host, err = dnsmessage.NewName(hostname + ".local."); err != nil {
+		return nil, fmt.Errorf("invalid mdns host name: %w", err)
+	}
+	a.service = dnsmessage.MustNewName(mdnsServiceType)
+	a.enum = dnsmessage.MustNewName(mdnsServiceEnum)
+
+	a.conn, err = net.ListenMulticastUDP("udp4", nil, mdnsGroupAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join mdns multicast group: %w", err)
+	}
+
+	go a.serve()
+	go a.announce()
+
+	log.Printf("mDNS advertising %q as %s on port %d", name, a.host, port)
+	return a, nil
+}
+
+// announce sends unsolicited responses at startup, as recommended by RFC 6762.
+func (a *mdnsAdvertiser) announce() {
+	for i := 0; i < 3; i++ {
+		if err := a.send(a.answers(true, true, true)); err != nil {
+			log.Printf("mDNS announcement failed: %v", err)
+			return
+		}
+		time.Sleep(time.Second << i)
+	}
+}
+
+// serve reads queries from the multicast group until the connection is closed.
+func (a *mdnsAdvertiser) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			if *verbose {
+				log.Printf("mDNS responder stopped: %v", err)
+			}
+			return
+		}
+		a.handleQuery(buf[:n], from)
+	}
+}
+
+// handleQuery answers a query. Queries from a source port other than 5353 are
+// "legacy unicast" one-shot queries (RFC 6762 section 6.7) and get a direct reply.
+func (a *mdnsAdvertiser) handleQuery(packet []byte, from *net.UDPAddr) {
+	var p dnsmessage.Parser
+	header, err := p.Start(packet)
+	if err != nil || header.Response {
+		return
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return
+	}
+
+	dest, id := mdnsGroupAddr, uint16(0)
+	if from.Port != mdnsGroupAddr.Port {
+		dest, id = from, header.ID
+	}
+
+	var ptr, srv, addr bool
+	for _, q := range questions {
+		switch {
+		case q.Name == a.service && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL):
+			ptr = true
+		case q.Name == a.enum && q.Type == dnsmessage.TypePTR:
+			// Service type enumeration: answer with our service type only.
+			a.sendEnum(dest, id)
+		case q.Name == a.instance:
+			srv = true
+		case q.Name == a.host && (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL):
+			addr = true
+		}
+	}
+	if ptr || srv || addr {
+		if err := a.sendTo(dest, id, a.answers(ptr, srv || ptr, true)); err != nil && *verbose {
+			log.Printf("mDNS response failed: %v", err)
+		}
+	}
+}
+
+// answers builds the resource records for the requested parts of the service.
+func (a *mdnsAdvertiser) answers(ptr, srv, addr bool) []dnsmessage.Resource {
+	var rr []dnsmessage.Resource
+	hdr := func(name dnsmessage.Name, t dnsmessage.Type, flush bool) dnsmessage.ResourceHeader {
+		class := dnsmessage.ClassINET
+		if flush {
+			class |= 1 << 15 // cache-flush bit for unique records
+		}
+		return dnsmessage.ResourceHeader{Name: name, Type: t, Class: class, TTL: mdnsTTL}
+	}
+
+	if ptr {
+		rr = append(rr, dnsmessage.Resource{
+			Header: hdr(a.service, dnsmessage.TypePTR, false),
+			Body:   &dnsmessage.PTRResource{PTR: a.instance},
+		})
+	}
+	if srv {
+		rr = append(rr,
+			dnsmessage.Resource{
+				Header: hdr(a.instance, dnsmessage.TypeSRV, true),
+				Body:   &dnsmessage.SRVResource{Target: a.host, Port: a.port},
+			},
+			dnsmessage.Resource{
+				Header: hdr(a.instance, dnsmessage.TypeTXT, true),
+				Body:   &dnsmessage.TXTResource{TXT: a.txt},
+			},
+		)
+	}
+	if addr {
+		for _, ip := range localIPv4s() {
+			var a4 [4]byte
+			copy(a4[:], ip)
+			rr = append(rr, dnsmessage.Resource{
+				Header: hdr(a.host, dnsmessage.TypeA, true),
+				Body:   &dnsmessage.AResource{A: a4},
+			})
+		}
+	}
+	return rr
+}
+
+func (a *mdnsAdvertiser) sendEnum(dest *net.UDPAddr, id uint16) {
+	rr := []dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{Name: a.enum, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: mdnsTTL},
+		Body:   &dnsmessage.PTRResource{PTR: a.service},
+	}}
+	if err := a.sendTo(dest, id, rr); err != nil && *verbose {
+		log.Printf("mDNS enumeration response failed: %v", err)
+	}
+}
+
+// send multicasts answers to the mDNS group.
+func (a *mdnsAdvertiser) send(answers []dnsmessage.Resource) error {
+	return a.sendTo(mdnsGroupAddr, 0, answers)
+}
+
+func (a *mdnsAdvertiser) sendTo(dest *net.UDPAddr, id uint16, answers []dnsmessage.Resource) error {
+	msg := dnsmessage.Message{
+		Header:  dnsmessage.Header{ID: id, Response: true, Authoritative: true},
+		Answers: answers,
+	}
+	packet, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = a.conn.WriteToUDP(packet, dest)
+	return err
+}
+
+// Close stops the responder.
+func (a *mdnsAdvertiser) Close() error {
+	return a.conn.Close()
+}
+
+// localIPv4s returns the non-loopback IPv4 addresses of this host.
+func localIPv4s() []net.IP {
+	var ips []net.IP
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			ips = append(ips, ip4)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,66 @@
+// Package measure holds the arithmetic that turns raw test observations into
+// reported metrics. The server and the Go client use it directly, and the web
+// client runs the same code compiled to WebAssembly (see ../wasm), so a result
+// does not depend on which of them computed it.
+package measure
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// Mbps converts bytes over a duration to megabits per second in the web
+// client's units: (Bytes * 8) / (Seconds * 1024^2).
+func Mbps(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / (d.Seconds() * 1024 * 1024)
+}
+
+// MeanLatency is the average of the round-trip times, or 0 without any.
+func MeanLatency(rtts []float64) float64 {
+	if len(rtts) == 0 {
+		return 0
+	}
+	var total float64
+	for _, rtt := range rtts {
+		total += rtt
+	}
+	return total / float64(len(rtts))
+}
+
+// JitterLoss computes jitter as the mean difference between consecutive
+// round-trip times, in arrival order, and loss as the share of sent packets
+// without an echo.
+func JitterLoss(rtts []float64, sent int) (jitterMs, lossPercent float64) {
+	if sent > 0 {
+		lossPercent = float64(sent-len(rtts)) / float64(sent) * 100
+	}
+	if len(rtts) > 1 {
+		var diffs float64
+		for i := 1; i < len(rtts); i++ {
+			diffs += math.Abs(rtts[i] - rtts[i-1])
+		}
+		jitterMs = diffs / float64(len(rtts)-1)
+	}
+	return jitterMs, lossPercent
+}
+
+// RPM scores responsiveness as round trips per minute, like Apple's
+// networkQuality and the IETF responsiveness draft: 60000 divided by the
+// mean of the round-trip times in milliseconds after dropping the slowest
+// 10%. Higher is better; it is 0 without round trips.
+func RPM(rtts []float64) float64 {
+	if len(rtts) == 0 {
+		return 0
+	}
+	sorted := slices.Sorted(slices.Values(rtts))
+	kept := sorted[:max(1, int(math.Ceil(float64(len(sorted))*0.9)))]
+	mean := MeanLatency(kept)
+	if mean <= 0 {
+		return 0
+	}
+	return 60000 / mean
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore implements ResultStore as a fixed-size ring buffer, for
+// stateless containers that only need recent results. Once it is full, each
+// save overwrites the oldest saved result. Nothing survives a restart.
+//
+// Stored results are shared with the callers of Load and the iterators, so
+// they must not be modified.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	ring  []TestResult // a zero ID marks a free slot
+	next  int          // the slot the next save writes
+	byID  map[string]int
+	count int
+
+	resultTTL time.Duration // zero keeps results until they are overwritten
+}
+
+// NewMemoryStore returns an empty store holding up to capacity results.
+func NewMemoryStore(capacity int, ttl time.Duration) *MemoryStore {
+	log.Printf("Memory store configured for the last %d results (data will be lost on exit).", capacity)
+	return &MemoryStore{ring: make([]TestResult, capacity), byID: make(map[string]int, capacity), resultTTL: ttl}
+}
+
+// Save generates a unique ID, saves the result, and returns the ID.
+func (s *MemoryStore) Save(result TestResult) (string, error) {
+	result.ID = uuid.New().String()
+	result.Timestamp = time.Now() // Use server time for official record
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(result)
+	log.Printf("Result saved with ID: %s", result.ID)
+	return result.ID, nil
+}
+
+// Import saves a result with its original ID and timestamp.
+func (s *MemoryStore) Import(result TestResult) (string, error) {
+	if s.expired(result, time.Now()) {
+		return result.ID, fmt.Errorf("result %s has already expired", result.ID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[result.ID]; ok {
+		return "", fmt.Errorf("result %s: %w", result.ID, errResultExists)
+	}
+	s.put(result)
+	return result.ID, nil
+}
+
+// put writes result to the next slot, evicting the result there. The caller
+// holds the write lock.
+func (s *MemoryStore) put(result TestResult) {
+	if old := s.ring[s.next].ID; old != "" {
+		delete(s.byID, old)
+		s.count--
+	}
+	s.ring[s.next] = result
+	s.byID[result.ID] = s.next
+	s.count++
+	s.next = (s.next + 1) % len(s.ring)
+}
+
+func (s *MemoryStore) expired(result TestResult, now time.Time) bool {
+	return s.resultTTL > 0 && now.Sub(result.Timestamp) > s.resultTTL
+}
+
+// Load retrieves a result by ID.
+func (s *MemoryStore) Load(id string) (TestResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	slot, ok := s.byID[id]
+	if !ok || s.expired(s.ring[slot], time.Now()) {
+		return TestResult{}, fmt.Errorf("result not found for ID: %s", id)
+	}
+	return s.ring[slot], nil
+}
+
+// Delete removes a result.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot, ok := s.byID[id]
+	if !ok {
+		return fmt.Errorf("result not found for ID: %s", id)
+	}
+	s.ring[slot] = TestResult{}
+	delete(s.byID, id)
+	s.count--
+	log.Printf("Result deleted with ID: %s", id)
+	return nil
+}
+
+// matching returns the unexpired results matching filter, newest first;
+// results with the same timestamp sort by ID, descending.
+func (s *MemoryStore) matching(filter ResultFilter) []TestResult {
+	now := time.Now()
+	s.mu.RLock()
+	results := make([]TestResult, 0, s.count)
+	for _, result := range s.ring {
+		if result.ID != "" && !s.expired(result, now) && filter.Matches(result) {
+			results = append(results, result)
+		}
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(results, func(a, b TestResult) int {
+		if c := b.Timestamp.Compare(a.Timestamp); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+	return results
+}
+
+// Iterate calls fn for every stored result, oldest first, stopping at the first error.
+func (s *MemoryStore) Iterate(fn func(result TestResult) error) error {
+	results := s.matching(ResultFilter{})
+	for i := len(results) - 1; i >= 0; i-- {
+		if err := fn(results[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IterateMatching calls fn for every result matching filter, newest first,
+// stopping at the first error.
+func (s *MemoryStore) IterateMatching(filter ResultFilter, fn func(result TestResult) error) error {
+	for _, result := range s.matching(filter) {
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query returns one page of results matching filter, newest first, along with
+// the total number of matches.
+func (s *MemoryStore) Query(filter ResultFilter, offset, limit int) ([]TestResult, int, error) {
+	results := s.matching(filter)
+	offset = min(offset, len(results))
+	return slices.Clone(results[offset:min(offset+limit, len(results))]), len(results), nil
+}
+
+// List returns up to limit results matching filter, newest first, starting
+// after cursor (empty for the first page). The returned cursor continues the
+// listing and is empty after the last page. Cursors are the timestamp and ID
+// of the last result of a page, so pages stay stable while new results are
+// saved.
+func (s *MemoryStore) List(filter ResultFilter, cursor string, limit int) ([]TestResult, string, error) {
+	results := s.matching(filter)
+	if cursor != "" {
+		position, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		ts, id, ok := strings.Cut(string(position), " ")
+		nanos, err := strconv.ParseInt(ts, 10, 64)
+		if !ok || err != nil {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		after := time.Unix(0, nanos)
+		i, _ := slices.BinarySearchFunc(results, after, func(r TestResult, t time.Time) int {
+			return t.Compare(r.Timestamp) // results are sorted newest first
+		})
+		for i < len(results) && results[i].Timestamp.Equal(after) && results[i].ID >= id {
+			i++
+		}
+		results = results[i:]
+	}
+
+	page := slices.Clone(results[:min(limit, len(results))])
+	next := ""
+	if len(results) > limit && limit > 0 {
+		last := page[len(page)-1]
+		next = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(last.Timestamp.UnixNano(), 10) + " " + last.ID))
+	}
+	return page, next, nil
+}
+
+// Close releases the stored results.
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.ring)
+	clear(s.byID)
+	s.count = 0
+	return nil
+}
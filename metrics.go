@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A minimal Prometheus text-format registry. The server only needs counters,
+// computed gauges and a few histograms, which keeps this far smaller than the
+// client library.
+
+type metric interface {
+	write(b *strings.Builder)
+}
+
+var (
+	metricsMu sync.Mutex
+	metrics   []metric
+)
+
+func registerMetric(m metric) {
+	metricsMu.Lock()
+	metrics = append(metrics, m)
+	metricsMu.Unlock()
+}
+
+// counterVec is a monotonically increasing counter partitioned by label values.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64 // key: label values joined by \xff
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	registerMetric(c)
+	return c
+}
+
+// Add increases the counter for the given label values by v.
+func (c *counterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// Inc increases the counter for the given label values by one.
+func (c *counterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the current count for the given label values.
+func (c *counterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+func (c *counterVec) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeSample(b, c.name, c.labels, strings.Split(k, "\xff"), c.values[k])
+	}
+	c.mu.Unlock()
+}
+
+// histogramVec counts observations into cumulative buckets, partitioned by
+// label values.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64 // upper bounds, ascending; +Inf is implied
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries // key: label values joined by \xff
+}
+
+type histogramSeries struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	registerMetric(h)
+	return h
+}
+
+// Observe records v for the given label values.
+func (h *histogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	bucketLabels := append(slices.Clone(h.labels), "le")
+	for _, k := range keys {
+		s := h.series[k]
+		var labelValues []string
+		if len(h.labels) > 0 {
+			labelValues = strings.Split(k, "\xff")
+		}
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = strconv.FormatFloat(h.buckets[i], 'g', -1, 64)
+			}
+			writeSample(b, h.name+"_bucket", bucketLabels, append(slices.Clone(labelValues), le), float64(cumulative))
+		}
+		writeSample(b, h.name+"_sum", h.labels, labelValues, s.sum)
+		writeSample(b, h.name+"_count", h.labels, labelValues, float64(s.count))
+	}
+}
+
+// gaugeFunc is a gauge computed at scrape time. fn returns one value per
+// label-value combination.
+type gaugeFunc struct {
+	name   string
+	help   string
+	labels []string
+	fn     func() map[string]float64 // key: label values joined by \xff
+}
+
+func newGaugeFunc(name, help string, labels []string, fn func() map[string]float64) *gaugeFunc {
+	g := &gaugeFunc{name: name, help: help, labels: labels, fn: fn}
+	registerMetric(g)
+	return g
+}
+
+func (g *gaugeFunc) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	values := g.fn()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var labelValues []string
+		if len(g.labels) > 0 {
+			labelValues = strings.Split(k, "\xff")
+		}
+		writeSample(b, g.name, g.labels, labelValues, values[k])
+	}
+}
+
+func writeSample(b *strings.Builder, name string, labels, values []string, v float64) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteString("{")
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			value := ""
+			if i < len(values) {
+				value = values[i]
+			}
+			fmt.Fprintf(b, "%s=%q", label, value)
+		}
+		b.WriteString("}")
+	}
+	if math.IsNaN(v) {
+		b.WriteString(" NaN\n")
+		return
+	}
+	fmt.Fprintf(b, " %g\n", v)
+}
+
+// metricsHandler serves all registered metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	metricsMu.Lock()
+	for _, m := range metrics {
+		m.write(&b)
+	}
+	metricsMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mqttKeepAlive      = 60 * time.Second
+	mqttDialTimeout    = 10 * time.Second
+	mqttHealthInterval = 30 * time.Second
+)
+
+// MQTT 3.1.1 control packet types (upper nibble of the fixed header).
+const (
+	mqttConnect = 0x10
+	mqttConnAck = 0x20
+	mqttPublish = 0x30
+	mqttPingReq = 0xC0
+)
+
+// mqttPublisher is the MQTT notifier when -mqtt-broker is set, started by startMQTT.
+var mqttPublisher *mqttNotifier
+
+// mqttNotifier publishes saved results to an MQTT broker as retained JSON,
+// together with an availability topic and optional Home Assistant discovery.
+// It speaks the small QoS 0 subset of MQTT 3.1.1 it needs.
+type mqttNotifier struct {
+	broker   *url.URL
+	clientID string
+	node     string // topic-safe server name
+	proxy    *egressProxy
+
+	mu     sync.Mutex
+	conn   net.Conn
+	online bool // last availability published on the current connection
+}
+
+func newMQTTNotifier(broker string) (*mqttNotifier, error) {
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+	u, err := url.Parse(broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid MQTT broker %q", broker)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "1883")
+		}
+	case "ssl", "tls", "mqtts":
+		if u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), "8883")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported MQTT broker scheme %q (want tcp or mqtts)", u.Scheme)
+	}
+
+	hostname, _ := os.Hostname()
+	node := topicSafe(hostname)
+	if node == "" {
+		node = "server"
+	}
+	return &mqttNotifier{broker: u, clientID: "netspeed-" + node, node: node}, nil
+}
+
+// topicSafe lowercases s and replaces characters that are awkward in MQTT
+// topics and Home Assistant object IDs.
+func topicSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, s)
+}
+
+func (m *mqttNotifier) topic(name string) string {
+	return strings.TrimSuffix(*mqttTopic, "/") + "/" + m.node + "/" + name
+}
+
+func (m *mqttNotifier) Name() string {
+	return "mqtt"
+}
+
+// Notify publishes the result as the retained state of the server's sensors,
+// and alerts to the alert topic.
+func (m *mqttNotifier) Notify(ctx context.Context, event NotificationEvent) error {
+	topic, retain := m.topic("result"), true
+	var payload []byte
+	var err error
+	if event.Alert != nil {
+		topic, retain = m.topic("alert"), false
+		payload, err = json.Marshal(event.Alert)
+	} else {
+		payload, err = json.Marshal(event.Result)
+	}
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil {
+		if err := m.connectLocked(); err != nil {
+			return err
+		}
+	}
+	return m.publishLocked(topic, payload, retain)
+}
+
+// startMQTT connects to the broker and keeps the connection and availability
+// topic up to date until the process exits.
+func startMQTT() {
+	m := mqttPublisher
+	if m == nil {
+		return
+	}
+	log.Printf("Publishing results to MQTT broker %s under %s", m.broker.Host, m.topic("#"))
+	go func() {
+		lastPing := time.Now()
+		for {
+			m.mu.Lock()
+			if m.conn == nil {
+				if err := m.connectLocked(); err != nil {
+					log.Printf("MQTT connection failed: %v", err)
+				}
+				lastPing = time.Now()
+			}
+			if m.conn != nil {
+				healthy := serverHealthy() == nil
+				if healthy != m.online {
+					m.publishAvailabilityLocked(healthy)
+				}
+				if m.conn != nil && time.Since(lastPing) >= mqttKeepAlive/2 {
+					m.writeLocked([]byte{mqttPingReq, 0})
+					lastPing = time.Now()
+				}
+			}
+			m.mu.Unlock()
+			time.Sleep(mqttHealthInterval)
+		}
+	}()
+}
+
+// serverHealthy reports whether the server can still serve and store results.
+func serverHealthy() error {
+	if globalStore == nil {
+		return errors.New("result store is not open")
+	}
+	_, _, err := globalStore.Query(ResultFilter{From: time.Now()}, 0, 1)
+	return err
+}
+
+// connectLocked dials the broker and announces the server. The broker
+// publishes "offline" to the availability topic if the connection drops.
+func (m *mqttNotifier) connectLocked() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mqttDialTimeout)
+	defer cancel()
+	conn, err := m.proxy.dialContext(ctx, m.broker.Host)
+	if err != nil {
+		return err
+	}
+	if m.broker.Scheme != "tcp" && m.broker.Scheme != "mqtt" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: m.broker.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+
+	flags := byte(0x02 | 0x04 | 0x20) // clean session, will, retained will
+	var credentials []byte
+	if *mqttUsername != "" {
+		flags |= 0x80
+		credentials = appendMQTTString(credentials, *mqttUsername)
+		if *mqttPassword != "" {
+			flags |= 0x40
+			credentials = appendMQTTString(credentials, *mqttPassword)
+		}
+	}
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, flags) // protocol level 4 is MQTT 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = appendMQTTString(body, m.clientID)
+	body = appendMQTTString(body, m.topic("availability"))
+	body = appendMQTTString(body, "offline")
+	body = append(body, credentials...)
+
+	conn.SetDeadline(time.Now().Add(mqttDialTimeout))
+	if _, err := conn.Write(mqttPacket(mqttConnect, body)); err != nil {
+		conn.Close()
+		return err
+	}
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		conn.Close()
+		return fmt.Errorf("no CONNACK from broker: %w", err)
+	}
+	if ack[0] != mqttConnAck || ack[3] != 0 {
+		conn.Close()
+		return fmt.Errorf("broker refused connection (return code %d)", ack[3])
+	}
+	conn.SetDeadline(time.Time{})
+	m.conn = conn
+	m.online = false
+	go m.readLoop(conn)
+
+	if *mqttDiscovery {
+		m.publishDiscoveryLocked()
+	}
+	m.publishAvailabilityLocked(serverHealthy() == nil)
+	return nil
+}
+
+// readLoop discards broker packets (PINGRESP) and notices a dropped connection.
+func (m *mqttNotifier) readLoop(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		if _, err := r.ReadByte(); err != nil {
+			break
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			break
+		}
+		if _, err := r.Discard(int(length)); err != nil {
+			break
+		}
+	}
+	m.mu.Lock()
+	if m.conn == conn {
+		conn.Close()
+		m.conn = nil
+		log.Printf("MQTT connection to %s closed", m.broker.Host)
+	}
+	m.mu.Unlock()
+}
+
+func (m *mqttNotifier) publishAvailabilityLocked(healthy bool) {
+	state := "offline"
+	if healthy {
+		state = "online"
+	}
+	if err := m.publishLocked(m.topic("availability"), []byte(state), true); err == nil {
+		m.online = healthy
+	}
+}
+
+// haSensors are the result fields exposed as Home Assistant sensors.
+var haSensors = []struct {
+	Key, Name, Field, Unit, DeviceClass, Icon string
+}{
+	{"download", "Download", "downloadSpeedMbps", "Mbit/s", "data_rate", "mdi:download"},
+	{"upload", "Upload", "uploadSpeedMbps", "Mbit/s", "data_rate", "mdi:upload"},
+	{"latency", "Latency", "latencyMs", "ms", "duration", "mdi:timer-outline"},
+	{"jitter", "Jitter", "jitterMs", "ms", "duration", "mdi:sine-wave"},
+	{"packet_loss", "Packet loss", "packetLossPercent", "%", "", "mdi:lan-disconnect"},
+}
+
+// publishDiscoveryLocked announces the result sensors so Home Assistant
+// creates them automatically, grouped under one device per server.
+func (m *mqttNotifier) publishDiscoveryLocked() {
+	hostname, _ := os.Hostname()
+	device := map[string]any{
+		"identifiers":  []string{"netspeed_" + m.node},
+		"name":         "Netspeed " + hostname,
+		"manufacturer": "go-netspeed",
+		"model":        "Speed test server",
+	}
+	for _, s := range haSensors {
+		uniqueID := "netspeed_" + m.node + "_" + s.Key
+		config := map[string]any{
+			"name":                s.Name,
+			"unique_id":           uniqueID,
+			"object_id":           uniqueID,
+			"state_topic":         m.topic("result"),
+			"value_template":      "{{ value_json." + s.Field + " | round(2) }}",
+			"unit_of_measurement": s.Unit,
+			"state_class":         "measurement",
+			"icon":                s.Icon,
+			"availability_topic":  m.topic("availability"),
+			"device":              device,
+		}
+		if s.DeviceClass != "" {
+			config["device_class"] = s.DeviceClass
+		}
+		payload, err := json.Marshal(config)
+		if err != nil {
+			continue
+		}
+		topic := strings.TrimSuffix(*mqttDiscoveryPrefix, "/") + "/sensor/netspeed_" + m.node + "/" + s.Key + "/config"
+		if err := m.publishLocked(topic, payload, true); err != nil {
+			return
+		}
+	}
+}
+
+// publishLocked sends a QoS 0 PUBLISH on the current connection.
+func (m *mqttNotifier) publishLocked(topic string, payload []byte, retain bool) error {
+	header := byte(mqttPublish)
+	if retain {
+		header |= 0x01
+	}
+	body := append(appendMQTTString(nil, topic), payload...)
+	return m.writeLocked(mqttPacket(header, body))
+}
+
+func (m *mqttNotifier) writeLocked(packet []byte) error {
+	if m.conn == nil {
+		return errors.New("not connected to MQTT broker")
+	}
+	m.conn.SetWriteDeadline(time.Now().Add(mqttDialTimeout))
+	if _, err := m.conn.Write(packet); err != nil {
+		m.conn.Close()
+		m.conn = nil
+		return err
+	}
+	return nil
+}
+
+// mqttPacket frames body with a fixed header. MQTT encodes the remaining
+// length in the same base-128 form as a Go uvarint.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := binary.AppendUvarint([]byte{header}, uint64(len(body)))
+	return append(packet, body...)
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
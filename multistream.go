@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A single TCP connection often cannot fill a fast link, so a multi-stream
+// download splits one test into byte ranges of a virtual file, fetched in
+// parallel under a shared session: POST /download/multi starts the session
+// and suggests the ranges, each stream GETs /download/multi/{id} with a Range
+// header, and /download/multi/{id}/stats reports the aggregate throughput.
+// The session's samples at /sessions/{id}/samples cover all streams.
+
+// maxDownloadStreams bounds the streams of a multi-stream download.
+const maxDownloadStreams = 16
+
+// multiDownloadIdle is how long a multi-stream download waits without a
+// running stream before its session finishes.
+const multiDownloadIdle = 10 * time.Second
+
+// multiDownload coordinates the streams of one multi-stream download.
+type multiDownload struct {
+	session   *TestSession
+	size      int64
+	streams   int
+	chunkSize int64
+	generate  payloadGenerator // shared by the streams, so ranges agree
+
+	mu        sync.Mutex
+	active    int
+	served    []int64 // bytes per stream
+	first     time.Time
+	last      time.Time
+	finished  bool
+	idleTimer *time.Timer
+}
+
+// MultiDownloadStats is the response of GET /download/multi/{id}/stats.
+// Rates are in the web client's units, like the session samples.
+type MultiDownloadStats struct {
+	SessionID  string              `json:"sessionId"`
+	Size       int64               `json:"size"`
+	Streams    int                 `json:"streams"`
+	Bytes      int64               `json:"bytes"`
+	DurationMs float64             `json:"durationMs"` // from the first stream's start to the last byte
+	Mbps       float64             `json:"mbps"`
+	Finished   bool                `json:"finished"`
+	PerStream  []MultiStreamDetail `json:"perStream"`
+}
+
+// MultiStreamDetail is one stream's share of a multi-stream download.
+type MultiStreamDetail struct {
+	Stream int     `json:"stream"`
+	Bytes  int64   `json:"bytes"`
+	Share  float64 `json:"share"` // fraction of the bytes
+}
+
+var multiDownloads = struct {
+	sync.Mutex
+	byID map[string]*multiDownload
+}{byID: make(map[string]*multiDownload)}
+
+// lookupMultiDownload returns a running or recently finished multi-stream download.
+func lookupMultiDownload(id string) (*multiDownload, bool) {
+	multiDownloads.Lock()
+	defer multiDownloads.Unlock()
+	d, ok := multiDownloads.byID[id]
+	return d, ok
+}
+
+// finish ends the session; its stats stay available for recentTestWindow.
+func (d *multiDownload) finish() {
+	d.mu.Lock()
+	if d.finished {
+		d.mu.Unlock()
+		return
+	}
+	d.finished = true
+	d.idleTimer.Stop()
+	d.mu.Unlock()
+
+	d.session.EndDownload(d.session.Bytes() >= d.size)
+	activeSessions.Finish(d.session)
+	time.AfterFunc(recentTestWindow, func() {
+		multiDownloads.Lock()
+		delete(multiDownloads.byID, d.session.ID)
+		multiDownloads.Unlock()
+	})
+}
+
+// begin registers a starting stream, or returns false once the download finished.
+func (d *multiDownload) begin() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.finished {
+		return false
+	}
+	d.active++
+	d.idleTimer.Stop()
+	if d.first.IsZero() {
+		d.first = time.Now()
+	}
+	return true
+}
+
+// add records n bytes written by a stream.
+func (d *multiDownload) add(stream int, n int64) {
+	d.session.AddBytes(n)
+	d.mu.Lock()
+	d.served[stream] += n
+	d.last = time.Now()
+	d.mu.Unlock()
+}
+
+// end registers a finished stream. The download finishes once every byte
+// was served, or when no stream starts within multiDownloadIdle.
+func (d *multiDownload) end() {
+	d.mu.Lock()
+	d.active--
+	idle := d.active == 0
+	if idle {
+		d.idleTimer.Reset(multiDownloadIdle)
+	}
+	d.mu.Unlock()
+	if idle && d.session.Bytes() >= d.size {
+		d.finish()
+	}
+}
+
+func (d *multiDownload) stats() MultiDownloadStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := MultiDownloadStats{SessionID: d.session.ID, Size: d.size, Streams: d.streams, Finished: d.finished}
+	for _, n := range d.served {
+		stats.Bytes += n
+	}
+	for i, n := range d.served {
+		detail := MultiStreamDetail{Stream: i, Bytes: n}
+		if stats.Bytes > 0 {
+			detail.Share = float64(n) / float64(stats.Bytes)
+		}
+		stats.PerStream = append(stats.PerStream, detail)
+	}
+	if elapsed := d.last.Sub(d.first); !d.first.IsZero() && elapsed > 0 {
+		stats.DurationMs = float64(elapsed.Microseconds()) / 1000
+		stats.Mbps = float64(stats.Bytes) * 8 / (1024 * 1024) / elapsed.Seconds()
+	}
+	return stats
+}
+
+// multiDownloadHandler serves /download/multi and /download/multi/{id}[/stats].
+func multiDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/download/multi")
+	if path == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+			return
+		}
+		startMultiDownload(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	id, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	d, ok := lookupMultiDownload(id)
+	if !ok {
+		http.Error(w, tr(r, "Session not found or expired"), http.StatusNotFound)
+		return
+	}
+	switch rest {
+	case "":
+		serveDownloadStream(w, r, d)
+	case "stats":
+		writeJSON(w, d.stats())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// startMultiDownload serves POST /download/multi?size={MB}&streams={n}&chunk={bytes}:
+// it starts the shared session and answers with one suggested range per stream.
+func startMultiDownload(w http.ResponseWriter, r *http.Request) {
+	req, err := parseDownloadRequest(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if req.Congestion != "" {
+		badRequest(w, &paramError{"cc", "is not supported with multi-stream downloads"})
+		return
+	}
+	if req.Duration > 0 {
+		badRequest(w, &paramError{"duration", "is not supported with multi-stream downloads"})
+		return
+	}
+	p := newParamReader(r.URL.Query())
+	streams := p.Int("streams", 4, 1, maxDownloadStreams)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	session, ok := startSession(w, "download", r)
+	if !ok {
+		return
+	}
+	// Each stream arrives on a connection of its own
+	session.mu.Lock()
+	session.conn = nil
+	session.mu.Unlock()
+	session.SetChunkSize(req.ChunkSize)
+	session.SetDownloadGoal(req.SizeBytes, 0)
+
+	d := &multiDownload{session: session, size: req.SizeBytes, streams: streams, chunkSize: req.ChunkSize, generate: newPayloadGenerator(), served: make([]int64, streams)}
+	d.idleTimer = time.AfterFunc(multiDownloadIdle, d.finish)
+	multiDownloads.Lock()
+	multiDownloads.byID[session.ID] = d
+	multiDownloads.Unlock()
+
+	ranges := make([]string, streams)
+	part := req.SizeBytes / int64(streams)
+	for i := range ranges {
+		start, end := int64(i)*part, int64(i+1)*part-1
+		if i == streams-1 {
+			end = req.SizeBytes - 1
+		}
+		ranges[i] = fmt.Sprintf("bytes=%d-%d", start, end)
+	}
+	w.Header().Set("X-Session-ID", session.ID)
+	writeJSON(w, map[string]any{
+		"sessionId": session.ID,
+		"size":      req.SizeBytes,
+		"streams":   streams,
+		"chunkSize": req.ChunkSize,
+		"ranges":    ranges,
+	})
+}
+
+// parseByteRange parses a Range header of a single range within size, e.g.
+// bytes=0-1023 or bytes=1024-. An empty header is the whole file.
+func parseByteRange(header string, size int64) (start, end int64, err error) {
+	if header == "" {
+		return 0, size - 1, nil
+	}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	first, last, ok2 := strings.Cut(spec, "-")
+	if !ok || !ok2 || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("want a single range such as bytes=0-1023")
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 || start >= size {
+		return 0, 0, fmt.Errorf("range start must be between 0 and %d", size-1)
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, fmt.Errorf("range end must not be before its start")
+		}
+		end = min(end, size-1)
+	}
+	return start, end, nil
+}
+
+// serveDownloadStream serves GET /download/multi/{id}?stream={i}: the bytes
+// of the Range header, counted towards the shared session.
+func serveDownloadStream(w http.ResponseWriter, r *http.Request, d *multiDownload) {
+	p := newParamReader(r.URL.Query())
+	stream := p.Int("stream", 0, 0, d.streams-1)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	start, end, err := parseByteRange(r.Header.Get("Range"), d.size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", d.size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if !d.begin() {
+		http.Error(w, tr(r, "Session not found or expired"), http.StatusNotFound)
+		return
+	}
+	defer d.end()
+
+	w.Header().Set("X-Session-ID", d.session.ID)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("X-Chunk-Size", strconv.FormatInt(d.chunkSize, 10))
+	status := http.StatusOK
+	if r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, d.size))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+
+	// Aligned to offsets of the virtual file, so a range has the same content
+	// whichever stream fetches it
+	payload := newDownloadPayload(d.generate, d.chunkSize)
+	for offset := start; offset <= end; {
+		if err := r.Context().Err(); err != nil {
+			if *verbose {
+				log.Printf("Download stream %d canceled at byte %d: %v", stream, offset, err)
+			}
+			return
+		}
+		n := min(d.chunkSize, end-offset+1)
+		if _, err := w.Write(payload.At(offset, n)); err != nil {
+			log.Printf("Download stream write error: %v", err)
+			return
+		}
+		offset += n
+		d.add(stream, n)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
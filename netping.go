@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"go-netspeed/measure"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// HTTP pings include the browser's and the server's request handling, so
+// they overstate the latency of the network itself. With -net-ping a client
+// can opt in to being pinged: POST /api/netping makes the server send ICMP
+// echo requests to the address the request came from and answers with the
+// round trips, and a result saved with the returned netPingId carries them.
+// Only the requesting address is ever pinged, so the endpoint cannot be
+// pointed at third parties. Behind NAT the echoes are answered by the
+// client's router, which is the network path the test is about anyway.
+//
+// The server needs CAP_NET_RAW for raw ICMP sockets, or on Linux a group
+// within net.ipv4.ping_group_range for unprivileged ping sockets.
+
+const (
+	maxNetPings       = 1000
+	netPingMaxAge     = time.Hour
+	netPingTimeout    = time.Second // wait for late echoes after the last request
+	netPingMaxCount   = 100
+	netPingPayloadLen = 56 // like ping(8)
+)
+
+// NetPingResult is the outcome of pinging a client at the network layer.
+type NetPingResult struct {
+	ID          string    `json:"id"`
+	Method      string    `json:"method"` // methodICMPEcho
+	Timestamp   time.Time `json:"timestamp"`
+	Sent        int       `json:"sent"`
+	Received    int       `json:"received"`
+	LatencyMs   float64   `json:"latencyMs"` // mean round trip
+	MinMs       float64   `json:"minMs"`
+	MaxMs       float64   `json:"maxMs"`
+	JitterMs    float64   `json:"jitterMs"`
+	LossPercent float64   `json:"lossPercent"`
+	RTTsMs      []float64 `json:"rttsMs"` // in arrival order
+
+	client string
+}
+
+// netPingStore keeps recent pings until the client saves its result.
+type netPingStore struct {
+	mu    sync.Mutex
+	pings map[string]*NetPingResult
+}
+
+var netPings = &netPingStore{pings: make(map[string]*NetPingResult)}
+
+var netPingsTotal = newCounterVec("netspeed_net_pings_total",
+	"Network-layer pings of clients through /api/netping by result: ok, unreachable (no echo) or failed.", "result")
+
+// netPingLimiter bounds how often each client can have itself pinged.
+var netPingLimiter = newRateLimiter(6, 3)
+
+// Add keeps a ping, pruning expired or excess ones.
+func (s *netPingStore) Add(p *NetPingResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var oldest *NetPingResult
+	for id, existing := range s.pings {
+		if time.Since(existing.Timestamp) > netPingMaxAge {
+			delete(s.pings, id)
+		} else if oldest == nil || existing.Timestamp.Before(oldest.Timestamp) {
+			oldest = existing
+		}
+	}
+	if len(s.pings) >= maxNetPings && oldest != nil {
+		delete(s.pings, oldest.ID)
+	}
+	s.pings[p.ID] = p
+}
+
+// Get returns the ping with an ID, if it is still retained.
+func (s *netPingStore) Get(id string) (*NetPingResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pings[id]
+	return p, ok
+}
+
+// icmpConn is an ICMP socket for echoes to one address family.
+type icmpConn struct {
+	*icmp.PacketConn
+	protocol   int // IANA protocol number of ICMP or ICMPv6
+	privileged bool
+}
+
+var errICMPNotPermitted = errors.New("ICMP sockets are not permitted; run with CAP_NET_RAW or add the server's group to net.ipv4.ping_group_range")
+
+// listenICMP opens a raw ICMP socket, or an unprivileged ping socket when
+// raw sockets are not permitted.
+func listenICMP(ipv6 bool) (*icmpConn, error) {
+	network, pingNetwork, address, protocol := "ip4:icmp", "udp4", "0.0.0.0", 1
+	if ipv6 {
+		network, pingNetwork, address, protocol = "ip6:ipv6-icmp", "udp6", "::", 58
+	}
+	if c, err := icmp.ListenPacket(network, address); err == nil {
+		return &icmpConn{PacketConn: c, protocol: protocol, privileged: true}, nil
+	}
+	c, err := icmp.ListenPacket(pingNetwork, address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errICMPNotPermitted, err)
+	}
+	return &icmpConn{PacketConn: c, protocol: protocol}, nil
+}
+
+// checkNetPing verifies at startup that the server may send ICMP echoes.
+func checkNetPing() error {
+	if !*netPing {
+		return nil
+	}
+	c, err := listenICMP(false)
+	if err != nil {
+		return fmt.Errorf("-net-ping: %w", err)
+	}
+	if !c.privileged {
+		log.Printf("Network pings use unprivileged ping sockets")
+	}
+	return c.Close()
+}
+
+// pingAddr sends count ICMP echo requests to addr, interval apart, and
+// returns the round trips of the echoes in arrival order.
+func pingAddr(ctx context.Context, addr netip.Addr, count int, interval time.Duration) ([]float64, error) {
+	c, err := listenICMP(addr.Is6())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	stop := context.AfterFunc(ctx, func() { c.SetReadDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	var target net.Addr = &net.IPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}
+	if !c.privileged {
+		target = &net.UDPAddr{IP: addr.AsSlice(), Zone: addr.Zone()}
+	}
+	var request, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if addr.Is6() {
+		request, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+	// Unprivileged sockets replace the ID with their port and only receive
+	// their own echoes; raw sockets receive every echo on the host.
+	id := rand.N(0x10000)
+	payload := make([]byte, netPingPayloadLen)
+	for i := range payload {
+		payload[i] = byte(rand.N(256))
+	}
+
+	sent := make([]time.Time, count)
+	var mu sync.Mutex
+	var rtts []float64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1500)
+		echoed := make([]bool, count)
+		for {
+			n, peer, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			received := time.Now()
+			msg, err := icmp.ParseMessage(c.protocol, buf[:n])
+			if err != nil || msg.Type != reply || !samePeer(peer, addr) {
+				continue
+			}
+			echo, ok := msg.Body.(*icmp.Echo)
+			if !ok || (c.privileged && echo.ID != id) || echo.Seq >= count || echoed[echo.Seq] || !slices.Equal(echo.Data, payload) {
+				continue
+			}
+			mu.Lock()
+			if sent[echo.Seq].IsZero() {
+				mu.Unlock()
+				continue
+			}
+			echoed[echo.Seq] = true
+			rtts = append(rtts, float64(received.Sub(sent[echo.Seq]).Microseconds())/1000)
+			mu.Unlock()
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for seq := 0; seq < count; seq++ {
+		if seq > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				c.Close()
+				<-done
+				return nil, ctx.Err()
+			}
+		}
+		packet, err := (&icmp.Message{Type: request, Body: &icmp.Echo{ID: id, Seq: seq, Data: payload}}).Marshal(nil)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		sent[seq] = time.Now()
+		mu.Unlock()
+		if _, err := c.WriteTo(packet, target); err != nil {
+			c.Close()
+			<-done
+			return nil, err
+		}
+	}
+	c.SetReadDeadline(time.Now().Add(netPingTimeout))
+	<-done
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return rtts, nil
+}
+
+func samePeer(peer net.Addr, addr netip.Addr) bool {
+	var ip net.IP
+	switch p := peer.(type) {
+	case *net.IPAddr:
+		ip = p.IP
+	case *net.UDPAddr:
+		ip = p.IP
+	}
+	peerAddr, ok := netip.AddrFromSlice(ip)
+	return ok && peerAddr.Unmap() == addr.WithZone("")
+}
+
+// newNetPingResult summarizes the round trips of count echo requests.
+func newNetPingResult(id string, rtts []float64, count int) *NetPingResult {
+	p := &NetPingResult{
+		ID:        id,
+		Method:    methodICMPEcho,
+		Timestamp: time.Now(),
+		Sent:      count,
+		Received:  len(rtts),
+		LatencyMs: roundMs(measure.MeanLatency(rtts)),
+		RTTsMs:    rtts,
+	}
+	jitter, loss := measure.JitterLoss(rtts, count)
+	p.JitterMs, p.LossPercent = roundMs(jitter), loss
+	if len(rtts) > 0 {
+		p.MinMs, p.MaxMs = slices.Min(rtts), slices.Max(rtts)
+	}
+	if p.RTTsMs == nil {
+		p.RTTsMs = []float64{}
+	}
+	return p
+}
+
+func roundMs(ms float64) float64 {
+	return math.Round(ms*1000) / 1000
+}
+
+// netPingHandler serves POST /api/netping?count=20&interval=100ms: the server
+// pings the requesting client and answers with the round trips. The ping's ID
+// is saved with a result as netPingId.
+func netPingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	p := newParamReader(r.URL.Query())
+	count := p.Int("count", 20, 1, netPingMaxCount)
+	interval := p.Duration("interval", 100*time.Millisecond, 20*time.Millisecond, time.Second)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	addr, err := netip.ParseAddr(requestClientIP(r))
+	if err != nil {
+		http.Error(w, tr(r, "Cannot ping this client"), http.StatusBadRequest)
+		return
+	}
+	if !netPingLimiter.Allow(addr.Unmap().String()) {
+		http.Error(w, tr(r, "Too many requests"), http.StatusTooManyRequests)
+		return
+	}
+	session, ok := startSession(w, "netping", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+
+	rtts, err := pingAddr(r.Context(), addr.Unmap(), count, interval)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		netPingsTotal.Inc("failed")
+		log.Printf("Failed to ping %s: %v", loggedAddr(session.Client), err)
+		http.Error(w, tr(r, "Failed to ping this client"), http.StatusInternalServerError)
+		return
+	}
+	result := newNetPingResult(session.ID, rtts, count)
+	result.client = session.Client
+	if result.Received == 0 {
+		netPingsTotal.Inc("unreachable")
+	} else {
+		netPingsTotal.Inc("ok")
+	}
+	netPings.Add(result)
+	if *verbose {
+		log.Printf("Pinged %s: %d/%d echoes, %.3fms mean", loggedAddr(session.Client), result.Received, result.Sent, result.LatencyMs)
+	}
+	writeJSON(w, result)
+}
+
+// attachNetPing replaces the result's netPingId with the ping it names, when
+// that ping was of the client saving the result.
+func attachNetPing(result *TestResult) {
+	id := result.NetPingID
+	result.NetPingID, result.NetPing = "", nil
+	if p, ok := netPings.Get(id); ok && p.client == result.ClientIP {
+		result.NetPing = p
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	notifyTimeout       = 10 * time.Second
+	webhookRetryBackoff = 2 * time.Second // doubled after every failed attempt
+)
+
+// NotificationEvent is the data passed to notifiers and webhook templates.
+type NotificationEvent struct {
+	Type      string     `json:"type"` // "result" or "alert"
+	Server    string     `json:"server"`
+	Result    TestResult `json:"result"`
+	ResultURL string     `json:"resultUrl,omitempty"`
+	Alert     *Alert     `json:"alert,omitempty"` // set for "alert"
+	Time      time.Time  `json:"time"`
+}
+
+// Notifier delivers events to an external service.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, event NotificationEvent) error
+}
+
+// notifiers are the configured notification drivers, set up at startup.
+var notifiers []Notifier
+
+// notifyResult sends a saved result to every notifier in the background.
+// resultURL may be empty when the server's public URL is unknown.
+// It also checks the result against the alert rules.
+func notifyResult(result TestResult, resultURL string) {
+	checkAlerts(result, resultURL)
+	dispatchEvent(NotificationEvent{Type: "result", Server: serverHostname(), Result: result, ResultURL: resultURL, Time: time.Now()})
+}
+
+// dispatchEvent sends an event to every notifier in the background.
+func dispatchEvent(event NotificationEvent) {
+	for _, n := range notifiers {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := n.Notify(ctx, event); err != nil {
+				log.Printf("Notification via %s failed: %v", n.Name(), err)
+			} else if *verbose {
+				log.Printf("Sent %s of result %s via %s", event.Type, event.Result.ID, n.Name())
+			}
+		}()
+	}
+}
+
+func serverHostname() string {
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// shareURL builds the link to a saved result from the server's base URL.
+func shareURL(base, id string) string {
+	if base == "" || id == "" {
+		return ""
+	}
+	return strings.TrimSuffix(base, "/") + "/share/" + id
+}
+
+// --- Webhook driver ---
+
+// webhookPreset is a built-in payload template and its content type.
+type webhookPreset struct {
+	ContentType string
+	Template    string
+}
+
+var webhookPresets = map[string]webhookPreset{
+	"json": {
+		ContentType: "application/json",
+		Template:    `{{json .}}`,
+	},
+	"slack": {
+		ContentType: "application/json",
+		Template: `{"text": {{if .Alert}}{{json (title .)}}{{else}}{{json (printf (tr "Speed test on %s: %.1f down / %.1f up Mbps") .Server .Result.DownloadSpeedMbps .Result.UploadSpeedMbps)}}{{end}},
+"blocks": [
+  {"type": "header", "text": {"type": "plain_text", "text": {{json (title .)}}}},
+  {{if .Result.ID}}{"type": "section", "fields": [
+    {"type": "mrkdwn", "text": {{json (printf (tr "*Download*\n%.2f Mbps") .Result.DownloadSpeedMbps)}}},
+    {"type": "mrkdwn", "text": {{json (printf (tr "*Upload*\n%.2f Mbps") .Result.UploadSpeedMbps)}}},
+    {"type": "mrkdwn", "text": {{json (printf (tr "*Latency*\n%.2f ms") .Result.LatencyMs)}}},
+    {"type": "mrkdwn", "text": {{json (printf (tr "*Jitter / Loss*\n%.2f ms / %.2f%%") .Result.JitterMs .Result.PacketLossPercent)}}}
+  ]}{{else}}{"type": "section", "text": {"type": "plain_text", "text": {{json (summary .)}}}}{{end}}{{if .ResultURL}},
+  {"type": "context", "elements": [{"type": "mrkdwn", "text": {{json (printf "<%s|%s>" .ResultURL (tr "View result"))}}}]}{{end}}
+]}`,
+	},
+	"ntfy": {
+		ContentType: "text/plain",
+		Template: `{{summary .}}{{if .ResultURL}}
+{{.ResultURL}}{{end}}`,
+	},
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"round": func(v float64, places int) float64 {
+		p := math.Pow(10, float64(places))
+		return math.Round(v*p) / p
+	},
+	// tr translates its argument into the -locale language.
+	"tr": func(text string) string {
+		return localize(*defaultLocale, text)
+	},
+	"title":   resultTitle,
+	"summary": resultSummary,
+}
+
+// webhookNotifier POSTs a templated payload to a URL.
+type webhookNotifier struct {
+	url         string
+	contentType string
+	tmpl        *template.Template
+	headers     map[string]string
+	secret      []byte // signs payloads when set
+	retries     int
+	client      *http.Client
+}
+
+// newWebhookNotifier builds a webhook from a preset name, or from a Go
+// template file when templatePath is set (the preset then only picks the
+// content type, defaulting to JSON).
+func newWebhookNotifier(url, format, templatePath string) (*webhookNotifier, error) {
+	if format == "" {
+		format = "json"
+	}
+	preset, ok := webhookPresets[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown webhook format %q (want json, slack or ntfy)", format)
+	}
+	text := preset.Template
+	if templatePath != "" {
+		data, err := os.ReadFile(templatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook template: %w", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+
+	proxy, err := notifierProxy("webhook-proxy", *webhookProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &webhookNotifier{url: url, contentType: preset.ContentType, tmpl: tmpl, headers: map[string]string{}, retries: *webhookRetries, client: proxy.httpClient()}
+	if *webhookSecret != "" {
+		w.secret = []byte(*webhookSecret)
+	}
+	if format == "ntfy" {
+		w.headers["Title"] = localize(*defaultLocale, "Speed test result")
+		w.headers["Tags"] = "signal_strength"
+	}
+	return w, nil
+}
+
+func (w *webhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Notify delivers the payload, retrying network errors, 429 and 5xx
+// responses. Every attempt gets notifyTimeout, and all carry the same
+// X-Netspeed-Delivery ID so receivers can drop duplicates.
+func (w *webhookNotifier) Notify(ctx context.Context, event NotificationEvent) error {
+	var body bytes.Buffer
+	if err := w.tmpl.Execute(&body, event); err != nil {
+		return fmt.Errorf("failed to render payload: %w", err)
+	}
+	headers := maps.Clone(w.headers)
+	headers["X-Netspeed-Delivery"] = uuid.NewString()
+
+	ctx = context.WithoutCancel(ctx)
+	backoff := webhookRetryBackoff
+	for attempt := 0; ; attempt++ {
+		if w.secret != nil {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			headers["X-Netspeed-Timestamp"] = timestamp
+			headers["X-Netspeed-Signature"] = "sha256=" + signWebhook(w.secret, timestamp, body.Bytes())
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		err := postNotification(attemptCtx, w.client, w.url, w.contentType, headers, bytes.NewBuffer(body.Bytes()))
+		cancel()
+		var status *notifyStatusError
+		if err == nil || attempt >= w.retries ||
+			errors.As(err, &status) && status.StatusCode != http.StatusTooManyRequests && status.StatusCode < 500 {
+			return err
+		}
+		log.Printf("Webhook delivery to %s failed, retrying in %s: %v", w.url, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "{timestamp}.{body}", the value
+// of the X-Netspeed-Signature header after "sha256=".
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyStatusError is a non-2xx response to a notification.
+type notifyStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *notifyStatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d", e.URL, e.StatusCode)
+}
+
+// postNotification sends a payload and treats any non-2xx status as an error.
+func postNotification(ctx context.Context, client *http.Client, url, contentType string, headers map[string]string, body *bytes.Buffer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &notifyStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// setupNotifiers configures the notification drivers from flags.
+func setupNotifiers() error {
+	if *webhookRetries < 0 {
+		return fmt.Errorf("-webhook-retries must not be negative")
+	}
+	for _, url := range strings.Split(*webhookURL, ",") {
+		if url = strings.TrimSpace(url); url == "" {
+			continue
+		}
+		w, err := newWebhookNotifier(url, *webhookFormat, *webhookTemplate)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, w)
+	}
+	if *ntfyTopic != "" {
+		proxy, err := notifierProxy("ntfy-proxy", *ntfyProxy)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, &ntfyNotifier{server: *ntfyServer, topic: *ntfyTopic, token: *ntfyToken, client: proxy.httpClient()})
+	}
+	if *gotifyServer != "" {
+		if *gotifyToken == "" {
+			return fmt.Errorf("-gotify-url requires -gotify-token")
+		}
+		proxy, err := notifierProxy("gotify-proxy", *gotifyProxy)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, &gotifyNotifier{server: *gotifyServer, token: *gotifyToken, client: proxy.httpClient()})
+	}
+	if *syslogAddr != "" {
+		s, err := newSyslogNotifier(*syslogAddr, *syslogFacility)
+		if err != nil {
+			return err
+		}
+		if s.network == "udp" && *syslogProxy != "" && *syslogProxy != "direct" {
+			return fmt.Errorf("-syslog-proxy needs a tcp:// or tls:// -syslog-addr, UDP cannot be proxied")
+		}
+		if s.proxy, err = notifierProxy("syslog-proxy", *syslogProxy); err != nil {
+			return err
+		}
+		notifiers = append(notifiers, s)
+	}
+	if *mqttBroker != "" {
+		m, err := newMQTTNotifier(*mqttBroker)
+		if err != nil {
+			return err
+		}
+		if m.proxy, err = notifierProxy("mqtt-proxy", *mqttProxy); err != nil {
+			return err
+		}
+		mqttPublisher = m
+		notifiers = append(notifiers, m)
+	}
+	if len(notifiers) > 0 {
+		log.Printf("Sending result notifications to %d receivers", len(notifiers))
+	}
+	return nil
+}
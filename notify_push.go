@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// resultSummary is the plain-text message used by the push notification drivers.
+func resultSummary(event NotificationEvent) string {
+	if a := event.Alert; a != nil && isCertExpiryRule(a.Rule) {
+		if a.Value <= 0 {
+			return localize(*defaultLocale, "The TLS certificate has expired")
+		}
+		return fmt.Sprintf(localize(*defaultLocale, "The TLS certificate expires in %.1f days"), a.Value)
+	}
+	r := event.Result
+	return fmt.Sprintf(localize(*defaultLocale, "Download %.2f Mbps, upload %.2f Mbps\nLatency %.2f ms, jitter %.2f ms, loss %.2f%%"),
+		r.DownloadSpeedMbps, r.UploadSpeedMbps, r.LatencyMs, r.JitterMs, r.PacketLossPercent)
+}
+
+func resultTitle(event NotificationEvent) string {
+	if event.Alert != nil {
+		return alertTitle(event)
+	}
+	return fmt.Sprintf(localize(*defaultLocale, "Speed test on %s"), event.Server)
+}
+
+// ntfyNotifier publishes to an ntfy topic (https://ntfy.sh or self-hosted).
+type ntfyNotifier struct {
+	server string
+	topic  string
+	token  string
+	client *http.Client
+}
+
+func (n *ntfyNotifier) Name() string {
+	return "ntfy"
+}
+
+func (n *ntfyNotifier) Notify(ctx context.Context, event NotificationEvent) error {
+	headers := map[string]string{
+		"Title": resultTitle(event),
+		"Tags":  "signal_strength",
+	}
+	if event.ResultURL != "" {
+		headers["Click"] = event.ResultURL
+	}
+	if n.token != "" {
+		headers["Authorization"] = "Bearer " + n.token
+	}
+	url := strings.TrimSuffix(n.server, "/") + "/" + n.topic
+	return postNotification(ctx, n.client, url, "text/plain", headers, bytes.NewBufferString(resultSummary(event)))
+}
+
+// gotifyNotifier sends messages to a Gotify server using an application token.
+type gotifyNotifier struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+func (g *gotifyNotifier) Name() string {
+	return "gotify"
+}
+
+func (g *gotifyNotifier) Notify(ctx context.Context, event NotificationEvent) error {
+	message := map[string]any{
+		"title":    resultTitle(event),
+		"message":  resultSummary(event),
+		"priority": 5,
+	}
+	if event.ResultURL != "" {
+		message["extras"] = map[string]any{
+			"client::notification": map[string]any{"click": map[string]string{"url": event.ResultURL}},
+		}
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(g.server, "/") + "/message"
+	return postNotification(ctx, g.client, url, "application/json", map[string]string{"X-Gotify-Key": g.token}, bytes.NewBuffer(body))
+}
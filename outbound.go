@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/pion/transport/v3"
+	"github.com/pion/transport/v3/stdnet"
+	"github.com/pion/webrtc/v4"
+)
+
+// outboundSource is -probe-source, resolved by validateOutbound.
+var outboundSource net.IP
+
+// validateOutbound checks -probe-interface, -probe-source and -probe-fwmark,
+// which bind the connections of probes and peer tests to one uplink.
+func validateOutbound() error {
+	if *probeSource != "" {
+		if outboundSource = net.ParseIP(*probeSource); outboundSource == nil {
+			return fmt.Errorf("-probe-source %q is not an IP address", *probeSource)
+		}
+	}
+	if *probeInterface != "" {
+		if _, err := net.InterfaceByName(*probeInterface); err != nil {
+			return fmt.Errorf("-probe-interface: %w", err)
+		}
+	}
+	if *probeFwmark < 0 {
+		return fmt.Errorf("-probe-fwmark must not be negative")
+	}
+	if (*probeInterface != "" || *probeFwmark != 0) && !socketBindingSupported {
+		return fmt.Errorf("-probe-interface and -probe-fwmark are only supported on Linux")
+	}
+	return nil
+}
+
+// outboundBound reports whether outbound connections are bound to an uplink.
+func outboundBound() bool {
+	return *probeInterface != "" || outboundSource != nil || *probeFwmark != 0
+}
+
+// outboundLabel names the uplink outbound connections use, for tagging results.
+func outboundLabel() string {
+	switch {
+	case *probeInterface != "":
+		return *probeInterface
+	case outboundSource != nil:
+		return outboundSource.String()
+	case *probeFwmark != 0:
+		return fmt.Sprintf("fwmark %d", *probeFwmark)
+	}
+	return ""
+}
+
+// outboundControl applies the interface and fwmark to a socket before it
+// connects or binds.
+func outboundControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = bindSocket(fd, *probeInterface, *probeFwmark)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// outboundTransport is an HTTP transport whose connections leave through the
+// configured uplink.
+func outboundTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if !outboundBound() {
+		return t
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: outboundControl}
+	if outboundSource != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: outboundSource}
+	}
+	t.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+	return t
+}
+
+// outboundWebRTCAPI creates WebRTC peer connections whose ICE candidates are
+// gathered on the configured uplink only.
+func outboundWebRTCAPI() (*webrtc.API, error) {
+	s := webrtc.SettingEngine{}
+	if !outboundBound() {
+		return webrtc.NewAPI(webrtc.WithSettingEngine(s)), nil
+	}
+	if *probeInterface != "" {
+		s.SetInterfaceFilter(func(name string) bool { return name == *probeInterface })
+		if iface, err := net.InterfaceByName(*probeInterface); err == nil && iface.Flags&net.FlagLoopback != 0 {
+			s.SetIncludeLoopbackCandidate(true)
+		}
+	}
+	if outboundSource != nil {
+		s.SetIPFilter(func(ip net.IP) bool { return ip.Equal(outboundSource) })
+		if outboundSource.IsLoopback() {
+			s.SetIncludeLoopbackCandidate(true)
+		}
+	}
+	base, err := stdnet.NewNet()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate interfaces: %w", err)
+	}
+	s.SetNet(&outboundNet{Net: base})
+	return webrtc.NewAPI(webrtc.WithSettingEngine(s)), nil
+}
+
+// outboundNet applies outboundControl to the UDP sockets ICE gathers on.
+type outboundNet struct {
+	*stdnet.Net
+}
+
+func (n *outboundNet) ListenUDP(network string, laddr *net.UDPAddr) (transport.UDPConn, error) {
+	c, err := n.Net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := controlUDP(c, network); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (n *outboundNet) ListenPacket(network, address string) (net.PacketConn, error) {
+	c, err := n.Net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := controlUDP(c, network); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func controlUDP(c any, network string) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if err := outboundControl(network, "", raw); err != nil {
+		return fmt.Errorf("failed to bind WebRTC socket to the probe uplink: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import "syscall"
+
+const socketBindingSupported = true
+
+// bindSocket sets SO_BINDTODEVICE and SO_MARK on fd. Setting a mark needs
+// CAP_NET_ADMIN.
+func bindSocket(fd uintptr, iface string, mark int) error {
+	if iface != "" {
+		if err := syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface); err != nil {
+			return err
+		}
+	}
+	if mark != 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+// Binding sockets to an interface or fwmark is Linux-specific; -probe-source
+// works everywhere.
+const socketBindingSupported = false
+
+func bindSocket(fd uintptr, iface string, mark int) error {
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Results are public to whoever knows their ID, and /history/{clientId} to
+// whoever knows the client ID, which only the saving device has. With
+// -my-results, the server also gives each browser that saves a result an
+// anonymous owner token in a cookie, and lists the results saved with it at
+// /api/my/results. Only a hash of the token is stored, so the list cannot be
+// read without the cookie.
+
+// ownerCookieName is the cookie holding the owner token.
+const ownerCookieName = "netspeed_owner"
+
+// ownerCookieMaxAge is how long browsers keep the owner cookie; most cap
+// cookies at 400 days.
+const ownerCookieMaxAge = 400 * 24 * time.Hour
+
+// maxOwnedResults bounds a GET /api/my/results response.
+const maxOwnedResults = 1000
+
+// OwnerStore is implemented by result stores that remember which owner
+// saved a result. Claims are deleted along with their result.
+type OwnerStore interface {
+	// ClaimResult records that owner saved an existing result.
+	ClaimResult(owner, resultID string) error
+	// OwnedResults returns the IDs of up to limit of owner's results, newest first.
+	OwnedResults(owner string, limit int) ([]string, error)
+	// OwnsResult reports whether owner saved a result.
+	OwnsResult(owner, resultID string) (bool, error)
+}
+
+// ownerHash returns the owner a token stands for, as stored.
+func ownerHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// requestOwner returns the owner of the request's cookie, if any.
+func requestOwner(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(ownerCookieName)
+	if err != nil {
+		return "", false
+	}
+	token, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(token) != 32 {
+		return "", false
+	}
+	return ownerHash(cookie.Value), true
+}
+
+// ensureOwner returns the owner of the request's cookie, setting a new
+// cookie when the request carries none.
+func ensureOwner(w http.ResponseWriter, r *http.Request) string {
+	if owner, ok := requestOwner(r); ok {
+		return owner
+	}
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	http.SetCookie(w, &http.Cookie{
+		Name:     ownerCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(ownerCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return ownerHash(token)
+}
+
+// claimResult files a just saved result under the request's owner cookie.
+func claimResult(w http.ResponseWriter, r *http.Request, resultID string) {
+	if !*myResults {
+		return
+	}
+	store, ok := optionalStore[OwnerStore]()
+	if !ok {
+		return
+	}
+	if err := store.ClaimResult(ensureOwner(w, r), resultID); err != nil {
+		log.Printf("Failed to record the owner of result %s: %v", resultID, err)
+	}
+}
+
+// ownerDeletes reports whether browsers may delete the results they saved
+// with DELETE /results/{id}.
+func ownerDeletes() bool {
+	_, ok := optionalStore[OwnerStore]()
+	return *myResults && ok && !*deleteRequiresAdmin
+}
+
+// ownsResult reports whether the request's owner cookie saved a result.
+func ownsResult(r *http.Request, resultID string) bool {
+	if !*myResults {
+		return false
+	}
+	store, ok := optionalStore[OwnerStore]()
+	if !ok {
+		return false
+	}
+	owner, ok := requestOwner(r)
+	if !ok {
+		return false
+	}
+	owned, err := store.OwnsResult(owner, resultID)
+	if err != nil {
+		log.Printf("Failed to check the owner of result %s: %v", resultID, err)
+	}
+	return owned
+}
+
+// ownerStore returns the store as an OwnerStore and the request's owner, or
+// responds.
+func ownerStore(w http.ResponseWriter, r *http.Request) (OwnerStore, string, bool) {
+	if !*myResults {
+		http.Error(w, tr(r, "Result ownership is disabled on this server"), http.StatusNotFound)
+		return nil, "", false
+	}
+	store, ok := optionalStore[OwnerStore]()
+	if !ok {
+		http.Error(w, tr(r, "Result ownership is not supported by this store"), http.StatusNotImplemented)
+		return nil, "", false
+	}
+	w.Header().Set("Vary", "Cookie")
+	owner, ok := requestOwner(r)
+	if !ok {
+		http.Error(w, tr(r, "No results were saved from this browser"), http.StatusUnauthorized)
+		return nil, "", false
+	}
+	return store, owner, true
+}
+
+// myResultsHandler serves GET /api/my/results?limit=: the results saved with
+// the request's owner cookie, newest first.
+func myResultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	p := newParamReader(r.URL.Query())
+	limit := p.Int("limit", 100, 1, maxOwnedResults)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	store, owner, ok := ownerStore(w, r)
+	if !ok {
+		return
+	}
+
+	ids, err := store.OwnedResults(owner, limit)
+	if err != nil {
+		log.Printf("Failed to list owned results: %v", err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	results := []TestResult{}
+	for _, id := range ids {
+		result, err := globalStore.Load(id)
+		if err != nil {
+			// Expired between the listing and the load
+			if !strings.Contains(err.Error(), "result not found") {
+				log.Printf("Failed to load owned result %s: %v", id, err)
+			}
+			continue
+		}
+		results = append(results, publicResult(result))
+	}
+	writeJSON(w, map[string]any{"results": results})
+}
+
+// myResultHandler serves DELETE /api/my/results/{id}, which deletes a result
+// saved with the request's owner cookie. With -delete-requires-admin, the
+// request also needs the admin token.
+func myResultHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, tr(r, "Only DELETE method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	if *deleteRequiresAdmin && !isAdminRequest(r) {
+		http.Error(w, tr(r, "Deleting results requires the admin token"), http.StatusForbidden)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/my/results/")
+	store, owner, ok := ownerStore(w, r)
+	if !ok {
+		return
+	}
+	owned, err := store.OwnsResult(owner, id)
+	if err != nil {
+		log.Printf("Failed to check the owner of result %s: %v", id, err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	if !owned {
+		http.Error(w, tr(r, "Result not found"), http.StatusNotFound)
+		return
+	}
+	deleteResultHandler(w, r, id)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// saveOwnedResult saves a result with -my-results and returns its ID and the
+// owner cookie it was filed under.
+func saveOwnedResult(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	saveResultHandler(w, newSaveRequest(`{"downloadSpeedMbps": 10}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /save-result = %d %s", w.Code, w.Body)
+	}
+	var response struct{ ID string }
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == ownerCookieName {
+			return response.ID, cookie
+		}
+	}
+	t.Fatal("POST /save-result set no owner cookie")
+	return "", nil
+}
+
+func TestMyResultDeleteRequiresAdmin(t *testing.T) {
+	defer func(mine, requiresAdmin bool, token string) {
+		*myResults, *deleteRequiresAdmin, *adminToken = mine, requiresAdmin, token
+	}(*myResults, *deleteRequiresAdmin, *adminToken)
+	*myResults, *adminToken = true, "admin-secret"
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	globalStore = store
+
+	tests := []struct {
+		requiresAdmin bool
+		adminToken    string
+		want          int
+	}{
+		{false, "", http.StatusNoContent},
+		{true, "", http.StatusForbidden},
+		{true, "wrong", http.StatusForbidden},
+		{true, "admin-secret", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		*deleteRequiresAdmin = tt.requiresAdmin
+		id, cookie := saveOwnedResult(t)
+		r := httptest.NewRequest(http.MethodDelete, "/api/my/results/"+id, nil)
+		r.AddCookie(cookie)
+		if tt.adminToken != "" {
+			r.Header.Set("X-Admin-Token", tt.adminToken)
+		}
+		w := httptest.NewRecorder()
+		myResultHandler(w, r)
+		if w.Code != tt.want {
+			t.Errorf("DELETE with -delete-requires-admin=%v and token %q = %d, want %d", tt.requiresAdmin, tt.adminToken, w.Code, tt.want)
+		}
+		_, err := store.Load(id)
+		if deleted := err != nil; deleted != (tt.want == http.StatusNoContent) {
+			t.Errorf("DELETE with -delete-requires-admin=%v and token %q: deleted = %v", tt.requiresAdmin, tt.adminToken, deleted)
+		}
+	}
+}
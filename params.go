@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paramError describes an invalid request parameter; handlers answer it with
+// 400 and the message, instead of silently falling back to a default.
+type paramError struct {
+	Param  string
+	Reason string
+}
+
+func (e *paramError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Reason)
+}
+
+// paramReader parses typed query parameters. Missing parameters take their
+// default; malformed or out-of-range ones record the first error, so a
+// handler can read everything and check Err once.
+type paramReader struct {
+	values url.Values
+	err    error
+}
+
+func newParamReader(q url.Values) *paramReader {
+	return &paramReader{values: q}
+}
+
+func (p *paramReader) fail(name, format string, args ...any) {
+	if p.err == nil {
+		p.err = &paramError{Param: name, Reason: fmt.Sprintf(format, args...)}
+	}
+}
+
+// Err returns the first invalid parameter, if any.
+func (p *paramReader) Err() error {
+	return p.err
+}
+
+func (p *paramReader) raw(name string) (string, bool) {
+	v := strings.TrimSpace(p.values.Get(name))
+	return v, v != ""
+}
+
+// Int64 reads an integer in [lo, hi].
+func (p *paramReader) Int64(name string, def, lo, hi int64) int64 {
+	s, ok := p.raw(name)
+	if !ok {
+		return def
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		p.fail(name, "%q is not an integer", s)
+		return def
+	}
+	if v < lo {
+		p.fail(name, "%d is below the minimum of %d", v, lo)
+		return def
+	}
+	if v > hi {
+		p.fail(name, "%d is above the maximum of %d", v, hi)
+		return def
+	}
+	return v
+}
+
+// Int reads an integer in [lo, hi].
+func (p *paramReader) Int(name string, def, lo, hi int) int {
+	return int(p.Int64(name, int64(def), int64(lo), int64(hi)))
+}
+
+// Float reads a finite number greater than lo and at most hi.
+func (p *paramReader) Float(name string, def, lo, hi float64) float64 {
+	s, ok := p.raw(name)
+	if !ok {
+		return def
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		p.fail(name, "%q is not a number", s)
+		return def
+	}
+	if v <= lo || v > hi {
+		p.fail(name, "%g must be greater than %g and at most %g", v, lo, hi)
+		return def
+	}
+	return v
+}
+
+// OptionalFloat reads a finite number; nil when missing.
+func (p *paramReader) OptionalFloat(name string) *float64 {
+	s, ok := p.raw(name)
+	if !ok {
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		p.fail(name, "%q is not a number", s)
+		return nil
+	}
+	return &v
+}
+
+// OptionalBool reads true or false; nil when missing.
+func (p *paramReader) OptionalBool(name string) *bool {
+	s := p.Enum(name, "", "true", "false")
+	if s == "" {
+		return nil
+	}
+	b := s == "true"
+	return &b
+}
+
+// Enum reads one of the allowed values.
+func (p *paramReader) Enum(name, def string, allowed ...string) string {
+	s, ok := p.raw(name)
+	if !ok {
+		return def
+	}
+	for _, a := range allowed {
+		if s == a {
+			return s
+		}
+	}
+	p.fail(name, "%q is not one of %s", s, strings.Join(allowed, ", "))
+	return def
+}
+
+// Duration reads a Go duration such as 90m or 2h in [lo, hi].
+func (p *paramReader) Duration(name string, def, lo, hi time.Duration) time.Duration {
+	s, ok := p.raw(name)
+	if !ok {
+		return def
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		p.fail(name, "%q is not a duration such as 90m or 2h", s)
+		return def
+	}
+	if v < lo || v > hi {
+		p.fail(name, "%s must be between %s and %s", v, lo, hi)
+		return def
+	}
+	return v
+}
+
+// Time reads an RFC 3339 timestamp; the zero time when missing.
+func (p *paramReader) Time(name string) time.Time {
+	s, ok := p.raw(name)
+	if !ok {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		p.fail(name, "%q is not an RFC 3339 timestamp", s)
+		return time.Time{}
+	}
+	return t
+}
+
+// badRequest answers a parse error with 400.
+func badRequest(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// --- Typed requests ---
+
+// maxSizeParamMB bounds ?size= before server limits are applied, so the byte
+// count cannot overflow.
+const maxSizeParamMB = math.MaxInt64 / (1024 * 1024)
+
+// DownloadRequest is the parsed query of /download.
+type DownloadRequest struct {
+	SizeBytes  int64         // clamped to -min-size and -maxsize
+	Duration   time.Duration // stream for this long instead of SizeBytes; zero for a fixed size
+	ChunkSize  int64         // clamped to minDownloadChunkSize and -chunksize
+	Congestion string        // TCP congestion control to serve the download with; empty for the listener's
+}
+
+// parseDownloadRequest reads ?size= (MB) or ?duration= (e.g. 10s), ?chunk=
+// (bytes) and ?cc= (a congestion control algorithm). Sizes outside the
+// configured limits are clamped to them; malformed values are errors.
+func parseDownloadRequest(q url.Values) (DownloadRequest, error) {
+	p := newParamReader(q)
+	sizeMB := p.Int64("size", *defaultSize, 1, maxSizeParamMB)
+	var duration time.Duration
+	if _, ok := p.raw("duration"); ok {
+		if _, sized := p.raw("size"); sized {
+			p.fail("duration", "cannot be combined with size")
+		} else if *maxDownloadTime <= 0 {
+			p.fail("duration", "duration-based downloads are disabled on this server")
+		} else {
+			duration = p.Duration("duration", 0, time.Second, *maxDownloadTime)
+		}
+	}
+	chunk := p.Int64("chunk", 0, 1, math.MaxInt64)
+	var cc string
+	if _, ok := p.raw("cc"); ok {
+		if len(congestionControls) == 0 {
+			p.fail("cc", "congestion control selection is not supported by this server")
+		} else {
+			cc = p.Enum("cc", "", congestionControls...)
+		}
+	}
+	if err := p.Err(); err != nil {
+		return DownloadRequest{}, err
+	}
+
+	// Enforce size limits from flags (validated against the global cap at startup)
+	sizeMB = max(*minSize, min(sizeMB, *maxDownloadSize))
+
+	chunkSize := int64(*downloadChunkSize)
+	if chunkSize <= 0 {
+		chunkSize = 1024 * 1024 // Fallback 1MB
+	}
+	if chunk > 0 {
+		// The configured chunk size is the upper bound, since it caps per-request memory
+		chunkSize = min(max(chunk, minDownloadChunkSize), chunkSize)
+	}
+	return DownloadRequest{SizeBytes: sizeMB * 1024 * 1024, Duration: duration, ChunkSize: chunkSize, Congestion: cc}, nil
+}
+
+// UploadRequest is the parsed query of /upload.
+type UploadRequest struct {
+	Echo bool // stream acks of the bytes received while the upload runs
+}
+
+func parseUploadRequest(q url.Values) (UploadRequest, error) {
+	p := newParamReader(q)
+	req := UploadRequest{Echo: p.Enum("echo", "0", "0", "1") == "1"}
+	return req, p.Err()
+}
+
+// PageRequest is the ?offset= and ?limit= of paginated listings.
+type PageRequest struct {
+	Offset int
+	Limit  int
+}
+
+func parsePageRequest(q url.Values) (PageRequest, error) {
+	p := newParamReader(q)
+	page := PageRequest{
+		Offset: p.Int("offset", 0, 0, math.MaxInt32),
+		Limit:  p.Int("limit", 50, 1, 500),
+	}
+	return page, p.Err()
+}
+
+// ListRequest is the parsed query of GET /results.
+type ListRequest struct {
+	Cursor string
+	Limit  int
+}
+
+func parseListRequest(q url.Values) (ListRequest, error) {
+	p := newParamReader(q)
+	req := ListRequest{
+		Cursor: strings.TrimSpace(q.Get("cursor")),
+		Limit:  p.Int("limit", 50, 1, 500),
+	}
+	return req, p.Err()
+}
+
+// parseLookbackHours reads ?hours= for history endpoints, up to a year.
+func parseLookbackHours(q url.Values, def float64) (float64, error) {
+	p := newParamReader(q)
+	hours := p.Float("hours", def, 0, 366*24)
+	return hours, p.Err()
+}
+
+// StatsRequest is the parsed query of /stats: an optional time range, and
+// whether to count only results measured with or without other clients
+// testing at the same time.
+type StatsRequest struct {
+	From, To  time.Time
+	Contended *bool
+}
+
+func parseStatsRequest(q url.Values) (StatsRequest, error) {
+	p := newParamReader(q)
+	req := StatsRequest{From: p.Time("from"), To: p.Time("to"), Contended: p.OptionalBool("contended")}
+	if err := p.Err(); err != nil {
+		return req, err
+	}
+	if !req.From.IsZero() && !req.To.IsZero() && !req.From.Before(req.To) {
+		return req, &paramError{Param: "to", Reason: "must be after from"}
+	}
+	return req, nil
+}
+
+// TrendsRequest is the parsed query of /admin/api/trends.
+type TrendsRequest struct {
+	Period  string
+	Periods int
+}
+
+func parseTrendsRequest(q url.Values) (TrendsRequest, error) {
+	p := newParamReader(q)
+	req := TrendsRequest{
+		Period:  p.Enum("period", "daily", "daily", "weekly"),
+		Periods: p.Int("periods", 30, 1, 1000),
+	}
+	return req, p.Err()
+}
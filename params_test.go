@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// parseQuery parses a fuzzed query string, skipping ones url.ParseQuery
+// rejects, since handlers never see those.
+func parseQuery(t *testing.T, raw string) url.Values {
+	q, err := url.ParseQuery(raw)
+	if err != nil {
+		t.Skip()
+	}
+	return q
+}
+
+// checkParamError fails unless err is nil or a *paramError.
+func checkParamError(t *testing.T, raw string, err error) {
+	var pe *paramError
+	if err != nil && !errors.As(err, &pe) {
+		t.Fatalf("%q: error %v (%T) is not a *paramError", raw, err, err)
+	}
+}
+
+func addQuerySeeds(f *testing.F, seeds ...string) {
+	for _, s := range append(seeds, "", "x=1", "%zz", "size=", "limit=-1&offset=9999999999999999999") {
+		f.Add(s)
+	}
+}
+
+func FuzzParseDownloadRequest(f *testing.F) {
+	addQuerySeeds(f, "size=25", "size=0", "size=1e3", "size=9223372036854775807", "duration=10s", "duration=1h",
+		"size=5&duration=5s", "chunk=1", "chunk=65536", "cc=bbr", " size = 7 ")
+	f.Fuzz(func(t *testing.T, raw string) {
+		req, err := parseDownloadRequest(parseQuery(t, raw))
+		checkParamError(t, raw, err)
+		if err != nil {
+			return
+		}
+		if req.SizeBytes < *minSize*1024*1024 || req.SizeBytes > *maxDownloadSize*1024*1024 {
+			t.Fatalf("%q: size %d bytes outside -min-size and -maxsize", raw, req.SizeBytes)
+		}
+		if req.Duration != 0 && (req.Duration < time.Second || req.Duration > *maxDownloadTime) {
+			t.Fatalf("%q: duration %s outside 1s and -max-download-duration", raw, req.Duration)
+		}
+		if req.ChunkSize < minDownloadChunkSize || req.ChunkSize > int64(*downloadChunkSize) {
+			t.Fatalf("%q: chunk size %d outside %d and -chunksize", raw, req.ChunkSize, minDownloadChunkSize)
+		}
+		if req.Congestion != "" {
+			t.Fatalf("%q: congestion control %q without any available", raw, req.Congestion)
+		}
+	})
+}
+
+func FuzzParseUploadRequest(f *testing.F) {
+	addQuerySeeds(f, "echo=1", "echo=0", "echo=true")
+	f.Fuzz(func(t *testing.T, raw string) {
+		_, err := parseUploadRequest(parseQuery(t, raw))
+		checkParamError(t, raw, err)
+	})
+}
+
+func FuzzParsePageRequest(f *testing.F) {
+	addQuerySeeds(f, "offset=10&limit=20", "limit=500", "limit=501", "offset=-1")
+	f.Fuzz(func(t *testing.T, raw string) {
+		page, err := parsePageRequest(parseQuery(t, raw))
+		checkParamError(t, raw, err)
+		if err == nil && (page.Offset < 0 || page.Limit < 1 || page.Limit > 500) {
+			t.Fatalf("%q: page %+v out of range", raw, page)
+		}
+	})
+}
+
+func FuzzParseListRequest(f *testing.F) {
+	addQuerySeeds(f, "cursor=abc&limit=50", "limit=0")
+	f.Fuzz(func(t *testing.T, raw string) {
+		req, err := parseListRequest(parseQuery(t, raw))
+		checkParamError(t, raw, err)
+		if err == nil && (req.Limit < 1 || req.Limit > 500) {
+			t.Fatalf("%q: limit %d out of range", raw, req.Limit)
+		}
+	})
+}
+
+func FuzzParseLookbackHours(f *testing.F) {
+	addQuerySeeds(f, "hours=24", "hours=0", "hours=NaN", "hours=Inf", "hours=8785", "hours=0.5")
+	f.Fuzz(func(t *testing.T, raw string) {
+		hours, err := parseLookbackHours(parseQuery(t, raw), 24)
+		checkParamError(t, raw, err)
+		if err == nil && !(hours > 0 && hours <= 366*24) {
+			t.Fatalf("%q: hours %g out of range", raw, hours)
+		}
+	})
+}
+
+func FuzzParseStatsRequest(f *testing.F) {
+	addQuerySeeds(f, "from=2025-06-01T00:00:00Z&to=2025-06-08T00:00:00Z", "to=2025-06-01T00:00:00Z&from=2025-06-01T00:00:00Z",
+		"contended=true", "contended=1", "from=yesterday")
+	f.Fuzz(func(t *testing.T, raw string) {
+		req, err := parseStatsRequest(parseQuery(t, raw))
+		checkParamError(t, raw, err)
+		if err == nil && !req.From.IsZero() && !req.To.IsZero() && !req.From.Before(req.To) {
+			t.Fatalf("%q: from %s not before to %s", raw, req.From, req.To)
+		}
+	})
+}
+
+func FuzzParseTrendsRequest(f *testing.F) {
+	addQuerySeeds(f, "period=weekly&periods=12", "period=monthly", "periods=1001")
+	f.Fuzz(func(t *testing.T, raw string) {
+		req, err := parseTrendsRequest(parseQuery(t, raw))
+		checkParamError(t, raw, err)
+		if err == nil && (req.Period != "daily" && req.Period != "weekly" || req.Periods < 1 || req.Periods > 1000) {
+			t.Fatalf("%q: %+v out of range", raw, req)
+		}
+	})
+}
+
+func FuzzParseResultFilter(f *testing.F) {
+	addQuerySeeds(f, "download_lt=50&latency_gt=20", "speed_lt=5", "download_lt=abc", "download_lt=NaN",
+		"tag=location:office", "tag=location=office", "tag=:x", "tag=office", "ip=192.168.&client=device-123456",
+		"verified=true&contended=false", "verified=yes", "q=Office",
+		"from=2025-06-01T00:00:00Z&to=2025-06-08T00:00:00Z", "from=2025-06-08T00:00:00Z&to=2025-06-01T00:00:00Z")
+	f.Fuzz(func(t *testing.T, raw string) {
+		filter, err := parseResultFilter(parseQuery(t, raw))
+		checkParamError(t, raw, err)
+		if err != nil {
+			return
+		}
+		if !filter.From.IsZero() && !filter.To.IsZero() && !filter.To.After(filter.From) {
+			t.Fatalf("%q: to %s not after from %s", raw, filter.To, filter.From)
+		}
+		if filter.TagValue != "" && filter.TagKey == "" {
+			t.Fatalf("%q: tag value without a key", raw)
+		}
+		// Matching must not panic on any filter the parser accepts.
+		filter.Matches(TestResult{ID: "x", Timestamp: time.Now(), Tags: map[string]string{"location": "office"}})
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-netspeed/client"
+)
+
+const (
+	peerTestPings   = 10
+	peerTestTimeout = 5 * time.Minute
+)
+
+// PeerTestRequest is the body of POST /admin/api/peer-test.
+type PeerTestRequest struct {
+	Target string `json:"target"`
+	SizeMB int64  `json:"sizeMB"`
+}
+
+// zeroReader is an endless source of zero bytes for upload tests.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// runPeerTest measures the path to another netspeed instance using its public
+// test endpoints (see measureTarget). The result is saved like any client test
+// and tagged with the peer, in addition to tags.
+func runPeerTest(target string, sizeMB int64, tags map[string]string) (TestResult, error) {
+	base, err := parseTargetURL(target)
+	if err != nil {
+		return TestResult{}, err
+	}
+	result, err := measureTarget(base, sizeMB)
+	if err != nil {
+		return TestResult{}, err
+	}
+	result.ClientIP = base.Hostname()
+	result.Verified = true
+	stampMeasurement(&result)
+	result.QoS = nil // our marking applies to tests others run against us
+	tags = sanitizeTags(tags)
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	tags["source"], tags["peer"] = "peer-test", base.Host
+	if uplink := outboundLabel(); uplink != "" {
+		tags["uplink"] = uplink
+	}
+	result.Tags = tags
+
+	result.Timestamp = time.Now()
+	id, err := globalStore.Save(result)
+	if err != nil {
+		return TestResult{}, fmt.Errorf("failed to save peer result: %w", err)
+	}
+	result.ID = id
+	notifyResult(result, shareURL(*publicURL, id))
+	log.Printf("Peer test to %s: down %.2f Mbps, up %.2f Mbps, latency %.2f ms (result %s)",
+		base, result.DownloadSpeedMbps, result.UploadSpeedMbps, result.LatencyMs, id)
+	return result, nil
+}
+
+// parseTargetURL validates the base URL of a netspeed instance.
+func parseTargetURL(target string) (*url.URL, error) {
+	base, err := url.Parse(strings.TrimRight(target, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid target URL %q", target)
+	}
+	return base, nil
+}
+
+// newTargetClient returns a client for the netspeed instance at base whose
+// HTTP and WebRTC traffic leaves through the configured uplink.
+func newTargetClient(base *url.URL) (*client.Client, error) {
+	api, err := outboundWebRTCAPI()
+	if err != nil {
+		return nil, err
+	}
+	return &client.Client{
+		BaseURL:    base,
+		HTTPClient: &http.Client{Timeout: peerTestTimeout, Transport: outboundTransport()},
+		WebRTC:     api,
+		ICEServers: peerConnectionConfig.ICEServers,
+	}, nil
+}
+
+// measureTarget runs the web client's tests against the netspeed instance at
+// base (see client.Client.Run). Only the metrics and their methodology are
+// returned; the session IDs refer to the target's sessions.
+func measureTarget(base *url.URL, sizeMB int64) (TestResult, error) {
+	if sizeMB <= 0 {
+		sizeMB = *defaultSize
+	}
+	c, err := newTargetClient(base)
+	if err != nil {
+		return TestResult{}, err
+	}
+	measured, err := c.Run(serverContext, client.RunOptions{SizeMB: sizeMB, Pings: peerTestPings, Logf: log.Printf})
+	if err != nil {
+		return TestResult{}, err
+	}
+	return TestResult{
+		DownloadSpeedMbps: measured.DownloadSpeedMbps,
+		UploadSpeedMbps:   measured.UploadSpeedMbps,
+		LatencyMs:         measured.LatencyMs,
+		JitterMs:          measured.JitterMs,
+		PacketLossPercent: measured.PacketLossPercent,
+		Methodology:       measured.Methodology,
+	}, nil
+}
+
+// adminPeerTestHandler runs a peer test on demand and returns the saved result.
+func adminPeerTestHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	var req PeerTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, tr(r, "Invalid JSON peer test request"), http.StatusBadRequest)
+		return
+	}
+	if req.Target == "" {
+		badRequest(w, &paramError{Param: "target", Reason: "required"})
+		return
+	}
+	if req.SizeMB < 0 {
+		badRequest(w, &paramError{Param: "sizeMB", Reason: fmt.Sprintf("%d is negative", req.SizeMB)})
+		return
+	}
+	if req.SizeMB > *maxDownloadSize {
+		req.SizeMB = *maxDownloadSize
+	}
+
+	result, err := runPeerTest(req.Target, req.SizeMB, nil)
+	if err != nil {
+		log.Printf("Peer test failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, result)
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	portMappingLifetime    = time.Hour
+	portMappingDescription = "go-netspeed"
+	maxMappedUDPPorts      = 100
+)
+
+// portMapping describes a single port forwarding requested from the router.
+type portMapping struct {
+	Protocol string // "TCP" or "UDP"
+	Port     int
+}
+
+// portMapper is implemented by the router protocols we know how to speak.
+type portMapper interface {
+	Name() string
+	Map(m portMapping, lifetime time.Duration) error
+	Unmap(m portMapping) error
+	ExternalIP() (net.IP, error)
+}
+
+// portMapManager keeps the requested mappings alive until closed.
+type portMapManager struct {
+	mapper   portMapper
+	mappings []portMapping
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// startPortMapping discovers a gateway using the requested mode ("auto",
+// "natpmp" or "upnp") and maps the HTTP port plus the configured WebRTC UDP range.
+func startPortMapping(mode string, gateway string, httpPort, udpMin, udpMax int) (*portMapManager, error) {
+	if mode != "auto" && mode != "natpmp" && mode != "upnp" {
+		return nil, fmt.Errorf("unknown port mapping mode %q (expected auto, natpmp or upnp)", mode)
+	}
+
+	gw := net.ParseIP(gateway)
+	if gateway != "" && gw == nil {
+		return nil, fmt.Errorf("invalid gateway address: %s", gateway)
+	}
+	if gw == nil {
+		var err error
+		if gw, err = defaultGateway(); err != nil && mode != "upnp" {
+			log.Printf("Warning: unable to detect default gateway: %v", err)
+		}
+	}
+
+	var mapper portMapper
+	var errs []error
+	if (mode == "auto" || mode == "natpmp") && gw != nil {
+		m := &natPMPClient{gateway: gw}
+		if _, err := m.ExternalIP(); err == nil {
+			mapper = m
+		} else {
+			errs = append(errs, fmt.Errorf("nat-pmp: %w", err))
+		}
+	}
+	if mapper == nil && (mode == "auto" || mode == "upnp") {
+		m, err := discoverUPnP(3 * time.Second)
+		if err == nil {
+			mapper = m
+		} else {
+			errs = append(errs, fmt.Errorf("upnp: %w", err))
+		}
+	}
+	if mapper == nil {
+		return nil, fmt.Errorf("no port mapping gateway found: %w", errors.Join(errs...))
+	}
+
+	mappings := []portMapping{{Protocol: "TCP", Port: httpPort}}
+	if udpMin != 0 && udpMax != 0 && udpMin < udpMax {
+		if udpMax-udpMin+1 > maxMappedUDPPorts {
+			log.Printf("Warning: WebRTC port range too large to map, only mapping the first %d ports", maxMappedUDPPorts)
+			udpMax = udpMin + maxMappedUDPPorts - 1
+		}
+		for p := udpMin; p <= udpMax; p++ {
+			mappings = append(mappings, portMapping{Protocol: "UDP", Port: p})
+		}
+	} else {
+		log.Println("Warning: WebRTC UDP ports are not mapped. Set -webrtc-min-port/-webrtc-max-port to forward them.")
+	}
+
+	pm := &portMapManager{mapper: mapper, mappings: mappings, stop: make(chan struct{})}
+	if err := pm.refresh(); err != nil {
+		return nil, err
+	}
+
+	if ip, err := mapper.ExternalIP(); err == nil {
+		log.Printf("Port mapping via %s active. Remote testers can use http://%s", mapper.Name(), net.JoinHostPort(ip.String(), fmt.Sprint(httpPort)))
+	} else {
+		log.Printf("Port mapping via %s active (external IP unknown: %v)", mapper.Name(), err)
+	}
+
+	pm.wg.Add(1)
+	go pm.renew()
+	return pm, nil
+}
+
+func (pm *portMapManager) refresh() error {
+	for _, m := range pm.mappings {
+		if err := pm.mapper.Map(m, portMappingLifetime); err != nil {
+			return fmt.Errorf("failed to map %s port %d: %w", m.Protocol, m.Port, err)
+		}
+		if *verbose {
+			log.Printf("Mapped %s port %d via %s", m.Protocol, m.Port, pm.mapper.Name())
+		}
+	}
+	return nil
+}
+
+// renew re-requests the mappings at half their lifetime.
+func (pm *portMapManager) renew() {
+	defer pm.wg.Done()
+	ticker := time.NewTicker(portMappingLifetime / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := pm.refresh(); err != nil {
+				log.Printf("Port mapping renewal failed: %v", err)
+			}
+		case <-pm.stop:
+			return
+		}
+	}
+}
+
+// Close stops renewal and removes the mappings from the router.
+func (pm *portMapManager) Close() error {
+	close(pm.stop)
+	pm.wg.Wait()
+	var errs []error
+	for _, m := range pm.mappings {
+		if err := pm.mapper.Unmap(m); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// defaultGateway reads the IPv4 default route. Only Linux is supported; other
+// platforms should pass -nat-gateway explicitly.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		// The kernel prints the address in host (little-endian) byte order.
+		return net.IPv4(raw[3], raw[2], raw[1], raw[0]), nil
+	}
+	return nil, errors.New("no default route found")
+}
+
+// --- NAT-PMP (RFC 6886) ---
+
+type natPMPClient struct {
+	gateway net.IP
+}
+
+func (c *natPMPClient) Name() string { return "NAT-PMP" }
+
+func (c *natPMPClient) request(req []byte, respLen int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: c.gateway, Port: 5351})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	resp := make([]byte, 16)
+	// Retransmit with doubling timeouts as the RFC suggests, capped to keep startup quick.
+	for timeout := 250 * time.Millisecond; timeout <= 2*time.Second; timeout *= 2 {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		n, err := conn.Read(resp)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		if n < respLen || resp[1] != req[1]+128 {
+			return nil, errors.New("malformed NAT-PMP response")
+		}
+		if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+			return nil, fmt.Errorf("NAT-PMP result code %d", code)
+		}
+		return resp[:n], nil
+	}
+	return nil, errors.New("NAT-PMP gateway did not respond")
+}
+
+func (c *natPMPClient) ExternalIP() (net.IP, error) {
+	resp, err := c.request([]byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+func (c *natPMPClient) mapping(m portMapping, lifetime time.Duration) error {
+	req := make([]byte, 12)
+	req[1] = 2 // TCP
+	if m.Protocol == "UDP" {
+		req[1] = 1
+	}
+	binary.BigEndian.PutUint16(req[4:6], uint16(m.Port))
+	binary.BigEndian.PutUint16(req[6:8], uint16(m.Port))
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	resp, err := c.request(req, 16)
+	if err != nil {
+		return err
+	}
+	if lifetime > 0 {
+		if external := int(binary.BigEndian.Uint16(resp[10:12])); external != m.Port {
+			log.Printf("Warning: NAT-PMP mapped %s port %d to external port %d", m.Protocol, m.Port, external)
+		}
+	}
+	return nil
+}
+
+func (c *natPMPClient) Map(m portMapping, lifetime time.Duration) error {
+	return c.mapping(m, lifetime)
+}
+
+func (c *natPMPClient) Unmap(m portMapping) error {
+	return c.mapping(m, 0)
+}
+
+// --- UPnP IGD ---
+
+type upnpClient struct {
+	controlURL  string
+	serviceType string
+	localIP     net.IP
+}
+
+func (c *upnpClient) Name() string { return "UPnP" }
+
+// upnpDevice is the subset of the UPnP device description we need.
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+func (d upnpDevice) findWANService() (serviceType, controlURL string) {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, "WANIPConnection") || strings.Contains(s.ServiceType, "WANPPPConnection") {
+			return s.ServiceType, s.ControlURL
+		}
+	}
+	for _, child := range d.Devices {
+		if st, cu := child.findWANService(); cu != "" {
+			return st, cu
+		}
+	}
+	return "", ""
+}
+
+// discoverUPnP finds an Internet Gateway Device with SSDP and resolves its control URL.
+func discoverUPnP(timeout time.Duration) (*upnpClient, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	ssdpAddr := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+	if _, err := conn.WriteToUDP([]byte(search), ssdpAddr); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, errors.New("no UPnP gateway responded")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := resp.Header.Get("Location")
+		if location == "" {
+			continue
+		}
+		client, err := upnpClientFromLocation(location)
+		if err != nil {
+			if *verbose {
+				log.Printf("Ignoring UPnP device at %s: %v", from, err)
+			}
+			continue
+		}
+		return client, nil
+	}
+}
+
+func upnpClientFromLocation(location string) (*upnpClient, error) {
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	resp, err := httpClient.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var root struct {
+		Device upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return nil, fmt.Errorf("invalid device description: %w", err)
+	}
+	serviceType, control := root.Device.findWANService()
+	if control == "" {
+		return nil, errors.New("device has no WAN connection service")
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	controlURL, err := base.Parse(control)
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine which local address routes to the gateway; the router needs it
+	// as the internal client for the mapping.
+	probe, err := net.Dial("udp4", base.Host)
+	if err != nil {
+		return nil, err
+	}
+	localIP := probe.LocalAddr().(*net.UDPAddr).IP
+	probe.Close()
+
+	return &upnpClient{controlURL: controlURL.String(), serviceType: serviceType, localIP: localIP}, nil
+}
+
+// soap invokes an action on the WAN connection service and returns the raw response body.
+func (c *upnpClient) soap(action string, args [][2]string) ([]byte, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, c.serviceType)
+	for _, kv := range args {
+		fmt.Fprintf(&body, "<%s>", kv[0])
+		xml.EscapeText(&body, []byte(kv[1]))
+		fmt.Fprintf(&body, "</%s>", kv[0])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequest(http.MethodPost, c.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, c.serviceType, action))
+
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRequestSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s failed with HTTP %d", action, resp.StatusCode)
+	}
+	return data, nil
+}
+
+func (c *upnpClient) ExternalIP() (net.IP, error) {
+	data, err := c.soap("GetExternalIPAddress", nil)
+	if err != nil {
+		return nil, err
+	}
+	var env struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(env.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("gateway returned invalid external IP %q", env.IP)
+	}
+	return ip, nil
+}
+
+func (c *upnpClient) Map(m portMapping, lifetime time.Duration) error {
+	_, err := c.soap("AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", fmt.Sprint(m.Port)},
+		{"NewProtocol", m.Protocol},
+		{"NewInternalPort", fmt.Sprint(m.Port)},
+		{"NewInternalClient", c.localIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", portMappingDescription},
+		{"NewLeaseDuration", fmt.Sprint(int(lifetime / time.Second))},
+	})
+	return err
+}
+
+func (c *upnpClient) Unmap(m portMapping) error {
+	_, err := c.soap("DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", fmt.Sprint(m.Port)},
+		{"NewProtocol", m.Protocol},
+	})
+	return err
+}
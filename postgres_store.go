@@ -0,0 +1,1017 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+// PostgresStore implements ResultStore in PostgreSQL, so several instances
+// behind a load balancer can share their results.
+type PostgresStore struct {
+	db *sql.DB
+
+	// Optional limits, enforced after each save and periodically; zero disables them.
+	resultTTL  time.Duration
+	maxResults int
+	stopPrune  chan struct{}
+}
+
+// postgresMigrationLock is the advisory lock key that serializes migrations
+// of instances starting at the same time.
+const postgresMigrationLock = 0x6e657473 // "nets"
+
+// postgresPruneInterval is how often the result limits are applied when no
+// results are being saved.
+const postgresPruneInterval = 10 * time.Minute
+
+// postgresMigrations are applied in order, once each, and recorded in
+// schema_migrations. Released migrations are never edited; schema changes
+// are appended as new entries.
+var postgresMigrations = []string{
+	`CREATE TABLE results (
+		id                  TEXT PRIMARY KEY,
+		timestamp           TIMESTAMPTZ NOT NULL,
+		download_mbps       DOUBLE PRECISION NOT NULL,
+		upload_mbps         DOUBLE PRECISION NOT NULL,
+		latency_ms          DOUBLE PRECISION NOT NULL,
+		jitter_ms           DOUBLE PRECISION NOT NULL,
+		packet_loss_percent DOUBLE PRECISION NOT NULL,
+		client_ip           TEXT NOT NULL DEFAULT '',
+		verified            BOOLEAN NOT NULL DEFAULT false,
+		tags                JSONB NOT NULL DEFAULT '{}',
+		webrtc_session_id   TEXT NOT NULL DEFAULT '',
+		webrtc_log          JSONB,
+		server_id           TEXT NOT NULL DEFAULT '',
+		server_version      TEXT NOT NULL DEFAULT '',
+		server_label        TEXT NOT NULL DEFAULT '',
+		methodology         JSONB
+	);
+	CREATE INDEX results_timestamp ON results (timestamp DESC, id DESC);
+	CREATE INDEX results_tags ON results USING GIN (tags);
+
+	CREATE TABLE test_errors (
+		timestamp TIMESTAMPTZ NOT NULL,
+		phase     TEXT NOT NULL,
+		data      JSONB NOT NULL
+	);
+	CREATE INDEX test_errors_timestamp ON test_errors (timestamp);
+
+	CREATE TABLE probes (
+		timestamp TIMESTAMPTZ NOT NULL,
+		target    TEXT NOT NULL,
+		data      JSONB NOT NULL
+	);
+	CREATE INDEX probes_timestamp ON probes (timestamp);
+
+	CREATE TABLE report_snapshots (
+		period TEXT NOT NULL,
+		start  TIMESTAMPTZ NOT NULL,
+		data   JSONB NOT NULL,
+		PRIMARY KEY (period, start)
+	);`,
+
+	`CREATE TABLE result_amendments (
+		id        TEXT PRIMARY KEY,
+		result_id TEXT NOT NULL REFERENCES results (id) ON DELETE CASCADE,
+		timestamp TIMESTAMPTZ NOT NULL,
+		kind      TEXT NOT NULL,
+		value     TEXT NOT NULL,
+		author    TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX result_amendments_result ON result_amendments (result_id, timestamp);
+
+	-- Results and their amendments are append-only
+	CREATE FUNCTION netspeed_reject_update() RETURNS trigger LANGUAGE plpgsql AS $$
+	BEGIN
+		RAISE EXCEPTION '% are immutable', TG_TABLE_NAME;
+	END
+	$$;
+	CREATE TRIGGER results_immutable BEFORE UPDATE ON results
+		FOR EACH ROW EXECUTE FUNCTION netspeed_reject_update();
+	CREATE TRIGGER result_amendments_immutable BEFORE UPDATE ON result_amendments
+		FOR EACH ROW EXECUTE FUNCTION netspeed_reject_update();`,
+
+	`ALTER TABLE results
+		ADD COLUMN remote_ip       TEXT NOT NULL DEFAULT '',
+		ADD COLUMN user_agent      TEXT NOT NULL DEFAULT '',
+		ADD COLUMN http_protocol   TEXT NOT NULL DEFAULT '',
+		ADD COLUMN server_hostname TEXT NOT NULL DEFAULT '';`,
+
+	`ALTER TABLE results ADD COLUMN qos JSONB;`,
+
+	`ALTER TABLE results ADD COLUMN tcp_info JSONB;`,
+
+	`ALTER TABLE results ADD COLUMN server_load JSONB;`,
+
+	`ALTER TABLE results ADD COLUMN client_id TEXT NOT NULL DEFAULT '';
+	CREATE INDEX results_client_id ON results (client_id, timestamp);`,
+
+	`ALTER TABLE results ADD COLUMN samples JSONB;`,
+
+	`CREATE TABLE result_aliases (
+		alias     TEXT PRIMARY KEY,
+		result_id TEXT NOT NULL REFERENCES results (id) ON DELETE CASCADE
+	);
+	CREATE INDEX result_aliases_result ON result_aliases (result_id);`,
+
+	`ALTER TABLE results
+		ADD COLUMN geo_country_code TEXT NOT NULL DEFAULT '',
+		ADD COLUMN geo_country      TEXT NOT NULL DEFAULT '',
+		ADD COLUMN geo_city         TEXT NOT NULL DEFAULT '',
+		ADD COLUMN geo_asn          BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN geo_isp          TEXT NOT NULL DEFAULT '';`,
+
+	`CREATE TABLE alerts (
+		id   TEXT PRIMARY KEY,
+		data JSONB NOT NULL
+	);`,
+
+	`CREATE TABLE recurring_tests (
+		id   TEXT PRIMARY KEY,
+		data JSONB NOT NULL
+	);`,
+
+	`CREATE TABLE result_owners (
+		owner     TEXT NOT NULL,
+		result_id TEXT NOT NULL REFERENCES results (id) ON DELETE CASCADE,
+		PRIMARY KEY (owner, result_id)
+	);
+	CREATE INDEX result_owners_result ON result_owners (result_id);`,
+
+	`ALTER TABLE results ADD COLUMN net_ping JSONB;`,
+
+	`CREATE TABLE result_signatures (
+		result_id TEXT PRIMARY KEY REFERENCES results (id) ON DELETE CASCADE,
+		algorithm TEXT NOT NULL,
+		key_id    TEXT NOT NULL,
+		payload   TEXT NOT NULL,
+		value     TEXT NOT NULL
+	);
+	CREATE TRIGGER result_signatures_immutable BEFORE UPDATE ON result_signatures
+		FOR EACH ROW EXECUTE FUNCTION netspeed_reject_update();`,
+}
+
+const postgresResultColumns = `id, timestamp, download_mbps, upload_mbps, latency_ms, jitter_ms,
+	packet_loss_percent, client_ip, verified, tags, webrtc_session_id, webrtc_log,
+	server_id, server_version, server_label, methodology,
+	remote_ip, user_agent, http_protocol, server_hostname, qos, tcp_info, server_load, client_id, samples,
+	geo_country_code, geo_country, geo_city, geo_asn, geo_isp, net_ping`
+
+// NewPostgresStore connects to the database at dsn with a pool of up to
+// maxConns connections and migrates the schema.
+func NewPostgresStore(dsn string, maxConns int) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres db: %w", err)
+	}
+	db.SetMaxOpenConns(maxConns)
+	db.SetMaxIdleConns(max(maxConns/2, 1))
+	db.SetConnMaxLifetime(30 * time.Minute) // lets a load balanced database rebalance
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+	if err := migratePostgres(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate postgres schema: %w", err)
+	}
+	log.Printf("PostgreSQL configured for result storage (pool of %d connections)", maxConns)
+
+	store := &PostgresStore{db: db, stopPrune: make(chan struct{})}
+	go store.runPrune()
+	return store, nil
+}
+
+// migratePostgres applies the migrations the database has not seen yet.
+func migratePostgres(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, postgresMigrationLock); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	var current int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	for version := current + 1; version <= len(postgresMigrations); version++ {
+		if _, err := tx.Exec(postgresMigrations[version-1]); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			return err
+		}
+		log.Printf("Applied postgres migration %d", version)
+	}
+	return tx.Commit()
+}
+
+// jsonOrNull encodes v as JSON, or NULL when empty.
+func jsonOrNull[T any](v []T) (any, error) {
+	if len(v) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func scanPostgresResult(row rowScanner) (TestResult, error) {
+	var (
+		result                       TestResult
+		tags, webrtcLog, methodology []byte
+		qos, tcpInfo, load, samples  []byte
+		netPing                      []byte
+		server                       ServerIdentity
+		client                       ClientMetadata
+		geo                          GeoInfo
+	)
+	err := row.Scan(&result.ID, &result.Timestamp, &result.DownloadSpeedMbps, &result.UploadSpeedMbps,
+		&result.LatencyMs, &result.JitterMs, &result.PacketLossPercent, &result.ClientIP, &result.Verified,
+		&tags, &result.WebRTCSessionID, &webrtcLog, &server.ID, &server.Version, &server.Label, &methodology,
+		&client.RemoteIP, &client.UserAgent, &client.Protocol, &client.Hostname, &qos, &tcpInfo, &load, &result.ClientID, &samples,
+		&geo.CountryCode, &geo.Country, &geo.City, &geo.ASN, &geo.ISP, &netPing)
+	if err != nil {
+		return result, err
+	}
+	if server.ID != "" {
+		result.Server = &server
+	}
+	if client.RemoteIP != "" {
+		result.Client = &client
+	}
+	if geo != (GeoInfo{}) {
+		result.Geo = &geo
+	}
+	for _, field := range []struct {
+		name string
+		data []byte
+		into any
+	}{
+		{"tags", tags, &result.Tags},
+		{"webrtc_log", webrtcLog, &result.WebRTCLog},
+		{"methodology", methodology, &result.Methodology},
+		{"qos", qos, &result.QoS},
+		{"tcp_info", tcpInfo, &result.TCPInfo},
+		{"server_load", load, &result.Load},
+		{"samples", samples, &result.Samples},
+		{"net_ping", netPing, &result.NetPing},
+	} {
+		if len(field.data) == 0 || string(field.data) == "{}" {
+			continue
+		}
+		if err := json.Unmarshal(field.data, field.into); err != nil {
+			return result, fmt.Errorf("invalid %s for result %s: %w", field.name, result.ID, err)
+		}
+	}
+	return result, nil
+}
+
+func scanPostgresResults(rows *sql.Rows) ([]TestResult, error) {
+	defer rows.Close()
+	results := []TestResult{}
+	for rows.Next() {
+		result, err := scanPostgresResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// Save generates a unique ID, saves the result, and returns the ID.
+func (s *PostgresStore) Save(result TestResult) (string, error) {
+	result.ID = uuid.New().String()
+	result.Timestamp = time.Now() // Use server time for official record
+	return s.save(result)
+}
+
+// Import saves a result with its original ID and timestamp.
+func (s *PostgresStore) Import(result TestResult) (string, error) {
+	if _, err := s.Load(result.ID); err == nil {
+		return "", fmt.Errorf("result %s: %w", result.ID, errResultExists)
+	}
+	return s.save(result)
+}
+
+// save stores result under its ID and timestamp.
+func (s *PostgresStore) save(result TestResult) (string, error) {
+	id := result.ID
+
+	tags, err := json.Marshal(result.Tags)
+	if err != nil {
+		return "", err
+	}
+	if result.Tags == nil {
+		tags = []byte("{}")
+	}
+	webrtcLog, err := jsonOrNull(result.WebRTCLog)
+	if err != nil {
+		return "", err
+	}
+	var methodology any
+	if len(result.Methodology) > 0 {
+		data, err := json.Marshal(result.Methodology)
+		if err != nil {
+			return "", err
+		}
+		methodology = string(data)
+	}
+	var qos any
+	if result.QoS != nil {
+		data, err := json.Marshal(result.QoS)
+		if err != nil {
+			return "", err
+		}
+		qos = string(data)
+	}
+	tcpInfo, err := jsonOrNull(result.TCPInfo)
+	if err != nil {
+		return "", err
+	}
+	var load any
+	if result.Load != nil {
+		data, err := json.Marshal(result.Load)
+		if err != nil {
+			return "", err
+		}
+		load = string(data)
+	}
+	var samples any
+	if result.Samples != nil {
+		data, err := json.Marshal(result.Samples)
+		if err != nil {
+			return "", err
+		}
+		samples = string(data)
+	}
+	var netPing any
+	if result.NetPing != nil {
+		data, err := json.Marshal(result.NetPing)
+		if err != nil {
+			return "", err
+		}
+		netPing = string(data)
+	}
+	var server ServerIdentity
+	if result.Server != nil {
+		server = *result.Server
+	}
+	var client ClientMetadata
+	if result.Client != nil {
+		client = *result.Client
+	}
+	var geo GeoInfo
+	if result.Geo != nil {
+		geo = *result.Geo
+	}
+
+	_, err = s.db.Exec(`INSERT INTO results (id, timestamp, download_mbps, upload_mbps, latency_ms, jitter_ms,
+		packet_loss_percent, client_ip, verified, tags, webrtc_session_id, webrtc_log,
+		server_id, server_version, server_label, methodology, remote_ip, user_agent, http_protocol, server_hostname, qos, tcp_info, server_load, client_id, samples,
+		geo_country_code, geo_country, geo_city, geo_asn, geo_isp, net_ping)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25,
+		$26, $27, $28, $29, $30, $31)`,
+		id, result.Timestamp, result.DownloadSpeedMbps, result.UploadSpeedMbps, result.LatencyMs, result.JitterMs,
+		result.PacketLossPercent, result.ClientIP, result.Verified, string(tags), result.WebRTCSessionID, webrtcLog,
+		server.ID, server.Version, server.Label, methodology,
+		client.RemoteIP, client.UserAgent, client.Protocol, client.Hostname, qos, tcpInfo, load, result.ClientID, samples,
+		geo.CountryCode, geo.Country, geo.City, geo.ASN, geo.ISP, netPing)
+	if err != nil {
+		return id, err
+	}
+	log.Printf("Result saved with ID: %s", id)
+
+	if s.resultTTL > 0 || s.maxResults > 0 {
+		if err := s.prune(); err != nil {
+			log.Printf("Failed to enforce result limits: %v", err)
+		}
+	}
+	return id, nil
+}
+
+// Load retrieves a result by its unique ID.
+func (s *PostgresStore) Load(id string) (TestResult, error) {
+	result, err := scanPostgresResult(s.db.QueryRow(`SELECT `+postgresResultColumns+` FROM results WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return TestResult{}, fmt.Errorf("result not found for ID: %s", id)
+	}
+	return result, err
+}
+
+// Delete removes a result and its amendments.
+func (s *PostgresStore) Delete(id string) error {
+	res, err := s.db.Exec(`DELETE FROM results WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("result not found for ID: %s", id)
+	}
+	log.Printf("Result deleted with ID: %s", id)
+	return nil
+}
+
+// Iterate calls fn for every stored result, oldest first, stopping at the first error.
+func (s *PostgresStore) Iterate(fn func(result TestResult) error) error {
+	rows, err := s.db.Query(`SELECT ` + postgresResultColumns + ` FROM results ORDER BY timestamp, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		result, err := scanPostgresResult(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// postgresWhere translates filter into a WHERE clause and its arguments, with
+// the same semantics as ResultFilter.Matches.
+func postgresWhere(filter ResultFilter) (string, []any) {
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	metrics := []struct {
+		column string
+		m      metricRange
+	}{
+		{"download_mbps", filter.Download},
+		{"upload_mbps", filter.Upload},
+		{"latency_ms", filter.Latency},
+		{"jitter_ms", filter.Jitter},
+		{"packet_loss_percent", filter.Loss},
+	}
+	for _, metric := range metrics {
+		if metric.m.Lt != nil {
+			conds = append(conds, metric.column+" < "+arg(*metric.m.Lt))
+		}
+		if metric.m.Gt != nil {
+			conds = append(conds, metric.column+" > "+arg(*metric.m.Gt))
+		}
+	}
+	if filter.TagKey != "" {
+		tag, _ := json.Marshal(map[string]string{filter.TagKey: filter.TagValue})
+		conds = append(conds, "tags @> "+arg(string(tag))+"::jsonb")
+	}
+	if filter.IPPrefix != "" {
+		conds = append(conds, "starts_with(client_ip, "+arg(filter.IPPrefix)+")")
+	}
+	if filter.ClientID != "" {
+		conds = append(conds, "client_id = "+arg(filter.ClientID))
+	}
+	if filter.Verified != nil {
+		conds = append(conds, "verified = "+arg(*filter.Verified))
+	}
+	if filter.Contended != nil {
+		conds = append(conds, "(COALESCE((server_load->>'concurrentSessions')::int, 0) > 0) = "+arg(*filter.Contended))
+	}
+	if !filter.From.IsZero() {
+		conds = append(conds, "timestamp >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conds = append(conds, "timestamp < "+arg(filter.To))
+	}
+	if filter.Text != "" {
+		p := arg("%" + likePattern(filter.Text) + "%")
+		conds = append(conds, fmt.Sprintf(`(lower(id) LIKE %[1]s OR lower(client_ip) LIKE %[1]s OR EXISTS
+			(SELECT 1 FROM jsonb_each_text(tags) t WHERE lower(t.key) LIKE %[1]s OR lower(t.value) LIKE %[1]s))`, p))
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// Query returns one page of results matching filter, newest first, along with
+// the total number of matches.
+func (s *PostgresStore) Query(filter ResultFilter, offset, limit int) ([]TestResult, int, error) {
+	where, args := postgresWhere(filter)
+	total := 0
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM results`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	n := len(args)
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT %s FROM results%s ORDER BY timestamp DESC, id DESC LIMIT $%d OFFSET $%d`,
+		postgresResultColumns, where, n+1, n+2), append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	page, err := scanPostgresResults(rows)
+	return page, total, err
+}
+
+// IterateMatching calls fn for every result matching filter, newest first,
+// stopping at the first error.
+func (s *PostgresStore) IterateMatching(filter ResultFilter, fn func(result TestResult) error) error {
+	where, args := postgresWhere(filter)
+	rows, err := s.db.Query(`SELECT `+postgresResultColumns+` FROM results`+where+` ORDER BY timestamp DESC, id DESC`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		result, err := scanPostgresResult(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// List returns up to limit results matching filter, newest first, starting
+// after cursor (empty for the first page). The returned cursor continues the
+// listing and is empty after the last page. Cursors are the timestamp and ID of
+// the last result of a page, so pages stay stable while new results are saved.
+func (s *PostgresStore) List(filter ResultFilter, cursor string, limit int) ([]TestResult, string, error) {
+	where, args := postgresWhere(filter)
+	if cursor != "" {
+		position, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		timestamp, id, ok := strings.Cut(string(position), " ")
+		if !ok {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		t, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += fmt.Sprintf("(timestamp, id) < ($%d, $%d)", len(args)+1, len(args)+2)
+		args = append(args, t, id)
+	}
+
+	// One extra row tells whether there is a next page
+	rows, err := s.db.Query(`SELECT `+postgresResultColumns+` FROM results`+where+
+		fmt.Sprintf(" ORDER BY timestamp DESC, id DESC LIMIT $%d", len(args)+1), append(args, limit+1)...)
+	if err != nil {
+		return nil, "", err
+	}
+	page, err := scanPostgresResults(rows)
+	if err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if len(page) > limit {
+		page = page[:limit]
+		last := page[limit-1]
+		next = base64.RawURLEncoding.EncodeToString([]byte(last.Timestamp.UTC().Format(time.RFC3339Nano) + " " + last.ID))
+	}
+	return page, next, nil
+}
+
+// SetLimits deletes results older than ttl and all but the newest maxResults,
+// now and whenever results are saved. Zero disables a limit.
+func (s *PostgresStore) SetLimits(ttl time.Duration, maxResults int) error {
+	s.resultTTL = ttl
+	s.maxResults = maxResults
+	return s.prune()
+}
+
+// prune deletes results beyond the limits, and error reports and probes past
+// their retention.
+func (s *PostgresStore) prune() error {
+	now := time.Now()
+	var deleted int64
+	if s.resultTTL > 0 {
+		res, err := s.db.Exec(`DELETE FROM results WHERE timestamp < $1`, now.Add(-s.resultTTL))
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	if s.maxResults > 0 {
+		res, err := s.db.Exec(`DELETE FROM results WHERE (timestamp, id) <
+			(SELECT timestamp, id FROM results ORDER BY timestamp DESC, id DESC OFFSET $1 - 1 LIMIT 1)`, s.maxResults)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d results to stay within the result limits", deleted)
+	}
+
+	if _, err := s.db.Exec(`DELETE FROM test_errors WHERE timestamp < $1`, now.Add(-testErrorRetention)); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM probes WHERE timestamp < $1`, now.Add(-probeRetention))
+	return err
+}
+
+// runPrune applies the limits periodically until Close, so results expire
+// even when none are being saved.
+func (s *PostgresStore) runPrune() {
+	ticker := time.NewTicker(postgresPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopPrune:
+			return
+		case <-ticker.C:
+			if err := s.prune(); err != nil {
+				log.Printf("Failed to prune postgres store: %v", err)
+			}
+		}
+	}
+}
+
+// SaveTestError stores an anonymized client error report. Reports are
+// deleted after testErrorRetention.
+func (s *PostgresStore) SaveTestError(report TestErrorReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal error report: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO test_errors (timestamp, phase, data) VALUES ($1, $2, $3)`,
+		report.Timestamp, report.Phase, string(data))
+	return err
+}
+
+// TestErrors returns the stored error reports newer than since, oldest first.
+func (s *PostgresStore) TestErrors(since time.Time) ([]TestErrorReport, error) {
+	var reports []TestErrorReport
+	err := s.scanJSON(`SELECT data FROM test_errors WHERE timestamp >= $1 ORDER BY timestamp`, []any{since}, func(data []byte) error {
+		var report TestErrorReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return err
+		}
+		reports = append(reports, report)
+		return nil
+	})
+	return reports, err
+}
+
+// SaveProbe stores an external target probe result. Probes are deleted after
+// probeRetention.
+func (s *PostgresStore) SaveProbe(probe ProbeResult) error {
+	data, err := json.Marshal(probe)
+	if err != nil {
+		return fmt.Errorf("failed to marshal probe result: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO probes (timestamp, target, data) VALUES ($1, $2, $3)`,
+		probe.Timestamp, probe.Target, string(data))
+	return err
+}
+
+// Probes returns the stored probe results newer than since, oldest first.
+func (s *PostgresStore) Probes(since time.Time) ([]ProbeResult, error) {
+	var probes []ProbeResult
+	err := s.scanJSON(`SELECT data FROM probes WHERE timestamp >= $1 ORDER BY timestamp`, []any{since}, func(data []byte) error {
+		var probe ProbeResult
+		if err := json.Unmarshal(data, &probe); err != nil {
+			return err
+		}
+		probes = append(probes, probe)
+		return nil
+	})
+	return probes, err
+}
+
+// SaveSnapshot stores a report snapshot, replacing any for the same period
+// start. Every instance generates snapshots, so the last writer wins.
+func (s *PostgresStore) SaveSnapshot(snapshot ReportSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report snapshot: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO report_snapshots (period, start, data) VALUES ($1, $2, $3)
+		ON CONFLICT (period, start) DO UPDATE SET data = EXCLUDED.data`,
+		snapshot.Period, snapshot.Start, string(data))
+	return err
+}
+
+// HasSnapshot reports whether a snapshot exists for the period starting at start.
+func (s *PostgresStore) HasSnapshot(period string, start time.Time) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM report_snapshots WHERE period = $1 AND start = $2)`,
+		period, start).Scan(&exists)
+	return exists, err
+}
+
+// Snapshots returns the snapshots of a period starting at or after since, oldest first.
+func (s *PostgresStore) Snapshots(period string, since time.Time) ([]ReportSnapshot, error) {
+	var snapshots []ReportSnapshot
+	err := s.scanJSON(`SELECT data FROM report_snapshots WHERE period = $1 AND start >= $2 ORDER BY start`, []any{period, since}, func(data []byte) error {
+		var snapshot ReportSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	return snapshots, err
+}
+
+// SaveAmendment stores an amendment of an existing result.
+func (s *PostgresStore) SaveAmendment(amendment Amendment) (Amendment, error) {
+	amendment.ID = uuid.New().String()
+	amendment.Timestamp = time.Now()
+	res, err := s.db.Exec(`INSERT INTO result_amendments (id, result_id, timestamp, kind, value, author)
+		SELECT $1::text, id, $2::timestamptz, $3::text, $4::text, $5::text FROM results WHERE id = $6`,
+		amendment.ID, amendment.Timestamp, amendment.Kind, amendment.Value, amendment.Author, amendment.ResultID)
+	if err != nil {
+		return amendment, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return amendment, fmt.Errorf("result not found for ID: %s", amendment.ResultID)
+	}
+	log.Printf("Amendment %s (%s) saved for result ID: %s", amendment.ID, amendment.Kind, amendment.ResultID)
+	return amendment, nil
+}
+
+// Amendments returns the amendments of a result, oldest first.
+func (s *PostgresStore) Amendments(resultID string) ([]Amendment, error) {
+	rows, err := s.db.Query(`SELECT id, result_id, timestamp, kind, value, author FROM result_amendments
+		WHERE result_id = $1 ORDER BY timestamp, id`, resultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var amendments []Amendment
+	for rows.Next() {
+		var a Amendment
+		if err := rows.Scan(&a.ID, &a.ResultID, &a.Timestamp, &a.Kind, &a.Value, &a.Author); err != nil {
+			return nil, err
+		}
+		amendments = append(amendments, a)
+	}
+	return amendments, rows.Err()
+}
+
+// AddAlias points alias at an existing result.
+func (s *PostgresStore) AddAlias(alias, resultID string) error {
+	res, err := s.db.Exec(`INSERT INTO result_aliases (alias, result_id)
+		SELECT $1, id FROM results WHERE id = $2 ON CONFLICT (alias) DO NOTHING`, alias, resultID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		current, err := s.ResolveAlias(alias)
+		switch {
+		case errors.Is(err, errAliasNotFound):
+			return fmt.Errorf("result not found for ID: %s", resultID)
+		case err != nil:
+			return err
+		case current != resultID:
+			return errAliasTaken
+		}
+		return nil
+	}
+	log.Printf("Alias %s saved for result ID: %s", alias, resultID)
+	return nil
+}
+
+// ResolveAlias returns the ID of the result alias points at.
+func (s *PostgresStore) ResolveAlias(alias string) (string, error) {
+	var id string
+	err := s.db.QueryRow(`SELECT result_id FROM result_aliases WHERE alias = $1`, alias).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", errAliasNotFound
+	}
+	return id, err
+}
+
+// Aliases returns the aliases of a result, sorted.
+func (s *PostgresStore) Aliases(resultID string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT alias FROM result_aliases WHERE result_id = $1 ORDER BY alias`, resultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var aliases []string
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+// DeleteAlias removes an alias.
+func (s *PostgresStore) DeleteAlias(alias string) error {
+	res, err := s.db.Exec(`DELETE FROM result_aliases WHERE alias = $1`, alias)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errAliasNotFound
+	}
+	return nil
+}
+
+// SaveAlert stores an alert, replacing the one with its ID. Every instance
+// tracks the alerts of the results it saves, so the last writer wins.
+func (s *PostgresStore) SaveAlert(alert Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO alerts (id, data) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data`, alert.ID, string(data))
+	return err
+}
+
+// Alerts returns the stored alerts.
+func (s *PostgresStore) Alerts() ([]Alert, error) {
+	var list []Alert
+	err := s.scanJSON(`SELECT data FROM alerts`, nil, func(data []byte) error {
+		var alert Alert
+		if err := json.Unmarshal(data, &alert); err != nil {
+			return err
+		}
+		list = append(list, alert)
+		return nil
+	})
+	return list, err
+}
+
+// DeleteAlert removes an alert.
+func (s *PostgresStore) DeleteAlert(id string) error {
+	_, err := s.db.Exec(`DELETE FROM alerts WHERE id = $1`, id)
+	return err
+}
+
+// SaveRecurringTest stores a recurring test registration.
+func (s *PostgresStore) SaveRecurringTest(test RecurringTest) error {
+	data, err := json.Marshal(test)
+	if err != nil {
+		return fmt.Errorf("failed to marshal recurring test: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO recurring_tests (id, data) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data`, test.ID, string(data))
+	return err
+}
+
+// LoadRecurringTest returns a recurring test registration.
+func (s *PostgresStore) LoadRecurringTest(id string) (RecurringTest, error) {
+	var test RecurringTest
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM recurring_tests WHERE id = $1`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return test, errRecurringTestNotFound
+	} else if err != nil {
+		return test, err
+	}
+	return test, json.Unmarshal(data, &test)
+}
+
+// DeleteRecurringTest removes a recurring test registration.
+func (s *PostgresStore) DeleteRecurringTest(id string) error {
+	res, err := s.db.Exec(`DELETE FROM recurring_tests WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errRecurringTestNotFound
+	}
+	return nil
+}
+
+// ClaimResult records that owner saved an existing result.
+func (s *PostgresStore) ClaimResult(owner, resultID string) error {
+	res, err := s.db.Exec(`INSERT INTO result_owners (owner, result_id)
+		SELECT $1, id FROM results WHERE id = $2 ON CONFLICT DO NOTHING`, owner, resultID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if owned, err := s.OwnsResult(owner, resultID); err != nil || owned {
+			return err
+		}
+		return fmt.Errorf("result not found for ID: %s", resultID)
+	}
+	return nil
+}
+
+// OwnedResults returns the IDs of up to limit of owner's results, newest first.
+func (s *PostgresStore) OwnedResults(owner string, limit int) ([]string, error) {
+	rows, err := s.db.Query(`SELECT r.id FROM result_owners o JOIN results r ON r.id = o.result_id
+		WHERE o.owner = $1 ORDER BY r.timestamp DESC, r.id DESC LIMIT $2`, owner, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// OwnsResult reports whether owner saved a result.
+func (s *PostgresStore) OwnsResult(owner, resultID string) (bool, error) {
+	var one int
+	err := s.db.QueryRow(`SELECT 1 FROM result_owners WHERE owner = $1 AND result_id = $2`, owner, resultID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SaveSignature stores the signature of an existing result.
+func (s *PostgresStore) SaveSignature(resultID string, signature ResultSignature) error {
+	res, err := s.db.Exec(`INSERT INTO result_signatures (result_id, algorithm, key_id, payload, value)
+		SELECT id, $1, $2, $3, $4 FROM results WHERE id = $5`,
+		signature.Algorithm, signature.KeyID, signature.Payload, signature.Value, resultID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("result not found for ID: %s", resultID)
+	}
+	return nil
+}
+
+// Signature returns the signature of a result, or errSignatureNotFound.
+func (s *PostgresStore) Signature(resultID string) (ResultSignature, error) {
+	var signature ResultSignature
+	err := s.db.QueryRow(`SELECT algorithm, key_id, payload, value FROM result_signatures WHERE result_id = $1`, resultID).
+		Scan(&signature.Algorithm, &signature.KeyID, &signature.Payload, &signature.Value)
+	if err == sql.ErrNoRows {
+		return signature, errSignatureNotFound
+	}
+	return signature, err
+}
+
+// StoreSize returns the size of the results table with its indexes and
+// TOAST data; the other tables are small.
+func (s *PostgresStore) StoreSize() (int64, error) {
+	var size int64
+	err := s.db.QueryRow(`SELECT pg_total_relation_size('results')`).Scan(&size)
+	return size, err
+}
+
+// scanJSON calls fn with the single JSON column of each row of query.
+func (s *PostgresStore) scanJSON(query string, args []any, fn func(data []byte) error) error {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Close stops the periodic pruning and closes the connection pool.
+func (s *PostgresStore) Close() error {
+	close(s.stopPrune)
+	return s.db.Close()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	maxPrewarmConnections = 8 // browsers open at most 6 HTTP/1.1 connections per host
+	prewarmHold           = 150 * time.Millisecond
+)
+
+// prewarmTracker remembers which open connections were established by
+// /api/prewarm, so throughput requests can tell whether they reused one.
+type prewarmTracker struct {
+	mu    sync.Mutex
+	conns map[string]time.Time // remote address -> prewarmed at
+}
+
+var prewarmed = &prewarmTracker{conns: make(map[string]time.Time)}
+
+var (
+	prewarmRequests = newCounterVec("netspeed_prewarm_requests_total",
+		"Connections warmed by /api/prewarm.")
+	prewarmReuse = newCounterVec("netspeed_prewarm_reuse_total",
+		"Throughput requests by whether they arrived on a prewarmed connection.", "type", "prewarmed")
+	_ = newGaugeFunc("netspeed_prewarmed_connections",
+		"Open connections established by /api/prewarm.", nil,
+		func() map[string]float64 { return map[string]float64{"": float64(prewarmed.Count())} })
+)
+
+// Mark records the connection a prewarm request arrived on.
+func (t *prewarmTracker) Mark(remoteAddr string) {
+	t.mu.Lock()
+	t.conns[remoteAddr] = time.Now()
+	t.mu.Unlock()
+}
+
+// Used reports whether a request arrived on a prewarmed connection and counts it.
+func (t *prewarmTracker) Used(kind string, r *http.Request) bool {
+	t.mu.Lock()
+	_, ok := t.conns[r.RemoteAddr]
+	t.mu.Unlock()
+	prewarmReuse.Inc(kind, strconv.FormatBool(ok))
+	return ok
+}
+
+// Count returns the number of prewarmed connections still open.
+func (t *prewarmTracker) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// trackConnState forgets prewarmed connections once they close.
+// It is installed as the http.Server ConnState hook.
+func trackConnState(c net.Conn, state http.ConnState) {
+	if state == http.StateClosed || state == http.StateHijacked {
+		prewarmed.mu.Lock()
+		delete(prewarmed.conns, c.RemoteAddr().String())
+		prewarmed.mu.Unlock()
+	}
+}
+
+// prewarmHandler is called N times in parallel by the client before the
+// throughput phase. Each response is held briefly so the requests overlap and
+// the browser opens separate keep-alive connections that the download and
+// upload then reuse, taking connection setup out of short tests.
+func prewarmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	prewarmed.Mark(r.RemoteAddr)
+	prewarmRequests.Inc()
+	if *verbose {
+		log.Printf("Prewarmed connection from %s", loggedAddr(r.RemoteAddr))
+	}
+
+	select {
+	case <-time.After(prewarmHold):
+	case <-r.Context().Done():
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if r.ProtoMajor == 1 {
+		w.Header().Set("Connection", "keep-alive")
+	}
+	writeJSON(w, map[string]any{
+		"connection":     r.RemoteAddr,
+		"maxConnections": maxPrewarmConnections,
+	})
+}
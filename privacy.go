@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// privacyPresets bundle the settings that decide what the server keeps about
+// the people who test, by -privacy level. A preset only changes the flags not
+// given on the command line, so e.g. -privacy strict -result-ttl 2160h keeps
+// results for 90 days but is otherwise strict.
+var privacyPresets = map[string]map[string]string{
+	"strict": {
+		"anonymize-ip":    "drop",
+		"client-metadata": "false",
+		"log-client-ips":  "false",
+		"result-ttl":      "720h",
+	},
+	"balanced": {
+		"anonymize-ip":    "truncate",
+		"client-metadata": "true",
+		"log-client-ips":  "false",
+		"result-ttl":      "2160h",
+	},
+	"off": {
+		"anonymize-ip":    "none",
+		"client-metadata": "true",
+		"log-client-ips":  "true",
+		"result-ttl":      "0",
+	},
+}
+
+// ipAnonymizations are the accepted -anonymize-ip modes.
+var ipAnonymizations = []string{"none", "truncate", "drop"}
+
+// Prefix lengths addresses are truncated to, leaving the network but not the host.
+const (
+	truncatedIPv4Bits = 24
+	truncatedIPv6Bits = 48
+)
+
+// applyPrivacyPreset sets the flags of the -privacy preset that were not set
+// explicitly, and validates the privacy flags.
+func applyPrivacyPreset() error {
+	preset, ok := privacyPresets[*privacyLevel]
+	if !ok {
+		return fmt.Errorf("-privacy %q is not one of strict, balanced or off", *privacyLevel)
+	}
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	var applied []string
+	for name, value := range preset {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("-%s: %w", name, err)
+		}
+		applied = append(applied, "-"+name+"="+value)
+	}
+	if !slices.Contains(ipAnonymizations, *anonymizeIP) {
+		return fmt.Errorf("-anonymize-ip %q is not one of %s", *anonymizeIP, strings.Join(ipAnonymizations, ", "))
+	}
+	if *privacyLevel != "off" {
+		slices.Sort(applied)
+		log.Printf("Privacy preset %s: %s", *privacyLevel, strings.Join(applied, " "))
+	}
+	return nil
+}
+
+// truncateIP returns the network part of an address, e.g. 203.0.113.0 for
+// 203.0.113.7, or "" when addr is not an address.
+func truncateIP(addr string) string {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return ""
+	}
+	ip = ip.Unmap()
+	bits := truncatedIPv4Bits
+	if ip.Is6() {
+		bits = truncatedIPv6Bits
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.Addr().String()
+}
+
+// storedIP returns a client address as -anonymize-ip stores it.
+func storedIP(addr string) string {
+	switch *anonymizeIP {
+	case "truncate":
+		return truncateIP(addr)
+	case "drop":
+		return ""
+	}
+	return addr
+}
+
+// anonymizeResult applies the privacy settings to a result about to be
+// stored. It runs after the client address was used to verify the result
+// and attach its sessions.
+func anonymizeResult(result *TestResult) {
+	result.ClientIP = storedIP(result.ClientIP)
+	if result.Client != nil {
+		result.Client.RemoteIP = storedIP(result.Client.RemoteIP)
+		if !*storeClientMetadata {
+			result.Client.UserAgent = ""
+		}
+	}
+	if result.Geo != nil && !*storeClientMetadata {
+		// The country is kept for reports; the city and network narrow down who tested
+		result.Geo = &GeoInfo{CountryCode: result.Geo.CountryCode, Country: result.Geo.Country}
+	}
+}
+
+// publicResult is a result as served to anyone holding its ID, which every
+// share link contains: without the client ID, which would let them list the
+// device's whole /history.
+func publicResult(result TestResult) TestResult {
+	result.ClientID = ""
+	return result
+}
+
+// loggedAddr returns a client address, optionally with a port, as it may be
+// logged: truncated to its network unless -log-client-ips is set.
+func loggedAddr(addr string) string {
+	if *logClientIPs {
+		return addr
+	}
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	if truncated := truncateIP(host); truncated != "" {
+		return truncated
+	}
+	return "client"
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-netspeed/measure"
+)
+
+const (
+	probeMaxBytes  = 10 * 1024 * 1024 // throughput is measured on at most this much of the body
+	probeTimeout   = 60 * time.Second
+	probeRetention = 30 * 24 * time.Hour
+)
+
+// ProbeResult is one measurement from this server to an external target.
+type ProbeResult struct {
+	Timestamp      time.Time `json:"timestamp"`
+	Target         string    `json:"target"`
+	DNSMs          float64   `json:"dnsMs"`
+	ConnectMs      float64   `json:"connectMs"` // TCP (and TLS) setup
+	LatencyMs      float64   `json:"latencyMs"` // time to the first response byte
+	ThroughputMbps float64   `json:"throughputMbps"`
+	Bytes          int64     `json:"bytes"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// ProbeStore is implemented by result stores that can persist probe results.
+type ProbeStore interface {
+	SaveProbe(probe ProbeResult) error
+	Probes(since time.Time) ([]ProbeResult, error)
+}
+
+// latestProbes holds the most recent result per target for /metrics.
+var latestProbes = struct {
+	sync.Mutex
+	byTarget map[string]ProbeResult
+}{byTarget: make(map[string]ProbeResult)}
+
+var (
+	_ = newGaugeFunc("netspeed_probe_latency_ms",
+		"Time to first byte of the latest probe to each external target.", []string{"target"},
+		func() map[string]float64 {
+			return successfulProbeValues(func(p ProbeResult) float64 { return p.LatencyMs })
+		})
+	_ = newGaugeFunc("netspeed_probe_throughput_mbps",
+		"Throughput of the latest probe to each external target.", []string{"target"},
+		func() map[string]float64 {
+			return successfulProbeValues(func(p ProbeResult) float64 { return p.ThroughputMbps })
+		})
+	_ = newGaugeFunc("netspeed_probe_up",
+		"Whether the latest probe to each external target succeeded.", []string{"target"},
+		func() map[string]float64 {
+			return latestProbeValues(func(p ProbeResult) float64 {
+				if p.Error != "" {
+					return 0
+				}
+				return 1
+			})
+		})
+)
+
+func latestProbeValues(value func(ProbeResult) float64) map[string]float64 {
+	latestProbes.Lock()
+	defer latestProbes.Unlock()
+	values := make(map[string]float64, len(latestProbes.byTarget))
+	for target, p := range latestProbes.byTarget {
+		values[target] = value(p)
+	}
+	return values
+}
+
+// successfulProbeValues is latestProbeValues with NaN for failed probes, so a
+// failure is not graphed as zero latency or throughput.
+func successfulProbeValues(value func(ProbeResult) float64) map[string]float64 {
+	return latestProbeValues(func(p ProbeResult) float64 {
+		if p.Error != "" {
+			return math.NaN()
+		}
+		return value(p)
+	})
+}
+
+// parseProbeTargets splits the comma-separated -probe-targets flag.
+func parseProbeTargets(list string) []string {
+	var targets []string
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			targets = append(targets, field)
+		}
+	}
+	return targets
+}
+
+// probeTarget fetches target on a fresh connection, timing DNS, connection
+// setup, first byte and up to probeMaxBytes of body.
+func probeTarget(target string) ProbeResult {
+	p := ProbeResult{Timestamp: time.Now(), Target: target}
+
+	var dnsStart, connectStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart:         func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:          func(httptrace.DNSDoneInfo) { p.DNSMs = msSince(dnsStart) },
+		ConnectStart:     func(string, string) { connectStart = time.Now() },
+		ConnectDone:      func(string, string, error) { p.ConnectMs = msSince(connectStart) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) { p.ConnectMs = msSince(connectStart) },
+	}
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	req.Header.Set("Cache-Control", "no-cache")
+
+	transport := outboundTransport()
+	transport.DisableKeepAlives = true
+	client := &http.Client{
+		Timeout:   probeTimeout,
+		Transport: transport,
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	defer resp.Body.Close()
+	p.LatencyMs = msSince(start)
+	if resp.StatusCode >= 400 {
+		p.Error = "HTTP status " + strconv.Itoa(resp.StatusCode)
+		return p
+	}
+
+	bodyStart := time.Now()
+	p.Bytes, err = io.Copy(io.Discard, io.LimitReader(resp.Body, probeMaxBytes))
+	if err != nil {
+		p.Error = err.Error()
+	}
+	p.ThroughputMbps = measure.Mbps(p.Bytes, time.Since(bodyStart))
+	return p
+}
+
+func msSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
+
+// recordProbe keeps the probe for /metrics and persists it when the store supports it.
+func recordProbe(p ProbeResult) {
+	latestProbes.Lock()
+	latestProbes.byTarget[p.Target] = p
+	latestProbes.Unlock()
+
+	if p.Error != "" {
+		log.Printf("Probe to %s failed: %s", p.Target, p.Error)
+	} else if *verbose {
+		log.Printf("Probe to %s: %.1f ms, %.2f Mbps", p.Target, p.LatencyMs, p.ThroughputMbps)
+	}
+	if store, ok := optionalStore[ProbeStore](); ok {
+		if err := store.SaveProbe(p); err != nil {
+			log.Printf("Failed to save probe result: %v", err)
+		}
+	}
+}
+
+// adminProbesHandler returns probe results from the last ?hours= (default 24),
+// grouped into one time series per target.
+func adminProbesHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := optionalStore[ProbeStore]()
+	if !ok {
+		http.Error(w, tr(r, "Probe results are not supported by this store"), http.StatusNotImplemented)
+		return
+	}
+	hours, err := parseLookbackHours(r.URL.Query(), 24)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	probes, err := store.Probes(time.Now().Add(-time.Duration(hours * float64(time.Hour))))
+	if err != nil {
+		log.Printf("Failed to load probe results: %v", err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	series := make(map[string][]ProbeResult)
+	for _, target := range parseProbeTargets(*probeTargets) {
+		series[target] = []ProbeResult{}
+	}
+	for _, p := range probes {
+		series[p.Target] = append(series[p.Target], p)
+	}
+	writeJSON(w, map[string]any{"hours": hours, "targets": series})
+}
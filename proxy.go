@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Servers without direct egress reach notification services through a
+// proxy. By default, webhooks, ntfy and Gotify honor HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY, and MQTT and syslog over TCP or TLS tunnel through
+// HTTPS_PROXY. -notify-proxy sets the proxy of all of them, and
+// -webhook-proxy, -ntfy-proxy, -gotify-proxy, -syslog-proxy and -mqtt-proxy
+// the proxy of one: an http://, https:// or socks5:// URL with optional
+// user:password, or "direct" to ignore the environment. Syslog over UDP is
+// never proxied.
+
+// egressProxy is the proxy a notifier connects through.
+type egressProxy struct {
+	url         *url.URL // nil to connect directly
+	environment bool     // follow HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+}
+
+// proxyDefaultPorts are the proxy ports assumed when a proxy URL has none.
+var proxyDefaultPorts = map[string]string{"http": "80", "https": "443", "socks5": "1080"}
+
+// notifierProxy parses the proxy flag name of a notifier, falling back to
+// -notify-proxy when it is empty.
+func notifierProxy(name, value string) (*egressProxy, error) {
+	if value == "" {
+		name, value = "notify-proxy", *notifyProxy
+	}
+	switch value {
+	case "":
+		return &egressProxy{environment: true}, nil
+	case "direct":
+		return &egressProxy{}, nil
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("-%s: invalid proxy URL %q", name, value)
+	}
+	if proxyDefaultPorts[u.Scheme] == "" {
+		return nil, fmt.Errorf("-%s: unsupported proxy scheme %q (want http, https or socks5)", name, u.Scheme)
+	}
+	return &egressProxy{url: u}, nil
+}
+
+// httpClient returns a client that sends requests through the proxy.
+func (p *egressProxy) httpClient() *http.Client {
+	if p.environment {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	if p.url != nil {
+		transport.Proxy = http.ProxyURL(p.url)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// dialContext opens a TCP connection to addr, a host:port, through the proxy.
+func (p *egressProxy) dialContext(ctx context.Context, addr string) (net.Conn, error) {
+	proxyURL := p.url
+	if p.environment {
+		// The proxy an HTTPS request to the same host would use, so NO_PROXY applies
+		var err error
+		proxyURL, err = http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	dialer := &net.Dialer{}
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), proxyDefaultPorts[proxyURL.Scheme])
+	}
+	if proxyURL.Scheme == "socks5" {
+		var auth *proxy.Auth
+		if user := proxyURL.User; user != nil {
+			password, _ := user.Password()
+			auth = &proxy.Auth{User: user.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyAddr, auth, dialer)
+		if err != nil {
+			return nil, err
+		}
+		return socks.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	}
+	return dialConnect(ctx, dialer, proxyURL, proxyAddr, addr)
+}
+
+// dialConnect opens a tunnel to addr through an HTTP proxy with a CONNECT
+// request.
+func dialConnect(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, proxyAddr, addr string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	// Stop waiting for the proxy when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+	// MQTT brokers and syslog collectors wait for the client to speak first,
+	// so nothing past the response is left in the reader.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused the tunnel to %s: %s", proxyURL.Redacted(), addr, resp.Status)
+	}
+	return conn, nil
+}
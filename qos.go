@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pion/transport/v3"
+	"github.com/pion/transport/v3/stdnet"
+	"github.com/pion/webrtc/v4"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// QoSMarking records the DSCP values the server marked its test traffic
+// with, so results measured with and without marking can be compared.
+type QoSMarking struct {
+	TCP    *int `json:"tcp,omitempty"`    // HTTP tests, from -dscp
+	WebRTC *int `json:"webrtc,omitempty"` // data-channel packets, from -webrtc-dscp
+}
+
+// dscpNames are the standard per-hop behaviours accepted by -dscp.
+var dscpNames = map[string]int{
+	"cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+	"af11": 10, "af12": 12, "af13": 14, "af21": 18, "af22": 20, "af23": 22,
+	"af31": 26, "af32": 28, "af33": 30, "af41": 34, "af42": 36, "af43": 38,
+	"ef": 46, "va": 44, "le": 1,
+}
+
+// tcpDSCP and webrtcDSCP are resolved from -dscp and -webrtc-dscp by
+// configureQoS; -1 leaves sockets unmarked.
+var (
+	tcpDSCP    = -1
+	webrtcDSCP = -1
+)
+
+// parseDSCP accepts a DSCP codepoint as a number from 0 to 63 or a name
+// such as EF, AF41 or CS1. An empty value means unmarked (-1).
+func parseDSCP(value string) (int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return -1, nil
+	}
+	if dscp, ok := dscpNames[value]; ok {
+		return dscp, nil
+	}
+	dscp, err := strconv.Atoi(value)
+	if err != nil || dscp < 0 || dscp > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q (want 0-63 or a name such as EF, AF41 or CS1)", value)
+	}
+	return dscp, nil
+}
+
+// configureQoS applies -dscp to TCP listeners and -webrtc-dscp (or -dscp
+// when unset) to the WebRTC UDP sockets created through s.
+func configureQoS(s *webrtc.SettingEngine) error {
+	var err error
+	if tcpDSCP, err = parseDSCP(*dscpFlag); err != nil {
+		return fmt.Errorf("-dscp: %w", err)
+	}
+	webrtcDSCP = tcpDSCP
+	if *webrtcDSCPFlag != "" {
+		if webrtcDSCP, err = parseDSCP(*webrtcDSCPFlag); err != nil {
+			return fmt.Errorf("-webrtc-dscp: %w", err)
+		}
+	}
+	if webrtcDSCP >= 0 {
+		base, err := stdnet.NewNet()
+		if err != nil {
+			return fmt.Errorf("failed to enumerate interfaces: %w", err)
+		}
+		s.SetNet(&dscpNet{Net: base, dscp: webrtcDSCP})
+	}
+	if tcpDSCP >= 0 || webrtcDSCP >= 0 {
+		log.Printf("Marking test traffic with DSCP %s (TCP) and %s (WebRTC)", dscpString(tcpDSCP), dscpString(webrtcDSCP))
+	}
+	return nil
+}
+
+func dscpString(dscp int) string {
+	if dscp < 0 {
+		return "none"
+	}
+	return strconv.Itoa(dscp)
+}
+
+// qosMarking describes the marking in effect, or nil without one.
+func qosMarking() *QoSMarking {
+	if tcpDSCP < 0 && webrtcDSCP < 0 {
+		return nil
+	}
+	marking := &QoSMarking{}
+	if tcpDSCP >= 0 {
+		marking.TCP = &tcpDSCP
+	}
+	if webrtcDSCP >= 0 {
+		marking.WebRTC = &webrtcDSCP
+	}
+	return marking
+}
+
+// setDSCP marks the packets sent on c. The TOS byte carries the DSCP in its
+// upper six bits; IPv6 sockets use the traffic class instead. Sockets bound
+// to an IPv4 address, including IPv4-mapped ones, take IP_TOS.
+func setDSCP(c net.Conn, dscp int) error {
+	var ip net.IP
+	switch addr := c.LocalAddr().(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	}
+	if ip == nil || ip.To4() != nil {
+		return ipv4.NewConn(c).SetTOS(dscp << 2)
+	}
+	return ipv6.NewConn(c).SetTrafficClass(dscp << 2)
+}
+
+// dscpListener marks every accepted connection.
+type dscpListener struct {
+	net.Listener
+	dscp int
+}
+
+func (l *dscpListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if err := setDSCP(c, l.dscp); err != nil && *verbose {
+		log.Printf("Failed to set DSCP on connection from %s: %v", loggedAddr(c.RemoteAddr().String()), err)
+	}
+	return c, nil
+}
+
+// dscpNet marks the UDP sockets ICE gathers host candidates on.
+type dscpNet struct {
+	*stdnet.Net
+	dscp int
+}
+
+func (n *dscpNet) ListenUDP(network string, laddr *net.UDPAddr) (transport.UDPConn, error) {
+	c, err := n.Net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	if uc, ok := c.(*net.UDPConn); ok {
+		if err := setDSCP(uc, n.dscp); err != nil {
+			log.Printf("Failed to set DSCP on WebRTC socket %s: %v", uc.LocalAddr(), err)
+		}
+	}
+	return c, nil
+}
+
+func (n *dscpNet) ListenPacket(network, address string) (net.PacketConn, error) {
+	c, err := n.Net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if uc, ok := c.(*net.UDPConn); ok {
+		if err := setDSCP(uc, n.dscp); err != nil {
+			log.Printf("Failed to set DSCP on WebRTC socket %s: %v", uc.LocalAddr(), err)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// The default download payload repeats a byte pattern, which compressing
+// proxies and middleboxes shrink to almost nothing, inflating results.
+// -random-data fills downloads with pseudorandom data instead, with a new
+// key for every download and different data in every chunk: "fast" is a
+// splitmix64 counter, a few instructions per 8 bytes, and "crypto" is
+// ChaCha8, indistinguishable from noise at a few times the CPU cost. Both
+// are addressed by offset, so the ranges of a multi-stream download have the
+// same content whichever stream fetches them.
+
+// randomDataModes are the accepted -random-data values.
+var randomDataModes = []string{"off", "fast", "crypto"}
+
+// chaCha8BlockSize is the span of one ChaCha8 seed; data at an offset is
+// generated from the start of its block.
+const chaCha8BlockSize = 16 * 1024
+
+func validateRandomData() error {
+	if !slices.Contains(randomDataModes, *randomData) {
+		return fmt.Errorf("-random-data %q is not one of %s", *randomData, strings.Join(randomDataModes, ", "))
+	}
+	return nil
+}
+
+// payloadGenerator writes the download data at offset into buf.
+type payloadGenerator func(buf []byte, offset int64)
+
+// newPayloadGenerator returns the generator of a new download, or nil for the
+// repeating pattern.
+func newPayloadGenerator() payloadGenerator {
+	switch *randomData {
+	case "fast":
+		seed := rand.Uint64()
+		return func(buf []byte, offset int64) { fillSplitMix64(buf, offset, seed) }
+	case "crypto":
+		var key [32]byte
+		crand.Read(key[:])
+		return func(buf []byte, offset int64) { fillChaCha8(buf, offset, key) }
+	}
+	return nil
+}
+
+// downloadPayload is one stream's buffer for the data of a download.
+type downloadPayload struct {
+	buf      []byte
+	generate payloadGenerator
+}
+
+func newDownloadPayload(generate payloadGenerator, chunkSize int64) *downloadPayload {
+	if generate != nil {
+		return &downloadPayload{buf: make([]byte, chunkSize), generate: generate}
+	}
+	// The pattern is filled once, with room to start at any offset
+	buf := make([]byte, chunkSize+256)
+	for i := range buf {
+		buf[i] = byte(i % 256)
+	}
+	return &downloadPayload{buf: buf}
+}
+
+// At returns n bytes, at most the chunk size, of the download at offset. The
+// slice is only valid until the next call.
+func (p *downloadPayload) At(offset, n int64) []byte {
+	if p.generate == nil {
+		return p.buf[offset%256:][:n]
+	}
+	p.generate(p.buf[:n], offset)
+	return p.buf[:n]
+}
+
+// fillSplitMix64 fills buf with the output of splitmix64 for the 8-byte
+// words of the download from offset.
+func fillSplitMix64(buf []byte, offset int64, seed uint64) {
+	word := uint64(offset) / 8
+	skip := int(offset % 8)
+	var partial [8]byte
+	for len(buf) > 0 {
+		z := seed + word*0x9e3779b97f4a7c15
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		z ^= z >> 31
+		if skip == 0 && len(buf) >= 8 {
+			binary.LittleEndian.PutUint64(buf, z)
+			buf = buf[8:]
+		} else {
+			binary.LittleEndian.PutUint64(partial[:], z)
+			n := copy(buf, partial[skip:])
+			buf, skip = buf[n:], 0
+		}
+		word++
+	}
+}
+
+// fillChaCha8 fills buf with ChaCha8 output from offset, seeded per block by
+// key and the block number.
+func fillChaCha8(buf []byte, offset int64, key [32]byte) {
+	for len(buf) > 0 {
+		block, skip := offset/chaCha8BlockSize, offset%chaCha8BlockSize
+		seed := key
+		binary.LittleEndian.PutUint64(seed[24:], binary.LittleEndian.Uint64(key[24:])^uint64(block))
+		rng := rand.NewChaCha8(seed)
+		for skip > 0 {
+			// Discarded into buf, which is overwritten below
+			n := min(skip, int64(len(buf)))
+			rng.Read(buf[:n])
+			skip -= n
+		}
+		n := min(int64(len(buf)), chaCha8BlockSize-offset%chaCha8BlockSize)
+		rng.Read(buf[:n])
+		buf, offset = buf[n:], offset+n
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket per key (usually a client IP). Idle buckets are
+// dropped so memory stays bounded by the number of recently active keys.
+type rateLimiter struct {
+	rate  float64 // tokens added per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter allows `perMinute` events per key per minute with bursts up to burst.
+func newRateLimiter(perMinute float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		swept:   time.Now(),
+	}
+}
+
+// Allow consumes a token for key and reports whether the event is permitted.
+func (l *rateLimiter) Allow(key string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > time.Minute {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep removes buckets that have refilled completely.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+var (
+	errEchoRate  = errors.New("echo rate limit exceeded")
+	errEchoTotal = errors.New("echo message limit reached")
+)
+
+// echoLimiter caps the messages one connection may have echoed, so a client
+// cannot use an echo path to reflect traffic at will: a rate with bursts of
+// up to a second's worth, and a total per connection.
+type echoLimiter struct {
+	rate float64 // per second; zero for unlimited
+	left int     // remaining messages; negative for unlimited
+
+	mu     sync.Mutex
+	bucket tokenBucket
+}
+
+// newEchoLimiter applies -echo-max-rate and -echo-max-messages.
+func newEchoLimiter() *echoLimiter {
+	l := &echoLimiter{rate: float64(*echoMaxRate), left: -1}
+	if *echoMaxMessages > 0 {
+		l.left = *echoMaxMessages
+	}
+	l.bucket = tokenBucket{tokens: max(l.rate, 1), last: time.Now()}
+	return l
+}
+
+// Allow consumes one message: errEchoRate when it must be dropped, and
+// errEchoTotal once the connection has used up its messages.
+func (l *echoLimiter) Allow() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.left == 0 {
+		return errEchoTotal
+	}
+	if l.rate > 0 {
+		now := time.Now()
+		l.bucket.tokens = min(max(l.rate, 1), l.bucket.tokens+now.Sub(l.bucket.last).Seconds()*l.rate)
+		l.bucket.last = now
+		if l.bucket.tokens < 1 {
+			return errEchoRate
+		}
+		l.bucket.tokens--
+	}
+	if l.left > 0 {
+		l.left--
+	}
+	return nil
+}
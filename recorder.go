@@ -0,0 +1,246 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recordingIdleTimeout = 2 * time.Minute
+	maxRecordedRequests  = 2000
+)
+
+// recordedPaths are the test API endpoints captured by the session recorder.
+var recordedPaths = []string{"/latency", "/download", "/upload", "/relay", "/webrtc/offer", "/api/prewarm", "/save-result", "/sessions/"}
+
+// RecordedRequest is one API interaction of a recorded session. Payloads are
+// never recorded, only their sizes.
+type RecordedRequest struct {
+	OffsetMs      float64 `json:"offsetMs"` // since the recording started
+	Method        string  `json:"method"`
+	Port          int     `json:"port"`
+	Path          string  `json:"path"`
+	Query         string  `json:"query,omitempty"`
+	Status        int     `json:"status"`
+	RequestBytes  int64   `json:"requestBytes"`
+	ResponseBytes int64   `json:"responseBytes"`
+	DurationMs    float64 `json:"durationMs"`
+	SessionID     string  `json:"sessionId,omitempty"` // X-Session-ID of the response
+}
+
+// SessionRecording is the API activity of one client from its first request
+// until it saved a result or went idle.
+type SessionRecording struct {
+	Client     string                    `json:"client"`
+	UserAgent  string                    `json:"userAgent"`
+	ServerPort int                       `json:"serverPort"`
+	StartedAt  time.Time                 `json:"startedAt"`
+	Requests   []RecordedRequest         `json:"requests"`
+	WebRTC     map[string][]SessionEvent `json:"webrtc,omitempty"` // timelines by session ID
+
+	lastActivity time.Time
+}
+
+// sessionRecorder groups recorded requests by client and writes each
+// recording to its own file in dir.
+type sessionRecorder struct {
+	dir string
+
+	mu         sync.Mutex
+	recordings map[string]*SessionRecording
+}
+
+var recorder *sessionRecorder
+
+// startRecorder enables recording into dir and flushes idle recordings in the background.
+func startRecorder(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	recorder = &sessionRecorder{dir: dir, recordings: make(map[string]*SessionRecording)}
+	go func() {
+		for range time.Tick(recordingIdleTimeout / 4) {
+			recorder.flushIdle()
+		}
+	}()
+	log.Printf("Recording test sessions to %s", dir)
+	return nil
+}
+
+// recordingWriter counts response bytes and keeps the status code.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// countingReader counts request body bytes.
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// withRecorder records the test API requests handled by next when recording is enabled.
+func withRecorder(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if recorder == nil || !isRecordedPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rw := &recordingWriter{ResponseWriter: w}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		next.ServeHTTP(rw, r)
+
+		req := RecordedRequest{
+			Method:        r.Method,
+			Port:          localPort(r),
+			Path:          r.URL.Path,
+			Query:         r.URL.RawQuery,
+			Status:        rw.status,
+			RequestBytes:  body.bytes,
+			ResponseBytes: rw.bytes,
+			DurationMs:    float64(time.Since(start).Microseconds()) / 1000,
+			SessionID:     rw.Header().Get("X-Session-ID"),
+		}
+		recorder.add(requestClientIP(r), r.UserAgent(), start, req)
+	})
+}
+
+func isRecordedPath(path string) bool {
+	for _, p := range recordedPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func localPort(r *http.Request) int {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+func (s *sessionRecorder) add(client, userAgent string, start time.Time, req RecordedRequest) {
+	s.mu.Lock()
+	rec, ok := s.recordings[client]
+	if !ok {
+		rec = &SessionRecording{Client: client, UserAgent: userAgent, ServerPort: *port, StartedAt: start}
+		s.recordings[client] = rec
+	}
+	if start.Before(rec.StartedAt) {
+		// A request that started earlier finished later; shift the timeline
+		shift := float64(rec.StartedAt.Sub(start).Microseconds()) / 1000
+		for i := range rec.Requests {
+			rec.Requests[i].OffsetMs += shift
+		}
+		rec.StartedAt = start
+	}
+	req.OffsetMs = float64(start.Sub(rec.StartedAt).Microseconds()) / 1000
+	if len(rec.Requests) < maxRecordedRequests {
+		rec.Requests = append(rec.Requests, req)
+	}
+	rec.lastActivity = time.Now()
+	done := req.Path == "/save-result"
+	if done {
+		delete(s.recordings, client)
+	}
+	s.mu.Unlock()
+
+	if done {
+		s.write(rec)
+	}
+}
+
+func (s *sessionRecorder) flushIdle() {
+	var idle []*SessionRecording
+	s.mu.Lock()
+	for client, rec := range s.recordings {
+		if time.Since(rec.lastActivity) > recordingIdleTimeout {
+			idle = append(idle, rec)
+			delete(s.recordings, client)
+		}
+	}
+	s.mu.Unlock()
+	for _, rec := range idle {
+		s.write(rec)
+	}
+}
+
+// write attaches the WebRTC timelines of the recorded sessions and saves the recording.
+func (s *sessionRecorder) write(rec *SessionRecording) {
+	for _, req := range rec.Requests {
+		if req.Path != "/webrtc/offer" || req.SessionID == "" {
+			continue
+		}
+		if l, ok := webrtcLogs.Get(req.SessionID); ok {
+			if rec.WebRTC == nil {
+				rec.WebRTC = make(map[string][]SessionEvent)
+			}
+			rec.WebRTC[req.SessionID] = l.Snapshot()
+		}
+	}
+	rec.Client = storedIP(rec.Client)
+	if !*storeClientMetadata {
+		rec.UserAgent = ""
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode session recording: %v", err)
+		return
+	}
+	name := fmt.Sprintf("%s-%s.json", rec.StartedAt.UTC().Format("20060102-150405.000"), topicSafe(cmp.Or(rec.Client, "client")))
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Printf("Failed to write session recording: %v", err)
+		return
+	}
+	if *verbose {
+		log.Printf("Wrote session recording %s (%d requests)", path, len(rec.Requests))
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Recurring tests are tests of a user's own connection that their browser
+// runs on a schedule, e.g. from a service worker woken by periodic background
+// sync. The server cannot reach the user's network, so it only keeps the
+// registration: the browser asks GET /api/recurring whether a run is due and
+// saves each run's result with its token, which files it under the
+// registration's client ID.
+
+// recurringTokenPrefix starts every recurring test token, so save-result can
+// tell them from other bearer tokens.
+const recurringTokenPrefix = "nsr_"
+
+// Bounds of a recurring test's interval.
+const (
+	minRecurringInterval = 15 * time.Minute
+	maxRecurringInterval = 7 * 24 * time.Hour
+)
+
+// maxRecurringResults bounds a GET /api/recurring/results response.
+const maxRecurringResults = 1000
+
+var errRecurringTestNotFound = errors.New("recurring test not found")
+
+// RecurringTestStore is implemented by result stores that keep recurring
+// test registrations.
+type RecurringTestStore interface {
+	// SaveRecurringTest stores a registration, replacing the one with its ID.
+	SaveRecurringTest(test RecurringTest) error
+	// LoadRecurringTest returns a registration, or errRecurringTestNotFound.
+	LoadRecurringTest(id string) (RecurringTest, error)
+	// DeleteRecurringTest removes a registration, or returns errRecurringTestNotFound.
+	DeleteRecurringTest(id string) error
+}
+
+// RecurringTest is the registration of a recurring test. Its results are
+// saved with its client ID, so they also appear in /history/{clientId}, and
+// tagged recurring={id}.
+type RecurringTest struct {
+	ID           string     `json:"id"`
+	ClientID     string     `json:"clientId"`
+	Label        string     `json:"label,omitempty"`
+	Interval     string     `json:"interval"` // e.g. 6h
+	Paused       bool       `json:"paused"`
+	Created      time.Time  `json:"created"`
+	LastRun      *time.Time `json:"lastRun,omitempty"`
+	LastResultID string     `json:"lastResultId,omitempty"`
+	Runs         int        `json:"runs"`
+	TokenHash    string     `json:"tokenHash,omitempty"` // SHA-256 of the token's secret; never served
+
+	// Filled in for responses.
+	NextRun *time.Time `json:"nextRun,omitempty"` // unset while paused
+	Due     bool       `json:"due"`
+	Token   string     `json:"token,omitempty"` // only in the response to the registration
+}
+
+// interval returns the parsed interval; Interval is validated when set.
+func (t RecurringTest) interval() time.Duration {
+	d, _ := time.ParseDuration(t.Interval)
+	return d
+}
+
+// view returns the registration as served at now.
+func (t RecurringTest) view(now time.Time) RecurringTest {
+	t.TokenHash = ""
+	if !t.Paused {
+		next := t.Created
+		if t.LastRun != nil {
+			next = t.LastRun.Add(t.interval())
+		}
+		t.NextRun, t.Due = &next, !now.Before(next)
+	}
+	return t
+}
+
+// recurringTestRequest is the body of POST and PUT /api/recurring.
+type recurringTestRequest struct {
+	ClientID string `json:"clientId"`
+	Label    string `json:"label"`
+	Interval string `json:"interval"`
+	Paused   bool   `json:"paused"`
+}
+
+func (req recurringTestRequest) validate() error {
+	d, err := time.ParseDuration(req.Interval)
+	if err != nil {
+		return &paramError{"interval", "must be a duration such as 6h"}
+	}
+	if d < minRecurringInterval || d > maxRecurringInterval {
+		return &paramError{"interval", fmt.Sprintf("must be between %s and %s", minRecurringInterval, maxRecurringInterval)}
+	}
+	if len(req.Label) > maxTagLength {
+		return &paramError{"label", fmt.Sprintf("must be at most %d characters", maxTagLength)}
+	}
+	return nil
+}
+
+var (
+	// recurringMu serializes updates of registrations, e.g. a run saved while
+	// the user edits the schedule.
+	recurringMu sync.Mutex
+	// recurringLimiter bounds how often each client can register tests.
+	recurringLimiter = newRateLimiter(6, 3)
+)
+
+// recurringStore returns the store as a RecurringTestStore, or responds.
+func recurringStore(w http.ResponseWriter, r *http.Request) (RecurringTestStore, bool) {
+	if !*recurringTests {
+		http.Error(w, tr(r, "Recurring tests are disabled on this server"), http.StatusNotFound)
+		return nil, false
+	}
+	store, ok := optionalStore[RecurringTestStore]()
+	if !ok {
+		http.Error(w, tr(r, "Recurring tests are not supported by this store"), http.StatusNotImplemented)
+	}
+	return store, ok
+}
+
+// bearerRecurringToken returns the recurring test token the request carries
+// as a bearer token, if any.
+func bearerRecurringToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(token, recurringTokenPrefix) {
+		return "", false
+	}
+	return token, true
+}
+
+// authenticateRecurring returns the registration of the request's token. On
+// failure it responds and returns false. The caller holds recurringMu.
+func authenticateRecurring(w http.ResponseWriter, r *http.Request, store RecurringTestStore) (RecurringTest, bool) {
+	token, _ := bearerRecurringToken(r)
+	id, secret, _ := strings.Cut(strings.TrimPrefix(token, recurringTokenPrefix), "_")
+	if id == "" || secret == "" {
+		http.Error(w, tr(r, "Invalid recurring test token"), http.StatusUnauthorized)
+		return RecurringTest{}, false
+	}
+	test, err := store.LoadRecurringTest(id)
+	if errors.Is(err, errRecurringTestNotFound) {
+		http.Error(w, tr(r, "Invalid recurring test token"), http.StatusUnauthorized)
+		return test, false
+	} else if err != nil {
+		log.Printf("Failed to load recurring test %s: %v", id, err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return test, false
+	}
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hash[:])), []byte(test.TokenHash)) != 1 {
+		http.Error(w, tr(r, "Invalid recurring test token"), http.StatusUnauthorized)
+		return test, false
+	}
+	return test, true
+}
+
+// recurringHandler serves /api/recurring. POST registers a recurring test
+// and returns it with its token; GET, PUT and DELETE, authenticated with the
+// token as a bearer token, return, change and remove it.
+func recurringHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := recurringStore(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == http.MethodPost {
+		registerRecurringTest(w, r, store)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, tr(r, "Only GET, POST, PUT and DELETE methods are supported"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	recurringMu.Lock()
+	defer recurringMu.Unlock()
+	test, ok := authenticateRecurring(w, r, store)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, test.view(time.Now()))
+	case http.MethodPut:
+		r.Body = http.MaxBytesReader(w, r.Body, 16*1024)
+		var req recurringTestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, tr(r, "Invalid JSON body"), http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			badRequest(w, err)
+			return
+		}
+		test.Label, test.Interval, test.Paused = req.Label, req.Interval, req.Paused
+		if err := store.SaveRecurringTest(test); err != nil {
+			log.Printf("Failed to save recurring test %s: %v", test.ID, err)
+			http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, test.view(time.Now()))
+	case http.MethodDelete:
+		if err := store.DeleteRecurringTest(test.ID); err != nil && !errors.Is(err, errRecurringTestNotFound) {
+			log.Printf("Failed to delete recurring test %s: %v", test.ID, err)
+			http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Recurring test %s deleted", test.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// registerRecurringTest serves POST /api/recurring.
+func registerRecurringTest(w http.ResponseWriter, r *http.Request, store RecurringTestStore) {
+	if !recurringLimiter.Allow(requestClientIP(r)) {
+		http.Error(w, tr(r, "Too many requests"), http.StatusTooManyRequests)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16*1024)
+	var req recurringTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, tr(r, "Invalid JSON body"), http.StatusBadRequest)
+		return
+	}
+	if !validClientID(req.ClientID) {
+		badRequest(w, &paramError{Param: "clientId", Reason: "must be 8 to 64 letters, digits, dashes or underscores"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(encoded))
+	test := RecurringTest{
+		ID:        uuid.NewString(),
+		ClientID:  req.ClientID,
+		Label:     req.Label,
+		Interval:  req.Interval,
+		Paused:    req.Paused,
+		Created:   time.Now(),
+		TokenHash: hex.EncodeToString(hash[:]),
+	}
+	if err := store.SaveRecurringTest(test); err != nil {
+		log.Printf("Failed to save recurring test: %v", err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Recurring test %s registered every %s", test.ID, test.Interval)
+
+	view := test.view(test.Created)
+	view.Token = recurringTokenPrefix + test.ID + "_" + encoded
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(view); err != nil {
+		log.Printf("Failed to encode recurring test: %v", err)
+	}
+}
+
+// recurringResultsHandler serves GET /api/recurring/results?limit=: the
+// results of the token's recurring test, newest first.
+func recurringResultsHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := recurringStore(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	p := newParamReader(r.URL.Query())
+	limit := p.Int("limit", 100, 1, maxRecurringResults)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+	recurringMu.Lock()
+	test, ok := authenticateRecurring(w, r, store)
+	recurringMu.Unlock()
+	if !ok {
+		return
+	}
+
+	results := []TestResult{}
+	filter := ResultFilter{ClientID: test.ClientID, TagKey: "recurring", TagValue: test.ID}
+	err := globalStore.IterateMatching(filter, func(result TestResult) error {
+		if len(results) >= limit {
+			return errPageFull
+		}
+		results = append(results, result)
+		return nil
+	})
+	if err != nil && err != errPageFull {
+		log.Printf("Failed to load results of recurring test %s: %v", test.ID, err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{"recurringTest": test.view(time.Now()), "results": results})
+}
+
+// recurringRun checks a result submitted with a recurring test token and
+// files it under the registration. It returns the registration as it was
+// before this run, or nil for a result without a token; on failure it
+// responds and returns false. Runs closer together than half the interval
+// are refused, so a looping worker cannot flood the store: the run is
+// recorded as the last one right away, so a concurrent save is refused too,
+// and cancelRecurringRun takes it back if the result cannot be saved.
+func recurringRun(w http.ResponseWriter, r *http.Request, result *TestResult) (*RecurringTest, bool) {
+	if _, ok := bearerRecurringToken(r); !ok {
+		return nil, true
+	}
+	store, ok := recurringStore(w, r)
+	if !ok {
+		return nil, false
+	}
+	recurringMu.Lock()
+	defer recurringMu.Unlock()
+	test, ok := authenticateRecurring(w, r, store)
+	if !ok {
+		return nil, false
+	}
+	if test.Paused {
+		http.Error(w, tr(r, "This recurring test is paused"), http.StatusConflict)
+		return nil, false
+	}
+	now := time.Now()
+	if test.LastRun != nil && now.Sub(*test.LastRun) < test.interval()/2 {
+		http.Error(w, tr(r, "This recurring test ran too recently"), http.StatusTooManyRequests)
+		return nil, false
+	}
+	reserved := test
+	reserved.LastRun = &now
+	if err := store.SaveRecurringTest(reserved); err != nil {
+		log.Printf("Failed to save recurring test %s: %v", test.ID, err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return nil, false
+	}
+	result.ClientID = test.ClientID
+	if result.Tags == nil {
+		result.Tags = map[string]string{}
+	}
+	result.Tags["recurring"] = test.ID
+	return &test, true
+}
+
+// cancelRecurringRun restores the last run of a registration when the run
+// recurringRun admitted was not saved.
+func cancelRecurringRun(before *RecurringTest) {
+	store, ok := optionalStore[RecurringTestStore]()
+	if !ok {
+		return
+	}
+	recurringMu.Lock()
+	defer recurringMu.Unlock()
+	test, err := store.LoadRecurringTest(before.ID)
+	if err != nil {
+		log.Printf("Failed to load recurring test %s: %v", before.ID, err)
+		return
+	}
+	test.LastRun = before.LastRun
+	if err := store.SaveRecurringTest(test); err != nil {
+		log.Printf("Failed to save recurring test %s: %v", before.ID, err)
+	}
+}
+
+// recordRecurringRun notes a saved run of a recurring test.
+func recordRecurringRun(id, resultID string, at time.Time) {
+	store, ok := optionalStore[RecurringTestStore]()
+	if !ok {
+		return
+	}
+	recurringMu.Lock()
+	defer recurringMu.Unlock()
+	test, err := store.LoadRecurringTest(id)
+	if err != nil {
+		log.Printf("Failed to load recurring test %s: %v", id, err)
+		return
+	}
+	test.LastRun, test.LastResultID = &at, resultID
+	test.Runs++
+	if err := store.SaveRecurringTest(test); err != nil {
+		log.Printf("Failed to save recurring test %s: %v", id, err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// registerTestRecurring registers a recurring test on a fresh SQLite store
+// and returns its token.
+func registerTestRecurring(t *testing.T) string {
+	t.Helper()
+	defer func(enabled bool) { *recurringTests = enabled }(*recurringTests)
+	*recurringTests = true
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	globalStore = store
+	recurringLimiter = newRateLimiter(6, 3)
+
+	w := httptest.NewRecorder()
+	recurringHandler(w, newSaveRequest(`{"clientId": "recurring-client", "interval": "6h"}`))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/recurring = %d %s", w.Code, w.Body)
+	}
+	var test RecurringTest
+	if err := json.Unmarshal(w.Body.Bytes(), &test); err != nil {
+		t.Fatal(err)
+	}
+	return test.Token
+}
+
+// slowSaveStore delays saving results.
+type slowSaveStore struct{ *SQLiteStore }
+
+func (s slowSaveStore) Save(result TestResult) (string, error) {
+	time.Sleep(20 * time.Millisecond)
+	return s.SQLiteStore.Save(result)
+}
+
+func TestRecurringRunOncePerInterval(t *testing.T) {
+	token := registerTestRecurring(t)
+	defer func(enabled bool) { *recurringTests = enabled }(*recurringTests)
+	*recurringTests = true
+
+	// A slow save keeps the first run from being recorded while the others
+	// reach the interval check.
+	globalStore = slowSaveStore{globalStore.(*SQLiteStore)}
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := newSaveRequest(`{"downloadSpeedMbps": 10}`)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			saveResultHandler(w, r)
+			codes[i] = w.Code
+		}()
+	}
+	wg.Wait()
+
+	saved := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusOK:
+			saved++
+		case http.StatusTooManyRequests:
+		default:
+			t.Errorf("POST /save-result = %d", code)
+		}
+	}
+	if saved != 1 {
+		t.Errorf("%d concurrent runs were saved, want 1", saved)
+	}
+}
+
+func TestSaveResultDropsRecurringTag(t *testing.T) {
+	result := saveTestResult(t, newSaveRequest(`{"downloadSpeedMbps": 10, "tags": {"recurring": "5f0c4b7e-1111-4222-8333-944445555666"}}`))
+	if v, ok := result.Tags["recurring"]; ok {
+		t.Errorf("client tag recurring=%s was stored without a token", v)
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore implements ResultStore in Redis, for deployments without local
+// disk. Results expire through key TTLs; index entries of expired results
+// are pruned on save or dropped when a walk finds them.
+//
+// Keyspace, below a configurable prefix:
+//
+//	result:<id>            the result as JSON
+//	results                sorted set of result IDs by timestamp (unix microseconds)
+//	tag:<key>=<value>      sorted set of the IDs of results with that tag
+//	client:<client id>     sorted set of the IDs of results saved by one device
+//	meta:result-ttl        retention the stored results expire by
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+
+	// Optional limits, enforced after each save; zero disables them.
+	resultTTL  time.Duration
+	maxResults int
+}
+
+// redisBatch is how many results are read per round trip when walking an index.
+const redisBatch = 100
+
+// redisTimeout bounds each store operation.
+const redisTimeout = 10 * time.Second
+
+// NewRedisStore connects to the Redis server at url, e.g.
+// redis://:password@host:6379/0, keeping every key below prefix.
+func NewRedisStore(url, prefix string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	log.Printf("Redis configured for result storage at %s (key prefix %q)", opts.Addr, prefix)
+	return &RedisStore{client: client, prefix: prefix}, nil
+}
+
+func (s *RedisStore) resultKey(id string) string { return s.prefix + "result:" + id }
+func (s *RedisStore) timeIndex() string          { return s.prefix + "results" }
+func (s *RedisStore) tagIndex(key, value string) string {
+	return s.prefix + "tag:" + key + "=" + value
+}
+
+func (s *RedisStore) clientIndex(clientID string) string {
+	return s.prefix + "client:" + clientID
+}
+
+// redisScore orders results by timestamp; microseconds are exact in a float64.
+func redisScore(t time.Time) float64 {
+	return float64(t.UnixMicro())
+}
+
+// SetLimits expires results ttl after they were saved and keeps only the
+// newest maxResults. Zero disables a limit. When the TTL differs from the one
+// the stored results were saved with, their expiry is updated.
+func (s *RedisStore) SetLimits(ttl time.Duration, maxResults int) error {
+	s.resultTTL = ttl
+	s.maxResults = maxResults
+	if err := s.applyTTL(); err != nil {
+		return err
+	}
+	return s.prune(s.timeIndex())
+}
+
+// applyTTL sets the expiry of every stored result to its timestamp plus the
+// TTL, or removes it, if the TTL changed since the results were saved.
+func (s *RedisStore) applyTTL() error {
+	ctx := context.Background()
+	stored, err := s.client.Get(ctx, s.prefix+"meta:result-ttl").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if stored == s.resultTTL.String() {
+		return nil
+	}
+	updated := 0
+	err = s.walk(s.timeIndex(), ResultFilter{}, true, nil, func(result TestResult) error {
+		key := s.resultKey(result.ID)
+		updated++
+		if s.resultTTL == 0 {
+			return s.client.Persist(ctx, key).Err()
+		}
+		return s.client.PExpireAt(ctx, key, result.Timestamp.Add(s.resultTTL)).Err()
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.client.Set(ctx, s.prefix+"meta:result-ttl", s.resultTTL.String(), 0).Err(); err != nil {
+		return err
+	}
+	if updated > 0 {
+		log.Printf("Applied result TTL %s to %d stored results", s.resultTTL, updated)
+	}
+	return nil
+}
+
+// Save generates a unique ID, saves the result, and returns the ID.
+func (s *RedisStore) Save(result TestResult) (string, error) {
+	result.ID = uuid.New().String()
+	result.Timestamp = time.Now() // Use server time for official record
+	return s.save(result)
+}
+
+// Import saves a result with its original ID and timestamp.
+func (s *RedisStore) Import(result TestResult) (string, error) {
+	if _, err := s.Load(result.ID); err == nil {
+		return "", fmt.Errorf("result %s: %w", result.ID, errResultExists)
+	}
+	return s.save(result)
+}
+
+// save stores result under its ID and timestamp.
+func (s *RedisStore) save(result TestResult) (string, error) {
+	id := result.ID
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	member := redis.Z{Score: redisScore(result.Timestamp), Member: id}
+	indexes := []string{s.timeIndex()}
+	for k, v := range result.Tags {
+		indexes = append(indexes, s.tagIndex(k, v))
+	}
+	if result.ClientID != "" {
+		indexes = append(indexes, s.clientIndex(result.ClientID))
+	}
+	ttl := s.resultTTL
+	if ttl > 0 {
+		// Imported results keep the expiry they had on their original server
+		if ttl = time.Until(result.Timestamp.Add(s.resultTTL)); ttl <= 0 {
+			return id, fmt.Errorf("result %s has already expired", id)
+		}
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.resultKey(id), data, ttl)
+		for _, index := range indexes {
+			pipe.ZAdd(ctx, index, member)
+		}
+		return nil
+	})
+	if err != nil {
+		return id, err
+	}
+	log.Printf("Result saved with ID: %s", id)
+
+	if s.resultTTL > 0 || s.maxResults > 0 {
+		if err := s.prune(indexes...); err != nil {
+			log.Printf("Failed to enforce result limits: %v", err)
+		}
+	}
+	return id, nil
+}
+
+// prune drops the entries of expired results from indexes and evicts the
+// oldest results beyond maxResults. Index entries of evicted or expired
+// results elsewhere are dropped when a walk finds them.
+func (s *RedisStore) prune(indexes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if s.resultTTL > 0 {
+		cutoff := "(" + strconv.FormatFloat(redisScore(time.Now().Add(-s.resultTTL)), 'f', -1, 64)
+		for _, index := range indexes {
+			if err := s.client.ZRemRangeByScore(ctx, index, "-inf", cutoff).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	if s.maxResults <= 0 {
+		return nil
+	}
+	n, err := s.client.ZCard(ctx, s.timeIndex()).Result()
+	if err != nil || n <= int64(s.maxResults) {
+		return err
+	}
+	oldest, err := s.client.ZRange(ctx, s.timeIndex(), 0, n-int64(s.maxResults)-1).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range oldest {
+		if err := s.Delete(id); err != nil && !strings.Contains(err.Error(), "result not found") {
+			return err
+		}
+	}
+	log.Printf("Evicted %d results beyond -max-results", len(oldest))
+	return nil
+}
+
+// Load retrieves a result by ID.
+func (s *RedisStore) Load(id string) (TestResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	data, err := s.client.Get(ctx, s.resultKey(id)).Bytes()
+	if err == redis.Nil {
+		return TestResult{}, fmt.Errorf("result not found for ID: %s", id)
+	} else if err != nil {
+		return TestResult{}, err
+	}
+	var result TestResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return TestResult{}, fmt.Errorf("invalid result %s: %w", id, err)
+	}
+	return result, nil
+}
+
+// Delete removes a result and its index entries.
+func (s *RedisStore) Delete(id string) error {
+	result, err := s.Load(id)
+	if err != nil {
+		// Also drop a stale index entry of an expired result
+		s.client.ZRem(context.Background(), s.timeIndex(), id)
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.resultKey(id))
+		pipe.ZRem(ctx, s.timeIndex(), id)
+		for k, v := range result.Tags {
+			pipe.ZRem(ctx, s.tagIndex(k, v), id)
+		}
+		if result.ClientID != "" {
+			pipe.ZRem(ctx, s.clientIndex(result.ClientID), id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Result deleted with ID: %s", id)
+	return nil
+}
+
+// redisPosition is a place in an index: a score and the ID at that score.
+type redisPosition struct {
+	score float64
+	id    string
+}
+
+// walk calls fn for the results in index within the filter's time range,
+// newest first (oldest first with ascending), resuming behind after when it
+// is set, and stopping at the first error. Results are checked against the
+// filter.
+func (s *RedisStore) walk(index string, filter ResultFilter, ascending bool, after *redisPosition, fn func(result TestResult) error) error {
+	lo, hi := "-inf", "+inf"
+	if !filter.From.IsZero() {
+		lo = strconv.FormatInt(filter.From.UnixMicro(), 10)
+	}
+	if !filter.To.IsZero() {
+		hi = strconv.FormatInt(filter.To.UnixMicro(), 10) // inclusive; Matches drops results at To
+	} else if !ascending {
+		// Fix the upper bound, so results saved meanwhile don't shift the offsets
+		hi = strconv.FormatInt(time.Now().UnixMicro()+1, 10)
+	}
+	if after != nil {
+		hi = strconv.FormatFloat(after.score, 'f', -1, 64)
+	}
+
+	ctx := context.Background()
+	for offset := int64(0); ; {
+		members, err := s.client.ZRangeArgsWithScores(ctx, redis.ZRangeArgs{
+			Key: index, Start: lo, Stop: hi, ByScore: true, Rev: !ascending, Offset: offset, Count: redisBatch,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if len(members) == 0 {
+			return nil
+		}
+		keys := make([]string, 0, len(members))
+		for _, m := range members {
+			keys = append(keys, s.resultKey(m.Member.(string)))
+		}
+		values, err := s.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return err
+		}
+		removed := 0
+		for i, m := range members {
+			id := m.Member.(string)
+			// Members at the cursor's score sort by ID, descending
+			if after != nil && m.Score == after.score && id >= after.id {
+				continue
+			}
+			data, ok := values[i].(string)
+			if !ok {
+				s.client.ZRem(ctx, index, id) // the result expired
+				removed++
+				continue
+			}
+			var result TestResult
+			if err := json.Unmarshal([]byte(data), &result); err != nil {
+				return fmt.Errorf("invalid result %s: %w", id, err)
+			}
+			if !filter.Matches(result) {
+				continue
+			}
+			if err := fn(result); err != nil {
+				return err
+			}
+		}
+		if len(members) < redisBatch {
+			return nil
+		}
+		offset += int64(len(members) - removed)
+	}
+}
+
+// filterIndex picks the sorted set to walk for filter.
+func (s *RedisStore) filterIndex(filter ResultFilter) string {
+	if filter.ClientID != "" {
+		return s.clientIndex(filter.ClientID)
+	}
+	if filter.TagKey != "" {
+		return s.tagIndex(filter.TagKey, filter.TagValue)
+	}
+	return s.timeIndex()
+}
+
+// Iterate calls fn for every stored result, oldest first, stopping at the first error.
+func (s *RedisStore) Iterate(fn func(result TestResult) error) error {
+	return s.walk(s.timeIndex(), ResultFilter{}, true, nil, fn)
+}
+
+// IterateMatching calls fn for every result matching filter, newest first,
+// stopping at the first error.
+func (s *RedisStore) IterateMatching(filter ResultFilter, fn func(result TestResult) error) error {
+	return s.walk(s.filterIndex(filter), filter, false, nil, fn)
+}
+
+// Query returns one page of results matching filter, newest first, along with
+// the total number of matches.
+func (s *RedisStore) Query(filter ResultFilter, offset, limit int) ([]TestResult, int, error) {
+	page := []TestResult{}
+	total := 0
+	err := s.IterateMatching(filter, func(result TestResult) error {
+		if total >= offset && len(page) < limit {
+			page = append(page, result)
+		}
+		total++
+		return nil
+	})
+	return page, total, err
+}
+
+// List returns up to limit results matching filter, newest first, starting
+// after cursor (empty for the first page). The returned cursor continues the
+// listing and is empty after the last page. Cursors are the score and ID of
+// the last result of a page, so pages stay stable while new results are saved.
+func (s *RedisStore) List(filter ResultFilter, cursor string, limit int) ([]TestResult, string, error) {
+	var after *redisPosition
+	if cursor != "" {
+		position, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		score, id, ok := strings.Cut(string(position), " ")
+		micros, err := strconv.ParseInt(score, 10, 64)
+		if !ok || err != nil {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		after = &redisPosition{score: float64(micros), id: id}
+	}
+
+	page := []TestResult{}
+	next := ""
+	err := s.walk(s.filterIndex(filter), filter, false, after, func(result TestResult) error {
+		if len(page) == limit {
+			last := page[len(page)-1]
+			next = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(last.Timestamp.UnixMicro(), 10) + " " + last.ID))
+			return errPageFull
+		}
+		page = append(page, result)
+		return nil
+	})
+	if err == errPageFull {
+		err = nil
+	}
+	return page, next, err
+}
+
+// Close closes the connection pool.
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
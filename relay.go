@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-netspeed/measure"
+)
+
+// relayBufferChunks bounds how far the upstream fetch may run ahead of the
+// client, so the upstream hop is measured without buffering whole payloads.
+const relayBufferChunks = 64
+
+// relayTimeout bounds a whole relay transfer, so a stalled origin cannot
+// hold a session open.
+const relayTimeout = 5 * time.Minute
+
+// relayClient fetches the relay origin.
+var relayClient = &http.Client{Timeout: relayTimeout}
+
+// RelayStats describes both hops of a relay test: origin to server and server to client.
+type RelayStats struct {
+	Origin        string  `json:"origin"`
+	Bytes         int64   `json:"bytes"`
+	FirstByteMs   float64 `json:"firstByteMs"`
+	DurationMs    float64 `json:"durationMs"`
+	UpstreamMbps  float64 `json:"upstreamMbps"`
+	ClientMbps    float64 `json:"clientMbps"`
+	Complete      bool    `json:"complete"`
+	UpstreamError string  `json:"upstreamError,omitempty"`
+}
+
+// relayRegistry keeps relay stats by session ID until the session expires.
+type relayRegistry struct {
+	mu    sync.Mutex
+	stats map[string]*RelayStats
+}
+
+var relays = &relayRegistry{stats: make(map[string]*RelayStats)}
+
+func (reg *relayRegistry) Set(id string, stats RelayStats) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for existing := range reg.stats {
+		if _, ok := activeSessions.Lookup(existing); !ok {
+			delete(reg.stats, existing)
+		}
+	}
+	reg.stats[id] = &stats
+}
+
+func (reg *relayRegistry) Get(id string) (RelayStats, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	stats, ok := reg.stats[id]
+	if !ok {
+		return RelayStats{}, false
+	}
+	return *stats, true
+}
+
+// relayHandler fetches the configured origin payload and streams it to the
+// client (a double hop). The upstream hop is read ahead into a bounded buffer
+// so its speed can be reported separately from the client hop, which lets
+// users tell "my line" from "my ISP's upstream". Stats are served at
+// /sessions/{id}/relay after the transfer.
+func relayHandler(w http.ResponseWriter, r *http.Request) {
+	if *relayOrigin == "" {
+		http.Error(w, tr(r, "Relay mode is not configured on this server"), http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The session is started first, so refused clients never reach the origin.
+	session, ok := startSession(w, "relay", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, *relayOrigin, nil)
+	if err != nil {
+		log.Printf("Invalid relay origin: %v", err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	start := time.Now()
+	resp, err := relayClient.Do(req)
+	if err != nil {
+		log.Printf("Relay origin request failed: %v", err)
+		http.Error(w, tr(r, "Relay origin unreachable"), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		http.Error(w, fmt.Sprintf("Relay origin returned status %d", resp.StatusCode), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("X-Session-ID", session.ID)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+
+	chunkSize := *downloadChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 1024 * 1024
+	}
+	limit := *maxDownloadSize * 1024 * 1024
+	stats := RelayStats{Origin: *relayOrigin}
+
+	// Upstream reader: fills the bounded buffer and measures the origin hop.
+	chunks := make(chan []byte, relayBufferChunks)
+	upstreamDone := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		body := io.LimitReader(resp.Body, limit)
+		for {
+			buf := make([]byte, chunkSize)
+			n, err := io.ReadFull(body, buf)
+			if n > 0 {
+				if stats.Bytes == 0 {
+					stats.FirstByteMs = float64(time.Since(start).Microseconds()) / 1000
+				}
+				stats.Bytes += int64(n)
+				select {
+				case chunks <- buf[:n]:
+				case <-r.Context().Done():
+					upstreamDone <- r.Context().Err()
+					return
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				stats.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+				upstreamDone <- nil
+				return
+			}
+			if err != nil {
+				upstreamDone <- err
+				return
+			}
+		}
+	}()
+
+	// Client writer: drains the buffer as fast as the client accepts it.
+	clientStart := time.Now()
+	var sent int64
+	for chunk := range chunks {
+		if _, err := w.Write(chunk); err != nil {
+			log.Printf("Relay write error: %v", err)
+			break
+		}
+		sent += int64(len(chunk))
+		session.AddBytes(int64(len(chunk)))
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	for range chunks {
+		// Drain so the upstream reader can finish if the client went away
+	}
+
+	if err := <-upstreamDone; err != nil {
+		stats.UpstreamError = err.Error()
+	} else {
+		stats.Complete = sent == stats.Bytes
+	}
+	stats.UpstreamMbps = measure.Mbps(stats.Bytes, time.Duration(stats.DurationMs*float64(time.Millisecond)))
+	stats.ClientMbps = measure.Mbps(sent, time.Since(clientStart))
+	relays.Set(session.ID, stats)
+	if *verbose {
+		log.Printf("Relay finished: %d bytes, upstream %.2f Mbps, client %.2f Mbps",
+			sent, stats.UpstreamMbps, stats.ClientMbps)
+	}
+}
+
+// relayStatsHandler serves /sessions/{id}/relay.
+func relayStatsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	stats, ok := relays.Get(id)
+	if !ok {
+		http.Error(w, tr(r, "Relay stats not found or not finished"), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, stats)
+}
+
+// relayEnabled reports whether relay mode is configured, for /api/config.
+func relayEnabled() bool {
+	return *relayOrigin != ""
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// ReplayedRequest pairs a recorded request with what the server did when it
+// was driven again.
+type ReplayedRequest struct {
+	Recorded      RecordedRequest
+	Skipped       string // reason the request was not replayed
+	Status        int
+	ResponseBytes int64
+	DurationMs    float64
+	Error         string
+}
+
+// replayRecording re-drives target with the requests of rec, keeping their
+// original start offsets so concurrent streams overlap as they did. Upload
+// bodies are zero bytes of the recorded size. WebRTC offers, saves and
+// session lookups are skipped: they need a browser peer, would store a
+// result, or refer to sessions of the original server.
+func replayRecording(target *url.URL, rec SessionRecording) []ReplayedRequest {
+	client := &http.Client{Timeout: peerTestTimeout}
+	out := make([]ReplayedRequest, len(rec.Requests))
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, req := range rec.Requests {
+		out[i].Recorded = req
+		switch {
+		case req.Path == "/webrtc/offer":
+			out[i].Skipped = "needs a WebRTC peer"
+			continue
+		case req.Path == "/save-result":
+			out[i].Skipped = "would store a result"
+			continue
+		case strings.HasPrefix(req.Path, "/sessions/"):
+			out[i].Skipped = "refers to a recorded session"
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Until(start.Add(time.Duration(req.OffsetMs * float64(time.Millisecond)))))
+			out[i].replay(client, replayURL(target, rec.ServerPort, req))
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// replayURL maps a recorded request onto target. Requests to the recorded
+// server's main port go to target's port; data-plane ports are kept.
+func replayURL(target *url.URL, serverPort int, req RecordedRequest) string {
+	u := *target
+	if req.Port != 0 && req.Port != serverPort {
+		u.Host = net.JoinHostPort(target.Hostname(), strconv.Itoa(req.Port))
+	}
+	u.Path = strings.TrimSuffix(target.Path, "/") + req.Path
+	u.RawQuery = req.Query
+	return u.String()
+}
+
+func (r *ReplayedRequest) replay(client *http.Client, target string) {
+	var body io.Reader
+	if r.Recorded.RequestBytes > 0 {
+		body = io.LimitReader(zeroReader{}, r.Recorded.RequestBytes)
+	}
+	req, err := http.NewRequest(r.Recorded.Method, target, body)
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	if body != nil {
+		req.ContentLength = r.Recorded.RequestBytes
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	r.Status = resp.StatusCode
+	r.ResponseBytes, err = io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	r.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+}
+
+// runReplayCommand replays recording files given as arguments against -target
+// and prints recorded and replayed status, size and duration side by side.
+func runReplayCommand(files []string) {
+	if *peerTarget == "" || len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "replay requires -target and at least one recording, e.g. replay -target http://localhost:8080 recordings/*.json")
+		os.Exit(2)
+	}
+	target, err := url.Parse(strings.TrimRight(*peerTarget, "/"))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		fmt.Fprintf(os.Stderr, "Invalid -target %q\n", *peerTarget)
+		os.Exit(2)
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", file, err)
+			os.Exit(1)
+		}
+		var rec SessionRecording
+		if err := json.Unmarshal(data, &rec); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid recording %s: %v\n", file, err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s: %d requests from %s recorded %s\n", file, len(rec.Requests), rec.Client, rec.StartedAt.Format(time.RFC3339))
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "OFFSET\tREQUEST\tRECORDED\tREPLAYED")
+		for _, r := range replayRecording(target, rec) {
+			rq := r.Recorded
+			recorded := fmt.Sprintf("%d %dB %.1fms", rq.Status, rq.ResponseBytes+rq.RequestBytes, rq.DurationMs)
+			replayed := fmt.Sprintf("%d %dB %.1fms", r.Status, r.ResponseBytes+rq.RequestBytes, r.DurationMs)
+			switch {
+			case r.Skipped != "":
+				replayed = "skipped: " + r.Skipped
+			case r.Error != "":
+				replayed = "error: " + r.Error
+			}
+			request := rq.Method + " " + rq.Path
+			if rq.Query != "" {
+				request += "?" + rq.Query
+			}
+			fmt.Fprintf(tw, "%.0fms\t%s\t%s\t%s\n", rq.OffsetMs, request, recorded, replayed)
+		}
+		tw.Flush()
+		if len(rec.WebRTC) > 0 {
+			fmt.Printf("Recorded WebRTC timelines: %d (not replayed)\n", len(rec.WebRTC))
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+	_ "time/tzdata" // IANA zones for -report-timezone on hosts without zoneinfo
+)
+
+const reportCheckInterval = time.Hour
+
+// reportLocation is the time zone report periods are bucketed in, so a day
+// starts at local midnight rather than UTC midnight.
+var reportLocation = time.UTC
+
+// loadReportLocation resolves the -report-timezone flag.
+func loadReportLocation(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("unknown report time zone %q: %w", name, err)
+	}
+	reportLocation = loc
+	return nil
+}
+
+// reportPeriods are the snapshot granularities and how many completed periods
+// to backfill when a snapshot is missing.
+var reportPeriods = []struct {
+	Name     string
+	Backfill int
+}{
+	{"daily", 31},
+	{"weekly", 12},
+}
+
+// MetricSummary aggregates one metric over a report period.
+type MetricSummary struct {
+	Avg float64 `json:"avg"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+}
+
+// ReportSnapshot is a persisted aggregate of the results in one period, kept
+// separately from raw results so trends survive retention pruning.
+type ReportSnapshot struct {
+	Period      string                   `json:"period"`   // daily or weekly
+	TimeZone    string                   `json:"timeZone"` // IANA zone the period boundaries are in
+	Start       time.Time                `json:"start"`
+	End         time.Time                `json:"end"`
+	Count       int                      `json:"count"`
+	Verified    int                      `json:"verified"`
+	Metrics     map[string]MetricSummary `json:"metrics,omitempty"`
+	GeneratedAt time.Time                `json:"generatedAt"`
+}
+
+// SnapshotStore is implemented by result stores that can persist report snapshots.
+type SnapshotStore interface {
+	SaveSnapshot(snapshot ReportSnapshot) error
+	HasSnapshot(period string, start time.Time) (bool, error)
+	Snapshots(period string, since time.Time) ([]ReportSnapshot, error)
+}
+
+// periodStart returns the start of the period containing t: midnight for
+// daily, Monday midnight for weekly.
+func periodStart(period string, t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if period == "weekly" {
+		offset := (int(day.Weekday()) + 6) % 7 // days since Monday
+		return day.AddDate(0, 0, -offset)
+	}
+	return day
+}
+
+// nextPeriod returns the start of the period after the one starting at start.
+func nextPeriod(period string, start time.Time) time.Time {
+	if period == "weekly" {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
+
+func summarize(values []float64) MetricSummary {
+	sort.Float64s(values)
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return MetricSummary{
+		Avg: sum / float64(len(values)),
+		Min: values[0],
+		Max: values[len(values)-1],
+		P50: percentile(values, 50),
+		P90: percentile(values, 90),
+		P95: percentile(values, 95),
+	}
+}
+
+// resultAggregate accumulates results for count and metric summaries.
+type resultAggregate struct {
+	count, verified int
+	values          map[string][]float64
+}
+
+func (a *resultAggregate) add(r TestResult) {
+	if a.values == nil {
+		a.values = map[string][]float64{}
+	}
+	a.count++
+	if r.Verified {
+		a.verified++
+	}
+	a.values["downloadSpeedMbps"] = append(a.values["downloadSpeedMbps"], r.DownloadSpeedMbps)
+	a.values["uploadSpeedMbps"] = append(a.values["uploadSpeedMbps"], r.UploadSpeedMbps)
+	a.values["latencyMs"] = append(a.values["latencyMs"], r.LatencyMs)
+	a.values["jitterMs"] = append(a.values["jitterMs"], r.JitterMs)
+	a.values["packetLossPercent"] = append(a.values["packetLossPercent"], r.PacketLossPercent)
+}
+
+// metrics summarizes each metric, or returns nil without results.
+func (a *resultAggregate) metrics() map[string]MetricSummary {
+	if a.count == 0 {
+		return nil
+	}
+	metrics := make(map[string]MetricSummary, len(a.values))
+	for metric, v := range a.values {
+		metrics[metric] = summarize(v)
+	}
+	return metrics
+}
+
+// aggregateResults aggregates the stored results matching filter.
+func aggregateResults(filter ResultFilter) (*resultAggregate, error) {
+	var agg resultAggregate
+	err := globalStore.IterateMatching(filter, func(r TestResult) error {
+		agg.add(r)
+		return nil
+	})
+	return &agg, err
+}
+
+// buildSnapshot aggregates the stored results between start and end.
+func buildSnapshot(period string, start, end time.Time) (ReportSnapshot, error) {
+	snapshot := ReportSnapshot{Period: period, TimeZone: start.Location().String(), Start: start, End: end, GeneratedAt: time.Now()}
+	agg, err := aggregateResults(ResultFilter{From: start, To: end})
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.Count, snapshot.Verified, snapshot.Metrics = agg.count, agg.verified, agg.metrics()
+	return snapshot, nil
+}
+
+// generateSnapshots persists snapshots for recently completed periods that do
+// not have one yet.
+func generateSnapshots(store SnapshotStore, now time.Time) {
+	for _, p := range reportPeriods {
+		current := periodStart(p.Name, now)
+		start := current
+		for i := 0; i < p.Backfill; i++ {
+			start = periodStart(p.Name, start.Add(-time.Nanosecond))
+		}
+		for ; start.Before(current); start = nextPeriod(p.Name, start) {
+			if ok, err := store.HasSnapshot(p.Name, start); err != nil || ok {
+				if err != nil {
+					log.Printf("Failed to check %s snapshot: %v", p.Name, err)
+				}
+				continue
+			}
+			snapshot, err := buildSnapshot(p.Name, start, nextPeriod(p.Name, start))
+			if err != nil {
+				log.Printf("Failed to build %s snapshot for %s: %v", p.Name, start.Format("2006-01-02"), err)
+				continue
+			}
+			if err := store.SaveSnapshot(snapshot); err != nil {
+				log.Printf("Failed to save %s snapshot: %v", p.Name, err)
+				continue
+			}
+			if *verbose {
+				log.Printf("Saved %s report snapshot for %s (%d results)", p.Name, start.Format("2006-01-02"), snapshot.Count)
+			}
+		}
+	}
+}
+
+// startReportScheduler generates missing snapshots at startup and then hourly.
+func startReportScheduler() {
+	store, ok := optionalStore[SnapshotStore]()
+	if !ok {
+		return
+	}
+	go func() {
+		for {
+			generateSnapshots(store, time.Now().In(reportLocation))
+			time.Sleep(reportCheckInterval)
+		}
+	}()
+}
+
+// adminTrendsHandler serves stored snapshots for ?period=daily|weekly over the
+// last ?periods= (default 30) periods.
+func adminTrendsHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := optionalStore[SnapshotStore]()
+	if !ok {
+		http.Error(w, tr(r, "Report snapshots are not supported by this store"), http.StatusNotImplemented)
+		return
+	}
+	req, err := parseTrendsRequest(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	period, periods := req.Period, req.Periods
+
+	since := periodStart(period, time.Now().In(reportLocation))
+	for i := 0; i < periods; i++ {
+		since = periodStart(period, since.Add(-time.Nanosecond))
+	}
+	snapshots, err := store.Snapshots(period, since)
+	if err != nil {
+		log.Printf("Failed to load report snapshots: %v", err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	// Skip snapshots whose boundaries came from a previously configured zone
+	matching := []ReportSnapshot{}
+	for _, s := range snapshots {
+		if periodStart(period, s.Start.In(reportLocation)).Equal(s.Start) {
+			matching = append(matching, s)
+		}
+	}
+	writeJSON(w, map[string]any{"period": period, "timeZone": reportLocation.String(), "snapshots": matching})
+}
+
+// ResultStats is the response of GET /stats.
+type ResultStats struct {
+	From     *time.Time               `json:"from,omitempty"`
+	To       *time.Time               `json:"to,omitempty"`
+	Count    int                      `json:"count"`
+	Verified int                      `json:"verified"`
+	Metrics  map[string]MetricSummary `json:"metrics,omitempty"`
+}
+
+// statsLimiter bounds how often each client can make the server aggregate
+// every stored result.
+var statsLimiter = newRateLimiter(30, 10)
+
+// statsHandler serves GET /stats: the count and per-metric average, min, max,
+// median (p50), p90 and p95 of the stored results saved between ?from= and
+// ?to= (RFC 3339, both optional), optionally only those with ?contended=false.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := parseStatsRequest(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if !statsLimiter.Allow(requestClientIP(r)) {
+		http.Error(w, tr(r, "Too many requests"), http.StatusTooManyRequests)
+		return
+	}
+
+	agg, err := aggregateResults(ResultFilter{From: req.From, To: req.To, Contended: req.Contended})
+	if err != nil {
+		log.Printf("Failed to compute stats: %v", err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+	stats := ResultStats{Count: agg.count, Verified: agg.verified, Metrics: agg.metrics()}
+	if !req.From.IsZero() {
+		stats.From = &req.From
+	}
+	if !req.To.IsZero() {
+		stats.To = &req.To
+	}
+	writeJSON(w, stats)
+}
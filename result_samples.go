@@ -0,0 +1,61 @@
+package main
+
+import "fmt"
+
+// Limits on the raw samples a result may carry: an hour of per-second
+// throughput per direction, and far more round trips than any test sends.
+const (
+	maxThroughputSamples = 3600
+	maxRTTSamples        = 10000
+)
+
+// ResultSamples are the raw measurements behind a result's summary numbers,
+// kept when the client sends them so results can be analyzed later, e.g. for
+// bufferbloat (latency under load) or throughput variance.
+type ResultSamples struct {
+	Download    []ThroughputSample `json:"download,omitempty"`    // about one per second
+	Upload      []ThroughputSample `json:"upload,omitempty"`      // about one per second
+	LatencyRTTs []float64          `json:"latencyRtts,omitempty"` // ms, one per latency ping in the order sent
+	JitterRTTs  []float64          `json:"jitterRtts,omitempty"`  // ms, data-channel echoes in arrival order
+}
+
+// ThroughputSample is the rate of a transfer over the interval ending
+// ElapsedMs after it started.
+type ThroughputSample struct {
+	ElapsedMs float64 `json:"elapsedMs"`
+	Mbps      float64 `json:"mbps"`
+}
+
+// validateResultSamples checks the samples submitted with a result; nil is valid.
+func validateResultSamples(s *ResultSamples) error {
+	if s == nil {
+		return nil
+	}
+	for _, series := range []struct {
+		name    string
+		samples []ThroughputSample
+	}{{"samples.download", s.Download}, {"samples.upload", s.Upload}} {
+		if len(series.samples) > maxThroughputSamples {
+			return &paramError{series.name, fmt.Sprintf("must have at most %d samples", maxThroughputSamples)}
+		}
+		for _, sample := range series.samples {
+			if sample.ElapsedMs < 0 || sample.Mbps < 0 {
+				return &paramError{series.name, "must not be negative"}
+			}
+		}
+	}
+	for _, series := range []struct {
+		name string
+		rtts []float64
+	}{{"samples.latencyRtts", s.LatencyRTTs}, {"samples.jitterRtts", s.JitterRTTs}} {
+		if len(series.rtts) > maxRTTSamples {
+			return &paramError{series.name, fmt.Sprintf("must have at most %d samples", maxRTTSamples)}
+		}
+		for _, rtt := range series.rtts {
+			if rtt < 0 {
+				return &paramError{series.name, "must not be negative"}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sampleInterval    = 250 * time.Millisecond
+	maxSessionSamples = 2400 // ten minutes at sampleInterval
+)
+
+// ProgressSample is one throughput measurement taken while a session transfers data.
+type ProgressSample struct {
+	ElapsedMs float64 `json:"elapsedMs"` // since the session started
+	Bytes     int64   `json:"bytes"`     // total transferred so far
+	Mbps      float64 `json:"mbps"`      // rate since the previous sample
+}
+
+// progressBus holds a session's samples and fans new ones out to subscribers.
+type progressBus struct {
+	samples     []ProgressSample
+	lastSample  time.Time
+	subscribers map[chan ProgressSample]struct{}
+	done        bool
+}
+
+// maybeSample records a sample if sampleInterval has passed since the last one.
+// Called on every transfer, so the common case only takes the lock briefly.
+func (s *TestSession) maybeSample() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.bus.lastSample
+	if last.IsZero() {
+		last = s.StartedAt
+	}
+	if s.bus.done || now.Sub(last) < sampleInterval {
+		return
+	}
+	s.recordSample(now)
+}
+
+// recordSample appends a sample and publishes it. s.mu must be held.
+func (s *TestSession) recordSample(now time.Time) {
+	bytes := s.Bytes()
+	var prevBytes int64
+	prevAt := s.StartedAt
+	if n := len(s.bus.samples); n > 0 {
+		prevBytes = s.bus.samples[n-1].Bytes
+		prevAt = s.bus.lastSample
+	}
+	sample := ProgressSample{
+		ElapsedMs: float64(now.Sub(s.StartedAt).Microseconds()) / 1000,
+		Bytes:     bytes,
+	}
+	if secs := now.Sub(prevAt).Seconds(); secs > 0 {
+		// Same units as the web client: (Bytes * 8) / (Seconds * 1024^2)
+		sample.Mbps = float64(bytes-prevBytes) * 8 / (secs * 1024 * 1024)
+	}
+	s.bus.lastSample = now
+	if len(s.bus.samples) < maxSessionSamples {
+		s.bus.samples = append(s.bus.samples, sample)
+	}
+	for ch := range s.bus.subscribers {
+		select {
+		case ch <- sample:
+		default: // Drop samples for subscribers that fall behind
+		}
+	}
+}
+
+// closeBus records a final sample and ends all subscriptions.
+func (s *TestSession) closeBus() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bus.done {
+		return
+	}
+	if s.Bytes() > 0 {
+		s.recordSample(time.Now())
+	}
+	s.bus.done = true
+	for ch := range s.bus.subscribers {
+		close(ch)
+	}
+	s.bus.subscribers = nil
+}
+
+// Samples returns the samples recorded so far.
+func (s *TestSession) Samples() []ProgressSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ProgressSample(nil), s.bus.samples...)
+}
+
+// Subscribe returns the samples recorded so far and a channel of subsequent
+// ones, closed when the session finishes. cancel must be called when done.
+func (s *TestSession) Subscribe() (past []ProgressSample, ch <-chan ProgressSample, cancel func()) {
+	c := make(chan ProgressSample, 16)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	past = append([]ProgressSample(nil), s.bus.samples...)
+	if s.bus.done {
+		close(c)
+		return past, c, func() {}
+	}
+	if s.bus.subscribers == nil {
+		s.bus.subscribers = make(map[chan ProgressSample]struct{})
+	}
+	s.bus.subscribers[c] = struct{}{}
+	return past, c, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.bus.subscribers[c]; ok {
+			delete(s.bus.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+// currentMbps returns the rate of the latest sample, or zero before the first.
+func (s *TestSession) currentMbps() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.bus.samples); n > 0 {
+		return s.bus.samples[n-1].Mbps
+	}
+	return 0
+}
+
+// sessionSamplesHandler serves /sessions/{id}/samples as JSON, or as a live
+// Server-Sent Events stream when the client accepts text/event-stream.
+func sessionSamplesHandler(w http.ResponseWriter, r *http.Request, id string) {
+	s, ok := activeSessions.Lookup(id)
+	if !ok {
+		http.Error(w, tr(r, "Session not found or expired"), http.StatusNotFound)
+		return
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		response := map[string]any{
+			"sessionId": s.ID,
+			"type":      s.Type,
+			"protocol":  s.Protocol,
+			"startedAt": s.StartedAt,
+			"samples":   s.Samples(),
+		}
+		if info := s.TCPInfo(); info != nil {
+			response["tcpInfo"] = info
+		}
+		if c := s.Completion(); c != nil {
+			response["completion"] = c
+		}
+		response["load"] = activeSessions.Load([]*TestSession{s})
+		writeJSON(w, response)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, tr(r, "Streaming unsupported"), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	past, ch, cancel := s.Subscribe()
+	defer cancel()
+	for _, sample := range past {
+		writeSampleEvent(w, sample)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case sample, ok := <-ch:
+			if !ok {
+				fmt.Fprint(w, "event: done\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			writeSampleEvent(w, sample)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSampleEvent(w http.ResponseWriter, sample ProgressSample) {
+	data, _ := json.Marshal(sample)
+	fmt.Fprintf(w, "event: sample\ndata: %s\n\n", data)
+}
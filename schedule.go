@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// minScheduleInterval keeps scheduled tests from competing with client tests.
+const minScheduleInterval = time.Minute
+
+// ScheduleConfig is the content of -schedule-file: the tests the server runs
+// on its own, and when it must not run them.
+type ScheduleConfig struct {
+	Blackouts []Blackout `json:"blackouts,omitempty"` // apply to every schedule
+	Schedules []Schedule `json:"schedules"`
+}
+
+// Schedule is a set of tests run every Interval.
+type Schedule struct {
+	Name          string     `json:"name"`
+	Probes        []string   `json:"probes,omitempty"`        // URLs probed like -probe-targets
+	Peers         []string   `json:"peers,omitempty"`         // netspeed servers to run peer tests against
+	PeerSizeMB    int64      `json:"peerSizeMB,omitempty"`    // download and upload size of peer tests
+	Interval      string     `json:"interval"`                // e.g. "15m"
+	MaxConcurrent int        `json:"maxConcurrent,omitempty"` // tests of one run in parallel; 1 when zero
+	Blackouts     []Blackout `json:"blackouts,omitempty"`
+
+	interval time.Duration
+}
+
+// Blackout is a recurring window during which scheduled tests do not start,
+// e.g. backup hours. A run that falls due in a window waits for its end.
+type Blackout struct {
+	Days     []string `json:"days,omitempty"`     // "mon" to "sun" the window starts on; every day when empty
+	Start    string   `json:"start"`              // "HH:MM"
+	End      string   `json:"end"`                // "HH:MM", the next day when not after Start
+	Timezone string   `json:"timezone,omitempty"` // IANA name, e.g. "Europe/Berlin"; the server's when empty
+
+	days       [7]bool
+	start, end int // minutes after midnight
+	loc        *time.Location
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parse checks the window and fills in its parsed fields.
+func (b *Blackout) parse(param string) error {
+	for _, day := range b.Days {
+		i := slices.Index(weekdays, strings.ToLower(day))
+		if i < 0 {
+			return &paramError{param + ".days", fmt.Sprintf("%q is not one of %s", day, strings.Join(weekdays, ", "))}
+		}
+		b.days[i] = true
+	}
+	if len(b.Days) == 0 {
+		b.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+		dst   *int
+	}{{"start", b.Start, &b.start}, {"end", b.End, &b.end}} {
+		t, err := time.Parse("15:04", field.value)
+		if err != nil {
+			return &paramError{param + "." + field.name, fmt.Sprintf("%q is not a HH:MM time", field.value)}
+		}
+		*field.dst = t.Hour()*60 + t.Minute()
+	}
+	if b.start == b.end {
+		return &paramError{param, "start and end must differ"}
+	}
+	b.loc = time.Local
+	if b.Timezone != "" {
+		loc, err := time.LoadLocation(b.Timezone)
+		if err != nil {
+			return &paramError{param + ".timezone", err.Error()}
+		}
+		b.loc = loc
+	}
+	return nil
+}
+
+// active reports whether t is inside the window.
+func (b *Blackout) active(t time.Time) bool {
+	t = t.In(b.loc)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if b.start < b.end {
+		return minute >= b.start && minute < b.end && b.days[day]
+	}
+	// The window runs past midnight
+	if minute >= b.start {
+		return b.days[day]
+	}
+	return minute < b.end && b.days[(day+6)%7]
+}
+
+// validate checks the configuration and fills in its parsed fields.
+func (c *ScheduleConfig) validate() error {
+	for i := range c.Blackouts {
+		if err := c.Blackouts[i].parse(fmt.Sprintf("blackouts[%d]", i)); err != nil {
+			return err
+		}
+	}
+	names := make(map[string]bool)
+	for i := range c.Schedules {
+		s := &c.Schedules[i]
+		param := fmt.Sprintf("schedules[%d]", i)
+		if s.Name == "" || names[s.Name] {
+			return &paramError{param + ".name", "must be set and unique"}
+		}
+		names[s.Name] = true
+		if len(s.Probes) == 0 && len(s.Peers) == 0 {
+			return &paramError{param, "needs probes, peers or both"}
+		}
+		for _, peer := range s.Peers {
+			if _, err := parseTargetURL(peer); err != nil {
+				return &paramError{param + ".peers", err.Error()}
+			}
+		}
+		if s.PeerSizeMB < 0 {
+			return &paramError{param + ".peerSizeMB", "must not be negative"}
+		}
+		interval, err := time.ParseDuration(s.Interval)
+		if err != nil || interval < minScheduleInterval {
+			return &paramError{param + ".interval", fmt.Sprintf("%q is not a duration of at least %s", s.Interval, minScheduleInterval)}
+		}
+		s.interval = interval
+		if s.MaxConcurrent < 0 {
+			return &paramError{param + ".maxConcurrent", "must not be negative"}
+		}
+		for j := range s.Blackouts {
+			if err := s.Blackouts[j].parse(fmt.Sprintf("%s.blackouts[%d]", param, j)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ScheduleStatus is the state of a running schedule, for GET /admin/api/schedules.
+type ScheduleStatus struct {
+	Name       string     `json:"name"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	NextRun    time.Time  `json:"nextRun"`
+	Running    int        `json:"running"` // tests in progress
+	BlackedOut bool       `json:"blackedOut"`
+	Postponed  int        `json:"postponed"` // runs delayed by a blackout since startup
+}
+
+// scheduler runs the current ScheduleConfig; stop ends its goroutines when
+// the admin API replaces it.
+var scheduler = struct {
+	sync.Mutex
+	config ScheduleConfig
+	status map[string]*ScheduleStatus
+	stop   chan struct{}
+}{}
+
+// loadSchedules reads -schedule-file, or turns -probe-targets into a schedule.
+// A missing file is an empty configuration, written on the first change.
+func loadSchedules() error {
+	var config ScheduleConfig
+	if *scheduleFile == "" {
+		if targets := parseProbeTargets(*probeTargets); len(targets) > 0 {
+			interval := *probeInterval
+			if interval < minScheduleInterval {
+				log.Printf("Probe interval %s is too short, using %s", interval, minScheduleInterval)
+				interval = minScheduleInterval
+			}
+			config.Schedules = []Schedule{{Name: "probes", Probes: targets, Interval: interval.String(), interval: interval}}
+		}
+	} else {
+		if *probeTargets != "" {
+			return fmt.Errorf("-probe-targets cannot be combined with -schedule-file; add the targets to a schedule in the file")
+		}
+		data, err := os.ReadFile(*scheduleFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &config); err != nil {
+				return fmt.Errorf("%s: %w", *scheduleFile, err)
+			}
+		}
+		if err := config.validate(); err != nil {
+			return fmt.Errorf("%s: %w", *scheduleFile, err)
+		}
+	}
+	scheduler.config = config
+	return nil
+}
+
+// startScheduler runs each schedule of the loaded configuration.
+func startScheduler() {
+	scheduler.Lock()
+	defer scheduler.Unlock()
+	runSchedules()
+}
+
+// runSchedules starts the goroutines of scheduler.config; the caller holds
+// the scheduler lock.
+func runSchedules() {
+	scheduler.stop = make(chan struct{})
+	scheduler.status = make(map[string]*ScheduleStatus)
+	pruneMatrices(scheduler.config.Schedules)
+	for _, s := range scheduler.config.Schedules {
+		status := &ScheduleStatus{Name: s.Name}
+		scheduler.status[s.Name] = status
+		go runSchedule(s, scheduler.config.Blackouts, status, scheduler.stop)
+	}
+	if n := len(scheduler.config.Schedules); n > 0 {
+		log.Printf("Running %d test schedules", n)
+	}
+}
+
+// runSchedule runs the tests of s once at startup and then every interval,
+// holding each run back while a blackout is active.
+func runSchedule(s Schedule, global []Blackout, status *ScheduleStatus, stop chan struct{}) {
+	blackedOut := func(t time.Time) bool {
+		for _, b := range slices.Concat(global, s.Blackouts) {
+			if b.active(t) {
+				return true
+			}
+		}
+		return false
+	}
+	setStatus := func(update func(*ScheduleStatus)) {
+		scheduler.Lock()
+		update(status)
+		scheduler.Unlock()
+	}
+
+	next := time.Now()
+	for {
+		setStatus(func(st *ScheduleStatus) { st.NextRun = next })
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if blackedOut(time.Now()) {
+			var postponed bool
+			setStatus(func(st *ScheduleStatus) {
+				if postponed = !st.BlackedOut; postponed {
+					st.Postponed++
+				}
+				st.BlackedOut = true
+			})
+			if postponed {
+				log.Printf("Schedule %s is due during a blackout, waiting for it to end", s.Name)
+			}
+			// Windows are minute-aligned, so check again at the next minute
+			next = time.Now().Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		now := time.Now()
+		setStatus(func(st *ScheduleStatus) { st.BlackedOut, st.LastRun = false, &now })
+		runScheduledTests(s, status)
+		next = time.Now().Add(s.interval)
+	}
+}
+
+// runScheduledTests runs the probes and peer tests of one run, at most
+// MaxConcurrent at a time. The peer test results form the run's matrix.
+func runScheduledTests(s Schedule, status *ScheduleStatus) {
+	var tests []func()
+	for _, target := range s.Probes {
+		tests = append(tests, func() { recordProbe(probeTarget(target)) })
+	}
+	matrix := MatrixRun{Schedule: s.Name, RunID: uuid.NewString(), Started: time.Now(), Cells: make([]MatrixCell, len(s.Peers))}
+	tags := map[string]string{"schedule": s.Name, "run": matrix.RunID}
+	for i, peer := range s.Peers {
+		tests = append(tests, func() {
+			result, err := runPeerTest(peer, s.PeerSizeMB, tags)
+			if err != nil {
+				log.Printf("Scheduled peer test to %s failed: %v", peer, err)
+			}
+			matrix.Cells[i] = newMatrixCell(peer, result, err)
+		})
+	}
+
+	slots := make(chan struct{}, max(s.MaxConcurrent, 1))
+	var wg sync.WaitGroup
+	for _, test := range tests {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduler.Lock()
+			status.Running++
+			scheduler.Unlock()
+			test()
+			scheduler.Lock()
+			status.Running--
+			scheduler.Unlock()
+			<-slots
+		}()
+	}
+	wg.Wait()
+	if len(s.Peers) > 0 {
+		matrix.Finished = time.Now()
+		recordMatrix(matrix)
+	}
+}
+
+// saveSchedules writes config to -schedule-file, replacing it atomically.
+func saveSchedules(config ScheduleConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(*scheduleFile), ".schedules-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), *scheduleFile)
+}
+
+// adminSchedulesHandler serves /admin/api/schedules: GET returns the schedule
+// configuration and the state of each schedule, PUT replaces the
+// configuration, saves it to -schedule-file and restarts the schedules.
+func adminSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		scheduler.Lock()
+		statuses := make([]ScheduleStatus, 0, len(scheduler.config.Schedules))
+		for _, s := range scheduler.config.Schedules {
+			statuses = append(statuses, *scheduler.status[s.Name])
+		}
+		config := scheduler.config
+		scheduler.Unlock()
+		writeJSON(w, map[string]any{"config": config, "status": statuses})
+	case http.MethodPut:
+		if *scheduleFile == "" {
+			http.Error(w, tr(r, "Schedules are read-only; set -schedule-file"), http.StatusNotImplemented)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+		var config ScheduleConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, tr(r, "Invalid JSON schedules"), http.StatusBadRequest)
+			return
+		}
+		if err := config.validate(); err != nil {
+			badRequest(w, err)
+			return
+		}
+		if err := saveSchedules(config); err != nil {
+			log.Printf("Failed to save schedules: %v", err)
+			http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+			return
+		}
+
+		scheduler.Lock()
+		close(scheduler.stop)
+		scheduler.config = config
+		runSchedules()
+		scheduler.Unlock()
+		log.Printf("Schedules updated: %d schedules, %d global blackouts", len(config.Schedules), len(config.Blackouts))
+		writeJSON(w, config)
+	default:
+		http.Error(w, tr(r, "Only GET and PUT methods are supported"), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	selfCheckProbePath  = "/api/selfcheck/probe"
+	selfCheckProbeDelay = 750 * time.Millisecond
+	selfCheckTimeout    = 5 * time.Second
+	websocketGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// SelfCheckFinding is a single result reported by /api/selfcheck.
+type SelfCheckFinding struct {
+	Check  string `json:"check"`
+	Status string `json:"status"` // pass, warn, fail or skip
+	Detail string `json:"detail"`
+	Advice string `json:"advice,omitempty"`
+}
+
+// SelfCheckReport is the response body of /api/selfcheck.
+type SelfCheckReport struct {
+	PublicURL string             `json:"publicUrl"`
+	Findings  []SelfCheckFinding `json:"findings"`
+}
+
+// selfCheckProbeHandler streams two small pieces separated by a delay and
+// without a Content-Length. It also accepts a WebSocket upgrade and closes
+// immediately, which is enough to prove upgrades reach the server.
+func selfCheckProbeHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		acceptWebSocketProbe(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("X-Netspeed-Probe", "1")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, "first")
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	select {
+	case <-time.After(selfCheckProbeDelay):
+	case <-r.Context().Done():
+		return
+	}
+	fmt.Fprintln(w, "second")
+}
+
+func acceptWebSocketProbe(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	hj, ok := w.(http.Hijacker)
+	if key == "" || !ok {
+		http.Error(w, tr(r, "WebSocket upgrade not supported"), http.StatusBadRequest)
+		return
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		log.Printf("Self-check WebSocket hijack failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		websocketAcceptKey(key))
+	buf.Flush()
+}
+
+// selfCheckHandler probes the server through its public URL and reports
+// deployment problems introduced by reverse proxies or CDNs. Only the
+// configured -public-url is probed, never a URL taken from the request, so
+// the endpoint cannot be used to make the server connect to other hosts.
+func selfCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	report := SelfCheckReport{PublicURL: *publicURL}
+	if report.PublicURL == "" {
+		report.Findings = []SelfCheckFinding{{
+			Check:  "public-url",
+			Status: "skip",
+			Detail: "No public URL is configured.",
+			Advice: "Set -public-url to the address clients use to reach the server.",
+		}}
+		writeJSON(w, report)
+		return
+	}
+	base, err := url.Parse(report.PublicURL)
+	if err != nil || base.Host == "" {
+		http.Error(w, tr(r, "Invalid public URL"), http.StatusInternalServerError)
+		return
+	}
+
+	// A dedicated client that neither decompresses nor reuses connections, so
+	// every check observes exactly what the upstream path delivers.
+	client := &http.Client{
+		Timeout: selfCheckTimeout,
+		Transport: &http.Transport{
+			Proxy:              http.ProxyFromEnvironment,
+			DisableCompression: true,
+			DisableKeepAlives:  true,
+		},
+	}
+
+	report.Findings = append(report.Findings,
+		checkBuffering(client, base),
+		checkCompression(client, base),
+		checkChunking(client, base),
+		checkWebSocket(base),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Failed to encode self-check report: %v", err)
+	}
+}
+
+func probeURL(base *url.URL, path string) string {
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = ""
+	return u.String()
+}
+
+// checkBuffering measures how long the first streamed byte takes to arrive.
+// If it only shows up after the server's deliberate pause, something upstream
+// is holding the response until it completes.
+func checkBuffering(client *http.Client, base *url.URL) SelfCheckFinding {
+	finding := SelfCheckFinding{Check: "response-buffering"}
+
+	start := time.Now()
+	resp, err := client.Get(probeURL(base, selfCheckProbePath))
+	if err != nil {
+		finding.Status = "fail"
+		finding.Detail = fmt.Sprintf("Could not reach the public URL: %v", err)
+		finding.Advice = "Set -public-url to the address clients use, and make sure the server can resolve and reach it."
+		return finding
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("X-Netspeed-Probe") == "" {
+		finding.Status = "fail"
+		finding.Detail = fmt.Sprintf("The public URL answered with HTTP %d but the response did not come from this server.", resp.StatusCode)
+		finding.Advice = "Check that the proxy forwards /api/ paths to go-netspeed."
+		return finding
+	}
+
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(resp.Body, first); err != nil {
+		finding.Status = "fail"
+		finding.Detail = fmt.Sprintf("Failed to read probe body: %v", err)
+		return finding
+	}
+	ttfb := time.Since(start)
+	io.Copy(io.Discard, resp.Body)
+
+	if ttfb >= selfCheckProbeDelay {
+		finding.Status = "fail"
+		finding.Detail = fmt.Sprintf("First byte arrived after %v, the server flushed it immediately.", ttfb.Round(time.Millisecond))
+		finding.Advice = "Disable response buffering for this site (nginx: proxy_buffering off; or X-Accel-Buffering: no)."
+		return finding
+	}
+	finding.Status = "pass"
+	finding.Detail = fmt.Sprintf("First byte arrived after %v.", ttfb.Round(time.Millisecond))
+	return finding
+}
+
+// checkCompression verifies the download payload is not re-encoded on the way,
+// which would make download speeds look far higher than the line allows.
+func checkCompression(client *http.Client, base *url.URL) SelfCheckFinding {
+	finding := SelfCheckFinding{Check: "forced-compression"}
+
+	req, err := http.NewRequest(http.MethodGet, probeURL(base, "/download")+"?size=1", nil)
+	if err != nil {
+		finding.Status = "skip"
+		finding.Detail = err.Error()
+		return finding
+	}
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	if consentTerms != nil {
+		req.Header.Set("X-Consent-Version", consentTerms.Version)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		finding.Status = "skip"
+		finding.Detail = fmt.Sprintf("Download probe failed: %v", err)
+		return finding
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	// An error page says nothing about how test data is delivered
+	if resp.StatusCode != http.StatusOK {
+		finding.Status = "skip"
+		finding.Detail = fmt.Sprintf("Download probe answered with HTTP %d.", resp.StatusCode)
+		return finding
+	}
+
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		finding.Status = "fail"
+		finding.Detail = fmt.Sprintf("Download responses are served with Content-Encoding: %s.", enc)
+		finding.Advice = "Exclude /download and /upload from proxy or CDN compression; compressed test data inflates results."
+		return finding
+	}
+	finding.Status = "pass"
+	finding.Detail = "Download payload is delivered uncompressed."
+	return finding
+}
+
+// checkChunking confirms streamed responses stay streamed. A proxy that
+// collects the body and adds its own Content-Length has removed chunking.
+func checkChunking(client *http.Client, base *url.URL) SelfCheckFinding {
+	finding := SelfCheckFinding{Check: "chunked-streaming"}
+
+	resp, err := client.Get(probeURL(base, selfCheckProbePath))
+	if err != nil {
+		finding.Status = "skip"
+		finding.Detail = fmt.Sprintf("Probe failed: %v", err)
+		return finding
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.ContentLength >= 0 {
+		finding.Status = "warn"
+		finding.Detail = fmt.Sprintf("A streamed response arrived with Content-Length: %d over %s.", resp.ContentLength, resp.Proto)
+		finding.Advice = "The proxy is re-assembling streamed responses; enable streaming/chunked pass-through."
+		return finding
+	}
+	finding.Status = "pass"
+	finding.Detail = fmt.Sprintf("Streamed response kept its framing over %s.", resp.Proto)
+	return finding
+}
+
+// checkWebSocket attempts an upgrade handshake through the public URL.
+func checkWebSocket(base *url.URL) SelfCheckFinding {
+	finding := SelfCheckFinding{Check: "websocket-upgrade"}
+
+	host := base.Host
+	if base.Port() == "" {
+		if base.Scheme == "https" {
+			host = net.JoinHostPort(base.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(base.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: selfCheckTimeout}
+	var conn net.Conn
+	var err error
+	if base.Scheme == "https" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: base.Hostname(), NextProtos: []string{"http/1.1"}})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		finding.Status = "skip"
+		finding.Detail = fmt.Sprintf("Could not connect to %s: %v", host, err)
+		return finding
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(selfCheckTimeout))
+
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: %s\r\n\r\n",
+		probeURL(&url.URL{Path: base.Path}, selfCheckProbePath), base.Host, base64.StdEncoding.EncodeToString([]byte("netspeed-selfcheck")))
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		finding.Status = "fail"
+		finding.Detail = fmt.Sprintf("No valid handshake response: %v", err)
+		finding.Advice = "Allow Upgrade/Connection headers to pass through the proxy."
+		return finding
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		finding.Status = "fail"
+		finding.Detail = fmt.Sprintf("Upgrade request answered with HTTP %d.", resp.StatusCode)
+		finding.Advice = "Allow WebSocket upgrades (nginx: proxy_set_header Upgrade $http_upgrade; proxy_set_header Connection \"upgrade\")."
+		return finding
+	}
+	finding.Status = "pass"
+	finding.Detail = "WebSocket upgrades reach the server."
+	return finding
+}
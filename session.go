@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestSession tracks a single in-flight test (a download, an upload or a WebRTC echo session).
+type TestSession struct {
+	ID        string
+	Type      string
+	Client    string
+	StartedAt time.Time
+	Protocol  string // see requestProtocol
+
+	bytes     atomic.Int64
+	chunkSize atomic.Int64
+
+	mu      sync.Mutex
+	bus     progressBus
+	conn    *net.TCPConn // of a download or upload, until tcpInfo is read
+	tcpInfo *TCPInfo
+	goal    *downloadGoal // of a download, see SetDownloadGoal
+	outcome string        // of a download once it ended
+	endedAt time.Time
+
+	// Guarded by the registry lock
+	finishedAt  time.Time
+	overlapping map[string]bool // sessions of other clients running at the same time
+	startBytes  int64           // serverBytes when the session started
+	endBytes    int64           // and when it finished
+}
+
+// SessionSnapshot is the JSON view of a TestSession at a point in time.
+type SessionSnapshot struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Client    string    `json:"client"`
+	StartedAt time.Time `json:"startedAt"`
+	Bytes     int64     `json:"bytes"`
+	ChunkSize int64     `json:"chunkSize,omitempty"` // effective write granularity of a download
+	Mbps      float64   `json:"mbps"`                // rate of the latest progress sample
+	Protocol  string    `json:"protocol"`
+
+	Completion *DownloadCompletion `json:"completion,omitempty"` // of a download
+}
+
+// AddBytes records bytes transferred by the session and publishes a progress
+// sample when one is due.
+func (s *TestSession) AddBytes(n int64) {
+	s.bytes.Add(n)
+	serverBytes.Add(n)
+	s.maybeSample()
+}
+
+// Bytes returns the bytes transferred so far.
+func (s *TestSession) Bytes() int64 {
+	return s.bytes.Load()
+}
+
+// SetChunkSize records the write granularity the session streams with.
+func (s *TestSession) SetChunkSize(n int64) {
+	s.chunkSize.Store(n)
+}
+
+func (s *TestSession) snapshot() SessionSnapshot {
+	return SessionSnapshot{ID: s.ID, Type: s.Type, Client: s.Client, StartedAt: s.StartedAt, Bytes: s.Bytes(), ChunkSize: s.chunkSize.Load(), Mbps: s.currentMbps(), Protocol: s.Protocol, Completion: s.Completion()}
+}
+
+// recentTestWindow is how long a client counts as having tested against this
+// server after a session completes, for marking its saved results as verified.
+const recentTestWindow = 15 * time.Minute
+
+// maxFinishedSessions bounds how many completed sessions are kept (for at most
+// recentTestWindow) so their progress samples can still be fetched.
+const maxFinishedSessions = 1000
+
+// sessionRegistry holds the tests currently running on the server, plus the
+// clients and sessions that recently completed.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*TestSession
+	finished map[string]*TestSession
+	recent   map[string]time.Time // client -> last completed session with traffic
+}
+
+var activeSessions = &sessionRegistry{
+	sessions: make(map[string]*TestSession),
+	finished: make(map[string]*TestSession),
+	recent:   make(map[string]time.Time),
+}
+
+var (
+	sessionsRejected = newCounterVec("netspeed_sessions_rejected_total",
+		"Test sessions refused by the session limits or during shutdown, by type and reason.", "type", "reason")
+	_ = newGaugeFunc("netspeed_active_sessions",
+		"Test sessions currently running, by type.", []string{"type"}, activeSessions.countByType)
+)
+
+// Reasons Start refuses a session.
+var (
+	errServerBusy   = errors.New("server is running too many tests")
+	errClientBusy   = errors.New("too many tests running from this client")
+	errShuttingDown = errors.New("server is shutting down")
+)
+
+// Start registers a new session of the given type for the request's client.
+// It fails when the server is shutting down or the server or client is at
+// its -max-sessions or -max-client-sessions limit.
+func (reg *sessionRegistry) Start(kind string, r *http.Request) (*TestSession, error) {
+	var conn *net.TCPConn
+	if kind == "download" || kind == "upload" {
+		conn = requestTCPConn(r)
+	}
+	return reg.StartConn(kind, requestClientIP(r), requestProtocol(r), conn)
+}
+
+// StartConn is Start for tests that do not run over HTTP, such as iperf3's:
+// conn is the TCP connection of a download or upload, if known.
+func (reg *sessionRegistry) StartConn(kind, client, protocol string, conn *net.TCPConn) (*TestSession, error) {
+	s := &TestSession{
+		ID:        uuid.New().String(),
+		Type:      kind,
+		Client:    client,
+		StartedAt: time.Now(),
+		Protocol:  protocol,
+		conn:      conn,
+	}
+
+	reg.mu.Lock()
+	err := reg.admitLocked(s.Client)
+	if err == nil {
+		reg.overlapLocked(s)
+		reg.sessions[s.ID] = s
+	}
+	reg.mu.Unlock()
+	if err != nil {
+		sessionsRejected.Inc(kind, rejectReason(err))
+		return nil, err
+	}
+	sessionsStarted.Inc(kind)
+	return s, nil
+}
+
+func (reg *sessionRegistry) admitLocked(client string) error {
+	if serverContext.Err() != nil {
+		return errShuttingDown
+	}
+	if *maxSessions > 0 && len(reg.sessions) >= *maxSessions {
+		return errServerBusy
+	}
+	if *maxClientSessions > 0 {
+		n := 0
+		for _, s := range reg.sessions {
+			if s.Client == client {
+				n++
+			}
+		}
+		if n >= *maxClientSessions {
+			return errClientBusy
+		}
+	}
+	return nil
+}
+
+func rejectReason(err error) string {
+	switch err {
+	case errServerBusy:
+		return "server_limit"
+	case errClientBusy:
+		return "client_limit"
+	default:
+		return "shutdown"
+	}
+}
+
+// startSession starts a session for a test handler, answering the request
+// with 428 (consent missing), 503 (server busy or shutting down) or 429
+// (client limit) when refused.
+func startSession(w http.ResponseWriter, kind string, r *http.Request) (*TestSession, bool) {
+	if !checkConsent(w, r) || !checkAccessLimit(w, r) {
+		return nil, false
+	}
+	s, err := activeSessions.Start(kind, r)
+	if err == nil {
+		return s, true
+	}
+	if *verbose {
+		log.Printf("Refused %s session for %s: %v", kind, loggedAddr(requestClientIP(r)), err)
+	}
+	status := http.StatusServiceUnavailable
+	if err == errClientBusy {
+		status = http.StatusTooManyRequests
+	}
+	if err != errShuttingDown {
+		w.Header().Set("Retry-After", "5")
+	}
+	http.Error(w, err.Error(), status)
+	return nil, false
+}
+
+// requestProtocol names the protocol a test runs over, like ALPN: http/1.1,
+// h2, h3, or websocket for an upgraded connection.
+func requestProtocol(r *http.Request) string {
+	switch {
+	case strings.EqualFold(r.Header.Get("Upgrade"), "websocket"):
+		return "websocket"
+	case r.ProtoMajor == 2:
+		return "h2"
+	case r.ProtoMajor == 3:
+		return "h3"
+	}
+	return strings.ToLower(r.Proto)
+}
+
+// attachProtocol tags a result with the protocol of the sessions it reports,
+// e.g. protocol=h2, or the protocols joined by + when they differ. Without
+// sessions the result has no protocol tag.
+func attachProtocol(result *TestResult, sessions []*TestSession) {
+	delete(result.Tags, "protocol")
+	var protocols []string
+	for _, s := range sessions {
+		if !slices.Contains(protocols, s.Protocol) {
+			protocols = append(protocols, s.Protocol)
+		}
+	}
+	if len(protocols) == 0 {
+		return
+	}
+	sort.Strings(protocols)
+	if result.Tags == nil {
+		result.Tags = map[string]string{}
+	}
+	result.Tags["protocol"] = strings.Join(protocols, "+")
+}
+
+// requestClientIP identifies the client of a request by its remote IP.
+func requestClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Finish removes a session from the active set, records its connection
+// state and ends its progress stream. It is safe to call more than once.
+func (reg *sessionRegistry) Finish(s *TestSession) {
+	s.recordTCPInfo()
+	s.closeBus()
+
+	now := time.Now()
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, ok := reg.sessions[s.ID]; !ok {
+		return
+	}
+	delete(reg.sessions, s.ID)
+	if s.Bytes() > 0 {
+		reg.recent[s.Client] = now
+	}
+	for client, at := range reg.recent {
+		if now.Sub(at) > recentTestWindow {
+			delete(reg.recent, client)
+		}
+	}
+
+	var oldest *TestSession
+	for id, f := range reg.finished {
+		if now.Sub(f.finishedAt) > recentTestWindow {
+			delete(reg.finished, id)
+		} else if oldest == nil || f.finishedAt.Before(oldest.finishedAt) {
+			oldest = f
+		}
+	}
+	if len(reg.finished) >= maxFinishedSessions && oldest != nil {
+		delete(reg.finished, oldest.ID)
+	}
+	s.finishedAt = now
+	s.endBytes = serverBytes.Load()
+	reg.finished[s.ID] = s
+}
+
+// Lookup returns a running or recently finished session by ID.
+func (reg *sessionRegistry) Lookup(id string) (*TestSession, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if s, ok := reg.sessions[id]; ok {
+		return s, true
+	}
+	s, ok := reg.finished[id]
+	return s, ok
+}
+
+// sessionHandler serves per-session resources: /sessions/{id}/webrtc, /sessions/{id}/samples
+// and /sessions/{id}/relay.
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/")
+	switch {
+	case id == "":
+		http.NotFound(w, r)
+	case rest == "webrtc":
+		webrtcLogHandler(w, r, id)
+	case rest == "samples":
+		sessionSamplesHandler(w, r, id)
+	case rest == "relay":
+		relayStatsHandler(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Reported returns the sessions a client named in a saved result, skipping
+// unknown IDs, duplicates and other clients' sessions.
+func (reg *sessionRegistry) Reported(client string, ids []string) []*TestSession {
+	if len(ids) > maxResultSessions {
+		ids = ids[:maxResultSessions]
+	}
+	var sessions []*TestSession
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		s, ok := reg.Lookup(id)
+		if !ok || s.Client != client || seen[id] {
+			continue
+		}
+		seen[id] = true
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// RecentlyTested reports whether client completed a test with traffic within recentTestWindow.
+func (reg *sessionRegistry) RecentlyTested(client string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	at, ok := reg.recent[client]
+	return ok && time.Since(at) <= recentTestWindow
+}
+
+// Active returns a snapshot of the running sessions, oldest first.
+func (reg *sessionRegistry) Active() []SessionSnapshot {
+	reg.mu.Lock()
+	snapshots := make([]SessionSnapshot, 0, len(reg.sessions))
+	for _, s := range reg.sessions {
+		snapshots = append(snapshots, s.snapshot())
+	}
+	reg.mu.Unlock()
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].StartedAt.Before(snapshots[j].StartedAt) })
+	return snapshots
+}
+
+// Count returns the number of running sessions.
+func (reg *sessionRegistry) Count() int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return len(reg.sessions)
+}
+
+func (reg *sessionRegistry) countByType() map[string]float64 {
+	counts := map[string]float64{"download": 0, "upload": 0, "webrtc": 0, "relay": 0, "netping": 0}
+	reg.mu.Lock()
+	for _, s := range reg.sessions {
+		counts[s.Type]++
+	}
+	reg.mu.Unlock()
+	return counts
+}
+
+// Drain waits until no sessions are running or ctx is done, and returns the
+// number still running.
+func (reg *sessionRegistry) Drain(ctx context.Context) int {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n := reg.Count()
+		if n == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return n
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reasons a sessionReader stops an upload.
+var (
+	errUploadTooLarge = errors.New("upload exceeds -max-upload-size")
+	errUploadTooLong  = errors.New("upload exceeds -max-upload-duration")
+)
+
+// sessionReader counts bytes read through it against a session, and the
+// bytes that arrived in each second since the first.
+type sessionReader struct {
+	ctx      context.Context // stops the read once the client or server goes away
+	r        io.Reader
+	session  *TestSession
+	limit    int64     // bytes; 0 for no limit
+	deadline time.Time // zero for no limit
+
+	read        int64
+	first, last time.Time // when the first and the latest bytes arrived
+	perSecond   []int64
+}
+
+// newUploadReader reads an upload body within -max-upload-size and
+// -max-upload-duration. The duration is also set as the connection's read
+// deadline, so a client that stalls mid-upload cannot hold the read open.
+func newUploadReader(w http.ResponseWriter, r *http.Request, session *TestSession) *sessionReader {
+	sr := &sessionReader{ctx: r.Context(), r: r.Body, session: session, limit: *maxUploadSize * 1024 * 1024}
+	if *maxUploadTime > 0 {
+		sr.deadline = time.Now().Add(*maxUploadTime)
+		if err := http.NewResponseController(w).SetReadDeadline(sr.deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("Failed to set upload read deadline: %v", err)
+		}
+	}
+	return sr
+}
+
+func (sr *sessionReader) Read(p []byte) (int, error) {
+	if err := sr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if !sr.deadline.IsZero() && time.Now().After(sr.deadline) {
+		return 0, errUploadTooLong
+	}
+	n, err := sr.r.Read(p)
+	if n > 0 {
+		sr.last = time.Now()
+		if sr.first.IsZero() {
+			sr.first = sr.last
+		}
+		second := int(sr.last.Sub(sr.first) / time.Second)
+		for len(sr.perSecond) <= second {
+			sr.perSecond = append(sr.perSecond, 0)
+		}
+		sr.perSecond[second] += int64(n)
+	}
+	sr.read += int64(n)
+	sr.session.AddBytes(int64(n))
+	if sr.limit > 0 && sr.read > sr.limit {
+		return n, errUploadTooLarge
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) && !sr.deadline.IsZero() {
+		return n, errUploadTooLong
+	}
+	return n, err
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestAttachProtocol(t *testing.T) {
+	tests := []struct {
+		protocols []string
+		want      string // empty for no tag
+	}{
+		{nil, ""},
+		{[]string{"h2"}, "h2"},
+		{[]string{"http/1.1", "h2", "http/1.1"}, "h2+http/1.1"},
+	}
+	for _, tt := range tests {
+		var sessions []*TestSession
+		for _, p := range tt.protocols {
+			sessions = append(sessions, &TestSession{Protocol: p})
+		}
+		result := TestResult{Tags: map[string]string{"protocol": "h3"}}
+		attachProtocol(&result, sessions)
+		if got, ok := result.Tags["protocol"]; got != tt.want || ok != (tt.want != "") {
+			t.Errorf("attachProtocol(%v) tagged protocol=%q, want %q", tt.protocols, got, tt.want)
+		}
+	}
+}
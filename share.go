@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// shareTemplate renders /share/{id}: the result as plain HTML, readable
+// without JavaScript by text browsers, screen readers and link previews.
+// Browsers with JavaScript move on to the web UI's view of the result.
+var shareTemplate = template.Must(template.New("share").Funcs(template.FuncMap{"tr": localize}).Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>{{.Title}}</title>
+<meta name="description" content="{{.Summary}}">
+<meta property="og:type" content="website">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Summary}}">
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 2rem auto; padding: 0 1rem; color: #111827; }
+table { border-collapse: collapse; width: 100%; margin: 1rem 0; }
+th, td { text-align: left; padding: .4rem .6rem; border-bottom: 1px solid #e5e7eb; }
+td { font-variant-numeric: tabular-nums; }
+</style>
+{{- if .AppURL}}
+<script>location.replace({{.AppURL}});</script>
+{{- end}}
+</head>
+<body>
+<main>
+<h1>{{.Title}}</h1>
+<p>{{tr .Lang "Tested"}}: <time datetime="{{.Result.Timestamp.UTC.Format "2006-01-02T15:04:05Z07:00"}}">{{.Result.Timestamp.UTC.Format "2006-01-02 15:04 MST"}}</time>
+{{- with .Result.Server}}, {{tr $.Lang "server"}} {{if .Label}}{{.Label}}{{else}}{{.ID}}{{end}}{{end}}</p>
+<table>
+<caption>{{tr .Lang "Measurements"}}</caption>
+<thead><tr><th scope="col">{{tr .Lang "Metric"}}</th><th scope="col">{{tr .Lang "Value"}}</th></tr></thead>
+<tbody>
+<tr><th scope="row">{{tr .Lang "Download"}}</th><td>{{printf "%.2f" .Result.DownloadSpeedMbps}} Mbps</td></tr>
+<tr><th scope="row">{{tr .Lang "Upload"}}</th><td>{{printf "%.2f" .Result.UploadSpeedMbps}} Mbps</td></tr>
+<tr><th scope="row">{{tr .Lang "Latency"}}</th><td>{{printf "%.2f" .Result.LatencyMs}} ms</td></tr>
+<tr><th scope="row">{{tr .Lang "Jitter"}}</th><td>{{printf "%.2f" .Result.JitterMs}} ms</td></tr>
+<tr><th scope="row">{{tr .Lang "Packet loss"}}</th><td>{{printf "%.2f" .Result.PacketLossPercent}}%</td></tr>
+</tbody>
+</table>
+{{- if .Result.Tags}}
+<table>
+<caption>{{tr .Lang "Tags"}}</caption>
+<tbody>
+{{- range $key, $value := .Result.Tags}}
+<tr><th scope="row">{{$key}}</th><td>{{$value}}</td></tr>
+{{- end}}
+</tbody>
+</table>
+{{- end}}
+{{- if .AppURL}}
+<p><a href="{{.AppURL}}">{{tr .Lang "Open in the speed test"}}</a></p>
+{{- end}}
+</main>
+</body>
+</html>
+`))
+
+// sharePage is the data of shareTemplate.
+type sharePage struct {
+	Lang    string
+	Title   string
+	Summary string
+	AppURL  string // the web UI's view of the result; empty with -frontend-dir or -frontend-url
+	Result  TestResult
+}
+
+// shareHandler serves GET /share/{id}, the link handed out for a saved result.
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/share/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, tr(r, "Missing result ID"), http.StatusBadRequest)
+		return
+	}
+	result, err := globalStore.Load(id)
+	if err != nil {
+		resultError(w, r, id, err)
+		return
+	}
+
+	lang := requestLanguage(r)
+	page := sharePage{
+		Lang:  lang,
+		Title: localize(lang, "Speed test result"),
+		Summary: fmt.Sprintf(localize(lang, "Download %.2f Mbps, upload %.2f Mbps\nLatency %.2f ms, jitter %.2f ms, loss %.2f%%"),
+			result.DownloadSpeedMbps, result.UploadSpeedMbps, result.LatencyMs, result.JitterMs, result.PacketLossPercent),
+		Result: publicResult(result),
+	}
+	if !customFrontend() {
+		page.AppURL = "/?resultId=" + id
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("Vary", "Accept-Language")
+	if err := shareTemplate.Execute(w, page); err != nil {
+		log.Printf("Failed to render share page of result %s: %v", id, err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Signature algorithms of -signing-key.
+const (
+	signEd25519 = "ed25519"
+	signHMAC    = "hmac-sha256"
+)
+
+// resultSigner signs results when this server saves them, so a shared result
+// can be shown not to have been fabricated: anyone can check an Ed25519
+// signature against the public key at /api/signing-key, while an HMAC
+// signature can only be checked by asking this server. The signature is
+// stored with the result and served as made; results brought in with
+// /results/import, or edited in the database afterwards, are never signed.
+type resultSigner struct {
+	algorithm string
+	keyID     string
+	private   ed25519.PrivateKey
+	secret    []byte
+}
+
+// signer is nil unless -signing-key is set.
+var signer *resultSigner
+
+// ResultSignature is a signature over a result, as returned by
+// GET /results/{id}?verify=1. Payload is the signed JSON of the result; a
+// verifier checks Value against it and compares it with the result shown.
+type ResultSignature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"keyId"`
+	Payload   string `json:"payload"` // base64
+	Value     string `json:"value"`   // base64
+}
+
+// SignatureStore is implemented by result stores that can keep the signature
+// made when a result was saved. Signatures are deleted along with their
+// result and never replaced.
+type SignatureStore interface {
+	// SaveSignature stores the signature of an existing result.
+	SaveSignature(resultID string, signature ResultSignature) error
+	// Signature returns the signature of a result, or errSignatureNotFound
+	// for a result saved without one.
+	Signature(resultID string) (ResultSignature, error)
+}
+
+var errSignatureNotFound = errors.New("result has no signature")
+
+// loadSigningKey reads -signing-key: an Ed25519 private key in PKCS #8 PEM,
+// as "openssl genpkey -algorithm ed25519" writes, or else an HMAC secret.
+func loadSigningKey() error {
+	if *signingKey == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*signingKey)
+	if err != nil {
+		return err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *signingKey, err)
+		}
+		private, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return fmt.Errorf("%s is a %T, want an Ed25519 key", *signingKey, key)
+		}
+		sum := sha256.Sum256(private.Public().(ed25519.PublicKey))
+		signer = &resultSigner{algorithm: signEd25519, keyID: hex.EncodeToString(sum[:8]), private: private}
+	} else {
+		if len(data) < 32 {
+			return fmt.Errorf("%s: an HMAC secret needs at least 32 bytes", *signingKey)
+		}
+		sum := sha256.Sum256(append([]byte("go-netspeed key id:"), data...))
+		signer = &resultSigner{algorithm: signHMAC, keyID: hex.EncodeToString(sum[:8]), secret: data}
+	}
+	log.Printf("Signing results with %s key %s", signer.algorithm, signer.keyID)
+	return nil
+}
+
+// sign signs the JSON encoding of result. encoding/json writes struct fields
+// in declaration order and map keys sorted, so a result loaded from the store
+// always encodes, and signs, the same.
+func (s *resultSigner) sign(result TestResult) (ResultSignature, error) {
+	result = publicResult(result)
+	result.TCPSessionIDs = nil
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return ResultSignature{}, err
+	}
+	var value []byte
+	if s.algorithm == signEd25519 {
+		value = ed25519.Sign(s.private, payload)
+	} else {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(payload)
+		value = mac.Sum(nil)
+	}
+	return ResultSignature{
+		Algorithm: s.algorithm,
+		KeyID:     s.keyID,
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Value:     base64.StdEncoding.EncodeToString(value),
+	}, nil
+}
+
+// checkSigningStore refuses -signing-key with a store that cannot keep
+// signatures.
+func checkSigningStore() error {
+	if _, ok := optionalStore[SignatureStore](); signer != nil && !ok {
+		return fmt.Errorf("-signing-key is not supported by the %s store", *storeType)
+	}
+	return nil
+}
+
+// signStoredResult signs the result with the given ID, just saved, as the
+// store returns it, with the timestamp and any rounding the store applied,
+// and stores the signature with it.
+func signStoredResult(id string) (ResultSignature, error) {
+	result, err := globalStore.Load(id)
+	if err != nil {
+		return ResultSignature{}, err
+	}
+	signature, err := signer.sign(result)
+	if err != nil {
+		return ResultSignature{}, err
+	}
+	store, _ := optionalStore[SignatureStore]()
+	return signature, store.SaveSignature(id, signature)
+}
+
+// signingKeyHandler serves GET /api/signing-key: the algorithm and key ID of
+// result signatures, and the public key when it is Ed25519.
+func signingKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	if signer == nil {
+		http.Error(w, tr(r, "Result signing is not enabled on this server"), http.StatusNotFound)
+		return
+	}
+	key := map[string]string{"algorithm": signer.algorithm, "keyId": signer.keyID}
+	if signer.algorithm == signEd25519 {
+		key["publicKey"] = base64.StdEncoding.EncodeToString(signer.private.Public().(ed25519.PublicKey))
+	}
+	writeJSON(w, key)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// failingSignatureStore cannot store signatures.
+type failingSignatureStore struct{ *SQLiteStore }
+
+func (s failingSignatureStore) SaveSignature(string, ResultSignature) error {
+	return errors.New("disk full")
+}
+
+func TestSaveResultUnsignedIsNotStored(t *testing.T) {
+	defer func(s *resultSigner) { signer = s }(signer)
+	signer = &resultSigner{algorithm: signHMAC, keyID: "test", secret: make([]byte, 32)}
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	globalStore = failingSignatureStore{store}
+
+	w := httptest.NewRecorder()
+	saveResultHandler(w, newSaveRequest(`{"downloadSpeedMbps": 10}`))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("POST /save-result with a failing signature store = %d, want 500", w.Code)
+	}
+	results, _, err := store.List(ResultFilter{}, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("%d results were stored without a signature", len(results))
+	}
+}
@@ -0,0 +1,7 @@
+//go:build cgo
+
+package main
+
+// The SQLite driver needs cgo; without it -store=sqlite reports that SQLite
+// support is not compiled in.
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,1061 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SQLiteStore implements ResultStore in a SQLite database with a column per
+// metric, so results can be inspected and queried with standard SQL tools.
+type SQLiteStore struct {
+	db *sql.DB
+
+	// Optional limits, enforced after each save and periodically; zero disables them.
+	resultTTL  time.Duration
+	maxResults int
+	stopPrune  chan struct{}
+}
+
+// sqliteTimeFormat renders timestamps in UTC at a fixed width, so they sort
+// lexically in time order and SQLite's date and time functions accept them.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// sqlitePruneInterval is how often expired rows are deleted when no results are being saved.
+const sqlitePruneInterval = 10 * time.Minute
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS results (
+	id                  TEXT PRIMARY KEY,
+	timestamp           TEXT NOT NULL,
+	download_mbps       REAL NOT NULL,
+	upload_mbps         REAL NOT NULL,
+	latency_ms          REAL NOT NULL,
+	jitter_ms           REAL NOT NULL,
+	packet_loss_percent REAL NOT NULL,
+	client_ip           TEXT NOT NULL DEFAULT '',
+	verified            INTEGER NOT NULL DEFAULT 0,
+	webrtc_session_id   TEXT NOT NULL DEFAULT '',
+	webrtc_log          TEXT, -- JSON array of session events
+	server_id           TEXT NOT NULL DEFAULT '',
+	server_version      TEXT NOT NULL DEFAULT '',
+	server_label        TEXT NOT NULL DEFAULT '',
+	methodology         TEXT, -- JSON object of metric to method identifier
+	remote_ip           TEXT NOT NULL DEFAULT '',
+	user_agent          TEXT NOT NULL DEFAULT '',
+	http_protocol       TEXT NOT NULL DEFAULT '',
+	server_hostname     TEXT NOT NULL DEFAULT '',
+	qos                 TEXT, -- JSON object of the DSCP marking
+	tcp_info            TEXT, -- JSON array of connection states
+	server_load         TEXT, -- JSON object of the concurrent load
+	client_id           TEXT NOT NULL DEFAULT '',
+	samples             TEXT, -- JSON object of raw throughput and round-trip samples
+	geo_country_code    TEXT NOT NULL DEFAULT '',
+	geo_country         TEXT NOT NULL DEFAULT '',
+	geo_city            TEXT NOT NULL DEFAULT '',
+	geo_asn             INTEGER NOT NULL DEFAULT 0,
+	geo_isp             TEXT NOT NULL DEFAULT '',
+	net_ping            TEXT -- JSON object of the -net-ping round trips
+);
+CREATE INDEX IF NOT EXISTS results_timestamp ON results (timestamp);
+
+CREATE TABLE IF NOT EXISTS result_tags (
+	result_id TEXT NOT NULL REFERENCES results (id) ON DELETE CASCADE,
+	key       TEXT NOT NULL,
+	value     TEXT NOT NULL,
+	PRIMARY KEY (result_id, key)
+);
+CREATE INDEX IF NOT EXISTS result_tags_key_value ON result_tags (key, value);
+
+CREATE TABLE IF NOT EXISTS result_amendments (
+	id        TEXT PRIMARY KEY,
+	result_id TEXT NOT NULL REFERENCES results (id) ON DELETE CASCADE,
+	timestamp TEXT NOT NULL,
+	kind      TEXT NOT NULL,
+	value     TEXT NOT NULL,
+	author    TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS result_amendments_result ON result_amendments (result_id, timestamp);
+
+CREATE TABLE IF NOT EXISTS result_aliases (
+	alias     TEXT PRIMARY KEY,
+	result_id TEXT NOT NULL REFERENCES results (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS result_aliases_result ON result_aliases (result_id);
+
+CREATE TABLE IF NOT EXISTS result_owners (
+	owner     TEXT NOT NULL, -- SHA-256 of the owner token, see owner.go
+	result_id TEXT NOT NULL REFERENCES results (id) ON DELETE CASCADE,
+	PRIMARY KEY (owner, result_id)
+);
+CREATE INDEX IF NOT EXISTS result_owners_result ON result_owners (result_id);
+
+CREATE TABLE IF NOT EXISTS result_signatures (
+	result_id TEXT PRIMARY KEY REFERENCES results (id) ON DELETE CASCADE,
+	algorithm TEXT NOT NULL,
+	key_id    TEXT NOT NULL,
+	payload   TEXT NOT NULL,
+	value     TEXT NOT NULL
+);
+
+-- Results, their amendments and signatures are append-only
+CREATE TRIGGER IF NOT EXISTS results_immutable BEFORE UPDATE ON results
+BEGIN SELECT RAISE(ABORT, 'results are immutable'); END;
+CREATE TRIGGER IF NOT EXISTS result_amendments_immutable BEFORE UPDATE ON result_amendments
+BEGIN SELECT RAISE(ABORT, 'amendments are immutable'); END;
+CREATE TRIGGER IF NOT EXISTS result_signatures_immutable BEFORE UPDATE ON result_signatures
+BEGIN SELECT RAISE(ABORT, 'signatures are immutable'); END;
+
+CREATE TABLE IF NOT EXISTS test_errors (
+	timestamp TEXT NOT NULL,
+	phase     TEXT NOT NULL,
+	data      TEXT NOT NULL -- JSON error report
+);
+CREATE INDEX IF NOT EXISTS test_errors_timestamp ON test_errors (timestamp);
+
+CREATE TABLE IF NOT EXISTS probes (
+	timestamp TEXT NOT NULL,
+	target    TEXT NOT NULL,
+	data      TEXT NOT NULL -- JSON probe result
+);
+CREATE INDEX IF NOT EXISTS probes_timestamp ON probes (timestamp);
+
+CREATE TABLE IF NOT EXISTS report_snapshots (
+	period TEXT NOT NULL,
+	start  TEXT NOT NULL,
+	data   TEXT NOT NULL, -- JSON snapshot
+	PRIMARY KEY (period, start)
+);
+
+CREATE TABLE IF NOT EXISTS alerts (
+	id   TEXT PRIMARY KEY,
+	data TEXT NOT NULL -- JSON alert
+);
+
+CREATE TABLE IF NOT EXISTS recurring_tests (
+	id   TEXT PRIMARY KEY,
+	data TEXT NOT NULL -- JSON registration
+);
+`
+
+// sqliteAddedColumns are results columns added after the first schema. They
+// are added to existing databases when the store is opened.
+var sqliteAddedColumns = []struct{ name, definition string }{
+	{"server_id", "TEXT NOT NULL DEFAULT ''"},
+	{"server_version", "TEXT NOT NULL DEFAULT ''"},
+	{"server_label", "TEXT NOT NULL DEFAULT ''"},
+	{"methodology", "TEXT"},
+	{"remote_ip", "TEXT NOT NULL DEFAULT ''"},
+	{"user_agent", "TEXT NOT NULL DEFAULT ''"},
+	{"http_protocol", "TEXT NOT NULL DEFAULT ''"},
+	{"server_hostname", "TEXT NOT NULL DEFAULT ''"},
+	{"qos", "TEXT"},
+	{"tcp_info", "TEXT"},
+	{"server_load", "TEXT"},
+	{"client_id", "TEXT NOT NULL DEFAULT ''"},
+	{"samples", "TEXT"},
+	{"geo_country_code", "TEXT NOT NULL DEFAULT ''"},
+	{"geo_country", "TEXT NOT NULL DEFAULT ''"},
+	{"geo_city", "TEXT NOT NULL DEFAULT ''"},
+	{"geo_asn", "INTEGER NOT NULL DEFAULT 0"},
+	{"geo_isp", "TEXT NOT NULL DEFAULT ''"},
+	{"net_ping", "TEXT"},
+}
+
+// sqliteResultColumns selects a result row; tags are aggregated into a JSON object.
+const sqliteResultColumns = `id, timestamp, download_mbps, upload_mbps, latency_ms, jitter_ms,
+	packet_loss_percent, client_ip, verified, webrtc_session_id, webrtc_log,
+	server_id, server_version, server_label, methodology,
+	remote_ip, user_agent, http_protocol, server_hostname, qos, tcp_info, server_load, client_id, samples,
+	geo_country_code, geo_country, geo_city, geo_asn, geo_isp, net_ping,
+	(SELECT json_group_object(key, value) FROM result_tags WHERE result_id = results.id)`
+
+// NewSQLiteStore opens (creating if needed) the SQLite database at path.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if !slices.Contains(sql.Drivers(), "sqlite3") {
+		return nil, fmt.Errorf("SQLite support is not compiled in; build with CGO_ENABLED=1")
+	}
+	// WAL lets the report and admin readers run while results are being saved
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite db: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sqlite schema: %w", err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate sqlite schema: %w", err)
+	}
+	log.Printf("SQLite configured for FILE storage at: %s", path)
+
+	store := &SQLiteStore{db: db, stopPrune: make(chan struct{})}
+	go store.runPrune()
+	return store, nil
+}
+
+// migrateSQLite adds the columns missing from a database created by an older version.
+func migrateSQLite(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('results')`)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range sqliteAddedColumns {
+		if existing[c.name] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE results ADD COLUMN ` + c.name + ` ` + c.definition); err != nil {
+			return err
+		}
+		log.Printf("Added column %s to the sqlite results table", c.name)
+	}
+	// Indexes on added columns can only be created once they exist
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS results_client_id ON results (client_id, timestamp)`)
+	return err
+}
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanResult(row rowScanner) (TestResult, error) {
+	var (
+		result             TestResult
+		timestamp          string
+		webrtcLog, tagJSON sql.NullString
+		methodology, qos   sql.NullString
+		tcpInfo, load      sql.NullString
+		samples, netPing   sql.NullString
+		server             ServerIdentity
+		client             ClientMetadata
+		geo                GeoInfo
+	)
+	err := row.Scan(&result.ID, &timestamp, &result.DownloadSpeedMbps, &result.UploadSpeedMbps,
+		&result.LatencyMs, &result.JitterMs, &result.PacketLossPercent, &result.ClientIP,
+		&result.Verified, &result.WebRTCSessionID, &webrtcLog,
+		&server.ID, &server.Version, &server.Label, &methodology,
+		&client.RemoteIP, &client.UserAgent, &client.Protocol, &client.Hostname, &qos, &tcpInfo, &load, &result.ClientID, &samples,
+		&geo.CountryCode, &geo.Country, &geo.City, &geo.ASN, &geo.ISP, &netPing, &tagJSON)
+	if err != nil {
+		return result, err
+	}
+	if result.Timestamp, err = time.Parse(sqliteTimeFormat, timestamp); err != nil {
+		return result, fmt.Errorf("invalid timestamp %q for result %s: %w", timestamp, result.ID, err)
+	}
+	if webrtcLog.Valid {
+		if err := json.Unmarshal([]byte(webrtcLog.String), &result.WebRTCLog); err != nil {
+			return result, fmt.Errorf("invalid webrtc_log for result %s: %w", result.ID, err)
+		}
+	}
+	if server.ID != "" {
+		result.Server = &server // results saved before server identities were recorded have none
+	}
+	if client.RemoteIP != "" {
+		result.Client = &client
+	}
+	if geo != (GeoInfo{}) {
+		result.Geo = &geo
+	}
+	if methodology.Valid {
+		if err := json.Unmarshal([]byte(methodology.String), &result.Methodology); err != nil {
+			return result, fmt.Errorf("invalid methodology for result %s: %w", result.ID, err)
+		}
+	}
+	if qos.Valid {
+		if err := json.Unmarshal([]byte(qos.String), &result.QoS); err != nil {
+			return result, fmt.Errorf("invalid qos for result %s: %w", result.ID, err)
+		}
+	}
+	if tcpInfo.Valid {
+		if err := json.Unmarshal([]byte(tcpInfo.String), &result.TCPInfo); err != nil {
+			return result, fmt.Errorf("invalid tcp_info for result %s: %w", result.ID, err)
+		}
+	}
+	if load.Valid {
+		if err := json.Unmarshal([]byte(load.String), &result.Load); err != nil {
+			return result, fmt.Errorf("invalid server_load for result %s: %w", result.ID, err)
+		}
+	}
+	if samples.Valid {
+		if err := json.Unmarshal([]byte(samples.String), &result.Samples); err != nil {
+			return result, fmt.Errorf("invalid samples for result %s: %w", result.ID, err)
+		}
+	}
+	if netPing.Valid {
+		if err := json.Unmarshal([]byte(netPing.String), &result.NetPing); err != nil {
+			return result, fmt.Errorf("invalid net_ping for result %s: %w", result.ID, err)
+		}
+	}
+	if tagJSON.Valid && tagJSON.String != "{}" {
+		if err := json.Unmarshal([]byte(tagJSON.String), &result.Tags); err != nil {
+			return result, fmt.Errorf("invalid tags for result %s: %w", result.ID, err)
+		}
+	}
+	return result, nil
+}
+
+func scanResults(rows *sql.Rows) ([]TestResult, error) {
+	defer rows.Close()
+	results := []TestResult{}
+	for rows.Next() {
+		result, err := scanResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// Save generates a unique ID, saves the result, and returns the ID.
+func (s *SQLiteStore) Save(result TestResult) (string, error) {
+	result.ID = uuid.New().String()
+	result.Timestamp = time.Now() // Use server time for official record
+	return s.save(result)
+}
+
+// Import saves a result with its original ID and timestamp.
+func (s *SQLiteStore) Import(result TestResult) (string, error) {
+	if _, err := s.Load(result.ID); err == nil {
+		return "", fmt.Errorf("result %s: %w", result.ID, errResultExists)
+	}
+	return s.save(result)
+}
+
+// save stores result under its ID and timestamp.
+func (s *SQLiteStore) save(result TestResult) (string, error) {
+	id := result.ID
+
+	var webrtcLog any // NULL without a timeline
+	if len(result.WebRTCLog) > 0 {
+		data, err := json.Marshal(result.WebRTCLog)
+		if err != nil {
+			return "", err
+		}
+		webrtcLog = string(data)
+	}
+	var server ServerIdentity
+	if result.Server != nil {
+		server = *result.Server
+	}
+	var methodology any
+	if len(result.Methodology) > 0 {
+		data, err := json.Marshal(result.Methodology)
+		if err != nil {
+			return "", err
+		}
+		methodology = string(data)
+	}
+	var client ClientMetadata
+	if result.Client != nil {
+		client = *result.Client
+	}
+	var qos any
+	if result.QoS != nil {
+		data, err := json.Marshal(result.QoS)
+		if err != nil {
+			return "", err
+		}
+		qos = string(data)
+	}
+	var tcpInfo any
+	if len(result.TCPInfo) > 0 {
+		data, err := json.Marshal(result.TCPInfo)
+		if err != nil {
+			return "", err
+		}
+		tcpInfo = string(data)
+	}
+	var load any
+	if result.Load != nil {
+		data, err := json.Marshal(result.Load)
+		if err != nil {
+			return "", err
+		}
+		load = string(data)
+	}
+	var samples any
+	if result.Samples != nil {
+		data, err := json.Marshal(result.Samples)
+		if err != nil {
+			return "", err
+		}
+		samples = string(data)
+	}
+	var netPing any
+	if result.NetPing != nil {
+		data, err := json.Marshal(result.NetPing)
+		if err != nil {
+			return "", err
+		}
+		netPing = string(data)
+	}
+	var geo GeoInfo
+	if result.Geo != nil {
+		geo = *result.Geo
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return id, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO results (id, timestamp, download_mbps, upload_mbps, latency_ms, jitter_ms,
+		packet_loss_percent, client_ip, verified, webrtc_session_id, webrtc_log,
+		server_id, server_version, server_label, methodology, remote_ip, user_agent, http_protocol, server_hostname, qos, tcp_info, server_load, client_id, samples,
+		geo_country_code, geo_country, geo_city, geo_asn, geo_isp, net_ping)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, sqliteTime(result.Timestamp), result.DownloadSpeedMbps, result.UploadSpeedMbps, result.LatencyMs,
+		result.JitterMs, result.PacketLossPercent, result.ClientIP, result.Verified, result.WebRTCSessionID, webrtcLog,
+		server.ID, server.Version, server.Label, methodology,
+		client.RemoteIP, client.UserAgent, client.Protocol, client.Hostname, qos, tcpInfo, load, result.ClientID, samples,
+		geo.CountryCode, geo.Country, geo.City, geo.ASN, geo.ISP, netPing)
+	if err != nil {
+		return id, err
+	}
+	for k, v := range result.Tags {
+		if _, err := tx.Exec(`INSERT INTO result_tags (result_id, key, value) VALUES (?, ?, ?)`, id, k, v); err != nil {
+			return id, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return id, err
+	}
+	log.Printf("Result saved with ID: %s", id)
+
+	if s.resultTTL > 0 || s.maxResults > 0 {
+		if err := s.prune(); err != nil {
+			log.Printf("Failed to enforce result limits: %v", err)
+		}
+	}
+	return id, nil
+}
+
+// Load retrieves a result by its unique ID.
+func (s *SQLiteStore) Load(id string) (TestResult, error) {
+	result, err := scanResult(s.db.QueryRow(`SELECT `+sqliteResultColumns+` FROM results WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return TestResult{}, fmt.Errorf("result not found for ID: %s", id)
+	}
+	return result, err
+}
+
+// Delete removes a result, its tags and its amendments.
+func (s *SQLiteStore) Delete(id string) error {
+	res, err := s.db.Exec(`DELETE FROM results WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("result not found for ID: %s", id)
+	}
+	log.Printf("Result deleted with ID: %s", id)
+	return nil
+}
+
+// Iterate calls fn for every stored result, oldest first, stopping at the first error.
+func (s *SQLiteStore) Iterate(fn func(result TestResult) error) error {
+	rows, err := s.db.Query(`SELECT ` + sqliteResultColumns + ` FROM results ORDER BY timestamp, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		result, err := scanResult(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// likePattern escapes the LIKE wildcards in s, for use with ESCAPE '\'.
+func likePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// sqliteWhere translates filter into a WHERE clause and its arguments, with
+// the same semantics as ResultFilter.Matches.
+func sqliteWhere(filter ResultFilter) (string, []any) {
+	var conds []string
+	var args []any
+	add := func(cond string, a ...any) {
+		conds = append(conds, cond)
+		args = append(args, a...)
+	}
+
+	metrics := []struct {
+		column string
+		m      metricRange
+	}{
+		{"download_mbps", filter.Download},
+		{"upload_mbps", filter.Upload},
+		{"latency_ms", filter.Latency},
+		{"jitter_ms", filter.Jitter},
+		{"packet_loss_percent", filter.Loss},
+	}
+	for _, metric := range metrics {
+		if metric.m.Lt != nil {
+			add(metric.column+" < ?", *metric.m.Lt)
+		}
+		if metric.m.Gt != nil {
+			add(metric.column+" > ?", *metric.m.Gt)
+		}
+	}
+	if filter.TagKey != "" {
+		add(`EXISTS (SELECT 1 FROM result_tags WHERE result_id = results.id AND key = ? AND value = ?)`, filter.TagKey, filter.TagValue)
+	}
+	if filter.IPPrefix != "" {
+		add(`substr(client_ip, 1, length(?)) = ?`, filter.IPPrefix, filter.IPPrefix)
+	}
+	if filter.ClientID != "" {
+		add(`client_id = ?`, filter.ClientID)
+	}
+	if filter.Verified != nil {
+		add(`verified = ?`, *filter.Verified)
+	}
+	if filter.Contended != nil {
+		add(`(COALESCE(json_extract(server_load, '$.concurrentSessions'), 0) > 0) = ?`, *filter.Contended)
+	}
+	if !filter.From.IsZero() {
+		add(`timestamp >= ?`, sqliteTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		add(`timestamp < ?`, sqliteTime(filter.To))
+	}
+	if filter.Text != "" {
+		p := "%" + likePattern(filter.Text) + "%"
+		add(`(id LIKE ? ESCAPE '\' OR client_ip LIKE ? ESCAPE '\' OR EXISTS (SELECT 1 FROM result_tags
+			WHERE result_id = results.id AND (key LIKE ? ESCAPE '\' OR value LIKE ? ESCAPE '\')))`, p, p, p, p)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// Query returns one page of results matching filter, newest first, along with
+// the total number of matches.
+func (s *SQLiteStore) Query(filter ResultFilter, offset, limit int) ([]TestResult, int, error) {
+	where, args := sqliteWhere(filter)
+	total := 0
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM results`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.db.Query(`SELECT `+sqliteResultColumns+` FROM results`+where+
+		` ORDER BY timestamp DESC, id DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	page, err := scanResults(rows)
+	return page, total, err
+}
+
+// IterateMatching calls fn for every result matching filter, newest first,
+// stopping at the first error.
+func (s *SQLiteStore) IterateMatching(filter ResultFilter, fn func(result TestResult) error) error {
+	where, args := sqliteWhere(filter)
+	rows, err := s.db.Query(`SELECT `+sqliteResultColumns+` FROM results`+where+` ORDER BY timestamp DESC, id DESC`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		result, err := scanResult(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(result); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// List returns up to limit results matching filter, newest first, starting
+// after cursor (empty for the first page). The returned cursor continues the
+// listing and is empty after the last page. Cursors are the timestamp and ID of
+// the last result of a page, so pages stay stable while new results are saved.
+func (s *SQLiteStore) List(filter ResultFilter, cursor string, limit int) ([]TestResult, string, error) {
+	where, args := sqliteWhere(filter)
+	if cursor != "" {
+		position, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		timestamp, id, ok := strings.Cut(string(position), " ")
+		if !ok {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += `(timestamp, id) < (?, ?)`
+		args = append(args, timestamp, id)
+	}
+
+	// One extra row tells whether there is a next page
+	rows, err := s.db.Query(`SELECT `+sqliteResultColumns+` FROM results`+where+
+		` ORDER BY timestamp DESC, id DESC LIMIT ?`, append(args, limit+1)...)
+	if err != nil {
+		return nil, "", err
+	}
+	page, err := scanResults(rows)
+	if err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if len(page) > limit {
+		page = page[:limit]
+		last := page[limit-1]
+		next = base64.RawURLEncoding.EncodeToString([]byte(sqliteTime(last.Timestamp) + " " + last.ID))
+	}
+	return page, next, nil
+}
+
+// SetLimits deletes results older than ttl and all but the newest maxResults,
+// now and whenever results are saved. Zero disables a limit.
+func (s *SQLiteStore) SetLimits(ttl time.Duration, maxResults int) error {
+	s.resultTTL = ttl
+	s.maxResults = maxResults
+	return s.prune()
+}
+
+// prune deletes results beyond the limits, and error reports and probes past
+// their retention. Tags are deleted with their results.
+func (s *SQLiteStore) prune() error {
+	now := time.Now()
+	var deleted int64
+	if s.resultTTL > 0 {
+		res, err := s.db.Exec(`DELETE FROM results WHERE timestamp < ?`, sqliteTime(now.Add(-s.resultTTL)))
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	if s.maxResults > 0 {
+		res, err := s.db.Exec(`DELETE FROM results WHERE id NOT IN
+			(SELECT id FROM results ORDER BY timestamp DESC, id DESC LIMIT ?)`, s.maxResults)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d results to stay within the result limits", deleted)
+	}
+
+	if _, err := s.db.Exec(`DELETE FROM test_errors WHERE timestamp < ?`, sqliteTime(now.Add(-testErrorRetention))); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM probes WHERE timestamp < ?`, sqliteTime(now.Add(-probeRetention)))
+	return err
+}
+
+// runPrune applies the limits periodically until Close, so results expire
+// even when none are being saved.
+func (s *SQLiteStore) runPrune() {
+	ticker := time.NewTicker(sqlitePruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopPrune:
+			return
+		case <-ticker.C:
+			if err := s.prune(); err != nil {
+				log.Printf("Failed to prune sqlite store: %v", err)
+			}
+		}
+	}
+}
+
+// SaveTestError stores an anonymized client error report. Reports are
+// deleted after testErrorRetention.
+func (s *SQLiteStore) SaveTestError(report TestErrorReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal error report: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO test_errors (timestamp, phase, data) VALUES (?, ?, ?)`,
+		sqliteTime(report.Timestamp), report.Phase, string(data))
+	return err
+}
+
+// TestErrors returns the stored error reports newer than since, oldest first.
+func (s *SQLiteStore) TestErrors(since time.Time) ([]TestErrorReport, error) {
+	var reports []TestErrorReport
+	err := s.scanJSON(`SELECT data FROM test_errors WHERE timestamp >= ? ORDER BY timestamp`, []any{sqliteTime(since)}, func(data []byte) error {
+		var report TestErrorReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return err
+		}
+		reports = append(reports, report)
+		return nil
+	})
+	return reports, err
+}
+
+// SaveProbe stores an external target probe result. Probes are deleted after
+// probeRetention.
+func (s *SQLiteStore) SaveProbe(probe ProbeResult) error {
+	data, err := json.Marshal(probe)
+	if err != nil {
+		return fmt.Errorf("failed to marshal probe result: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO probes (timestamp, target, data) VALUES (?, ?, ?)`,
+		sqliteTime(probe.Timestamp), probe.Target, string(data))
+	return err
+}
+
+// Probes returns the stored probe results newer than since, oldest first.
+func (s *SQLiteStore) Probes(since time.Time) ([]ProbeResult, error) {
+	var probes []ProbeResult
+	err := s.scanJSON(`SELECT data FROM probes WHERE timestamp >= ? ORDER BY timestamp`, []any{sqliteTime(since)}, func(data []byte) error {
+		var probe ProbeResult
+		if err := json.Unmarshal(data, &probe); err != nil {
+			return err
+		}
+		probes = append(probes, probe)
+		return nil
+	})
+	return probes, err
+}
+
+// SaveSnapshot stores a report snapshot, replacing any for the same period start.
+func (s *SQLiteStore) SaveSnapshot(snapshot ReportSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report snapshot: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO report_snapshots (period, start, data) VALUES (?, ?, ?)`,
+		snapshot.Period, sqliteTime(snapshot.Start), string(data))
+	return err
+}
+
+// HasSnapshot reports whether a snapshot exists for the period starting at start.
+func (s *SQLiteStore) HasSnapshot(period string, start time.Time) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM report_snapshots WHERE period = ? AND start = ?)`,
+		period, sqliteTime(start)).Scan(&exists)
+	return exists, err
+}
+
+// Snapshots returns the snapshots of a period starting at or after since, oldest first.
+func (s *SQLiteStore) Snapshots(period string, since time.Time) ([]ReportSnapshot, error) {
+	var snapshots []ReportSnapshot
+	err := s.scanJSON(`SELECT data FROM report_snapshots WHERE period = ? AND start >= ? ORDER BY start`, []any{period, sqliteTime(since)}, func(data []byte) error {
+		var snapshot ReportSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	return snapshots, err
+}
+
+// SaveAmendment stores an amendment of an existing result.
+func (s *SQLiteStore) SaveAmendment(amendment Amendment) (Amendment, error) {
+	amendment.ID = uuid.New().String()
+	amendment.Timestamp = time.Now()
+	res, err := s.db.Exec(`INSERT INTO result_amendments (id, result_id, timestamp, kind, value, author)
+		SELECT ?, id, ?, ?, ?, ? FROM results WHERE id = ?`,
+		amendment.ID, sqliteTime(amendment.Timestamp), amendment.Kind, amendment.Value, amendment.Author, amendment.ResultID)
+	if err != nil {
+		return amendment, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return amendment, fmt.Errorf("result not found for ID: %s", amendment.ResultID)
+	}
+	log.Printf("Amendment %s (%s) saved for result ID: %s", amendment.ID, amendment.Kind, amendment.ResultID)
+	return amendment, nil
+}
+
+// Amendments returns the amendments of a result, oldest first.
+func (s *SQLiteStore) Amendments(resultID string) ([]Amendment, error) {
+	rows, err := s.db.Query(`SELECT id, result_id, timestamp, kind, value, author FROM result_amendments
+		WHERE result_id = ? ORDER BY timestamp, id`, resultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var amendments []Amendment
+	for rows.Next() {
+		var a Amendment
+		var timestamp string
+		if err := rows.Scan(&a.ID, &a.ResultID, &timestamp, &a.Kind, &a.Value, &a.Author); err != nil {
+			return nil, err
+		}
+		if a.Timestamp, err = time.Parse(sqliteTimeFormat, timestamp); err != nil {
+			return nil, fmt.Errorf("invalid timestamp for amendment %s: %w", a.ID, err)
+		}
+		amendments = append(amendments, a)
+	}
+	return amendments, rows.Err()
+}
+
+// AddAlias points alias at an existing result.
+func (s *SQLiteStore) AddAlias(alias, resultID string) error {
+	res, err := s.db.Exec(`INSERT INTO result_aliases (alias, result_id)
+		SELECT ?, id FROM results WHERE id = ? ON CONFLICT (alias) DO NOTHING`, alias, resultID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		current, err := s.ResolveAlias(alias)
+		switch {
+		case errors.Is(err, errAliasNotFound):
+			return fmt.Errorf("result not found for ID: %s", resultID)
+		case err != nil:
+			return err
+		case current != resultID:
+			return errAliasTaken
+		}
+		return nil
+	}
+	log.Printf("Alias %s saved for result ID: %s", alias, resultID)
+	return nil
+}
+
+// ResolveAlias returns the ID of the result alias points at.
+func (s *SQLiteStore) ResolveAlias(alias string) (string, error) {
+	var id string
+	err := s.db.QueryRow(`SELECT result_id FROM result_aliases WHERE alias = ?`, alias).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", errAliasNotFound
+	}
+	return id, err
+}
+
+// Aliases returns the aliases of a result, sorted.
+func (s *SQLiteStore) Aliases(resultID string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT alias FROM result_aliases WHERE result_id = ? ORDER BY alias`, resultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var aliases []string
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+// DeleteAlias removes an alias.
+func (s *SQLiteStore) DeleteAlias(alias string) error {
+	res, err := s.db.Exec(`DELETE FROM result_aliases WHERE alias = ?`, alias)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errAliasNotFound
+	}
+	return nil
+}
+
+// SaveAlert stores an alert, replacing the one with its ID.
+func (s *SQLiteStore) SaveAlert(alert Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO alerts (id, data) VALUES (?, ?)`, alert.ID, string(data))
+	return err
+}
+
+// Alerts returns the stored alerts.
+func (s *SQLiteStore) Alerts() ([]Alert, error) {
+	var list []Alert
+	err := s.scanJSON(`SELECT data FROM alerts`, nil, func(data []byte) error {
+		var alert Alert
+		if err := json.Unmarshal(data, &alert); err != nil {
+			return err
+		}
+		list = append(list, alert)
+		return nil
+	})
+	return list, err
+}
+
+// DeleteAlert removes an alert.
+func (s *SQLiteStore) DeleteAlert(id string) error {
+	_, err := s.db.Exec(`DELETE FROM alerts WHERE id = ?`, id)
+	return err
+}
+
+// SaveRecurringTest stores a recurring test registration.
+func (s *SQLiteStore) SaveRecurringTest(test RecurringTest) error {
+	data, err := json.Marshal(test)
+	if err != nil {
+		return fmt.Errorf("failed to marshal recurring test: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO recurring_tests (id, data) VALUES (?, ?)`, test.ID, string(data))
+	return err
+}
+
+// LoadRecurringTest returns a recurring test registration.
+func (s *SQLiteStore) LoadRecurringTest(id string) (RecurringTest, error) {
+	var test RecurringTest
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM recurring_tests WHERE id = ?`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return test, errRecurringTestNotFound
+	} else if err != nil {
+		return test, err
+	}
+	return test, json.Unmarshal(data, &test)
+}
+
+// DeleteRecurringTest removes a recurring test registration.
+func (s *SQLiteStore) DeleteRecurringTest(id string) error {
+	res, err := s.db.Exec(`DELETE FROM recurring_tests WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errRecurringTestNotFound
+	}
+	return nil
+}
+
+// ClaimResult records that owner saved an existing result.
+func (s *SQLiteStore) ClaimResult(owner, resultID string) error {
+	res, err := s.db.Exec(`INSERT INTO result_owners (owner, result_id)
+		SELECT ?, id FROM results WHERE id = ? ON CONFLICT DO NOTHING`, owner, resultID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if owned, err := s.OwnsResult(owner, resultID); err != nil || owned {
+			return err
+		}
+		return fmt.Errorf("result not found for ID: %s", resultID)
+	}
+	return nil
+}
+
+// OwnedResults returns the IDs of up to limit of owner's results, newest first.
+func (s *SQLiteStore) OwnedResults(owner string, limit int) ([]string, error) {
+	rows, err := s.db.Query(`SELECT r.id FROM result_owners o JOIN results r ON r.id = o.result_id
+		WHERE o.owner = ? ORDER BY r.timestamp DESC, r.id DESC LIMIT ?`, owner, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// OwnsResult reports whether owner saved a result.
+func (s *SQLiteStore) OwnsResult(owner, resultID string) (bool, error) {
+	var one int
+	err := s.db.QueryRow(`SELECT 1 FROM result_owners WHERE owner = ? AND result_id = ?`, owner, resultID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SaveSignature stores the signature of an existing result.
+func (s *SQLiteStore) SaveSignature(resultID string, signature ResultSignature) error {
+	res, err := s.db.Exec(`INSERT INTO result_signatures (result_id, algorithm, key_id, payload, value)
+		SELECT id, ?, ?, ?, ? FROM results WHERE id = ?`,
+		signature.Algorithm, signature.KeyID, signature.Payload, signature.Value, resultID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("result not found for ID: %s", resultID)
+	}
+	return nil
+}
+
+// Signature returns the signature of a result, or errSignatureNotFound.
+func (s *SQLiteStore) Signature(resultID string) (ResultSignature, error) {
+	var signature ResultSignature
+	err := s.db.QueryRow(`SELECT algorithm, key_id, payload, value FROM result_signatures WHERE result_id = ?`, resultID).
+		Scan(&signature.Algorithm, &signature.KeyID, &signature.Payload, &signature.Value)
+	if err == sql.ErrNoRows {
+		return signature, errSignatureNotFound
+	}
+	return signature, err
+}
+
+// StoreSize returns the size of the database file, including free pages.
+func (s *SQLiteStore) StoreSize() (int64, error) {
+	var size int64
+	err := s.db.QueryRow(`SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&size)
+	return size, err
+}
+
+// scanJSON calls fn with the single JSON column of each row of query.
+func (s *SQLiteStore) scanJSON(query string, args []any, fn func(data []byte) error) error {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Close stops the periodic pruning and closes the database.
+func (s *SQLiteStore) Close() error {
+	close(s.stopPrune)
+	return s.db.Close()
+}
@@ -7,19 +7,27 @@ const DOWNLOAD_URL = '/download';
 const UPLOAD_URL = '/upload';
 const LATENCY_URL = '/latency';
 const WEBRTC_SIGNALING_URL = '/webrtc/offer';
+const PREWARM_URL = '/api/prewarm';
+const RELAY_URL = '/relay';
+const NET_PING_URL = '/api/netping';
+const PREWARM_CONNECTIONS = 4; // Parallel keep-alive connections opened before the throughput tests
 const MAX_SIZE_MB = 100;
+const CONFIG_URL = '/api/config';
+const MEASURE_WASM_URL = 'measure.wasm'; // the server's measure package, see loadMeasureCore()
 const WEBRTC_CONFIG = {
     iceServers: [
         { urls: 'stun:stun.l.google.com:19302' }
     ]
 };
-const NUM_PACKETS = 250; 
+const NUM_PACKETS = 250; // defaults when the server config has no latency policy
 const PACKET_INTERVAL = 40; // ms
 const MAX_WAIT_BUFFER = 1000; //ms
 
 // History Constants
 const HISTORY_KEY = 'networkTestHistory';
 const MAX_HISTORY_ITEMS = 5; // Cap the history to the 5 most recent tests
+const CLIENT_ID_KEY = 'networkTestClientId'; // Persistent device ID; the server lists its results at /history/{id}
+const CONSENT_KEY = 'networkTestConsent'; // Version of the server's terms the user accepted
 
 // Global State and Utility
 let results = {};
@@ -100,7 +108,108 @@ const updateStatus = (id, message, loading = false) => {
 
 };
 
+// --- Shared Measurement Math ---
+
+// The server's Go measure package compiled to WebAssembly, so speeds, jitter,
+// loss and RPM are computed by the same code in the browser, the server and
+// the Go client. The JavaScript versions below are only used where
+// WebAssembly is unavailable.
+let measure = null;
+
+async function loadMeasureCore() {
+    if (typeof Go === 'undefined' || typeof WebAssembly === 'undefined') {
+        return;
+    }
+    try {
+        const go = new Go();
+        const { instance } = await WebAssembly.instantiateStreaming(fetch(MEASURE_WASM_URL), go.importObject);
+        go.run(instance);
+        measure = window.netspeedMeasure;
+    } catch (e) {
+        console.warn('Failed to load measure.wasm, using JavaScript math:', e);
+    }
+}
+
+// computeMbps: (Bytes * 8) / (Seconds * 1024^2) = Mbps
+function computeMbps(bytes, durationMs) {
+    if (measure) return measure.mbps(bytes, durationMs);
+    return durationMs > 0 ? (bytes * 8) / ((durationMs / 1000) * 1024 * 1024) : 0;
+}
+
+function computeMeanLatency(rtts) {
+    if (measure) return measure.meanLatency(rtts);
+    return rtts.length > 0 ? rtts.reduce((a, b) => a + b, 0) / rtts.length : 0;
+}
+
+// computeJitterLoss: jitter is the mean difference between consecutive RTTs,
+// loss the share of sent packets without an echo
+function computeJitterLoss(rtts, totalPacketsSent) {
+    if (measure) return measure.jitterLoss(rtts, totalPacketsSent);
+    const lossPercent = totalPacketsSent > 0 ? ((totalPacketsSent - rtts.length) / totalPacketsSent) * 100 : 0;
+    let sumOfDifferences = 0;
+    for (let i = 1; i < rtts.length; i++) {
+        sumOfDifferences += Math.abs(rtts[i] - rtts[i - 1]);
+    }
+    const jitterMs = rtts.length > 1 ? sumOfDifferences / (rtts.length - 1) : 0;
+    return { jitterMs, lossPercent };
+}
+
+// computeRPM: round trips per minute from the RTTs without the slowest 10%
+function computeRPM(rtts) {
+    if (measure) return measure.rpm(rtts);
+    if (rtts.length === 0) return 0;
+    const kept = [...rtts].sort((a, b) => a - b).slice(0, Math.max(1, Math.ceil(rtts.length * 0.9)));
+    const mean = computeMeanLatency(kept);
+    return mean > 0 ? 60000 / mean : 0;
+}
+
 // --- Configuration and Validation ---
+
+// Server-provided settings, replaced by loadServerConfig() on page load
+let serverConfig = { dataPorts: [], maxSizeMB: MAX_SIZE_MB };
+
+async function loadServerConfig() {
+    try {
+        const response = await fetch(CONFIG_URL, { cache: 'no-store' });
+        if (response.ok) {
+            serverConfig = await response.json();
+            ['download-size', 'upload-size'].forEach(id => $(id) && ($(id).max = serverConfig.maxSizeMB));
+            $('demo-banner')?.classList.toggle('hidden', !serverConfig.demo);
+            $('net-ping-option')?.classList.toggle('hidden', !serverConfig.netPing);
+            showConsent();
+        }
+    } catch (e) {
+        console.error('Failed to load server config:', e);
+    }
+}
+
+/**
+ * Shows the server's terms until the user accepts their current version;
+ * the server refuses tests until then.
+ */
+function showConsent() {
+    const consent = serverConfig.consent;
+    if (!consent || localStorage.getItem(CONSENT_KEY) === consent.version) return;
+    $('consent-text').textContent = consent.text;
+    $('consent-panel').classList.remove('hidden');
+    $('start-test-btn').disabled = true;
+    $('consent-accept-btn').onclick = () => {
+        localStorage.setItem(CONSENT_KEY, consent.version);
+        $('consent-panel').classList.add('hidden');
+        $('start-test-btn').disabled = false;
+    };
+}
+
+/**
+ * Adds the accepted terms version to a test URL. A query parameter rather
+ * than a header keeps cross-origin data plane requests free of preflights.
+ */
+function withConsent(url) {
+    if (!serverConfig.consent) return url;
+    const separator = url.includes('?') ? '&' : '?';
+    return `${url}${separator}consent=${encodeURIComponent(serverConfig.consent.version)}`;
+}
+
 function validateSize(input, max) {
     let value = parseInt(input.value);
     if (isNaN(value) || value < 1) {
@@ -112,13 +221,13 @@ function validateSize(input, max) {
 
 function getDownloadSizeMB() {
     const input = $('download-size');
-    validateSize(input, MAX_SIZE_MB);
+    validateSize(input, serverConfig.maxSizeMB);
     return parseInt(input.value);
 }
 
 function getUploadSizeMB() {
     const input = $('upload-size');
-    validateSize(input, MAX_SIZE_MB);
+    validateSize(input, serverConfig.maxSizeMB);
     return parseInt(input.value);
 }
 
@@ -162,6 +271,25 @@ function saveHistory(result) {
     }
 }
 
+/**
+ * Returns this browser's persistent client ID, generating it on first use.
+ * Returns undefined when localStorage is unavailable.
+ */
+function getClientId() {
+    try {
+        let id = localStorage.getItem(CLIENT_ID_KEY);
+        if (!id) {
+            id = crypto.randomUUID ? crypto.randomUUID()
+                : Array.from(crypto.getRandomValues(new Uint8Array(16)), b => b.toString(16).padStart(2, '0')).join('');
+            localStorage.setItem(CLIENT_ID_KEY, id);
+        }
+        return id;
+    } catch (e) {
+        console.error("Error accessing the client ID in localStorage:", e);
+        return undefined;
+    }
+}
+
 /**
  * Loads history from localStorage and updates the display.
  */
@@ -234,10 +362,20 @@ function finalizeTest(success = true) {
         latencyMs: parseFloat(document.getElementById('latency-result').innerText) || 0,
         jitterMs: parseFloat(document.getElementById('jitter-result').innerText) || 0,
         packetLossPercent: parseFloat(document.getElementById('loss-result').innerText) || 0,
+        webrtcSessionId: results.webrtcSessionId,
+        tcpSessionIds: results.tcpSessionIds,
+        netPingId: results.netPingId,
+        clientId: getClientId(),
+        // Raw measurements for later analysis; browsers cannot observe upload progress
+        samples: {
+            download: results.downloadSamples,
+            latencyRtts: results.latencyRtts,
+            jitterRtts: results.jitterRtts,
+        },
     };
 
     // 1. Send results to the server to be saved and get a unique ID
-    fetch(`/save-result`, {
+    fetch(withConsent('/save-result'), {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify(finalResults)
@@ -252,7 +390,7 @@ function finalizeTest(success = true) {
             // Construct the shareable URL
             const currentHostname = window.location.hostname;
             const portSegment = window.location.port ? `:${window.location.port}` : '';
-            const shareUrl = `${window.location.protocol}//${currentHostname}${portSegment}/?resultId=${resultId}`;
+            const shareUrl = `${window.location.protocol}//${currentHostname}${portSegment}/share/${resultId}`;
             
             // Display the shareable link
             shareUrlElement.innerHTML = `
@@ -262,12 +400,16 @@ function finalizeTest(success = true) {
                     <button onclick="copyToClipboard('${shareUrl}')" class="ml-4 p-1 rounded-full text-indigo-600 hover:bg-indigo-200 transition duration-150">
                         <svg class="w-5 h-5" fill="none" stroke="currentColor" viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M8 5H6a2 2 0 00-2 2v12a2 2 0 002 2h12a2 2 0 002-2v-2m-4-4l-4 4m0 0l-4-4m4 4V5"></path></svg>
                     </button>
+                    ${serverConfig.deleteResults ? `<button onclick="deleteResult('${resultId}')" title="Delete this result from the server" class="ml-2 p-1 rounded-full text-red-600 hover:bg-red-100 transition duration-150">
+                        <svg class="w-5 h-5" fill="none" stroke="currentColor" viewBox="0 0 24 24" xmlns="http://www.w3.org/2000/svg"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M19 7l-.867 12.142A2 2 0 0116.138 21H7.862a2 2 0 01-1.995-1.858L5 7m5 4v6m4-6v6m1-10V4a1 1 0 00-1-1h-4a1 1 0 00-1 1v3M4 7h16"></path></svg>
+                    </button>` : ''}
                 </div>
             `;
         }
     })
     .catch(error => {
         console.error("Error saving or fetching share ID:", error);
+        reportTestError('save', error);
         shareUrlElement.innerHTML = `<p class="text-red-500 mt-4">Failed to save results for sharing.</p>`;
     })
     .finally(() => {
@@ -282,9 +424,40 @@ function finalizeTest(success = true) {
     });
 }
 
+/**
+ * Deletes a saved result from the server, e.g. one saved by accident, and
+ * removes its share link.
+ */
+function deleteResult(resultId) {
+    if (!confirm('Delete this result from the server? Its share link will stop working.')) return;
+    fetch(`/results/${resultId}`, { method: 'DELETE' })
+    .then(response => {
+        if (!response.ok) throw new Error(`Server returned ${response.status}`);
+        document.getElementById('share-url').innerHTML = `<p class="text-gray-500 mt-4">Result deleted from the server.</p>`;
+    })
+    .catch(error => {
+        console.error("Error deleting result:", error);
+        alert('Failed to delete the result.');
+    });
+}
+
 
 // --- Test Functions ---
 
+/**
+ * Reports a failed test phase to the server so operators can see failure rates.
+ * Best effort: errors while reporting are ignored.
+ */
+function reportTestError(phase, error) {
+    const reason = error instanceof Error ? error.message : String(error);
+    fetch('/api/test-error', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ phase, reason }),
+        keepalive: true
+    }).catch(() => {});
+}
+
 /**
  * LATENCY (RTT) Test
  */
@@ -310,15 +483,101 @@ async function runLatencyTest() {
 
     if (latencies.length === 0) {
         updateStatus('latency-status', 'Failed to measure.', false);
+        reportTestError('latency', 'no successful pings');
         return;
     }
 
-    const total = latencies.reduce((a, b) => a + b, 0);
-    const avgLatency = total / latencies.length;
+    const avgLatency = computeMeanLatency(latencies);
+    const rpm = computeRPM(latencies);
 
     results.latency = avgLatency;
+    results.rpm = rpm;
+    results.latencyRtts = latencies;
     updateResult('latency-result', avgLatency.toFixed(2), ' ms');
-    updateStatus('latency-status', 'Complete', false);
+    updateStatus('latency-status', `Complete (${Math.round(rpm)} RPM)`, false);
+}
+
+/**
+ * Opens keep-alive connections ahead of the throughput tests so connection setup
+ * does not count against short downloads and uploads. Best effort.
+ */
+async function prewarmConnections(count) {
+    const requests = [];
+    for (let i = 0; i < count; i++) {
+        requests.push(fetch(`${PREWARM_URL}?n=${i}&t=${Date.now()}`, { cache: 'no-store' }).catch(() => {}));
+    }
+    await Promise.all(requests);
+}
+
+/**
+ * Returns the URLs to spread a throughput test across: one per data plane port
+ * advertised in /api/config, or just the main server when there are none.
+ */
+function dataPlaneURLs(path) {
+    if (!serverConfig.dataPorts || serverConfig.dataPorts.length === 0) {
+        return [path];
+    }
+    return serverConfig.dataPorts.map(port => `${window.location.protocol}//${window.location.hostname}:${port}${path}`);
+}
+
+/**
+ * Remembers the server session of a download or upload stream, so the saved
+ * result can include the server's view of its TCP connection.
+ */
+function recordTCPSession(response) {
+    const sessionId = response.headers.get('X-Session-ID');
+    if (sessionId) {
+        results.tcpSessionIds = [...(results.tcpSessionIds || []), sessionId];
+    }
+}
+
+/**
+ * Records the combined throughput of a test's streams about once per second,
+ * saved with the result as raw samples.
+ */
+function createThroughputSampler() {
+    const start = performance.now();
+    let last = start;
+    let bytes = 0;
+    const samples = [];
+    const record = (now) => {
+        samples.push({ elapsedMs: now - start, mbps: computeMbps(bytes, now - last) });
+        last = now;
+        bytes = 0;
+    };
+    return {
+        add(n) {
+            bytes += n;
+            const now = performance.now();
+            if (now - last >= 1000) record(now);
+        },
+        finish() {
+            if (bytes > 0) record(performance.now());
+            return samples;
+        },
+    };
+}
+
+async function downloadStream(url, sampler) {
+    const response = await fetch(url);
+
+    if (!response.ok) {
+        throw new Error(`HTTP error! status: ${response.status}`);
+    }
+    recordTCPSession(response);
+
+    // Wait for the entire stream to finish reading
+    const reader = response.body.getReader();
+    let downloadedBytes = 0;
+    while (true) {
+        const { done, value } = await reader.read();
+        if (done) break;
+        downloadedBytes += value.length;
+        sampler.add(value.length);
+    }
+    // In demo mode the server sends a token body and reports the simulated size
+    const demoSize = parseInt(response.headers.get('X-Demo-Size'));
+    return serverConfig.demo && demoSize > 0 ? demoSize : downloadedBytes;
 }
 
 /**
@@ -327,45 +586,90 @@ async function runLatencyTest() {
 async function runDownloadTest() {
     updateStatus('download-status', 'Testing Download...', true);
     const requestedSizeMB = getDownloadSizeMB();
-    // Pass size as query param for server to use
-    const url = `${DOWNLOAD_URL}?size=${requestedSizeMB}`; 
+    const urls = dataPlaneURLs(DOWNLOAD_URL);
+    // Pass size as query param for server to use, split across the streams
+    const streamSizeMB = Math.max(1, Math.ceil(requestedSizeMB / urls.length));
     
     const start = performance.now();
+    const sampler = createThroughputSampler();
     try {
-        const response = await fetch(url);
-
-        if (!response.ok) {
-            throw new Error(`HTTP error! status: ${response.status}`);
-        }
-
-        // Wait for the entire stream to finish reading
-        const reader = response.body.getReader();
-        let downloadedBytes = 0;
-        while (true) {
-            const { done, value } = await reader.read();
-            if (done) break;
-            downloadedBytes += value.length;
-        }
+        const streamBytes = await Promise.all(urls.map(url => downloadStream(withConsent(`${url}?size=${streamSizeMB}`), sampler)));
 
         const end = performance.now();
-        const durationSeconds = (end - start) / 1000;
-        const bytes = downloadedBytes; 
+        const bytes = streamBytes.reduce((a, b) => a + b, 0);
         
         if (bytes === 0) {
             updateStatus('download-status', 'Failed: Zero bytes received.', false);
             return;
         }
 
-        // Calculation: (Bytes * 8) / (Seconds * 1024^2) = Mbps
-        const speedMbps = (bytes * 8) / (durationSeconds * 1024 * 1024);
+        const speedMbps = computeMbps(bytes, end - start);
 
         results.download = speedMbps;
+        if (!serverConfig.demo) {
+            results.downloadSamples = sampler.finish();
+        }
         updateResult('download-result', speedMbps.toFixed(2), ' Mbps');
         updateStatus('download-status', 'Complete', false);
 
     } catch (e) {
         console.error('Download test failed:', e);
         updateStatus('download-status', 'Failed', false);
+        reportTestError('download', e);
+    }
+}
+
+/**
+ * RELAY (double-hop) Test: the server streams a payload it fetches from an
+ * upstream origin, then reports the origin-to-server hop speed separately.
+ */
+async function runRelayTest() {
+    if (!serverConfig.relay) return;
+    const row = $('relay-row');
+    row.classList.remove('hidden');
+    $('relay-result').innerText = 'Testing...';
+    try {
+        const response = await fetch(withConsent(`${RELAY_URL}?t=${Date.now()}`), { cache: 'no-store' });
+        if (!response.ok) {
+            throw new Error(`HTTP error! status: ${response.status}`);
+        }
+        const sessionId = response.headers.get('X-Session-ID');
+        const reader = response.body.getReader();
+        while (!(await reader.read()).done) { /* discard */ }
+
+        const stats = await (await fetch(`/sessions/${sessionId}/relay`, { cache: 'no-store' })).json();
+        results.relay = stats;
+        $('relay-result').innerText = `client hop ${stats.clientMbps.toFixed(2)} Mbps, upstream hop ${stats.upstreamMbps.toFixed(2)} Mbps`;
+    } catch (e) {
+        console.error('Relay test failed:', e);
+        $('relay-result').innerText = 'Failed';
+        reportTestError('download', `relay: ${e instanceof Error ? e.message : e}`);
+    }
+}
+
+/**
+ * NETWORK PING Test: with the user's opt-in, the server pings this client's
+ * address with ICMP echoes, which leaves out the HTTP overhead of /latency.
+ */
+async function runNetPingTest() {
+    const row = $('net-ping-row');
+    row.classList.add('hidden');
+    if (!serverConfig.netPing || !$('net-ping-optin')?.checked) return;
+    row.classList.remove('hidden');
+    $('net-ping-result').innerText = 'Testing...';
+    try {
+        const response = await fetch(withConsent(NET_PING_URL), { method: 'POST', cache: 'no-store' });
+        if (!response.ok) {
+            throw new Error(`HTTP error! status: ${response.status}`);
+        }
+        const ping = await response.json();
+        results.netPingId = ping.id;
+        $('net-ping-result').innerText = ping.received > 0
+            ? `${ping.latencyMs.toFixed(2)} ms, jitter ${ping.jitterMs.toFixed(2)} ms, loss ${ping.lossPercent.toFixed(1)}%`
+            : 'No echoes (ICMP blocked)';
+    } catch (e) {
+        console.error('Network ping failed:', e);
+        $('net-ping-result').innerText = 'Failed';
     }
 }
 
@@ -375,33 +679,39 @@ async function runDownloadTest() {
 async function runUploadTest() {
     updateStatus('upload-status', 'Testing Upload...', true);
     const sizeMB = getUploadSizeMB();
-    const sizeBytes = sizeMB * 1024 * 1024;
+    const urls = dataPlaneURLs(UPLOAD_URL);
+    const streamBytes = Math.ceil(sizeMB * 1024 * 1024 / urls.length);
     
-    // Create the blob of the requested size
-    const testBlob = new Blob([new ArrayBuffer(sizeBytes)], { type: 'application/octet-stream' });
+    // Create the blob of the requested size, one per stream. In demo mode only
+    // the size is sent and the server simulates the transfer time.
+    const testBlob = new Blob([new ArrayBuffer(serverConfig.demo ? 1 : streamBytes)], { type: 'application/octet-stream' });
 
     const start = performance.now();
     try {
-        const response = await fetch(UPLOAD_URL, {
+        const responses = await Promise.all(urls.map(url => fetch(withConsent(url), {
             method: 'POST',
             body: testBlob,
             headers: {
                 'Content-Type': 'application/octet-stream',
-                'Content-Length': testBlob.size,
+                ...(serverConfig.demo ? { 'X-Demo-Size': String(streamBytes) } : {}),
             },
             mode: 'cors' 
-        });
+        })));
 
-        if (!response.ok) {
-            throw new Error(`HTTP error! status: ${response.status}`);
+        const failed = responses.find(response => !response.ok);
+        if (failed) {
+            throw new Error(`HTTP error! status: ${failed.status}`);
         }
+        responses.forEach(recordTCPSession);
 
         const end = performance.now();
-        const durationSeconds = (end - start) / 1000;
-        const bytes = testBlob.size;
-        
-        // Calculation: (Bytes * 8) / (Seconds * 1024^2) = Mbps
-        const speedMbps = (bytes * 8) / (durationSeconds * 1024 * 1024);
+        const bytes = streamBytes * urls.length;
+        // Prefer the rate the server observed: the request time includes
+        // however long the browser buffered the body before sending it
+        const reports = await Promise.all(responses.map(response => response.json().catch(() => null)));
+        const speedMbps = reports.every(report => report && report.mbps > 0)
+            ? reports.reduce((sum, report) => sum + report.mbps, 0)
+            : computeMbps(bytes, end - start);
 
         results.upload = speedMbps;
         updateResult('upload-result', speedMbps.toFixed(2), ' Mbps');
@@ -410,6 +720,7 @@ async function runUploadTest() {
     } catch (e) {
         console.error('Upload test failed:', e);
         updateStatus('upload-status', 'Failed', false);
+        reportTestError('upload', e);
     }
 }
 
@@ -417,6 +728,10 @@ async function runUploadTest() {
  * WEBRTC (Jitter & Packet Loss) Test
  */
 function runWebRTCTest() {
+    if (serverConfig.demo) {
+        runDemoWebRTCTest();
+        return;
+    }
     updateStatus('jitter-status', 'Starting WebRTC connection...', true);
 
     const pc = new RTCPeerConnection(WEBRTC_CONFIG);
@@ -425,6 +740,9 @@ function runWebRTCTest() {
     // Set binaryType to 'arraybuffer' to handle raw byte echo from Go server
     dc.binaryType = 'arraybuffer';
     
+    // Follow the server's cadence, which stays within its echo limits
+    const numPackets = serverConfig.latency?.count || NUM_PACKETS;
+    const packetInterval = serverConfig.latency?.intervalMs || PACKET_INTERVAL;
     let packetCounter = 0;
     let intervalId = null;
 
@@ -444,7 +762,7 @@ function runWebRTCTest() {
 
         // Function to send a packet
         const sendPacket = () => {
-            if (packetCounter >= NUM_PACKETS) {
+            if (packetCounter >= numPackets) {
                 clearInterval(intervalId);
                 console.log("All packets sent");
                 //Wait a max period of time for all packets to be received
@@ -453,7 +771,7 @@ function runWebRTCTest() {
                                 dc.close();
                                 console.log("Calculating jitter - timeout reached");
                                 calculateJitterLoss(receivedTimestamps, packetCounter);
-                    },(Date.now() -(startTestTime + NUM_PACKETS * PACKET_INTERVAL + MAX_WAIT_BUFFER)))
+                    },(Date.now() -(startTestTime + numPackets * packetInterval + MAX_WAIT_BUFFER)))
                 return;
             }
             const payload = JSON.stringify({
@@ -462,11 +780,11 @@ function runWebRTCTest() {
             });
             dc.send(payload); 
             packetCounter++;
-            updateStatus('jitter-status', 'Connection established. Sending packets...'+packetCounter+'/'+NUM_PACKETS, true);
+            updateStatus('jitter-status', 'Connection established. Sending packets...'+packetCounter+'/'+numPackets, true);
         };
         
         // Start sending packets
-        intervalId = setInterval(sendPacket, PACKET_INTERVAL);
+        intervalId = setInterval(sendPacket, packetInterval);
        
         // Handle echo response from Go server
         dc.onmessage = (event) => {
@@ -490,7 +808,7 @@ function runWebRTCTest() {
 
                 // Check for test completion
                 // The new timeout is: (250 * 40) + 1000 = 11000 ms
-                if ((receivedCounter >= NUM_PACKETS) || (packetCounter >= NUM_PACKETS && Date.now() - startTestTime > (NUM_PACKETS * PACKET_INTERVAL + MAX_WAIT_BUFFER))) {
+                if ((receivedCounter >= numPackets) || (packetCounter >= numPackets && Date.now() - startTestTime > (numPackets * packetInterval + MAX_WAIT_BUFFER))) {
                     clearInterval(intervalId);
                     dc.close();
                     console.log("Calculating jitter");
@@ -506,8 +824,9 @@ function runWebRTCTest() {
     dc.onclose = () => {
         clearInterval(intervalId);
         pc.close();
-        if (packetCounter < NUM_PACKETS) {
+        if (packetCounter < numPackets) {
             updateStatus('jitter-status', 'WebRTC Disconnected before completion.', false);
+            reportTestError('webrtc', `data channel closed after ${packetCounter}/${numPackets} packets`);
         }
         // Ensure finalization runs if WebRTC connection closes unexpectedly
         finalizeTest();
@@ -518,6 +837,7 @@ function runWebRTCTest() {
         if (pc.iceConnectionState === 'failed' || pc.iceConnectionState === 'disconnected') {
             clearInterval(intervalId);
             updateStatus('jitter-status', 'WebRTC Failed or Disconnected.', false);
+            reportTestError('webrtc', `ICE ${pc.iceConnectionState}`);
              // Ensure finalization runs if ICE fails
             finalizeTest();
         }
@@ -542,7 +862,7 @@ function runWebRTCTest() {
             });
         })
         .then(() => {
-            return fetch(WEBRTC_SIGNALING_URL, {
+            return fetch(withConsent(WEBRTC_SIGNALING_URL), {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
                 body: JSON.stringify({ sdp: pc.localDescription.sdp })
@@ -550,36 +870,43 @@ function runWebRTCTest() {
         })
         .then(response => response.json())
         .then(answer => {
+            // Keep the server's session ID so its WebRTC timeline is attached to the saved result
+            results.webrtcSessionId = answer.sessionId;
             const sdpAnswer = new RTCSessionDescription({ type: 'answer', sdp: answer.sdp });
             return pc.setRemoteDescription(sdpAnswer);
         })
         .catch(error => {
             console.error('WebRTC Signaling Error:', error);
             updateStatus('jitter-status', 'WebRTC setup failed.', false);
+            reportTestError('webrtc', error);
             // Ensure finalization runs if signaling fails
             finalizeTest();
         });
 }
 
+// In demo mode the server answers the offer with synthesized round-trip times
+function runDemoWebRTCTest() {
+    updateStatus('jitter-status', 'Simulating jitter test...', true);
+    fetch(withConsent(WEBRTC_SIGNALING_URL), {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ sdp: '' })
+    })
+        .then(response => response.json())
+        .then(demo => calculateJitterLoss(demo.rtts, demo.sent))
+        .catch(error => {
+            console.error('Demo jitter test failed:', error);
+            updateStatus('jitter-status', 'Failed', false);
+            finalizeTest();
+        });
+}
+
 function calculateJitterLoss(rtts, totalPacketsSent) {
-    const receivedPackets = rtts.length;
-    
-    // Packet Loss Calculation
-    const lossPercent = ((totalPacketsSent - receivedPackets) / totalPacketsSent) * 100;
-    
-    // Jitter Calculation (Variation in RTTs)
-    let sumOfDifferences = 0;
-    let previousRTT = rtts.length > 0 ? rtts[0] : 0;
-    
-    for (let i = 1; i < rtts.length; i++) {
-        sumOfDifferences += Math.abs(rtts[i] - previousRTT);
-        previousRTT = rtts[i];
-    }
-    
-    const averageJitter = receivedPackets > 1 ? sumOfDifferences / (receivedPackets - 1) : 0;
+    const { jitterMs: averageJitter, lossPercent } = computeJitterLoss(rtts, totalPacketsSent);
     
     results.packetLoss = lossPercent;
     results.jitter = averageJitter;
+    results.jitterRtts = rtts;
 
     updateResult('loss-result', lossPercent.toFixed(2), '%');
     updateResult('jitter-result', averageJitter.toFixed(2), ' ms');
@@ -617,7 +944,10 @@ async function runAllTests() {
 
     // Run sequentially
     await runLatencyTest();
+    await runNetPingTest();
+    await prewarmConnections(PREWARM_CONNECTIONS);
     await runDownloadTest();
+    await runRelayTest();
     await runUploadTest();
     runWebRTCTest(); // WebRTC is asynchronous and runs independently
 }
@@ -627,10 +957,10 @@ window.onload = () => {
     const downloadInput = $('download-size');
     const uploadInput = $('upload-size');
     if (downloadInput) {
-        downloadInput.addEventListener('change', () => validateSize(downloadInput, MAX_SIZE_MB));
+        downloadInput.addEventListener('change', () => validateSize(downloadInput, serverConfig.maxSizeMB));
     }
     if (uploadInput) {
-        uploadInput.addEventListener('change', () => validateSize(uploadInput, MAX_SIZE_MB));
+        uploadInput.addEventListener('change', () => validateSize(uploadInput, serverConfig.maxSizeMB));
     }
 
     // Attach event listener to the main button
@@ -639,7 +969,10 @@ window.onload = () => {
         startBtn.addEventListener('click', runAllTests);
     }
 
-    // Load history on page load
+    // Load server settings (size limits, data plane ports), the shared
+    // measurement code and history on page load
+    loadServerConfig();
+    loadMeasureCore();
     loadHistory();
 
     // Check if we are loading a shared result URL
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errStoreBusy is returned when a maintenance task is already running.
+var errStoreBusy = errors.New("another maintenance task is running")
+
+// MaintenanceStore is implemented by result stores that can be maintained
+// through /admin/store while the server runs.
+type MaintenanceStore interface {
+	// StoreInfo reports the size and key count of the store.
+	StoreInfo() (StoreInfo, error)
+	// CollectGarbage reclaims the space of deleted and expired entries and
+	// returns the number of files rewritten.
+	CollectGarbage() (int, error)
+	// Compact merges the store's files, dropping overwritten entries.
+	Compact() error
+	// Backup writes the entries written after version since to path and
+	// returns the since of the next incremental backup.
+	Backup(path string, since uint64) (uint64, error)
+}
+
+// StoreInfo is the response of GET /admin/store.
+type StoreInfo struct {
+	Store     string `json:"store"`
+	Path      string `json:"path,omitempty"` // empty in memory
+	Encrypted bool   `json:"encrypted"`
+	LSMBytes  int64  `json:"lsmBytes"`
+	VlogBytes int64  `json:"vlogBytes"`
+	Keys      int64  `json:"keys"`
+	Results   int64  `json:"results"`
+}
+
+// StoreBackup is the response of POST /admin/store/backup.
+type StoreBackup struct {
+	File  string `json:"file"`
+	Bytes int64  `json:"bytes"`
+	Since uint64 `json:"since"`
+	Next  uint64 `json:"next"` // pass as since for the next incremental backup
+}
+
+// maintenanceStore returns the store as a MaintenanceStore, or responds 501.
+func maintenanceStore(w http.ResponseWriter, r *http.Request) (MaintenanceStore, bool) {
+	store, ok := optionalStore[MaintenanceStore]()
+	if !ok {
+		http.Error(w, tr(r, "Store maintenance is not supported by this store"), http.StatusNotImplemented)
+	}
+	return store, ok
+}
+
+// maintenanceError responds to a failed maintenance task.
+func maintenanceError(w http.ResponseWriter, r *http.Request, task string, err error) {
+	if errors.Is(err, errStoreBusy) {
+		http.Error(w, tr(r, "Another maintenance task is running"), http.StatusConflict)
+		return
+	}
+	log.Printf("Store %s failed: %v", task, err)
+	http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+}
+
+// adminStoreHandler serves GET /admin/store.
+func adminStoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	store, ok := maintenanceStore(w, r)
+	if !ok {
+		return
+	}
+	info, err := store.StoreInfo()
+	if err != nil {
+		maintenanceError(w, r, "info", err)
+		return
+	}
+	writeJSON(w, info)
+}
+
+// adminStoreGCHandler serves POST /admin/store/gc.
+func adminStoreGCHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	store, ok := maintenanceStore(w, r)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	files, err := store.CollectGarbage()
+	if err != nil {
+		maintenanceError(w, r, "garbage collection", err)
+		return
+	}
+	log.Printf("Store garbage collection rewrote %d files in %s", files, time.Since(start).Round(time.Millisecond))
+	writeJSON(w, map[string]any{"filesRewritten": files, "durationMs": time.Since(start).Milliseconds()})
+}
+
+// adminStoreCompactHandler serves POST /admin/store/compact.
+func adminStoreCompactHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	store, ok := maintenanceStore(w, r)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	if err := store.Compact(); err != nil {
+		maintenanceError(w, r, "compaction", err)
+		return
+	}
+	log.Printf("Store compacted in %s", time.Since(start).Round(time.Millisecond))
+	writeJSON(w, map[string]any{"durationMs": time.Since(start).Milliseconds()})
+}
+
+// adminStoreBackupHandler serves POST /admin/store/backup?since={version},
+// writing a backup file to -store-backup-dir.
+func adminStoreBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+	store, ok := maintenanceStore(w, r)
+	if !ok {
+		return
+	}
+	if *storeBackupDir == "" {
+		http.Error(w, tr(r, "Backups are disabled; set -store-backup-dir"), http.StatusNotImplemented)
+		return
+	}
+	p := newParamReader(r.URL.Query())
+	since := p.Int64("since", 0, 0, 1<<62)
+	if err := p.Err(); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	if err := os.MkdirAll(*storeBackupDir, 0700); err != nil {
+		maintenanceError(w, r, "backup", err)
+		return
+	}
+	name := fmt.Sprintf("go-netspeed-%s.bak", time.Now().UTC().Format("20060102T150405.000Z"))
+	path := filepath.Join(*storeBackupDir, name)
+	next, err := store.Backup(path, uint64(since))
+	if err != nil {
+		maintenanceError(w, r, "backup", err)
+		return
+	}
+	backup := StoreBackup{File: name, Since: uint64(since), Next: next}
+	if fi, err := os.Stat(path); err == nil {
+		backup.Bytes = fi.Size()
+	}
+	log.Printf("Store backed up to %s (%d bytes, since version %d)", path, backup.Bytes, since)
+	writeJSON(w, backup)
+}
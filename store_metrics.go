@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// SizedStore is implemented by result stores that can cheaply report how much
+// space they take, for netspeed_store_size_bytes.
+type SizedStore interface {
+	// StoreSize returns the size of the store in bytes.
+	StoreSize() (int64, error)
+}
+
+// slowStoreOperation is how long a store operation may take before it is
+// logged.
+const slowStoreOperation = 2 * time.Second
+
+var (
+	storeOperationDuration = newHistogramVec("netspeed_store_operation_duration_seconds",
+		"Time taken by result store operations, excluding the callers' processing of iterated results.",
+		[]float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, "operation")
+	storeErrors = newCounterVec("netspeed_store_errors_total",
+		"Result store operations that failed, by operation.", "operation")
+	_ = newGaugeFunc("netspeed_store_size_bytes",
+		"Size of the result store, for stores that report it.", nil,
+		func() map[string]float64 {
+			store, ok := optionalStore[SizedStore]()
+			if !ok {
+				return nil
+			}
+			size, err := store.StoreSize()
+			if err != nil {
+				log.Printf("Failed to measure store size: %v", err)
+				return nil
+			}
+			return map[string]float64{"": float64(size)}
+		})
+)
+
+// instrumentedStore records the latency and errors of the operations of the
+// ResultStore it wraps. Optional store interfaces are reached through
+// optionalStore.
+type instrumentedStore struct {
+	ResultStore
+}
+
+// optionalStore returns the result store as T, e.g. AliasStore, if it
+// implements it.
+func optionalStore[T any]() (T, bool) {
+	store := globalStore
+	if s, ok := store.(instrumentedStore); ok {
+		store = s.ResultStore
+	}
+	t, ok := store.(T)
+	return t, ok
+}
+
+// observe records an operation that started at start, less the time and
+// error of its callbacks. Missing results are not errors of the store.
+func (s instrumentedStore) observe(operation string, start time.Time, cb *callbacks, err error) {
+	elapsed := time.Since(start)
+	if cb != nil {
+		elapsed -= cb.spent
+		if err == cb.err {
+			err = nil
+		}
+	}
+	storeOperationDuration.Observe(elapsed.Seconds(), operation)
+	if err != nil && !strings.Contains(err.Error(), "result not found") {
+		storeErrors.Inc(operation)
+	}
+	if elapsed > slowStoreOperation {
+		log.Printf("Slow store operation: %s took %s", operation, elapsed.Round(time.Millisecond))
+	}
+}
+
+// callbacks tracks the iteration callbacks of an operation, so the time they
+// take and the error that stops them are not charged to the store.
+type callbacks struct {
+	spent time.Duration
+	err   error
+}
+
+func (cb *callbacks) wrap(fn func(result TestResult) error) func(result TestResult) error {
+	return func(result TestResult) error {
+		start := time.Now()
+		cb.err = fn(result)
+		cb.spent += time.Since(start)
+		return cb.err
+	}
+}
+
+func (s instrumentedStore) Save(result TestResult) (string, error) {
+	start := time.Now()
+	id, err := s.ResultStore.Save(result)
+	s.observe("save", start, nil, err)
+	return id, err
+}
+
+func (s instrumentedStore) Import(result TestResult) (string, error) {
+	start := time.Now()
+	id, err := s.ResultStore.Import(result)
+	s.observe("import", start, nil, err)
+	return id, err
+}
+
+func (s instrumentedStore) Load(id string) (TestResult, error) {
+	start := time.Now()
+	result, err := s.ResultStore.Load(id)
+	s.observe("load", start, nil, err)
+	return result, err
+}
+
+func (s instrumentedStore) Iterate(fn func(result TestResult) error) error {
+	start := time.Now()
+	var cb callbacks
+	err := s.ResultStore.Iterate(cb.wrap(fn))
+	s.observe("iterate", start, &cb, err)
+	return err
+}
+
+func (s instrumentedStore) IterateMatching(filter ResultFilter, fn func(result TestResult) error) error {
+	start := time.Now()
+	var cb callbacks
+	err := s.ResultStore.IterateMatching(filter, cb.wrap(fn))
+	s.observe("iterate", start, &cb, err)
+	return err
+}
+
+func (s instrumentedStore) Query(filter ResultFilter, offset, limit int) ([]TestResult, int, error) {
+	start := time.Now()
+	results, total, err := s.ResultStore.Query(filter, offset, limit)
+	s.observe("query", start, nil, err)
+	return results, total, err
+}
+
+func (s instrumentedStore) List(filter ResultFilter, cursor string, limit int) ([]TestResult, string, error) {
+	start := time.Now()
+	results, next, err := s.ResultStore.List(filter, cursor, limit)
+	s.observe("list", start, nil, err)
+	return results, next, err
+}
+
+func (s instrumentedStore) Delete(id string) error {
+	start := time.Now()
+	err := s.ResultStore.Delete(id)
+	s.observe("delete", start, nil, err)
+	return err
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syslogSDID is the structured-data ID of result events. 32473 is the
+// private enterprise number IANA reserves for documentation and examples.
+const syslogSDID = "netspeed@32473"
+
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3, "local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogNotifier sends one RFC 5424 message per event to a collector over
+// UDP, TCP or TLS. TCP and TLS use octet-counting framing (RFC 6587).
+type syslogNotifier struct {
+	network  string // udp, tcp or tls
+	addr     string
+	facility int
+	hostname string
+	proxy    *egressProxy // for tcp and tls
+}
+
+func newSyslogNotifier(addr, facility string) (*syslogNotifier, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "udp://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog address %q", addr)
+	}
+	defaultPort := map[string]string{"udp": "514", "tcp": "601", "tls": "6514"}[u.Scheme]
+	if defaultPort == "" {
+		return nil, fmt.Errorf("unsupported syslog scheme %q (want udp, tcp or tls)", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	return &syslogNotifier{network: u.Scheme, addr: host, facility: code, hostname: hostname}, nil
+}
+
+func (s *syslogNotifier) Name() string {
+	return "syslog"
+}
+
+func (s *syslogNotifier) Notify(ctx context.Context, event NotificationEvent) error {
+	msg := s.format(event)
+
+	var conn net.Conn
+	var err error
+	if s.network == "udp" {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "udp", s.addr)
+	} else {
+		conn, err = s.proxy.dialContext(ctx, s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	if s.network == "tls" {
+		host, _, _ := net.SplitHostPort(s.addr)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if s.network != "udp" {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+	_, err = conn.Write([]byte(msg))
+	return err
+}
+
+// format renders event as an RFC 5424 message with the result in structured data.
+func (s *syslogNotifier) format(event NotificationEvent) string {
+	severity := 6 // informational
+	if event.Type == "alert" {
+		severity = 4 // warning
+	}
+	r := event.Result
+	params := [][2]string{
+		{"id", r.ID},
+		{"downloadMbps", strconv.FormatFloat(r.DownloadSpeedMbps, 'f', 2, 64)},
+		{"uploadMbps", strconv.FormatFloat(r.UploadSpeedMbps, 'f', 2, 64)},
+		{"latencyMs", strconv.FormatFloat(r.LatencyMs, 'f', 2, 64)},
+		{"jitterMs", strconv.FormatFloat(r.JitterMs, 'f', 2, 64)},
+		{"packetLossPercent", strconv.FormatFloat(r.PacketLossPercent, 'f', 2, 64)},
+		{"clientIp", r.ClientIP},
+		{"verified", strconv.FormatBool(r.Verified)},
+	}
+	if event.ResultURL != "" {
+		params = append(params, [2]string{"url", event.ResultURL})
+	}
+	if a := event.Alert; a != nil {
+		params = append(params, [2]string{"alertRule", a.Rule}, [2]string{"alertSubject", a.Subject}, [2]string{"alertState", a.State})
+	}
+	var sd strings.Builder
+	sd.WriteString("[" + syslogSDID)
+	for _, p := range params {
+		if p[1] != "" {
+			fmt.Fprintf(&sd, ` %s="%s"`, p[0], escapeSDValue(p[1]))
+		}
+	}
+	sd.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s go-netspeed %d %s %s %s",
+		s.facility*8+severity, event.Time.UTC().Format(time.RFC3339Nano), s.hostname, os.Getpid(),
+		event.Type, sd.String(), strings.ReplaceAll(resultSummary(event), "\n", ", "))
+}
+
+// escapeSDValue escapes the characters RFC 5424 reserves in PARAM-VALUE.
+func escapeSDValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
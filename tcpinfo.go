@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+// maxResultSessions bounds the sessions a saved result may reference, one
+// per download or upload stream.
+const maxResultSessions = 16
+
+// TCPInfo is the kernel's view of a download or upload connection when the
+// session ended (Linux TCP_INFO). Counters cover the connection's lifetime,
+// which includes earlier requests on a reused keep-alive connection, and on
+// HTTP/2 all streams sharing it.
+type TCPInfo struct {
+	SessionID        string  `json:"sessionId"`
+	Type             string  `json:"type"`                 // download or upload
+	Congestion       string  `json:"congestion,omitempty"` // congestion control algorithm
+	RTTMs            float64 `json:"rttMs"`
+	RTTVarMs         float64 `json:"rttVarMs"`
+	MinRTTMs         float64 `json:"minRttMs"`
+	Retransmits      uint32  `json:"retransmits"` // segments retransmitted
+	Lost             uint32  `json:"lost"`        // segments currently considered lost
+	Cwnd             uint32  `json:"cwnd"`        // congestion window, in segments
+	MSS              uint32  `json:"mss"`
+	DeliveryRateMbps float64 `json:"deliveryRateMbps"` // most recent goodput the kernel measured
+	PacingRateMbps   float64 `json:"pacingRateMbps"`
+	BytesSent        uint64  `json:"bytesSent"`
+	BytesRetrans     uint64  `json:"bytesRetrans"`
+	// How long sending was limited by the peer's receive window or by the
+	// server's send buffer rather than by the network, out of BusyMs
+	BusyMs          float64 `json:"busyMs"`
+	RwndLimitedMs   float64 `json:"rwndLimitedMs"`
+	SndbufLimitedMs float64 `json:"sndbufLimitedMs"`
+}
+
+type connContextKey struct{}
+
+// connContext makes a request's connection available to handlers. It is
+// installed as the http.Server ConnContext hook.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// requestTCPConn returns the TCP connection a request arrived on, if any.
+func requestTCPConn(r *http.Request) *net.TCPConn {
+	c, _ := r.Context().Value(connContextKey{}).(net.Conn)
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	tcp, _ := c.(*net.TCPConn)
+	return tcp
+}
+
+// recordTCPInfo reads the connection state of a finishing download or upload
+// session. Without TCP_INFO support it records nothing.
+func (s *TestSession) recordTCPInfo() {
+	s.mu.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	info, err := readTCPInfo(conn)
+	if err != nil || info == nil {
+		return
+	}
+	info.SessionID = s.ID
+	info.Type = s.Type
+	s.mu.Lock()
+	s.tcpInfo = info
+	s.mu.Unlock()
+}
+
+// TCPInfo returns the connection state recorded when the session finished.
+func (s *TestSession) TCPInfo() *TCPInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tcpInfo
+}
+
+// attachTCPInfo sets the connection state of those of the result's sessions
+// that recorded one. When every download was served with the same
+// congestion control, the result is tagged with it, e.g. congestion=bbr.
+func attachTCPInfo(result *TestResult, sessions []*TestSession) {
+	result.TCPInfo = nil
+	for _, s := range sessions {
+		if info := s.TCPInfo(); info != nil {
+			result.TCPInfo = append(result.TCPInfo, *info)
+		}
+	}
+
+	congestion := ""
+	for _, info := range result.TCPInfo {
+		if info.Type != "download" {
+			continue
+		}
+		if congestion != "" && info.Congestion != congestion {
+			return
+		}
+		congestion = info.Congestion
+	}
+	if congestion != "" {
+		if result.Tags == nil {
+			result.Tags = map[string]string{}
+		}
+		result.Tags["congestion"] = congestion
+	}
+}
+
+// bytesPerSecondMbps converts a kernel rate to the web client's units:
+// (Bytes * 8) / 1024^2 per second.
+func bytesPerSecondMbps(rate uint64) float64 {
+	return float64(rate) * 8 / (1024 * 1024)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// readTCPInfo reads TCP_INFO from c.
+func readTCPInfo(c *net.TCPConn) (*TCPInfo, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ti *unix.TCPInfo
+	var congestion string
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		if ti, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO); sockErr == nil {
+			congestion, _ = getCongestion(fd)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+	pacing := ti.Pacing_rate
+	if pacing == math.MaxUint64 { // not paced
+		pacing = 0
+	}
+	return &TCPInfo{
+		Congestion:       congestion,
+		RTTMs:            float64(ti.Rtt) / 1000,
+		RTTVarMs:         float64(ti.Rttvar) / 1000,
+		MinRTTMs:         float64(ti.Min_rtt) / 1000,
+		Retransmits:      ti.Total_retrans,
+		Lost:             ti.Lost,
+		Cwnd:             ti.Snd_cwnd,
+		MSS:              ti.Snd_mss,
+		DeliveryRateMbps: bytesPerSecondMbps(ti.Delivery_rate),
+		PacingRateMbps:   bytesPerSecondMbps(pacing),
+		BytesSent:        ti.Bytes_sent,
+		BytesRetrans:     ti.Bytes_retrans,
+		BusyMs:           float64(ti.Busy_time) / 1000,
+		RwndLimitedMs:    float64(ti.Rwnd_limited) / 1000,
+		SndbufLimitedMs:  float64(ti.Sndbuf_limited) / 1000,
+	}, nil
+}
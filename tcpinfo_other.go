@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// TCP_INFO with these fields is Linux-specific; other platforms record none.
+func readTCPInfo(c *net.TCPConn) (*TCPInfo, error) {
+	return nil, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxErrorReasonLength = 200
+	testErrorRetention   = 7 * 24 * time.Hour
+)
+
+// testPhases are the phases a client may report failures for.
+var testPhases = []string{"latency", "download", "upload", "webrtc", "save"}
+
+// phaseSessionType maps a phase to the session type counted as an attempt.
+var phaseSessionType = map[string]string{"download": "download", "upload": "upload", "webrtc": "webrtc"}
+
+// TestErrorReport is an anonymized record of a failed test phase. It
+// deliberately carries no client address or identifier.
+type TestErrorReport struct {
+	Timestamp time.Time `json:"timestamp"`
+	Phase     string    `json:"phase"`
+	Reason    string    `json:"reason"`
+	Browser   string    `json:"browser"`
+}
+
+// TestErrorStore is implemented by result stores that can persist error reports.
+type TestErrorStore interface {
+	SaveTestError(report TestErrorReport) error
+	TestErrors(since time.Time) ([]TestErrorReport, error)
+}
+
+var (
+	testErrorsTotal = newCounterVec("netspeed_test_errors_total",
+		"Test phase failures reported by clients.", "phase")
+	testErrorsDropped = newCounterVec("netspeed_test_errors_dropped_total",
+		"Client error reports rejected by the rate limiter.")
+	sessionsStarted = newCounterVec("netspeed_sessions_started_total",
+		"Test sessions started, by type.", "type")
+	_ = newGaugeFunc("netspeed_test_failure_ratio",
+		"Reported failures divided by sessions started since the server started, by phase.",
+		[]string{"phase"}, testFailureRatios)
+
+	// Per-client and global limits keep a misbehaving client from flooding the store.
+	testErrorClientLimiter = newRateLimiter(10, 5)
+	testErrorGlobalLimiter = newRateLimiter(120, 60)
+)
+
+// testFailureRatios computes the failure ratio for phases with a matching session type.
+func testFailureRatios() map[string]float64 {
+	ratios := make(map[string]float64)
+	for phase, kind := range phaseSessionType {
+		started := sessionsStarted.Value(kind)
+		if started == 0 {
+			ratios[phase] = math.NaN()
+			continue
+		}
+		ratios[phase] = testErrorsTotal.Value(phase) / started
+	}
+	return ratios
+}
+
+// browserFamily reduces a User-Agent to a coarse browser name.
+func browserFamily(ua string) string {
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		return "edge"
+	case strings.Contains(ua, "Firefox/"):
+		return "firefox"
+	case strings.Contains(ua, "Chrome/"), strings.Contains(ua, "CriOS/"):
+		return "chrome"
+	case strings.Contains(ua, "Safari/"):
+		return "safari"
+	case ua == "":
+		return "unknown"
+	}
+	return "other"
+}
+
+// sanitizeReason trims a client-supplied reason and strips control characters.
+func sanitizeReason(reason string) string {
+	reason = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, strings.TrimSpace(reason))
+	if len(reason) > maxErrorReasonLength {
+		reason = reason[:maxErrorReasonLength]
+	}
+	return reason
+}
+
+// testErrorHandler accepts a failure report from the web client:
+// {"phase": "webrtc", "reason": "ICE failed"}.
+func testErrorHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+
+	if r.Method != http.MethodPost {
+		http.Error(w, tr(r, "Only POST method is supported"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload struct {
+		Phase  string `json:"phase"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, tr(r, "Invalid JSON error report"), http.StatusBadRequest)
+		return
+	}
+	valid := false
+	for _, phase := range testPhases {
+		valid = valid || payload.Phase == phase
+	}
+	if !valid {
+		http.Error(w, tr(r, "Unknown test phase"), http.StatusBadRequest)
+		return
+	}
+
+	if !testErrorClientLimiter.Allow(requestClientIP(r)) || !testErrorGlobalLimiter.Allow("") {
+		testErrorsDropped.Inc()
+		http.Error(w, tr(r, "Too many error reports"), http.StatusTooManyRequests)
+		return
+	}
+
+	report := TestErrorReport{
+		Timestamp: time.Now(),
+		Phase:     payload.Phase,
+		Reason:    sanitizeReason(payload.Reason),
+		Browser:   browserFamily(r.UserAgent()),
+	}
+	testErrorsTotal.Inc(report.Phase)
+
+	if store, ok := optionalStore[TestErrorStore](); ok {
+		if err := store.SaveTestError(report); err != nil {
+			log.Printf("Failed to save test error report: %v", err)
+		}
+	}
+	if *verbose {
+		log.Printf("Client reported %s failure: %s", report.Phase, report.Reason)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TestErrorSummary aggregates stored error reports for one phase.
+type TestErrorSummary struct {
+	Phase   string         `json:"phase"`
+	Count   int            `json:"count"`
+	Reasons map[string]int `json:"reasons"`
+}
+
+// adminTestErrorsHandler summarizes error reports from the last ?hours= (default 24).
+func adminTestErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := optionalStore[TestErrorStore]()
+	if !ok {
+		http.Error(w, tr(r, "Error reports are not supported by this store"), http.StatusNotImplemented)
+		return
+	}
+	hours, err := parseLookbackHours(r.URL.Query(), 24)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	reports, err := store.TestErrors(time.Now().Add(-time.Duration(hours * float64(time.Hour))))
+	if err != nil {
+		log.Printf("Failed to load test error reports: %v", err)
+		http.Error(w, tr(r, "Internal server error"), http.StatusInternalServerError)
+		return
+	}
+
+	byPhase := make(map[string]*TestErrorSummary)
+	summaries := []*TestErrorSummary{}
+	for _, phase := range testPhases {
+		s := &TestErrorSummary{Phase: phase, Reasons: make(map[string]int)}
+		byPhase[phase] = s
+		summaries = append(summaries, s)
+	}
+	for _, report := range reports {
+		if s, ok := byPhase[report.Phase]; ok {
+			s.Count++
+			s.Reasons[report.Reason]++
+		}
+	}
+	writeJSON(w, map[string]any{"hours": hours, "phases": summaries})
+}
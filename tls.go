@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	acmeChallengePrefix = "/.well-known/acme-challenge/"
+	certCheckInterval   = time.Minute
+)
+
+// serverTLSConfig is set by setupTLS when -tls-cert and -tls-key are given;
+// listenTCP then wraps every test listener in TLS.
+var serverTLSConfig *tls.Config
+
+// certReloader serves the certificate from disk and picks up renewed files
+// (e.g. written by certbot or lego) without a restart.
+type certReloader struct {
+	certPath, keyPath string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func (c *certReloader) load() error {
+	info, err := os.Stat(c.certPath)
+	if err != nil {
+		return err
+	}
+	if c.cert != nil && !info.ModTime().After(c.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return err
+	}
+	if c.cert != nil {
+		log.Printf("Reloaded TLS certificate from %s", c.certPath)
+	}
+	c.cert = &cert
+	c.modTime = info.ModTime()
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checkedAt) >= certCheckInterval {
+		c.checkedAt = time.Now()
+		if err := c.load(); err != nil {
+			log.Printf("Failed to reload TLS certificate, keeping the current one: %v", err)
+		}
+	}
+	return c.cert, nil
+}
+
+// setupTLS loads the certificate and key so startup fails early on bad files.
+func setupTLS(certPath, keyPath string) error {
+	if certPath == "" && keyPath == "" {
+		if *http2 {
+			return fmt.Errorf("-http2 needs -tls-cert and -tls-key")
+		}
+		if err := validateHTTP3(); err != nil {
+			return err
+		}
+		return validateHTTP2()
+	}
+	if certPath == "" || keyPath == "" {
+		return fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+	reloader := &certReloader{certPath: certPath, keyPath: keyPath, checkedAt: time.Now()}
+	if err := reloader.load(); err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	serverCert = reloader
+	serverTLSConfig = &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		// HTTP/1.1 only, so parallel test streams use separate connections
+		// instead of being multiplexed onto one HTTP/2 connection.
+		NextProtos: []string{"http/1.1"},
+		MinVersion: tls.VersionTLS12,
+	}
+	if *http2 {
+		serverTLSConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return validateHTTP2()
+}
+
+// startHTTPRedirect runs a plain-HTTP listener that redirects to the HTTPS
+// server and serves ACME HTTP-01 challenge files from webroot, so a separate
+// web server is not needed on port 80.
+func startHTTPRedirect(redirectPort int, webroot string) {
+	mux := http.NewServeMux()
+	if webroot != "" {
+		mux.HandleFunc(acmeChallengePrefix, func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimPrefix(r.URL.Path, acmeChallengePrefix)
+			if token == "" || strings.ContainsAny(token, "/\\") || strings.HasPrefix(token, ".") {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			http.ServeFile(w, r, filepath.Join(webroot, acmeChallengePrefix, token))
+		})
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, httpsURL(r), http.StatusMovedPermanently)
+	})
+
+	ln, err := net.Listen(listenNetwork, net.JoinHostPort(listenHost, strconv.Itoa(redirectPort)))
+	if err != nil {
+		log.Printf("Warning: HTTP redirect listener disabled: %v", err)
+		return
+	}
+	log.Printf("Redirecting HTTP on port %d to HTTPS", redirectPort)
+	go func() {
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		if err := server.Serve(ln); err != nil {
+			log.Printf("HTTP redirect listener stopped: %v", err)
+		}
+	}()
+}
+
+// httpsURL is the HTTPS address of the request, on the public URL when it is
+// HTTPS and otherwise on the request host and the server's port.
+func httpsURL(r *http.Request) string {
+	if strings.HasPrefix(*publicURL, "https://") {
+		return strings.TrimSuffix(*publicURL, "/") + r.URL.RequestURI()
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if *port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(*port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return "https://" + host + r.URL.RequestURI()
+}
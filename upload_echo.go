@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// uploadAckInterval is how often an echoing upload acknowledges the bytes
+// received so far.
+const uploadAckInterval = sampleInterval
+
+// UploadAck is one line of the newline-delimited JSON response of
+// /upload?echo=1. Comparing the acknowledged bytes with the bytes a client
+// has written shows whether a proxy buffers the upload: the client gets far
+// ahead of the server, and the acks arrive in a burst at the end.
+type UploadAck struct {
+	Bytes      int64  `json:"bytes"`      // received by the server so far
+	ServerTime int64  `json:"serverTime"` // unix milliseconds
+	Done       bool   `json:"done,omitempty"`
+	Error      string `json:"error,omitempty"` // set on the final ack if the upload failed
+}
+
+// echoUpload reads an upload while streaming acks, and returns the number of
+// bytes received. The response is committed with 200 before the body is read,
+// so failures are reported in the final ack.
+func echoUpload(w http.ResponseWriter, r *http.Request, session *TestSession) (int64, error) {
+	// Committing the response before reading would refuse a client waiting
+	// for 100 Continue; the first read sends it.
+	body := bufio.NewReader(newUploadReader(w, r, session))
+	body.Peek(1)
+
+	rc := http.NewResponseController(w)
+	// HTTP/1 responses normally wait for the body to be consumed; HTTP/2 is
+	// always full duplex and reports ErrNotSupported here.
+	rc.EnableFullDuplex()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	var (
+		received int64
+		err      error
+		done     = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		received, err = io.Copy(io.Discard, body)
+	}()
+
+	enc := json.NewEncoder(w)
+	ack := func(a UploadAck) {
+		a.ServerTime = time.Now().UnixMilli()
+		if enc.Encode(a) == nil {
+			rc.Flush()
+		}
+	}
+	ticker := time.NewTicker(uploadAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ack(UploadAck{Bytes: session.Bytes()})
+		case <-done:
+			final := UploadAck{Bytes: received, Done: true}
+			switch {
+			case errors.Is(err, errUploadTooLarge):
+				uploadsLimited.Inc("size")
+				final.Error = "upload exceeds the size limit"
+			case errors.Is(err, errUploadTooLong):
+				uploadsLimited.Inc("duration")
+				final.Error = "upload exceeds the time limit"
+			case err != nil:
+				final.Error = "upload failed to read body"
+			}
+			ack(final)
+			return received, err
+		}
+	}
+}
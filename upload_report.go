@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// UploadReport is the response of /upload: the throughput the server
+// observed. Clients timing the request instead also measure how long their
+// network stack buffered the body before sending it, which overstates the
+// rate of small uploads and hides stalls. Rates are in the web client's
+// units, like the session samples.
+type UploadReport struct {
+	SessionID  string           `json:"sessionId"`
+	Bytes      int64            `json:"bytes"`
+	DurationMs float64          `json:"durationMs"` // from the first to the last byte received
+	Mbps       float64          `json:"mbps"`
+	Samples    []ProgressSample `json:"samples"` // every 250ms while the upload ran
+
+	BytesPerSecond []int64 `json:"bytesPerSecond"` // received in each second from the first byte
+}
+
+var uploadsLimited = newCounterVec("netspeed_uploads_limited_total",
+	"Uploads refused or cut off by -max-upload-size or -max-upload-duration, by limit: size or duration.", "limit")
+
+// writeUploadReport answers a finished upload with what the server observed
+// between its first and last bytes.
+func writeUploadReport(w http.ResponseWriter, session *TestSession, first, last time.Time, perSecond []int64) {
+	report := UploadReport{SessionID: session.ID, Bytes: session.Bytes(), Samples: session.Samples(), BytesPerSecond: perSecond}
+	if report.Samples == nil {
+		report.Samples = []ProgressSample{}
+	}
+	if report.BytesPerSecond == nil {
+		report.BytesPerSecond = []int64{}
+	}
+	if elapsed := last.Sub(first); !first.IsZero() && elapsed > 0 {
+		report.DurationMs = float64(elapsed.Microseconds()) / 1000
+		report.Mbps = float64(report.Bytes) * 8 / (1024 * 1024) / elapsed.Seconds()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, report)
+}
+
+// refuseUpload answers an upload stopped by -max-upload-size or
+// -max-upload-duration. An HTTP/1 connection is closed instead of reading the
+// rest of the body; HTTP/2 and HTTP/3 reset just the stream.
+func refuseUpload(w http.ResponseWriter, r *http.Request, err error) {
+	if r.ProtoMajor == 1 {
+		w.Header().Set("Connection", "close")
+	}
+	if errors.Is(err, errUploadTooLong) {
+		uploadsLimited.Inc("duration")
+		http.Error(w, tr(r, "Upload exceeds the server's time limit"), http.StatusRequestTimeout)
+		return
+	}
+	uploadsLimited.Inc("size")
+	http.Error(w, tr(r, "Upload exceeds the server's size limit"), http.StatusRequestEntityTooLarge)
+}
@@ -0,0 +1,45 @@
+//go:build js && wasm
+
+// Command wasm exposes the measure package to the web client as the global
+// netspeedMeasure object. Running go generate in the repository root
+// compiles it to static/measure.wasm and copies the matching wasm_exec.js.
+package main
+
+import (
+	"syscall/js"
+	"time"
+
+	"go-netspeed/measure"
+)
+
+// floats converts a JavaScript array of numbers.
+func floats(v js.Value) []float64 {
+	out := make([]float64, v.Length())
+	for i := range out {
+		out[i] = v.Index(i).Float()
+	}
+	return out
+}
+
+func main() {
+	js.Global().Set("netspeedMeasure", js.ValueOf(map[string]any{
+		// mbps(bytes, milliseconds)
+		"mbps": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return measure.Mbps(int64(args[0].Float()), time.Duration(args[1].Float()*float64(time.Millisecond)))
+		}),
+		// meanLatency(rtts)
+		"meanLatency": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return measure.MeanLatency(floats(args[0]))
+		}),
+		// jitterLoss(rtts, sent) returns {jitterMs, lossPercent}
+		"jitterLoss": js.FuncOf(func(this js.Value, args []js.Value) any {
+			jitter, loss := measure.JitterLoss(floats(args[0]), args[1].Int())
+			return map[string]any{"jitterMs": jitter, "lossPercent": loss}
+		}),
+		// rpm(rtts)
+		"rpm": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return measure.RPM(floats(args[0]))
+		}),
+	}))
+	select {} // the functions are called for as long as the page is open
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	maxWebRTCLogs   = 1000
+	webrtcLogMaxAge = time.Hour
+	maxWebRTCEvents = 64
+)
+
+// SessionEvent is one entry in a WebRTC session timeline.
+type SessionEvent struct {
+	ElapsedMs float64 `json:"elapsedMs"` // since the offer was received
+	Event     string  `json:"event"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// WebRTCSessionLog is the server-side timeline of one WebRTC session, kept so
+// "jitter test never starts" reports can be diagnosed after the fact.
+type WebRTCSessionLog struct {
+	SessionID string         `json:"sessionId"`
+	Client    string         `json:"client"`
+	StartedAt time.Time      `json:"startedAt"`
+	Events    []SessionEvent `json:"events"`
+
+	mu            sync.Mutex
+	firstMessage  bool
+	messageCount  int
+	dtlsStartedAt time.Time
+}
+
+// Add appends an event to the timeline, dropping events beyond maxWebRTCEvents.
+func (l *WebRTCSessionLog) Add(event, detail string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.Events) >= maxWebRTCEvents {
+		return
+	}
+	l.Events = append(l.Events, SessionEvent{
+		ElapsedMs: float64(time.Since(l.StartedAt).Microseconds()) / 1000,
+		Event:     event,
+		Detail:    detail,
+	})
+}
+
+// Snapshot returns a copy of the timeline that is safe to encode or store.
+func (l *WebRTCSessionLog) Snapshot() []SessionEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]SessionEvent(nil), l.Events...)
+}
+
+// webrtcLogStore keeps the timelines of recent sessions in memory.
+type webrtcLogStore struct {
+	mu   sync.Mutex
+	logs map[string]*WebRTCSessionLog
+}
+
+var webrtcLogs = &webrtcLogStore{logs: make(map[string]*WebRTCSessionLog)}
+
+// Start creates the timeline for a session, pruning expired or excess logs.
+func (s *webrtcLogStore) Start(session *TestSession) *WebRTCSessionLog {
+	l := &WebRTCSessionLog{SessionID: session.ID, Client: session.Client, StartedAt: session.StartedAt}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var oldest *WebRTCSessionLog
+	for id, existing := range s.logs {
+		if time.Since(existing.StartedAt) > webrtcLogMaxAge {
+			delete(s.logs, id)
+		} else if oldest == nil || existing.StartedAt.Before(oldest.StartedAt) {
+			oldest = existing
+		}
+	}
+	if len(s.logs) >= maxWebRTCLogs && oldest != nil {
+		delete(s.logs, oldest.SessionID)
+	}
+	s.logs[l.SessionID] = l
+	return l
+}
+
+// Get returns the timeline for a session ID, if it is still retained.
+func (s *webrtcLogStore) Get(id string) (*WebRTCSessionLog, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.logs[id]
+	return l, ok
+}
+
+// instrumentPeerConnection records ICE and DTLS state changes on the timeline.
+// Peer connection state is recorded by the offer handler, which owns that callback.
+func instrumentPeerConnection(pc *webrtc.PeerConnection, l *WebRTCSessionLog) {
+	pc.OnICEGatheringStateChange(func(state webrtc.ICEGatheringState) {
+		l.Add("ice-gathering", state.String())
+	})
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		l.Add("ice-connection", state.String())
+		if state == webrtc.ICEConnectionStateConnected {
+			logSelectedCandidatePair(pc, l)
+		}
+	})
+	dtls := pc.SCTP().Transport()
+	dtls.OnStateChange(func(state webrtc.DTLSTransportState) {
+		l.mu.Lock()
+		if state == webrtc.DTLSTransportStateConnecting {
+			l.dtlsStartedAt = time.Now()
+		}
+		started := l.dtlsStartedAt
+		l.mu.Unlock()
+
+		detail := state.String()
+		if state == webrtc.DTLSTransportStateConnected && !started.IsZero() {
+			detail = fmt.Sprintf("%s after %.1fms handshake", detail, float64(time.Since(started).Microseconds())/1000)
+		}
+		l.Add("dtls", detail)
+	})
+}
+
+func logSelectedCandidatePair(pc *webrtc.PeerConnection, l *WebRTCSessionLog) {
+	pair, err := pc.SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil || pair == nil {
+		l.Add("selected-candidate-pair", "unavailable")
+		return
+	}
+	l.Add("selected-candidate-pair", fmt.Sprintf("local %s %s %s <-> remote %s %s %s",
+		pair.Local.Typ, pair.Local.Protocol, net.JoinHostPort(pair.Local.Address, strconv.Itoa(int(pair.Local.Port))),
+		pair.Remote.Typ, pair.Remote.Protocol, net.JoinHostPort(pair.Remote.Address, strconv.Itoa(int(pair.Remote.Port)))))
+}
+
+// recordMessage notes the first echoed message and keeps a running count.
+func (l *WebRTCSessionLog) recordMessage(size int) {
+	l.mu.Lock()
+	first := !l.firstMessage
+	l.firstMessage = true
+	l.messageCount++
+	l.mu.Unlock()
+	if first {
+		l.Add("first-message", fmt.Sprintf("%d bytes", size))
+	}
+}
+
+// recordClose notes the data channel closing with the number of echoed messages.
+func (l *WebRTCSessionLog) recordClose(label string) {
+	l.mu.Lock()
+	count := l.messageCount
+	l.mu.Unlock()
+	l.Add("datachannel-close", fmt.Sprintf("%s after %d messages", label, count))
+}
+
+// webrtcLogHandler serves a session timeline at /sessions/{id}/webrtc.
+func webrtcLogHandler(w http.ResponseWriter, r *http.Request, id string) {
+	l, ok := webrtcLogs.Get(id)
+	if !ok {
+		http.Error(w, tr(r, "Session not found or expired"), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"sessionId": l.SessionID,
+		"client":    l.Client,
+		"startedAt": l.StartedAt,
+		"events":    l.Snapshot(),
+	})
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The WebSocket transport runs a test over one connection of up to two
+// minutes, for clients and networks where streamed fetches perform poorly or
+// proxies buffer HTTP responses. Browsers cannot set headers on a WebSocket, so
+// consent is acknowledged with ?consent=. Binary frames carry a header of a
+// big-endian uint64 sequence number and int64 server time in unix
+// nanoseconds:
+//
+//   - /ws/download?size={MB}|duration={d}&chunk={bytes} sends a text frame
+//     {"type":"start"}, binary frames of seq, server time and padding, and a
+//     text frame {"type":"end"} with the totals before closing.
+//   - /ws/upload acknowledges each binary message, whose first 8 bytes are
+//     its seq, with a 24-byte frame of that seq, the bytes received so far and
+//     the server time.
+//   - /ws/ping echoes each binary message of at most 64 bytes, whose first 8
+//     bytes are its seq, as the seq, the server time and the rest of the
+//     message, e.g. the client's clock.
+//
+// Only the parts of RFC 6455 these exchanges need are implemented: no
+// extensions or subprotocols, and messages the server sends are never
+// fragmented.
+
+const (
+	websocketMaxDuration  = 2 * time.Minute // after which the server ends the connection
+	websocketMaxFrame     = 16 << 20        // largest message payload accepted from clients
+	websocketMaxPing      = 64
+	websocketFrameHeader  = 16 // seq and server time
+	websocketWriteTimeout = 10 * time.Second
+	websocketCloseNormal  = 1000
+	websocketCloseData    = 1003
+	websocketClosePolicy  = 1008
+	websocketCloseTooBig  = 1009
+)
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// errWebSocketClosed is returned once the client sent a close frame, which
+// was already answered.
+var errWebSocketClosed = errors.New("websocket closed by client")
+
+// WebSocketStatus is a text frame the WebSocket endpoints send. Rates are in
+// the web client's units, like the session samples.
+type WebSocketStatus struct {
+	Type       string  `json:"type"` // start, end or error
+	SessionID  string  `json:"sessionId,omitempty"`
+	ChunkSize  int64   `json:"chunkSize,omitempty"`
+	Frames     int64   `json:"frames,omitempty"`
+	Bytes      int64   `json:"bytes,omitempty"`
+	DurationMs float64 `json:"durationMs,omitempty"`
+	Mbps       float64 `json:"mbps,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// websocketAcceptKey returns the Sec-WebSocket-Accept value for a client key.
+func websocketAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsConn is a server-side WebSocket connection. Frames may be written
+// concurrently with reading, e.g. a pong while a download runs.
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex // serializes writes
+
+	header [10]byte // of the frame being written, guarded by mu
+}
+
+// wsFrame is the header of a frame being read, and how much of its payload was.
+type wsFrame struct {
+	fin    bool
+	opcode byte
+	length int64
+	mask   [4]byte
+	read   int64
+}
+
+// checkWebSocketUpgrade reports whether r is a WebSocket handshake this
+// server accepts, or responds.
+func checkWebSocketUpgrade(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		http.Error(w, tr(r, "Only GET method is supported"), http.StatusMethodNotAllowed)
+		return false
+	}
+	key, _ := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key"))
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || len(key) != 16 ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, tr(r, "Expected a WebSocket upgrade"), http.StatusUpgradeRequired)
+		return false
+	}
+	return true
+}
+
+// acceptWebSocket completes a handshake checked by checkWebSocketUpgrade.
+// The connection is closed when the server shuts down, since hijacked
+// connections are not part of a graceful shutdown.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request, session *TestSession) (*wsConn, error) {
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, tr(r, "WebSocket upgrade not supported"), http.StatusInternalServerError)
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(websocketMaxDuration))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\nX-Session-ID: %s\r\n\r\n",
+		websocketAcceptKey(r.Header.Get("Sec-WebSocket-Key")), session.ID)
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	context.AfterFunc(r.Context(), func() { conn.Close() })
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// writeFrame sends one unfragmented frame whose payload is the concatenation of parts.
+func (c *wsConn) writeFrame(opcode byte, parts ...[]byte) error {
+	var length int
+	for _, p := range parts {
+		length += len(p)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header := append(c.header[:0], 0x80|opcode, 0)
+	switch {
+	case length < 126:
+		header[1] = byte(length)
+	case length <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := c.rw.Write(p); err != nil {
+			return err
+		}
+	}
+	return c.rw.Flush()
+}
+
+// writeStatus sends a text frame.
+func (c *wsConn) writeStatus(status WebSocketStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsText, data)
+}
+
+// close sends a close frame with code and closes the connection.
+func (c *wsConn) close(code uint16, reason string) {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	c.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, code), []byte(reason))
+	c.conn.Close()
+}
+
+// fail sends an error frame and closes the connection with code.
+func (c *wsConn) fail(code uint16, message string) {
+	c.writeStatus(WebSocketStatus{Type: "error", Error: message})
+	c.close(code, message)
+}
+
+// readHeader reads the header of the next frame. Client frames must be masked.
+func (c *wsConn) readHeader() (*wsFrame, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(c.rw, b[:2]); err != nil {
+		return nil, err
+	}
+	f := &wsFrame{fin: b[0]&0x80 != 0, opcode: b[0] & 0x0F, length: int64(b[1] & 0x7F)}
+	if b[0]&0x70 != 0 || b[1]&0x80 == 0 {
+		return nil, fmt.Errorf("unmasked frame or unexpected extension bits")
+	}
+	switch f.length {
+	case 126:
+		if _, err := io.ReadFull(c.rw, b[:2]); err != nil {
+			return nil, err
+		}
+		f.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.rw, b[:8]); err != nil {
+			return nil, err
+		}
+		f.length = int64(binary.BigEndian.Uint64(b[:8]) & (1<<63 - 1))
+	}
+	if _, err := io.ReadFull(c.rw, f.mask[:]); err != nil {
+		return nil, err
+	}
+	if f.opcode >= wsClose && (!f.fin || f.length > 125) {
+		return nil, fmt.Errorf("fragmented or oversized control frame")
+	}
+	return f, nil
+}
+
+// readPayload reads up to len(p) bytes of the payload of f, unmasked.
+func (c *wsConn) readPayload(f *wsFrame, p []byte) (int, error) {
+	if remaining := f.length - f.read; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := io.ReadFull(c.rw, p)
+	for i := range n {
+		p[i] ^= f.mask[(f.read+int64(i))%4]
+	}
+	f.read += int64(n)
+	return n, err
+}
+
+// nextDataFrame returns the header of the next text, binary or continuation
+// frame, answering control frames on the way. It returns errWebSocketClosed
+// after answering a close frame.
+func (c *wsConn) nextDataFrame() (*wsFrame, error) {
+	for {
+		f, err := c.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if f.opcode < wsClose {
+			return f, nil
+		}
+		payload := make([]byte, f.length)
+		if _, err := c.readPayload(f, payload); err != nil {
+			return nil, err
+		}
+		switch f.opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+		case wsClose:
+			code := uint16(websocketCloseNormal)
+			if len(payload) >= 2 {
+				code = binary.BigEndian.Uint16(payload)
+			}
+			c.close(code, "")
+			return nil, errWebSocketClosed
+		}
+	}
+}
+
+// websocketDownloadHandler serves /ws/download?size={MB}|duration={d}&chunk={bytes}.
+func websocketDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkWebSocketUpgrade(w, r) {
+		return
+	}
+	req, err := parseDownloadRequest(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if req.Congestion != "" {
+		badRequest(w, &paramError{"cc", "is not supported with WebSocket downloads"})
+		return
+	}
+	session, ok := startSession(w, "download", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+	session.SetChunkSize(req.ChunkSize)
+	session.SetDownloadGoal(req.SizeBytes, req.Duration)
+	// Recorded as aborted or cut off unless it completes
+	defer session.EndDownload(false)
+	c, err := acceptWebSocket(w, r, session)
+	if err != nil {
+		log.Printf("WebSocket download hijack failed: %v", err)
+		return
+	}
+	defer c.conn.Close()
+
+	// The client sends nothing but control frames; a close ends the download early
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			f, err := c.nextDataFrame()
+			if err != nil {
+				closed <- err
+				return
+			}
+			if _, err := io.CopyN(io.Discard, wsPayloadReader{c, f}, f.length); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	if err := c.writeStatus(WebSocketStatus{Type: "start", SessionID: session.ID, ChunkSize: req.ChunkSize}); err != nil {
+		return
+	}
+	payload := newDownloadPayload(newPayloadGenerator(), req.ChunkSize)
+	header := make([]byte, websocketFrameHeader)
+	start := time.Now()
+	deadline := start.Add(req.Duration)
+	var seq, sent int64
+	for (req.Duration > 0 && time.Now().Before(deadline)) || (req.Duration == 0 && sent < req.SizeBytes) {
+		select {
+		case err := <-closed:
+			if *verbose {
+				log.Printf("WebSocket download from %s ended after %d bytes: %v", loggedAddr(r.RemoteAddr), sent, err)
+			}
+			return
+		default:
+		}
+		n := req.ChunkSize
+		if req.Duration == 0 {
+			n = min(n, req.SizeBytes-sent)
+		}
+		// The header counts towards the payload, so a sized download sends its
+		// size, rounded up to a whole header
+		n = max(n-websocketFrameHeader, 0)
+		binary.BigEndian.PutUint64(header[0:8], uint64(seq))
+		binary.BigEndian.PutUint64(header[8:16], uint64(time.Now().UnixNano()))
+		if err := c.writeFrame(wsBinary, header, payload.At(sent, n)); err != nil {
+			if *verbose {
+				log.Printf("WebSocket download write error: %v", err)
+			}
+			return
+		}
+		seq++
+		sent += n + websocketFrameHeader
+		session.AddBytes(n + websocketFrameHeader)
+	}
+
+	session.EndDownload(true)
+	elapsed := time.Since(start)
+	c.writeStatus(WebSocketStatus{
+		Type:       "end",
+		SessionID:  session.ID,
+		Frames:     seq,
+		Bytes:      sent,
+		DurationMs: float64(elapsed.Microseconds()) / 1000,
+		Mbps:       float64(sent) * 8 / (1024 * 1024) / elapsed.Seconds(),
+	})
+	c.close(websocketCloseNormal, "")
+}
+
+// wsPayloadReader reads the payload of one frame.
+type wsPayloadReader struct {
+	c *wsConn
+	f *wsFrame
+}
+
+func (r wsPayloadReader) Read(p []byte) (int, error) {
+	if r.f.read >= r.f.length {
+		return 0, io.EOF
+	}
+	return r.c.readPayload(r.f, p)
+}
+
+// websocketUploadHandler serves /ws/upload: it counts binary messages
+// towards the session and acknowledges each one once it was received.
+func websocketUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkWebSocketUpgrade(w, r) {
+		return
+	}
+	session, ok := startSession(w, "upload", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+	c, err := acceptWebSocket(w, r, session)
+	if err != nil {
+		log.Printf("WebSocket upload hijack failed: %v", err)
+		return
+	}
+	defer c.conn.Close()
+	if err := c.writeStatus(WebSocketStatus{Type: "start", SessionID: session.ID}); err != nil {
+		return
+	}
+
+	buf := make([]byte, 64*1024)
+	ack := make([]byte, 24)
+	var received, message, messages int64
+	seq := make([]byte, 0, 8)
+	for {
+		f, err := c.nextDataFrame()
+		if err != nil {
+			if *verbose {
+				log.Printf("WebSocket upload from %s ended after %d messages and %d bytes: %v", loggedAddr(r.RemoteAddr), messages, received, err)
+			}
+			return
+		}
+		if f.opcode == wsText {
+			c.fail(websocketCloseData, "binary messages only")
+			return
+		}
+		if message+f.length > websocketMaxFrame {
+			c.fail(websocketCloseTooBig, fmt.Sprintf("messages must be at most %d bytes", websocketMaxFrame))
+			return
+		}
+		for f.read < f.length {
+			n, err := c.readPayload(f, buf)
+			if err != nil {
+				return
+			}
+			if len(seq) < 8 {
+				seq = append(seq, buf[:min(n, 8-len(seq))]...)
+			}
+			received += int64(n)
+			message += int64(n)
+			session.AddBytes(int64(n))
+		}
+		if !f.fin {
+			continue
+		}
+		if len(seq) < 8 {
+			c.fail(websocketCloseData, "messages must start with an 8-byte sequence number")
+			return
+		}
+		copy(ack[0:8], seq)
+		binary.BigEndian.PutUint64(ack[8:16], uint64(received))
+		binary.BigEndian.PutUint64(ack[16:24], uint64(time.Now().UnixNano()))
+		if err := c.writeFrame(wsBinary, ack); err != nil {
+			return
+		}
+		seq = seq[:0]
+		message = 0
+		messages++
+	}
+}
+
+// websocketPingHandler serves /ws/ping: each probe is echoed as soon as it
+// arrives, within -echo-max-rate and -echo-max-messages.
+func websocketPingHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkWebSocketUpgrade(w, r) {
+		return
+	}
+	session, ok := startSession(w, "latency", r)
+	if !ok {
+		return
+	}
+	defer activeSessions.Finish(session)
+	c, err := acceptWebSocket(w, r, session)
+	if err != nil {
+		log.Printf("WebSocket ping hijack failed: %v", err)
+		return
+	}
+	defer c.conn.Close()
+	if err := c.writeStatus(WebSocketStatus{Type: "start", SessionID: session.ID}); err != nil {
+		return
+	}
+
+	limiter := newEchoLimiter()
+	probe := make([]byte, websocketMaxPing)
+	now := make([]byte, 8)
+	probes := 0
+	for {
+		f, err := c.nextDataFrame()
+		if err != nil {
+			if *verbose {
+				log.Printf("WebSocket ping from %s ended after %d probes: %v", loggedAddr(r.RemoteAddr), probes, err)
+			}
+			return
+		}
+		if f.opcode != wsBinary || !f.fin || f.length < 8 || f.length > websocketMaxPing {
+			c.fail(websocketCloseData, fmt.Sprintf("probes must be single binary frames of 8 to %d bytes", websocketMaxPing))
+			return
+		}
+		n, err := c.readPayload(f, probe)
+		if err != nil {
+			return
+		}
+		// Dropping a probe would leave the client waiting for it; end the connection instead
+		if err := limiter.Allow(); err != nil {
+			echoDropped.Inc("/ws/ping", echoRejectReason(err))
+			c.fail(websocketClosePolicy, err.Error())
+			return
+		}
+		binary.BigEndian.PutUint64(now, uint64(serverUnixNano()))
+		if err := c.writeFrame(wsBinary, probe[:8], now, probe[8:n]); err != nil {
+			return
+		}
+		probes++
+	}
+}